@@ -24,7 +24,9 @@ import (
 	"github.com/armon/go-metrics"
 	"github.com/armon/go-metrics/prometheus"
 	"github.com/mitchellh/cli"
+	promclient "github.com/prometheus/client_golang/prometheus"
 
+	ucli "github.com/actiontech/dtle/internal/client"
 	ulog "github.com/actiontech/dtle/internal/logger"
 )
 
@@ -454,6 +456,13 @@ func (c *Command) setupMetric(config *Config) error {
 	fanout = append(fanout, inm)
 	metrics.NewGlobal(metricsConf, fanout)
 
+	// Expose task metrics labeled by job, alloc and task
+	taskSink := ucli.NewPrometheusSink()
+	if err := promclient.Register(taskSink); err != nil {
+		return err
+	}
+	ucli.RegisterMetricsSink(taskSink)
+
 	return nil
 }
 
@@ -483,5 +483,7 @@ func ApiTaskToStructsTask(apiTask *api.Task, structsTask *models.Task) {
 	structsTask.NodeName = apiTask.NodeName
 	structsTask.Driver = apiTask.Driver
 	structsTask.Leader = apiTask.Leader
+	structsTask.StatsInterval = apiTask.StatsInterval
+	structsTask.MaxRuntime = apiTask.MaxRuntime
 	structsTask.Config = apiTask.Config
 }
@@ -19,6 +19,9 @@ type Task struct {
 	Config   map[string]interface{}
 	Leader   bool
 	Status   string
+
+	StatsInterval time.Duration
+	MaxRuntime    time.Duration
 }
 
 // Configure is used to configure a single k/v pair on
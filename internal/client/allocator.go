@@ -7,6 +7,7 @@
 package client
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -133,7 +134,11 @@ func (r *Allocator) saveAllocatorState() error {
 		AllocClientStatus:      allocClientStatus,
 		AllocClientDescription: allocClientDescription,
 	}
-	return persistState(r.stateFilePath(), &snap)
+	key, err := stateKey(r.config)
+	if err != nil {
+		return err
+	}
+	return persistState(r.stateFilePath(), &snap, r.config.StateSyncDir, key)
 }
 
 func (r *Allocator) saveWorkerState(tr *Worker) error {
@@ -184,7 +189,7 @@ func (r *Allocator) Alloc() *models.Allocation {
 	alloc.TaskStates = copyTaskStates(r.taskStates)
 	for _, state := range r.taskStates {
 		switch state.State {
-		case models.TaskStateRunning:
+		case models.TaskStateRunning, models.TaskStatePaused:
 			running = true
 		case models.TaskStatePending:
 			pending = true
@@ -277,8 +282,9 @@ func (r *Allocator) setTaskState(taskName, state string, event *models.TaskEvent
 
 	switch state {
 	case models.TaskStateRunning:
-		// Capture the start time if it is just starting
-		if taskState.State != models.TaskStateRunning {
+		// Capture the start time if it is just starting. Resuming a paused
+		// task keeps the original start time.
+		if taskState.State != models.TaskStateRunning && taskState.State != models.TaskStatePaused {
 			taskState.StartedAt = time.Now()
 		}
 	case models.TaskStateDead:
@@ -417,11 +423,14 @@ func (r *Allocator) Run() {
 		return
 	}
 
-	tr := NewWorker(r.logger, r.config, r.setTaskState, r.Alloc(), t.Copy(), r.workUpdates)
+	tr := NewWorker(NewLogger(r.logger), r.config, r.setTaskState, r.Alloc(), t.Copy(), r.workUpdates)
+	if err := tr.RestoreState(); err != nil {
+		r.logger.Warnf("agent: Failed to restore state for alloc %s task '%s': %v", r.alloc.ID, t.Type, err)
+	}
 	r.tasks[t.Type] = tr
 	tr.MarkReceived()
 
-	go tr.Run()
+	go tr.Run(context.Background())
 	r.taskLock.Unlock()
 
 	// taskDestroyEvent contains an event that caused the destroyment of a task
@@ -0,0 +1,97 @@
+/*
+ * Copyright (C) 2016-2018. ActionTech.
+ * Based on: github.com/hashicorp/nomad, github.com/github/gh-ost .
+ * License: MPL version 2: https://www.mozilla.org/en-US/MPL/2.0 .
+ */
+
+package client
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/actiontech/dtle/internal/client/driver"
+	usql "github.com/actiontech/dtle/internal/client/driver/mysql/sql"
+)
+
+// captureFilePath returns the path of the file the queries of the task are
+// captured to, next to the store file of the task.
+func (r *Worker) captureFilePath() string {
+	return filepath.Join(filepath.Dir(r.stateFilePath()), "capture.sql")
+}
+
+// openCapture opens the capture file of the task for appending if its
+// "CaptureSQL" config is set, or returns nil. Capturing needs a StateDir.
+// The statements are written in plaintext, so it is refused on a client
+// encrypting its state.
+func (r *Worker) openCapture() (*os.File, error) {
+	if !r.configFlag("CaptureSQL") {
+		return nil, nil
+	}
+	if r.config.StateDir == "" {
+		return nil, fmt.Errorf("capturing the queries of task %q needs a state dir", r.task.Type)
+	}
+	if key, err := stateKey(r.config); err != nil {
+		return nil, err
+	} else if key != nil {
+		return nil, fmt.Errorf("capturing the queries of task %q would write them in plaintext next to its encrypted state", r.task.Type)
+	}
+	path := r.captureFilePath()
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, fmt.Errorf("failed to make the capture dir of task %q: %v", r.task.Type, err)
+	}
+	return os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+}
+
+// writeCaptured appends a query with its args in place to w, as a statement
+// which can be replayed by hand.
+func writeCaptured(w io.Writer, query string, args []interface{}) error {
+	statement, err := usql.InlineArgs(query, args)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "%s;\n", statement)
+	return err
+}
+
+// captureExecutor returns an executor appending the statements the task runs
+// to its capture file, or nil if its CaptureSQL is not set. A statement which
+// fails to be written is logged, and stops the capture until the next start.
+func (r *Worker) captureExecutor() (driver.Executor, error) {
+	r.captureLock.Lock()
+	defer r.captureLock.Unlock()
+	if r.capture == nil {
+		capture, err := r.openCapture()
+		if err != nil || capture == nil {
+			return nil, err
+		}
+		r.capture = capture
+	}
+	return func(query string, args []interface{}) {
+		r.captureLock.Lock()
+		defer r.captureLock.Unlock()
+		if r.capture == nil {
+			return
+		}
+		if err := writeCaptured(r.capture, query, args); err != nil {
+			r.logger.Warn("agent: Failed to capture a query, not capturing any more", "error", err)
+			r.capture.Close()
+			r.capture = nil
+		}
+	}, nil
+}
+
+// closeCapture closes the capture file of the task, if open.
+func (r *Worker) closeCapture() {
+	r.captureLock.Lock()
+	defer r.captureLock.Unlock()
+	if r.capture == nil {
+		return
+	}
+	if err := r.capture.Close(); err != nil {
+		r.logger.Warn("agent: Failed to close the capture file", "error", err)
+	}
+	r.capture = nil
+}
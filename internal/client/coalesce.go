@@ -0,0 +1,118 @@
+/*
+ * Copyright (C) 2016-2018. ActionTech.
+ * Based on: github.com/hashicorp/nomad, github.com/github/gh-ost .
+ * License: MPL version 2: https://www.mozilla.org/en-US/MPL/2.0 .
+ */
+
+package client
+
+import (
+	"sync"
+	"time"
+
+	"github.com/actiontech/dtle/internal/config"
+	"github.com/actiontech/dtle/internal/models"
+)
+
+// eventKey identifies the updates coalesced together: the same state and
+// event, but for when it happened and the restart delay, which is jittered.
+type eventKey struct {
+	state string
+	event models.TaskEvent
+}
+
+func newEventKey(state string, event *models.TaskEvent) eventKey {
+	key := eventKey{state: state, event: *event}
+	key.event.Time = time.Time{}
+	key.event.StartDelay = 0
+	key.event.Repeats = 0
+	return key
+}
+
+// update is a state and event passed to setState.
+type update struct {
+	state string
+	event *models.TaskEvent
+}
+
+// eventCoalescer passes the updates of a task on, holding back those
+// identical to one passed on within the window. At the end of the window the
+// latest held back update is passed on with the number of updates it stands
+// for, so the last state of the task is never lost. Distinct updates, and
+// those without an event, are passed on right away.
+type eventCoalescer struct {
+	window time.Duration
+	emit   func(state string, event *models.TaskEvent)
+
+	lock    sync.Mutex
+	emitted map[eventKey]time.Time
+	repeats map[eventKey]int
+	latest  *update
+	timer   *time.Timer
+}
+
+// newEventCoalescer returns a coalescer passing updates on to emit, or nil if
+// coalescing is disabled.
+func newEventCoalescer(cfg *config.ClientConfig, emit func(state string, event *models.TaskEvent)) *eventCoalescer {
+	if cfg == nil || cfg.EventCoalesceWindow <= 0 {
+		return nil
+	}
+	return &eventCoalescer{
+		window:  cfg.EventCoalesceWindow,
+		emit:    emit,
+		emitted: make(map[eventKey]time.Time),
+		repeats: make(map[eventKey]int),
+	}
+}
+
+// Update passes an update on, or holds it back as a repeat.
+func (c *eventCoalescer) Update(state string, event *models.TaskEvent) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if event == nil {
+		c.latest = nil
+		c.emit(state, nil)
+		return
+	}
+	key := newEventKey(state, event)
+	now := time.Now()
+	if last, ok := c.emitted[key]; ok && now.Sub(last) < c.window {
+		c.repeats[key]++
+		c.latest = &update{state: state, event: event}
+		if c.timer == nil {
+			c.timer = time.AfterFunc(last.Add(c.window).Sub(now), c.flush)
+		}
+		return
+	}
+	c.latest = nil
+	c.emitLocked(key, state, event, now)
+}
+
+// flush passes on the latest update held back, if any.
+func (c *eventCoalescer) flush() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.timer = nil
+	if c.latest == nil {
+		return
+	}
+	latest := c.latest
+	c.latest = nil
+	c.emitLocked(newEventKey(latest.state, latest.event), latest.state, latest.event, time.Now())
+}
+
+func (c *eventCoalescer) emitLocked(key eventKey, state string, event *models.TaskEvent, now time.Time) {
+	if n := c.repeats[key]; n > 0 {
+		event = event.Copy()
+		event.Repeats = n
+		delete(c.repeats, key)
+	}
+	for k, t := range c.emitted {
+		if now.Sub(t) >= c.window {
+			delete(c.emitted, k)
+		}
+	}
+	c.emitted[key] = now
+	c.emit(state, event)
+}
@@ -0,0 +1,69 @@
+/*
+ * Copyright (C) 2016-2018. ActionTech.
+ * Based on: github.com/hashicorp/nomad, github.com/github/gh-ost .
+ * License: MPL version 2: https://www.mozilla.org/en-US/MPL/2.0 .
+ */
+
+package client
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/actiontech/dtle/internal/config"
+	"github.com/actiontech/dtle/internal/models"
+)
+
+func TestEventCoalescer(t *testing.T) {
+	var lock sync.Mutex
+	var updates []update
+	c := newEventCoalescer(&config.ClientConfig{EventCoalesceWindow: 200 * time.Millisecond}, func(state string, event *models.TaskEvent) {
+		lock.Lock()
+		defer lock.Unlock()
+		updates = append(updates, update{state: state, event: event})
+	})
+
+	// A restart loop: each restart is a Restarting and a Started event.
+	const restarts = 50
+	for i := 0; i < restarts; i++ {
+		c.Update(models.TaskStatePending, models.NewTaskEvent(models.TaskRestarting).
+			SetRestartDelay(time.Duration(i)*time.Millisecond).SetRestartReason(ReasonWithinPolicy))
+		c.Update(models.TaskStateRunning, models.NewTaskEvent(models.TaskStarted))
+	}
+	lock.Lock()
+	if len(updates) != 2 {
+		t.Errorf("passed on %d of %d updates within the window, want the first 2", len(updates), 2*restarts)
+	}
+	lock.Unlock()
+
+	// The last update held back is passed on at the end of the window, for
+	// all its repeats.
+	time.Sleep(300 * time.Millisecond)
+	lock.Lock()
+	if len(updates) != 3 {
+		t.Fatalf("passed on %d updates after the window, want 3", len(updates))
+	}
+	last := updates[2]
+	if last.state != models.TaskStateRunning || last.event.Type != models.TaskStarted || last.event.Repeats != restarts-1 {
+		t.Errorf("got %q %+v, want the latest update standing for %d", last.state, last.event, restarts-1)
+	}
+	lock.Unlock()
+
+	// A distinct event passes right away, within the window of the repeats.
+	c.Update(models.TaskStatePending, models.NewTaskEvent(models.TaskRestarting).SetRestartReason(ReasonWithinPolicy))
+	c.Update(models.TaskStateFailed, models.NewTaskEvent(models.TaskNotRestarting).SetFailsTask())
+	c.Update(models.TaskStateDead, nil)
+	lock.Lock()
+	defer lock.Unlock()
+	if len(updates) != 6 {
+		t.Fatalf("passed on %d updates, want 6", len(updates))
+	}
+	if updates[4].event.Type != models.TaskNotRestarting || updates[5].state != models.TaskStateDead {
+		t.Errorf("distinct updates %q %+v, %q not passed on", updates[4].state, updates[4].event, updates[5].state)
+	}
+
+	if newEventCoalescer(&config.ClientConfig{}, nil) != nil {
+		t.Errorf("coalescer without a window, want none")
+	}
+}
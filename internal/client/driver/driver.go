@@ -9,6 +9,7 @@ package driver
 import (
 	"errors"
 	"fmt"
+	"os"
 
 	uconf "github.com/actiontech/dtle/internal/config"
 	log "github.com/actiontech/dtle/internal/logger"
@@ -56,6 +57,48 @@ type Driver interface {
 	Validate(task *models.Task) (*models.TaskValidateResponse, error)
 }
 
+// Executor receives the queries of a dry run in place of the target
+// database, with the args they would be run with.
+type Executor func(query string, args []interface{})
+
+// DryRunner is implemented by drivers that can preview a task: DryRun passes
+// the queries the task would run to exec, without writing to the target.
+type DryRunner interface {
+	DryRun(ctx *ExecContext, task *models.Task, exec Executor) error
+}
+
+// SQLHookRunner is implemented by drivers that can run the PreStartSQL and
+// PostStopSQL statements of a task. RunSQL runs them in order on one
+// connection to the task's database, and stops at the first that fails.
+type SQLHookRunner interface {
+	RunSQL(ctx *ExecContext, task *models.Task, statements []string) error
+}
+
+// Splitter is implemented by drivers that can copy a task in parallel. Split
+// returns up to n tasks, each copying one of as many non-overlapping ranges
+// of the task's key space, which together cover all of it. A task it can't
+// split is returned as is.
+type Splitter interface {
+	Split(ctx *ExecContext, task *models.Task, n int) ([]*models.Task, error)
+}
+
+// Signaler is implemented by handles that can forward an OS signal to their
+// task, such as those of drivers wrapping an external process which reloads
+// its config on SIGHUP.
+type Signaler interface {
+	Signal(s os.Signal) error
+}
+
+// KeyBoundsCacher is implemented by handles of tasks which find the
+// KeyBounds of the tables they copy. KeyBounds returns those found so far by
+// qualified table name. The worker saves them with its state and passes them
+// back to the restarted task as ExecContext.KeyBounds, which reuses those
+// still holding instead of scanning for them again, see
+// sql.BuildKeyBoundsCheckQuery.
+type KeyBoundsCacher interface {
+	KeyBounds() map[string]*models.KeyBounds
+}
+
 // DriverContext is a means to inject dependencies such as loggers, configs, and
 // node attributes into a Driver without having to change the Driver interface
 // each time we do it. Used in conjection with Factory, above.
@@ -100,14 +143,49 @@ type DriverHandle interface {
 	// Shutdown is used to stop the task
 	Shutdown() error
 
+	// Drain stops the task once the work in flight, such as the chunk being
+	// copied, is committed. It returns when the task is stopped, after which
+	// Shutdown is not needed.
+	Drain() error
+
 	// Stats returns aggregated stats of the driver
 	Stats() (*models.TaskStatistics, error)
+
+	// Pause stops the task from consuming events. Events already in flight
+	// and the replication position are kept, so Resume continues from where
+	// the task was paused.
+	Pause() error
+
+	// Resume continues a task stopped by Pause
+	Resume() error
+
+	// Throttle sets the rate the task copies at, as a fraction of full speed
+	// in (0, 1].
+	Throttle(factor float64)
+
+	// Checkpoint returns an opaque replication position that the task can be
+	// started from again. For the mysql drivers it is the GTID set, which is
+	// passed back through the task's "Gtid" config.
+	Checkpoint() []byte
+
+	// PoolStats returns the state of the connection pool the task writes to
+	// its target with, or nil if it has none.
+	PoolStats() *models.PoolStats
 }
 
 type ExecContext struct {
 	Subject    string
 	Tp         string
 	MaxPayload int
+	// QueryComment, if set, tags the queries the task runs, so that they
+	// can be told apart in the logs of the database.
+	QueryComment string
+	// KeyBounds are those the task found before it was restarted, if any,
+	// see KeyBoundsCacher.
+	KeyBounds map[string]*models.KeyBounds
+	// Capture, if set, is passed the statements the task runs on its target,
+	// with their args, once their transaction commits.
+	Capture Executor
 }
 
 // NewExecContext is used to create a new execution context
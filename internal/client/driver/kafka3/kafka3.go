@@ -14,6 +14,7 @@ import (
 	"strconv"
 	mysqlDriver "github.com/actiontech/dtle/internal/client/driver/mysql"
 	"github.com/actiontech/dtle/internal/config/mysql"
+	"sync/atomic"
 
 	"github.com/golang/snappy"
 	gonats "github.com/nats-io/go-nats"
@@ -42,6 +43,10 @@ type KafkaRunner struct {
 	shutdown   bool
 	shutdownCh chan struct{}
 
+	// paused is set while the task is paused. Messages are not acked, so the
+	// extractor keeps resending them until Resume.
+	paused int64
+
 	kafkaConfig *KafkaConfig
 	kafkaMgr    *KafkaManager
 
@@ -99,6 +104,41 @@ func (kr *KafkaRunner) Shutdown() error {
 	return nil
 }
 
+// Drain stops acking messages from the extractor and shuts the runner down.
+// A message that wasn't acked is sent again by the extractor, so none is lost.
+func (kr *KafkaRunner) Drain() error {
+	atomic.StoreInt64(&kr.paused, 1)
+	return kr.Shutdown()
+}
+
+// Throttle does nothing, the extractor sets the pace
+func (kr *KafkaRunner) Throttle(factor float64) {
+}
+
+// Checkpoint returns nil, the kafka runner restarts from the extractor's
+// position
+func (kr *KafkaRunner) Checkpoint() []byte {
+	return nil
+}
+
+// PoolStats returns nil, the kafka producer has no connection pool.
+func (kr *KafkaRunner) PoolStats() *models.PoolStats {
+	return nil
+}
+
+// Pause stops acking messages from the extractor until Resume is called
+func (kr *KafkaRunner) Pause() error {
+	atomic.StoreInt64(&kr.paused, 1)
+	kr.logger.Printf("kafka: Paused")
+	return nil
+}
+
+func (kr *KafkaRunner) Resume() error {
+	atomic.StoreInt64(&kr.paused, 0)
+	kr.logger.Printf("kafka: Resumed")
+	return nil
+}
+
 func (kr *KafkaRunner) Stats() (*models.TaskStatistics, error) {
 	taskResUsage := &models.TaskStatistics{}
 	return taskResUsage, nil
@@ -165,6 +205,9 @@ func (kr *KafkaRunner) initiateStreaming() error {
 	var err error
 
 	_, err = kr.natsConn.Subscribe(fmt.Sprintf("%s_full", kr.subject), func(m *gonats.Msg) {
+		if atomic.LoadInt64(&kr.paused) == 1 {
+			return
+		}
 		kr.logger.Debugf("kafka: recv a msg")
 		dumpData := &mysqlDriver.DumpEntry{}
 		if err := Decode(m.Data, dumpData); err != nil {
@@ -200,12 +243,18 @@ func (kr *KafkaRunner) initiateStreaming() error {
 	}
 
 	_, err = kr.natsConn.Subscribe(fmt.Sprintf("%s_full_complete", kr.subject), func(m *gonats.Msg) {
+		if atomic.LoadInt64(&kr.paused) == 1 {
+			return
+		}
 		if err := kr.natsConn.Publish(m.Reply, nil); err != nil {
 			kr.onError(TaskStateDead, err)
 		}
 	})
 
 	_, err = kr.natsConn.Subscribe(fmt.Sprintf("%s_incr_hete", kr.subject), func(m *gonats.Msg) {
+		if atomic.LoadInt64(&kr.paused) == 1 {
+			return
+		}
 		var binlogEntries binlog.BinlogEntries
 		if err := Decode(m.Data, &binlogEntries); err != nil {
 			kr.onError(TaskStateDead, err)
@@ -7,8 +7,11 @@
 package driver
 
 import (
+	"context"
+	gosql "database/sql"
 	"fmt"
 	"strings"
+	"sync"
 
 	"github.com/mitchellh/mapstructure"
 
@@ -16,6 +19,7 @@ import (
 	ubase "github.com/actiontech/dtle/internal/client/driver/mysql/base"
 	usql "github.com/actiontech/dtle/internal/client/driver/mysql/sql"
 	"github.com/actiontech/dtle/internal/config"
+	umconf "github.com/actiontech/dtle/internal/config/mysql"
 	"github.com/actiontech/dtle/internal/models"
 
 	"github.com/actiontech/dtle/internal/g"
@@ -227,6 +231,8 @@ func (m *MySQLDriver) Start(ctx *ExecContext, task *models.Task) (DriverHandle,
 	if err := mapstructure.WeakDecode(task.Config, &driverConfig); err != nil {
 		return nil, err
 	}
+	driverConfig.QueryComment = ctx.QueryComment
+	driverConfig.Capture = ctx.Capture
 
 	switch task.Type {
 	case models.TaskTypeSrc:
@@ -258,3 +264,152 @@ func (m *MySQLDriver) Start(ctx *ExecContext, task *models.Task) (DriverHandle,
 
 	return nil, nil
 }
+
+// Split splits the full copy of a src task into up to n copies by ranges of
+// the unique keys of its tables, see mysql.SplitKeyRanges, reusing the
+// bounds in ctx.KeyBounds that still hold. A task streaming from a GTID set
+// makes no full copy, and a dest task takes the rows of all copies, so
+// neither is split.
+func (m *MySQLDriver) Split(ctx *ExecContext, task *models.Task, n int) ([]*models.Task, error) {
+	if task.Type != models.TaskTypeSrc {
+		return []*models.Task{task}, nil
+	}
+	var driverConfig config.MySQLDriverConfig
+	if err := mapstructure.WeakDecode(task.Config, &driverConfig); err != nil {
+		return nil, err
+	}
+	if driverConfig.Gtid != "" || driverConfig.AutoGtid || driverConfig.GtidStart != "" {
+		return []*models.Task{task}, nil
+	}
+	ranges, err := mysql.SplitKeyRanges(&driverConfig, n, ctx.KeyBounds, m.logger)
+	if err != nil {
+		return nil, err
+	}
+	if ranges == nil {
+		m.logger.Printf("mysql: No table of the src task of %q can be split, copying it whole", ctx.Subject)
+		return []*models.Task{task}, nil
+	}
+
+	tasks := make([]*models.Task, len(ranges))
+	for i, copyRange := range ranges {
+		t := task.Copy()
+		t.Config = make(map[string]interface{}, len(task.Config)+1)
+		for k, v := range task.Config {
+			t.Config[k] = v
+		}
+		t.Config["CopyRange"] = copyRange
+		t.ConfigLock = &sync.RWMutex{}
+		tasks[i] = t
+	}
+	return tasks, nil
+}
+
+// DryRun passes the insert, update and delete the applier would run on each
+// table of a dest task to exec, with the task's InsertMode and renames. The
+// columns of a table are looked up on the target unless the config has them.
+// A src task writes nothing, so it has no queries.
+func (m *MySQLDriver) DryRun(ctx *ExecContext, task *models.Task, exec Executor) error {
+	if task.Type != models.TaskTypeDest {
+		return nil
+	}
+	var driverConfig config.MySQLDriverConfig
+	if err := mapstructure.WeakDecode(task.Config, &driverConfig); err != nil {
+		return err
+	}
+	insertMode, err := usql.ParseInsertMode(driverConfig.InsertMode)
+	if err != nil {
+		return err
+	}
+	nameMapper := usql.NewNameMapperFromDataSources(driverConfig.ReplicateDoDb)
+	nameMapper.SetLowerCaseTableNames(driverConfig.LowerCaseTableNames)
+
+	var db *gosql.DB
+	defer func() {
+		if db != nil {
+			db.Close()
+		}
+	}()
+	for _, dataSource := range driverConfig.ReplicateDoDb {
+		for _, table := range dataSource.Tables {
+			databaseName, tableName := nameMapper.Map(dataSource.TableSchema, table.TableName)
+			columns := table.OriginalTableColumns
+			if columns == nil {
+				if db == nil {
+					if db, err = usql.CreateDB(driverConfig.ConnectionConfig.GetDBUri()); err != nil {
+						return err
+					}
+				}
+				if columns, err = ubase.GetTableColumns(db, databaseName, tableName); err != nil {
+					return err
+				}
+				if err = ubase.ApplyColumnTypes(db, databaseName, tableName, columns); err != nil {
+					return err
+				}
+			}
+			columns.VersionColumn = table.VersionColumn
+			m.logger.Debugf("mysql: Dry run of %s.%s", databaseName, tableName)
+			if err := dryRunTable(databaseName, tableName, columns, insertMode, exec); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// dryRunTable renders the queries of a row of the table with placeholder
+// values.
+func dryRunTable(databaseName, tableName string, columns *umconf.ColumnList, mode usql.InsertMode, exec Executor) error {
+	values := make([]interface{}, columns.Len())
+	for i := range values {
+		values[i] = "?"
+	}
+	args := umconf.ToColumnValues(values).GetAbstractValues()
+	d := usql.MySQLDialect{}
+
+	insert, insertArgs, err := usql.BuildDMLInsertQueryAST(d, databaseName, tableName, columns, columns, columns, args, mode)
+	if err != nil {
+		return err
+	}
+	exec(insert.String(), insertArgs)
+
+	update, sharedArgs, keyArgs, err := usql.BuildDMLUpdateQueryAST(d, databaseName, tableName, columns, columns, columns, columns, args, args)
+	if err != nil {
+		return err
+	}
+	exec(update.String(), append(sharedArgs, keyArgs...))
+
+	del, keyArgs, err := usql.BuildDMLDeleteQueryAST(d, databaseName, tableName, columns, args)
+	if err != nil {
+		return err
+	}
+	exec(del.String(), keyArgs)
+	return nil
+}
+
+// RunSQL runs the hook statements of a task on its database. They share a
+// session, so a statement may use what an earlier one set up, but the session
+// isn't the one the task replicates with.
+func (m *MySQLDriver) RunSQL(ctx *ExecContext, task *models.Task, statements []string) error {
+	var driverConfig config.MySQLDriverConfig
+	if err := mapstructure.WeakDecode(task.Config, &driverConfig); err != nil {
+		return err
+	}
+	db, err := usql.CreateDB(driverConfig.ConnectionConfig.GetDBUri())
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	conn, err := db.Conn(context.Background())
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	for i, statement := range statements {
+		m.logger.Debugf("mysql: Running hook statement %d: %s", i, statement)
+		if _, err := conn.ExecContext(context.Background(), statement); err != nil {
+			return fmt.Errorf("statement %d %q: %v", i, statement, err)
+		}
+	}
+	return nil
+}
@@ -71,10 +71,11 @@ func (q *Int64PriQueue) Pop() interface{} {
 }
 
 type applierTableItem struct {
-	columns  *umconf.ColumnList
-	psInsert []*gosql.Stmt
-	psDelete []*gosql.Stmt
-	psUpdate []*gosql.Stmt
+	columns       *umconf.ColumnList
+	mappedColumns *umconf.ColumnList // columns under their names on the target
+	psInsert      []*gosql.Stmt
+	psDelete      []*gosql.Stmt
+	psUpdate      []*gosql.Stmt
 }
 
 func newApplierTableItem(parallelWorkers int) *applierTableItem {
@@ -100,6 +101,7 @@ func (ait *applierTableItem) Reset() {
 	closeStmts(ait.psUpdate)
 
 	ait.columns = nil
+	ait.mappedColumns = nil
 }
 
 type mapSchemaTableItems map[string](map[string](*applierTableItem))
@@ -232,9 +234,23 @@ type Applier struct {
 	shutdownCh   chan struct{}
 	shutdownLock sync.Mutex
 
+	// paused is set while the task is paused. Messages are not acked, so the
+	// extractor keeps resending them until Resume.
+	paused int64
+	// applyLock is held while a chunk or a group of transactions is applied,
+	// and held for reading while a binlog transaction is, as the MTS workers
+	// apply them concurrently. Drain locks it to wait for those in flight.
+	applyLock sync.RWMutex
+	// appliedTimestamp is the source commit time, in seconds since the
+	// epoch, of the last transaction applied. Zero until one is.
+	appliedTimestamp int64
+
 	mtsManager     *MtsManager
 	printTps       bool
 	txLastNSeconds uint32
+
+	insertMode sql.InsertMode
+	nameMapper *sql.NameMapper
 }
 
 func NewApplier(subject, tp string, cfg *config.MySQLDriverConfig, logger *log.Logger) (*Applier, error) {
@@ -247,6 +263,10 @@ func NewApplier(subject, tp string, cfg *config.MySQLDriverConfig, logger *log.L
 		logger.Errorf("job id is not a valid UUID: %v", err.Error())
 		return nil, err
 	}
+	insertMode, err := sql.ParseInsertMode(cfg.InsertMode)
+	if err != nil {
+		return nil, err
+	}
 
 	a := &Applier{
 		logger:                  entry,
@@ -265,6 +285,8 @@ func NewApplier(subject, tp string, cfg *config.MySQLDriverConfig, logger *log.L
 		waitCh:                  make(chan *models.WaitResult, 1),
 		shutdownCh:              make(chan struct{}),
 		printTps:                os.Getenv("UDUP_PRINT_TPS") != "",
+		insertMode:              insertMode,
+		nameMapper:              sql.NewNameMapperFromDataSources(cfg.ReplicateDoDb),
 	}
 	a.mtsManager = NewMtsManager(a.shutdownCh)
 	go a.mtsManager.LcUpdater()
@@ -278,7 +300,7 @@ func (a *Applier) MtsWorker(workerIndex int) {
 		case tx := <-a.applyBinlogMtsTxQueue:
 			a.logger.Debugf("mysql.applier: a binlogEntry MTS dequeue, worker: %v. GNO: %v",
 				workerIndex, tx.Coordinates.GNO)
-			if err := a.ApplyBinlogEvent(workerIndex, tx); err != nil {
+			if err := a.applyBinlogEntry(workerIndex, tx); err != nil {
 				a.onError(TaskStateDead, err) // TODO coordinate with other goroutine
 				keepLoop = false
 			} else {
@@ -392,9 +414,13 @@ func (a *Applier) executeWriteFuncs() {
 				select {
 				case copyRows := <-a.copyRowsQueue:
 					if nil != copyRows {
-						if err := a.ApplyEventQueries(a.db, copyRows); err != nil {
-							a.onError(TaskStateDead, err)
+						a.applyLock.Lock()
+						if !a.shutdown {
+							if err := a.ApplyEventQueries(a.db, copyRows); err != nil {
+								a.onError(TaskStateDead, err)
+							}
 						}
+						a.applyLock.Unlock()
 					}
 				case <-a.rowCopyComplete:
 					stopLoop = true
@@ -436,6 +462,11 @@ func (a *Applier) executeWriteFuncs() {
 			if len(groupTx) == 0 {
 				continue
 			}
+			a.applyLock.Lock()
+			if a.shutdown {
+				a.applyLock.Unlock()
+				continue
+			}
 			for idx, binlogTx := range groupTx {
 				dbApplier = a.dbs[idx%a.mysqlContext.ParallelWorkers]
 				go func(tx *binlog.BinlogTx) {
@@ -452,6 +483,7 @@ func (a *Applier) executeWriteFuncs() {
 				a.lastAppliedBinlogTx = groupTx[len(groupTx)-1]
 				a.mysqlContext.Gtid = fmt.Sprintf("%s:1-%d", a.lastAppliedBinlogTx.SID, a.lastAppliedBinlogTx.GNO)
 			}
+			a.applyLock.Unlock()
 		case <-time.After(1 * time.Second):
 			// do nothing
 		}
@@ -480,6 +512,22 @@ func Decode(data []byte, vPtr interface{}) (err error) {
 	return gob.NewDecoder(bytes.NewBuffer(msg)).Decode(vPtr)
 }
 
+// versionColumn returns the configured VersionColumn of a source table, or ""
+// if it has none.
+func (a *Applier) versionColumn(schema, table string) string {
+	for _, dataSource := range a.mysqlContext.ReplicateDoDb {
+		if dataSource.TableSchema != schema {
+			continue
+		}
+		for _, t := range dataSource.Tables {
+			if t.TableName == table {
+				return t.VersionColumn
+			}
+		}
+	}
+	return ""
+}
+
 func (a *Applier) setTableItemForBinlogEntry(binlogEntry *binlog.BinlogEntry) error {
 	var err error
 	for i := range binlogEntry.Events {
@@ -495,6 +543,21 @@ func (a *Applier) setTableItemForBinlogEntry(binlogEntry *binlog.BinlogEntry) er
 				if err != nil {
 					return err
 				}
+				// the rows name the columns as the source does
+				tableItem.columns, err = a.nameMapper.SourceColumns(dmlEvent.DatabaseName, dmlEvent.TableName, tableItem.columns)
+				if err != nil {
+					return err
+				}
+				tableItem.columns.VersionColumn = a.versionColumn(dmlEvent.DatabaseName, dmlEvent.TableName)
+				if dmlEvent.Table != nil && dmlEvent.Table.OriginalTableColumns != nil {
+					// rows are in the column order of the source table, which
+					// may differ from the target's
+					tableItem.columns.SourceOrdinals = dmlEvent.Table.OriginalTableColumns.Ordinals
+				}
+				tableItem.mappedColumns, err = a.nameMapper.MapColumns(dmlEvent.DatabaseName, dmlEvent.TableName, tableItem.columns)
+				if err != nil {
+					return err
+				}
 			} else {
 				a.logger.Debugf("mysql.applier: reuse tableColumns %v.%v", dmlEvent.DatabaseName, dmlEvent.TableName)
 			}
@@ -510,6 +573,9 @@ func (a *Applier) initiateStreaming() error {
 		a.mysqlContext.MarkRowCopyStartTime()
 		a.logger.Debugf("mysql.applier: nats subscribe")
 		_, err := a.natsConn.Subscribe(fmt.Sprintf("%s_full", a.subject), func(m *gonats.Msg) {
+			if atomic.LoadInt64(&a.paused) == 1 {
+				return
+			}
 			a.logger.Debugf("mysql.applier: recv a msg")
 			dumpData := &DumpEntry{}
 			if err := Decode(m.Data, dumpData); err != nil {
@@ -529,16 +595,24 @@ func (a *Applier) initiateStreaming() error {
 		}*/
 
 		_, err = a.natsConn.Subscribe(fmt.Sprintf("%s_full_complete", a.subject), func(m *gonats.Msg) {
+			if atomic.LoadInt64(&a.paused) == 1 {
+				return
+			}
 			dumpData := &dumpStatResult{}
 			if err := Decode(m.Data, dumpData); err != nil {
 				a.onError(TaskStateDead, err)
 			}
-			a.currentCoordinates.RetrievedGtidSet = dumpData.Gtid
-			a.mysqlContext.Stage = models.StageSlaveWaitingForWorkersToProcessQueue
 			if err := a.natsConn.Publish(m.Reply, nil); err != nil {
 				a.onError(TaskStateDead, err)
 			}
 			atomic.AddInt64(&a.mysqlContext.TotalRowsCopied, dumpData.TotalCount)
+			if dumpData.Partial {
+				// one of the copies of a split full copy, the first of which
+				// completes last
+				return
+			}
+			a.currentCoordinates.RetrievedGtidSet = dumpData.Gtid
+			a.mysqlContext.Stage = models.StageSlaveWaitingForWorkersToProcessQueue
 			atomic.StoreInt64(&a.rowCopyCompleteFlag, 1)
 		})
 		if err != nil {
@@ -548,6 +622,9 @@ func (a *Applier) initiateStreaming() error {
 
 	if a.mysqlContext.ApproveHeterogeneous {
 		_, err := a.natsConn.Subscribe(fmt.Sprintf("%s_incr_hete", a.subject), func(m *gonats.Msg) {
+			if atomic.LoadInt64(&a.paused) == 1 {
+				return
+			}
 			var binlogEntries binlog.BinlogEntries
 			if err := Decode(m.Data, &binlogEntries); err != nil {
 				a.onError(TaskStateDead, err)
@@ -567,7 +644,12 @@ func (a *Applier) initiateStreaming() error {
 				}
 				a.mysqlContext.Stage = models.StageWaitingForMasterToSendEvent
 
-				if err := a.natsConn.Publish(m.Reply, nil); err != nil {
+				// The ack tells the extractor the delay, which it slows down by.
+				var ack []byte
+				if delay, ok := a.replicationDelay(); ok {
+					ack = []byte(strconv.FormatUint(delay, 10))
+				}
+				if err := a.natsConn.Publish(m.Reply, ack); err != nil {
 					a.onError(TaskStateDead, err)
 				}
 				a.logger.Debugf("applier. incr. ack-recv. nEntries: %v", len(binlogEntries.Entries))
@@ -690,7 +772,7 @@ func (a *Applier) initiateStreaming() error {
 							a.onError(TaskStateDead, err)
 							return
 						}
-						if err := a.ApplyBinlogEvent(0, binlogEntry); err != nil {
+						if err := a.applyBinlogEntry(0, binlogEntry); err != nil {
 							a.onError(TaskStateDead, err)
 							return
 						}
@@ -765,6 +847,9 @@ func (a *Applier) initiateStreaming() error {
 		}()
 	} else {
 		_, err := a.natsConn.Subscribe(fmt.Sprintf("%s_incr", a.subject), func(m *gonats.Msg) {
+			if atomic.LoadInt64(&a.paused) == 1 {
+				return
+			}
 			var binlogTx []*binlog.BinlogTx
 			if err := Decode(m.Data, &binlogTx); err != nil {
 				a.onError(TaskStateDead, err)
@@ -864,6 +949,9 @@ func (a *Applier) initDBConnections() (err error) {
 		return err
 	}
 	a.logger.Debugf("mysql.applier. after validateAndReadTimeZone")
+	if err := a.readLowerCaseTableNames(); err != nil {
+		return err
+	}
 
 	if a.mysqlContext.ApproveHeterogeneous {
 		if err := a.createTableGtidExecutedV2(); err != nil {
@@ -985,6 +1073,17 @@ func (a *Applier) validateAndReadTimeZone() error {
 	return nil
 }
 
+// readLowerCaseTableNames reads how the server stores table names, so that
+// the names written to match it.
+func (a *Applier) readLowerCaseTableNames() error {
+	query := `select @@global.lower_case_table_names`
+	if err := a.db.QueryRow(query).Scan(&a.mysqlContext.LowerCaseTableNames); err != nil {
+		return err
+	}
+	a.nameMapper.SetLowerCaseTableNames(a.mysqlContext.LowerCaseTableNames)
+	return nil
+}
+
 func (a *Applier) createTableGtidExecutedV2() error {
 	if result, err := sql.QueryResultData(a.db, fmt.Sprintf("SHOW TABLES FROM %v LIKE '%v'",
 		g.DtleSchemaName, g.GtidExecutedTableV2)); nil == err && len(result) > 0 {
@@ -1033,15 +1132,22 @@ func (a *Applier) getTableItem(schema string, table string) *applierTableItem {
 
 // buildDMLEventQuery creates a query to operate on the ghost table, based on an intercepted binlog
 // event entry on the original table.
-func (a *Applier) buildDMLEventQuery(dmlEvent binlog.DataEvent, workerIdx int) (query *gosql.Stmt, args []interface{}, rowsDelta int64, err error) {
+// The statement, of text querySQL, is cached on the table item if cached is
+// true. Otherwise the caller closes it after use.
+func (a *Applier) buildDMLEventQuery(dmlEvent binlog.DataEvent, workerIdx int) (query *gosql.Stmt, querySQL string, cached bool, args []interface{}, argColumns []string, rowsDelta int64, err error) {
 	// Large piece of code deleted here. See git annotate.
 	tableItem := dmlEvent.TableItem.(*applierTableItem)
 	var tableColumns = tableItem.columns
+	var mappedColumns = tableItem.mappedColumns
+	databaseName, tableName := a.nameMapper.Map(dmlEvent.DatabaseName, dmlEvent.TableName)
 
-	doPrepareIfNil := func(stmts []*gosql.Stmt, query string) (*gosql.Stmt, error) {
+	doPrepareIfNil := func(stmts []*gosql.Stmt, ps *sql.PreparedStmt) (*gosql.Stmt, error) {
+		if !ps.Reusable {
+			return a.dbs[workerIdx].Db.PrepareContext(context.Background(), ps.SQL)
+		}
 		var err error
 		if stmts[workerIdx] == nil {
-			stmts[workerIdx], err = a.dbs[workerIdx].Db.PrepareContext(context.Background(), query)
+			stmts[workerIdx], err = a.dbs[workerIdx].Db.PrepareContext(context.Background(), ps.SQL)
 		}
 		return stmts[workerIdx], err
 	}
@@ -1049,47 +1155,64 @@ func (a *Applier) buildDMLEventQuery(dmlEvent binlog.DataEvent, workerIdx int) (
 	switch dmlEvent.DML {
 	case binlog.DeleteDML:
 		{
-			query, uniqueKeyArgs, err := sql.BuildDMLDeleteQuery(dmlEvent.DatabaseName, dmlEvent.TableName, tableColumns, dmlEvent.WhereColumnValues.GetAbstractValues())
+			query, uniqueKeyArgs, err := sql.BuildDMLDeleteQueryAST(sql.MySQLDialect{}, databaseName, tableName, mappedColumns, dmlEvent.WhereColumnValues.GetAbstractValues())
 			if err != nil {
-				return nil, nil, -1, err
+				return nil, "", false, nil, nil, -1, err
 			}
-			stmt, err := doPrepareIfNil(tableItem.psDelete, query)
+			query.Comment = a.mysqlContext.QueryComment
+			ps := query.Prepared()
+			stmt, err := doPrepareIfNil(tableItem.psDelete, ps)
 			if err != nil {
-				return nil, nil, -1, err
+				return nil, "", false, nil, nil, -1, err
 			}
-			return stmt, uniqueKeyArgs, -1, err
+			return stmt, ps.SQL, ps.Reusable, uniqueKeyArgs, ps.ArgColumns, -1, err
 		}
 	case binlog.InsertDML:
 		{
 			// TODO no need to generate query string every time
-			query, sharedArgs, err := sql.BuildDMLInsertQuery(dmlEvent.DatabaseName, dmlEvent.TableName, tableColumns, tableColumns, tableColumns, dmlEvent.NewColumnValues.GetAbstractValues())
+			query, sharedArgs, err := sql.BuildDMLInsertQueryAST(sql.MySQLDialect{}, databaseName, tableName, tableColumns, tableColumns, mappedColumns, dmlEvent.NewColumnValues.GetAbstractValues(), a.insertMode)
 			if err != nil {
-				return nil, nil, -1, err
+				return nil, "", false, nil, nil, -1, err
 			}
-			stmt, err := doPrepareIfNil(tableItem.psInsert, query)
+			query.Comment = a.mysqlContext.QueryComment
+			ps := query.Prepared()
+			stmt, err := doPrepareIfNil(tableItem.psInsert, ps)
 			if err != nil {
-				return nil, nil, -1, err
+				return nil, "", false, nil, nil, -1, err
 			}
-			return stmt, sharedArgs, 1, err
+			return stmt, ps.SQL, ps.Reusable, sharedArgs, ps.ArgColumns, 1, err
 		}
 	case binlog.UpdateDML:
 		{
-			query, sharedArgs, uniqueKeyArgs, err := sql.BuildDMLUpdateQuery(dmlEvent.DatabaseName, dmlEvent.TableName, tableColumns, tableColumns, tableColumns, tableColumns, dmlEvent.NewColumnValues.GetAbstractValues(), dmlEvent.WhereColumnValues.GetAbstractValues())
+			query, sharedArgs, uniqueKeyArgs, err := sql.BuildDMLUpdateQueryAST(sql.MySQLDialect{}, databaseName, tableName, tableColumns, tableColumns, mappedColumns, tableColumns, dmlEvent.NewColumnValues.GetAbstractValues(), dmlEvent.WhereColumnValues.GetAbstractValues())
 			if err != nil {
-				return nil, nil, -1, err
+				return nil, "", false, nil, nil, -1, err
 			}
 			args = append(args, sharedArgs...)
 			args = append(args, uniqueKeyArgs...)
 
-			stmt, err := doPrepareIfNil(tableItem.psUpdate, query)
+			query.Comment = a.mysqlContext.QueryComment
+			ps := query.Prepared()
+			stmt, err := doPrepareIfNil(tableItem.psUpdate, ps)
 			if err != nil {
-				return nil, nil, -1, err
+				return nil, "", false, nil, nil, -1, err
 			}
 
-			return stmt, args, 0, err
+			return stmt, ps.SQL, ps.Reusable, args, ps.ArgColumns, 0, err
 		}
 	}
-	return nil, args, 0, fmt.Errorf("Unknown dml event type: %+v", dmlEvent.DML)
+	return nil, "", false, args, nil, 0, fmt.Errorf("Unknown dml event type: %+v", dmlEvent.DML)
+}
+
+// applyBinlogEntry applies a binlog transaction with ApplyBinlogEvent unless
+// the applier is shut down, for Drain to wait for.
+func (a *Applier) applyBinlogEntry(workerIdx int, binlogEntry *binlog.BinlogEntry) error {
+	a.applyLock.RLock()
+	defer a.applyLock.RUnlock()
+	if a.shutdown {
+		return nil
+	}
+	return a.ApplyBinlogEvent(workerIdx, binlogEntry)
 }
 
 // ApplyEventQueries applies multiple DML queries onto the dest table
@@ -1106,11 +1229,14 @@ func (a *Applier) ApplyBinlogEvent(workerIdx int, binlogEntry *binlog.BinlogEntr
 	if err != nil {
 		return err
 	}
+	var captured []capturedStatement
 	defer func() {
 		if err := tx.Commit(); err != nil {
 			a.onError(TaskStateDead, err)
 		} else {
+			a.flushCaptured(captured)
 			a.mtsManager.Executed(binlogEntry)
+			atomic.StoreInt64(&a.appliedTimestamp, int64(binlogEntry.Coordinates.Timestamp))
 		}
 		if a.printTps {
 			atomic.AddUint32(&a.txLastNSeconds, 1)
@@ -1140,6 +1266,7 @@ func (a *Applier) ApplyBinlogEvent(workerIdx int, binlogEntry *binlog.BinlogEntr
 						a.logger.Warnf("mysql.applier: Ignore error: %v", err)
 					}
 				}
+				a.capture(&captured, query)
 			}
 
 			if event.TableName != "" {
@@ -1172,10 +1299,11 @@ func (a *Applier) ApplyBinlogEvent(workerIdx int, binlogEntry *binlog.BinlogEntr
 					a.logger.Warnf("mysql.applier: Ignore error: %v", err)
 				}
 			}
+			a.capture(&captured, event.Query)
 			a.logger.Debugf("mysql.applier: Exec [%s]", event.Query)
 		default:
 			a.logger.Debugf("mysql.applier: ApplyBinlogEvent: a dml event")
-			stmt, args, rowDelta, err := a.buildDMLEventQuery(event, workerIdx)
+			stmt, querySQL, cached, args, argColumns, rowDelta, err := a.buildDMLEventQuery(event, workerIdx)
 			if err != nil {
 				a.logger.Errorf("mysql.applier: Build dml query error: %v", err)
 				return err
@@ -1184,10 +1312,15 @@ func (a *Applier) ApplyBinlogEvent(workerIdx int, binlogEntry *binlog.BinlogEntr
 			a.logger.Debugf("ApplyBinlogEvent. args: %v", args)
 
 			_, err = stmt.Exec(args...)
+			if !cached {
+				stmt.Close()
+			}
 			if err != nil {
-				a.logger.Errorf("mysql.applier: gtid: %s:%d, error: %v", txSid, binlogEntry.Coordinates.GNO, err)
+				a.logger.Errorf("mysql.applier: gtid: %s:%d, error: %v, args: %s", txSid, binlogEntry.Coordinates.GNO, err,
+					sql.FormatArgs(argColumns, args))
 				return err
 			}
+			a.capture(&captured, querySQL, args...)
 			totalDelta += rowDelta
 		}
 	}
@@ -1212,9 +1345,12 @@ func (a *Applier) ApplyEventQueries(db *gosql.DB, entry *DumpEntry) error {
 	if err != nil {
 		return err
 	}
+	var captured []capturedStatement
 	defer func() {
 		if err := tx.Commit(); err != nil {
 			a.onError(TaskStateDead, err)
+		} else {
+			a.flushCaptured(captured)
 		}
 		atomic.AddInt64(&a.mysqlContext.TotalRowsReplay, entry.RowsCount)
 	}()
@@ -1222,6 +1358,7 @@ func (a *Applier) ApplyEventQueries(db *gosql.DB, entry *DumpEntry) error {
 	if _, err := tx.Exec(sessionQuery); err != nil {
 		return err
 	}
+	a.capture(&captured, sessionQuery)
 	execQuery := func(query string) error {
 		a.logger.Debugf("mysql.applier: Exec [%s]", utils.StrLim(query, 256))
 		_, err := tx.Exec(query)
@@ -1234,6 +1371,7 @@ func (a *Applier) ApplyEventQueries(db *gosql.DB, entry *DumpEntry) error {
 				a.logger.Warnf("mysql.applier: Ignore error: %v", err)
 			}
 		}
+		a.capture(&captured, query)
 		return nil
 	}
 
@@ -1247,13 +1385,38 @@ func (a *Applier) ApplyEventQueries(db *gosql.DB, entry *DumpEntry) error {
 		}
 	}
 
+	databaseName, tableName := a.nameMapper.Map(entry.TableSchema, entry.TableName)
+	// the ranges and checksums of chunks name the columns as the source does
+	if entry.Checksum != nil && entry.Table != nil && !a.mysqlContext.SkipResumeVerify &&
+		len(a.nameMapper.ColumnRenames(entry.TableSchema, entry.TableName)) == 0 {
+		copied, err := a.verifyChunk(tx, databaseName, tableName, entry, execQuery)
+		if err != nil || copied {
+			return err
+		}
+	}
+	onDuplicateClause := ""
+	if a.insertMode == sql.InsertModeOnDuplicateUpdate {
+		if entry.Table == nil || entry.Table.OriginalTableColumns == nil {
+			return fmt.Errorf("insert mode 'update' needs the columns of %s.%s", entry.TableSchema, entry.TableName)
+		}
+		mappedColumns, err := a.nameMapper.MapColumns(entry.TableSchema, entry.TableName, entry.Table.OriginalTableColumns)
+		if err != nil {
+			return err
+		}
+		onDuplicateClause, err = sql.BuildOnDuplicateUpdateClause(mappedColumns)
+		if err != nil {
+			return err
+		}
+	}
+
 	var buf bytes.Buffer
 	BufSizeLimit := 1 * 1024 * 1024 // 1MB. TODO parameterize it
 	BufSizeLimitDelta := 1024
 	buf.Grow(BufSizeLimit + BufSizeLimitDelta)
 	for i, _ := range entry.ValuesX {
 		if buf.Len() == 0 {
-			buf.WriteString(fmt.Sprintf(`replace into %s.%s values (`, entry.TableSchema, entry.TableName))
+			buf.WriteString(fmt.Sprintf(`%s %s values (`, a.insertMode.Verb(),
+				sql.EscapeQualifiedName(databaseName, tableName)))
 		} else {
 			buf.WriteString(",(")
 		}
@@ -1281,6 +1444,10 @@ func (a *Applier) ApplyEventQueries(db *gosql.DB, entry *DumpEntry) error {
 		// last rows or sql too large
 
 		if needInsert {
+			if onDuplicateClause != "" {
+				buf.WriteByte(' ')
+				buf.WriteString(onDuplicateClause)
+			}
 			err := execQuery(buf.String())
 			buf.Reset()
 			if err != nil {
@@ -1292,6 +1459,55 @@ func (a *Applier) ApplyEventQueries(db *gosql.DB, entry *DumpEntry) error {
 	return nil
 }
 
+// verifyChunk compares a chunk of a full copy with its range on the target,
+// which has rows if an earlier copy got there before it was restarted. It
+// tells whether the target has the same rows already, so that the chunk is
+// skipped. If the target has other rows in the range, they are deleted with
+// exec, for the chunk to be copied again.
+func (a *Applier) verifyChunk(tx *gosql.Tx, databaseName, tableName string, entry *DumpEntry, exec func(query string) error) (bool, error) {
+	query, err := sql.BuildRangeChecksumQuery(databaseName, tableName, entry.Table.OriginalTableColumns, entry.ChunkRange)
+	if err != nil {
+		return false, err
+	}
+	var target ChunkChecksum
+	if err := tx.QueryRow(query).Scan(&target.Count, &target.Sum); err != nil {
+		return false, fmt.Errorf("exec [%s] error: %v", query, err)
+	}
+	switch {
+	case target.Count == 0:
+		return false, nil
+	case target == *entry.Checksum:
+		a.logger.Debugf("mysql.applier: Chunk of %s.%s is on the target already, skipping it", databaseName, tableName)
+		return true, nil
+	}
+	a.logger.Warnf("mysql.applier: Chunk of %s.%s differs on the target, copying it again", databaseName, tableName)
+	return false, exec(fmt.Sprintf("delete from %s where %s", sql.EscapeQualifiedName(databaseName, tableName), entry.ChunkRange))
+}
+
+// capturedStatement is a statement the applier ran on the target, with its
+// args.
+type capturedStatement struct {
+	query string
+	args  []interface{}
+}
+
+// capture adds a statement the applier ran in a transaction to captured, if
+// the task has a Capture. flushCaptured passes them to it once the
+// transaction commits, so that those rolled back are not captured.
+func (a *Applier) capture(captured *[]capturedStatement, query string, args ...interface{}) {
+	if a.mysqlContext.Capture != nil {
+		*captured = append(*captured, capturedStatement{query: query, args: args})
+	}
+}
+
+// flushCaptured passes the statements of a committed transaction to the
+// Capture of the task.
+func (a *Applier) flushCaptured(captured []capturedStatement) {
+	for _, statement := range captured {
+		a.mysqlContext.Capture(statement.query, statement.args)
+	}
+}
+
 func (a *Applier) Stats() (*models.TaskStatistics, error) {
 	totalRowsReplay := a.mysqlContext.GetTotalRowsReplay()
 	rowsEstimate := atomic.LoadInt64(&a.mysqlContext.RowsEstimate)
@@ -1349,6 +1565,12 @@ func (a *Applier) Stats() (*models.TaskStatistics, error) {
 		},
 		Timestamp: time.Now().UTC().UnixNano(),
 	}
+	if delay, ok := a.replicationDelay(); ok {
+		taskResUsage.DelayCount = &models.DelayCount{
+			Num:  uint64(len(a.applyDataEntryQueue) + len(a.applyBinlogMtsTxQueue)),
+			Time: delay,
+		}
+	}
 	if a.natsConn != nil {
 		taskResUsage.MsgStat = a.natsConn.Statistics
 	}
@@ -1356,6 +1578,25 @@ func (a *Applier) Stats() (*models.TaskStatistics, error) {
 	return &taskResUsage, nil
 }
 
+// replicationDelay returns how many seconds the target is behind the source:
+// the time since the last transaction applied was committed on the source,
+// or zero if there are none waiting to be applied. It returns false until a
+// transaction is applied.
+func (a *Applier) replicationDelay() (uint64, bool) {
+	applied := atomic.LoadInt64(&a.appliedTimestamp)
+	if applied == 0 {
+		return 0, false
+	}
+	if len(a.applyDataEntryQueue) == 0 && len(a.applyBinlogMtsTxQueue) == 0 {
+		return 0, true
+	}
+	delay := time.Now().Unix() - applied
+	if delay < 0 {
+		return 0, true
+	}
+	return uint64(delay), true
+}
+
 func (a *Applier) ID() string {
 	id := config.DriverCtx{
 		DriverConfig: &config.MySQLDriverConfig{
@@ -1404,6 +1645,53 @@ func (a *Applier) WaitCh() chan *models.WaitResult {
 	return a.waitCh
 }
 
+// Throttle does nothing, the extractor sets the pace
+func (a *Applier) Throttle(factor float64) {
+}
+
+// Checkpoint returns the GTID set applied so far
+func (a *Applier) Checkpoint() []byte {
+	return []byte(a.mysqlContext.Gtid)
+}
+
+// PoolStats returns the state of the pool of connections to the target. The
+// connections of the apply workers are held for good, so they are always in
+// use.
+func (a *Applier) PoolStats() *models.PoolStats {
+	if a.db == nil {
+		return nil
+	}
+	stats := a.db.Stats()
+	return &models.PoolStats{
+		MaxOpen: stats.MaxOpenConnections,
+		InUse:   stats.InUse,
+		Idle:    stats.Idle,
+	}
+}
+
+// Pause stops acking messages from the extractor until Resume is called
+func (a *Applier) Pause() error {
+	atomic.StoreInt64(&a.paused, 1)
+	a.logger.Printf("mysql.applier: Paused")
+	return nil
+}
+
+func (a *Applier) Resume() error {
+	atomic.StoreInt64(&a.paused, 0)
+	a.logger.Printf("mysql.applier: Resumed")
+	return nil
+}
+
+// Drain stops acking new messages, waits for the chunk or transactions being
+// applied to commit and then shuts the applier down.
+func (a *Applier) Drain() error {
+	atomic.StoreInt64(&a.paused, 1)
+	a.applyLock.Lock()
+	defer a.applyLock.Unlock()
+	a.logger.Printf("mysql.applier: Drained")
+	return a.Shutdown()
+}
+
 func (a *Applier) Shutdown() error {
 	a.shutdownLock.Lock()
 	defer a.shutdownLock.Unlock()
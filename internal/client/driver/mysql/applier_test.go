@@ -7,13 +7,20 @@
 package mysql
 
 import (
+	"context"
 	gosql "database/sql"
+	sqldriver "database/sql/driver"
+	"errors"
+	"io/ioutil"
 	"reflect"
+	"strings"
 	"sync"
 	"testing"
+	"time"
 	"github.com/actiontech/dtle/internal/client/driver/mysql/binlog"
 	"github.com/actiontech/dtle/internal/client/driver/mysql/sql"
 	"github.com/actiontech/dtle/internal/config"
+	umconf "github.com/actiontech/dtle/internal/config/mysql"
 	log "github.com/actiontech/dtle/internal/logger"
 	"github.com/actiontech/dtle/internal/models"
 
@@ -299,6 +306,337 @@ func TestApplier_ApplyEventQueries(t *testing.T) {
 	}
 }
 
+// chunkServer is a database/sql connector serving rows, under names if set,
+// which answers checksum queries with checksum. It records the queries and
+// statements run and prepared on it.
+type chunkServer struct {
+	names    []string
+	rows     [][]sqldriver.Value
+	checksum ChunkChecksum
+
+	queries  []string
+	execs    []string
+	prepared []string
+	// release, if set, holds the statements in flight until it is closed.
+	// started is signalled as they start.
+	release chan struct{}
+	started chan struct{}
+	// commitErr, if set, fails the commits.
+	commitErr error
+}
+
+func (s *chunkServer) Connect(context.Context) (sqldriver.Conn, error) {
+	return &chunkConn{s}, nil
+}
+
+func (s *chunkServer) Driver() sqldriver.Driver {
+	return nil
+}
+
+type chunkConn struct {
+	server *chunkServer
+}
+
+func (c *chunkConn) Prepare(query string) (sqldriver.Stmt, error) {
+	c.server.prepared = append(c.server.prepared, query)
+	return &chunkStmt{c, query}, nil
+}
+
+func (c *chunkConn) Close() error {
+	return nil
+}
+
+func (c *chunkConn) Begin() (sqldriver.Tx, error) {
+	return c, nil
+}
+
+func (c *chunkConn) Commit() error {
+	return c.server.commitErr
+}
+
+func (c *chunkConn) Rollback() error {
+	return nil
+}
+
+func (c *chunkConn) Exec(query string, args []sqldriver.Value) (sqldriver.Result, error) {
+	if c.server.release != nil {
+		select {
+		case c.server.started <- struct{}{}:
+		default:
+		}
+		<-c.server.release
+	}
+	c.server.execs = append(c.server.execs, query)
+	return sqldriver.RowsAffected(1), nil
+}
+
+func (c *chunkConn) Query(query string, args []sqldriver.Value) (sqldriver.Rows, error) {
+	c.server.queries = append(c.server.queries, query)
+	if !strings.HasPrefix(query, "select count(*), coalesce(bit_xor(") {
+		return &valueRows{names: c.server.names, values: c.server.rows}, nil
+	}
+	return &valueRows{values: [][]sqldriver.Value{{c.server.checksum.Count, int64(c.server.checksum.Sum)}}}, nil
+}
+
+type chunkStmt struct {
+	conn  *chunkConn
+	query string
+}
+
+func (s *chunkStmt) Close() error {
+	return nil
+}
+
+func (s *chunkStmt) NumInput() int {
+	return -1
+}
+
+func (s *chunkStmt) Exec(args []sqldriver.Value) (sqldriver.Result, error) {
+	return s.conn.Exec(s.query, args)
+}
+
+func (s *chunkStmt) Query(args []sqldriver.Value) (sqldriver.Rows, error) {
+	return s.conn.Query(s.query, args)
+}
+
+func TestApplier_captureCommitted(t *testing.T) {
+	for _, commitErr := range []error{nil, errors.New("lock wait timeout")} {
+		server := &chunkServer{commitErr: commitErr}
+		db := gosql.OpenDB(server)
+		defer db.Close()
+		var captured []string
+		a, err := NewApplier("1c4a9b9a-6c81-4b6e-9d2c-0d8f2d1c1f3e", "dest", &config.MySQLDriverConfig{
+			ConnectionConfig: &umconf.ConnectionConfig{},
+			Capture: func(query string, args []interface{}) {
+				captured = append(captured, query)
+			},
+		}, log.New(ioutil.Discard, log.ErrorLevel))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer a.Shutdown()
+		if err := a.ApplyEventQueries(db, &DumpEntry{DbSQL: "create database if not exists `db`"}); err != nil {
+			t.Fatalf("ApplyEventQueries() error = %v", err)
+		}
+
+		// The statements are captured once they are committed, and not at
+		// all if their transaction fails to.
+		var want []string
+		if commitErr == nil {
+			want = []string{"SET @@session.foreign_key_checks = 0", "create database if not exists `db`"}
+		}
+		if !reflect.DeepEqual(captured, want) {
+			t.Errorf("captured %q with commit error %v, want %q", captured, commitErr, want)
+		}
+	}
+}
+
+func TestApplier_Drain(t *testing.T) {
+	server := &chunkServer{release: make(chan struct{}), started: make(chan struct{}, 1)}
+	db := gosql.OpenDB(server)
+	defer db.Close()
+	conn, err := db.Conn(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, err := NewApplier("1c4a9b9a-6c81-4b6e-9d2c-0d8f2d1c1f3e", "dest", &config.MySQLDriverConfig{ConnectionConfig: &umconf.ConnectionConfig{}},
+		log.New(ioutil.Discard, log.ErrorLevel))
+	if err != nil {
+		t.Fatal(err)
+	}
+	psInsertExecutedGtid, err := conn.PrepareContext(context.Background(), "insert into executed_gtid")
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.dbs = []*sql.Conn{{DbMutex: &sync.Mutex{}, Db: conn, PsInsertExecutedGtid: psInsertExecutedGtid}}
+	entry := &binlog.BinlogEntry{Events: []binlog.DataEvent{{DML: binlog.NotDML, Query: "create table t (id int)"}}}
+
+	applied := make(chan error, 1)
+	go func() {
+		applied <- a.applyBinlogEntry(0, entry)
+	}()
+	<-server.started
+	drained := make(chan error, 1)
+	go func() {
+		drained <- a.Drain()
+	}()
+
+	// The transaction in flight commits before the applier shuts down.
+	select {
+	case err := <-drained:
+		t.Fatalf("Drain() = %v with a transaction in flight", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+	close(server.release)
+	if err := <-applied; err != nil {
+		t.Errorf("applyBinlogEntry() error = %v", err)
+	}
+	if err := <-drained; err != nil {
+		t.Errorf("Drain() error = %v", err)
+	}
+
+	// Those after are skipped.
+	if err := a.applyBinlogEntry(0, entry); err != nil {
+		t.Errorf("applyBinlogEntry() error = %v after Drain", err)
+	}
+}
+
+func TestApplier_buildDMLEventQueryRenamedColumns(t *testing.T) {
+	server := &chunkServer{
+		names: []string{"Field", "Type", "Null", "Key"},
+		rows: [][]sqldriver.Value{
+			{"id", "int(11)", "NO", "PRI"},
+			{"user_id", "int(11)", "YES", ""},
+		},
+	}
+	db := gosql.OpenDB(server)
+	defer db.Close()
+	conn, err := db.Conn(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	a, err := NewApplier("1c4a9b9a-6c81-4b6e-9d2c-0d8f2d1c1f3e", "dest", &config.MySQLDriverConfig{
+		ConnectionConfig: &umconf.ConnectionConfig{},
+		ReplicateDoDb: []*config.DataSource{{
+			TableSchema: "db",
+			Tables:      []*config.Table{{TableSchema: "db", TableName: "tbl", ColumnRenames: map[string]string{"ID": "id", "UserId": "user_id"}}},
+		}},
+	}, log.New(ioutil.Discard, log.ErrorLevel))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Shutdown()
+	a.db = db
+	a.dbs = []*sql.Conn{{Db: conn}}
+
+	// the rows are in the column order of the source, which differs from the target's
+	source := &config.Table{TableSchema: "db", TableName: "tbl", OriginalTableColumns: umconf.NewColumnList(umconf.NewColumns([]string{"UserId", "ID"}))}
+	newEvent := func(dml binlog.EventDML, where, values []interface{}) binlog.DataEvent {
+		event := binlog.NewDataEvent("db", "tbl", dml, 2)
+		event.Table = source
+		if where != nil {
+			event.WhereColumnValues = umconf.ToColumnValues(where)
+		}
+		if values != nil {
+			event.NewColumnValues = umconf.ToColumnValues(values)
+		}
+		return event
+	}
+	entry := &binlog.BinlogEntry{Events: []binlog.DataEvent{
+		newEvent(binlog.InsertDML, nil, []interface{}{17, 3}),
+		newEvent(binlog.UpdateDML, []interface{}{17, 3}, []interface{}{18, 3}),
+		newEvent(binlog.DeleteDML, []interface{}{18, 3}, nil),
+	}}
+	if err := a.setTableItemForBinlogEntry(entry); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		query string
+		args  []interface{}
+	}{
+		{"replace into `db`.`tbl` (`id`, `user_id`) values (?, ?)", []interface{}{3, 17}},
+		{"update `db`.`tbl` set `id`=?, `user_id`=? where ((`id` = ?)) limit 1", []interface{}{3, 18, 3}},
+		{"delete from `db`.`tbl` where ((`id` = ?))", []interface{}{3}},
+	}
+	for i, tt := range tests {
+		_, querySQL, _, args, _, _, err := a.buildDMLEventQuery(entry.Events[i], 0)
+		if err != nil {
+			t.Fatalf("buildDMLEventQuery(%v) error = %v", entry.Events[i].DML, err)
+		}
+		if querySQL != tt.query {
+			t.Errorf("buildDMLEventQuery(%v) = %s, want %s", entry.Events[i].DML, querySQL, tt.query)
+		}
+		if !reflect.DeepEqual(args, tt.args) {
+			t.Errorf("buildDMLEventQuery(%v) args = %v, want %v", entry.Events[i].DML, args, tt.args)
+		}
+	}
+}
+
+func TestApplier_verifyChunk(t *testing.T) {
+	chunkRange := "(true) and (true) and not (((`id` > 2)))"
+	tests := []struct {
+		name       string
+		target     ChunkChecksum
+		wantDelete bool
+		wantInsert bool
+	}{
+		{"not copied yet", ChunkChecksum{}, false, true},
+		{"copied", ChunkChecksum{Count: 2, Sum: 42}, false, false},
+		{"differs", ChunkChecksum{Count: 3, Sum: 7}, true, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, err := NewApplier("1c4a9b9a-6c81-4b6e-9d2c-0d8f2d1c1f3e", "dest", &config.MySQLDriverConfig{ConnectionConfig: &umconf.ConnectionConfig{}},
+				log.New(ioutil.Discard, log.ErrorLevel))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer a.Shutdown()
+			server := &chunkServer{checksum: tt.target}
+			db := gosql.OpenDB(server)
+			defer db.Close()
+			id1, name1, id2, name2 := interface{}([]byte("1")), interface{}([]byte("a")), interface{}([]byte("2")), interface{}([]byte("b"))
+			entry := &DumpEntry{
+				TableSchema: "db",
+				TableName:   "tbl",
+				ValuesX:     [][]*interface{}{{&id1, &name1}, {&id2, &name2}},
+				RowsCount:   2,
+				Table:       &config.Table{OriginalTableColumns: umconf.NewColumnList(umconf.NewColumns([]string{"id", "name"}))},
+				ChunkRange:  chunkRange,
+				Checksum:    &ChunkChecksum{Count: 2, Sum: 42},
+			}
+
+			if err := a.ApplyEventQueries(db, entry); err != nil {
+				t.Fatalf("ApplyEventQueries() = %v", err)
+			}
+			var deleted, inserted bool
+			for i, query := range server.execs {
+				if query == "delete from `db`.`tbl` where "+chunkRange {
+					deleted = true
+					if inserted {
+						t.Errorf("deleted the chunk's range after copying it: %q", server.execs[i:])
+					}
+				}
+				inserted = inserted || strings.Contains(query, "`db`.`tbl` values ('1','a'),('2','b')")
+			}
+			if deleted != tt.wantDelete || inserted != tt.wantInsert {
+				t.Errorf("ran %q, want delete %v and insert %v", server.execs, tt.wantDelete, tt.wantInsert)
+			}
+		})
+	}
+}
+
+func TestApplier_replicationDelay(t *testing.T) {
+	a, err := NewApplier("1c4a9b9a-6c81-4b6e-9d2c-0d8f2d1c1f3e", "dest", &config.MySQLDriverConfig{ConnectionConfig: &umconf.ConnectionConfig{}},
+		log.New(ioutil.Discard, log.ErrorLevel))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Shutdown()
+	if _, ok := a.replicationDelay(); ok {
+		t.Error("replicationDelay() known before a transaction was applied")
+	}
+
+	// Caught up, there is no delay however long ago the last one was.
+	a.appliedTimestamp = time.Now().Add(-time.Hour).Unix()
+	if delay, ok := a.replicationDelay(); !ok || delay != 0 {
+		t.Errorf("replicationDelay() = %v, %v caught up, want 0", delay, ok)
+	}
+
+	// With transactions waiting, it is the time since the last one applied
+	// was committed.
+	a.applyDataEntryQueue <- &binlog.BinlogEntry{}
+	a.appliedTimestamp = time.Now().Add(-time.Minute).Unix()
+	if delay, ok := a.replicationDelay(); !ok || delay < 60 || delay > 65 {
+		t.Errorf("replicationDelay() = %v, %v, want about 60", delay, ok)
+	}
+	stats, err := a.Stats()
+	if err != nil || stats.DelayCount == nil || stats.DelayCount.Num != 1 || stats.DelayCount.Time < 60 {
+		t.Errorf("Stats() = %+v, %v, want the delay of 1 transaction", stats.DelayCount, err)
+	}
+}
+
 func TestApplier_Stats(t *testing.T) {
 	tests := []struct {
 		name    string
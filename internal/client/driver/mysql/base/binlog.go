@@ -34,6 +34,9 @@ type BinlogCoordinateTx struct {
 	GNO           int64
 	LastCommitted int64
 	SeqenceNumber int64
+	// Timestamp is when the transaction was committed on the source, in
+	// seconds since the epoch.
+	Timestamp uint32
 }
 
 // Do not call this frequently. Cache your result.
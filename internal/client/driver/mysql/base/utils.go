@@ -84,10 +84,9 @@ func ParseBinlogCoordinatesFromRows(rows *sql.Rows) (selfBinlogCoordinates *Binl
 // GetTableColumns reads column list from given table
 func GetTableColumns(db usql.QueryAble, databaseName, tableName string) (*umconf.ColumnList, error) {
 	query := fmt.Sprintf(`
-		show columns from %s.%s
+		show columns from %s
 		`,
-		usql.EscapeName(databaseName),
-		usql.EscapeName(tableName),
+		usql.EscapeQualifiedName(databaseName, tableName),
 	)
 	columns := []umconf.Column{}
 	err := usql.QueryRowsMap(db, query, func(rowMap usql.RowMap) error {
@@ -113,7 +112,7 @@ func GetTableColumns(db usql.QueryAble, databaseName, tableName string) (*umconf
 
 func ShowCreateTable(db *gosql.DB, databaseName, tableName string, dropTableIfExists bool) (statement []string, err error) {
 	var dummy, createTableStatement string
-	query := fmt.Sprintf(`show create table %s.%s`, usql.EscapeName(databaseName), usql.EscapeName(tableName))
+	query := usql.BuildShowCreateTable(databaseName, tableName)
 	err = db.QueryRow(query).Scan(&dummy, &createTableStatement)
 	statement = append(statement, fmt.Sprintf("USE %s", databaseName))
 	if dropTableIfExists {
@@ -125,7 +124,7 @@ func ShowCreateTable(db *gosql.DB, databaseName, tableName string, dropTableIfEx
 
 func ShowCreateView(db *gosql.DB, databaseName, tableName string, dropTableIfExists bool) (createTableStatement string, err error) {
 	var dummy, character_set_client, collation_connection string
-	query := fmt.Sprintf(`show create table %s.%s`, usql.EscapeName(databaseName), usql.EscapeName(tableName))
+	query := usql.BuildShowCreateTable(databaseName, tableName)
 	err = db.QueryRow(query).Scan(&dummy, &createTableStatement, &character_set_client, &collation_connection)
 	statement := fmt.Sprintf("USE %s", databaseName)
 	if dropTableIfExists {
@@ -236,6 +235,13 @@ func ApplyColumnTypes(db usql.QueryAble, databaseName, tableName string, columns
 	err := usql.QueryRowsMap(db, query, func(m usql.RowMap) error {
 		columnName := m.GetString("COLUMN_NAME")
 		columnType := m.GetString("COLUMN_TYPE")
+		// 8.0 also reports DEFAULT_GENERATED for columns with a default
+		// expression, which are written to like any other.
+		if extra := strings.ToUpper(m.GetString("EXTRA")); strings.Contains(extra, "VIRTUAL GENERATED") || strings.Contains(extra, "STORED GENERATED") {
+			for _, columnsList := range columnsLists {
+				columnsList.GetColumn(columnName).Generated = true
+			}
+		}
 		if strings.Contains(columnType, "unsigned") {
 			for _, columnsList := range columnsLists {
 				columnsList.SetUnsigned(columnName)
@@ -261,6 +267,7 @@ func ApplyColumnTypes(db usql.QueryAble, databaseName, tableName string, columns
 		if strings.HasPrefix(columnType, "enum") {
 			for _, columnsList := range columnsLists {
 				columnsList.GetColumn(columnName).Type = umconf.EnumColumnType
+				columnsList.GetColumn(columnName).ColumnType = columnType
 			}
 		}
 		if strings.HasPrefix(columnType, "binary") {
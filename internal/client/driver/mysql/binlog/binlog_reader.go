@@ -263,6 +263,7 @@ func (b *BinlogReader) handleEvent(ev *replication.BinlogEvent, entriesChannel c
 		b.currentCoordinates.GNO = evt.GNO
 		b.currentCoordinates.LastCommitted = evt.LastCommitted
 		b.currentCoordinates.SeqenceNumber = evt.SequenceNumber
+		b.currentCoordinates.Timestamp = ev.Header.Timestamp
 		b.currentBinlogEntry = NewBinlogEntryAt(b.currentCoordinates)
 	case replication.QUERY_EVENT:
 		evt := ev.Event.(*replication.QueryEvent)
@@ -0,0 +1,87 @@
+/*
+ * Copyright (C) 2016-2018. ActionTech.
+ * Based on: github.com/hashicorp/nomad, github.com/github/gh-ost .
+ * License: MPL version 2: https://www.mozilla.org/en-US/MPL/2.0 .
+ */
+
+package mysql
+
+import (
+	"time"
+)
+
+// chunkTargetTolerance is how far, as a fraction of the target, a chunk may
+// take from the target time before its size is changed.
+const chunkTargetTolerance = 0.2
+
+// chunkMaxResize bounds how much the size changes after one chunk, so that a
+// single slow or fast chunk doesn't swing it.
+const chunkMaxResize = 2.0
+
+// ChunkSizer tunes the chunk size of a dump so that reading a chunk takes
+// about Target, like the dynamic chunking of gh-ost. Rows of wide tables take
+// longer to read, so their chunks end up smaller.
+type ChunkSizer struct {
+	Target time.Duration
+	Min    int64
+	Max    int64
+
+	size int64
+}
+
+// NewChunkSizer returns a ChunkSizer starting at size. A min or max of 0
+// defaults to a tenth and ten times of size.
+func NewChunkSizer(size, min, max int64, target time.Duration) *ChunkSizer {
+	if min <= 0 {
+		min = size / 10
+	}
+	if min <= 0 {
+		min = 1
+	}
+	if max <= 0 {
+		max = size * 10
+	}
+	if max < min {
+		max = min
+	}
+	s := &ChunkSizer{Target: target, Min: min, Max: max, size: size}
+	s.size = s.clamp(size)
+	return s
+}
+
+// Next returns the size of the next chunk, given how long the previous one
+// took to read. A zero lastDuration, i.e. before the first chunk, keeps the
+// size.
+func (s *ChunkSizer) Next(lastDuration time.Duration) int64 {
+	if lastDuration <= 0 || s.Target <= 0 {
+		return s.size
+	}
+	low := time.Duration(float64(s.Target) * (1 - chunkTargetTolerance))
+	high := time.Duration(float64(s.Target) * (1 + chunkTargetTolerance))
+	if lastDuration >= low && lastDuration <= high {
+		return s.size
+	}
+
+	ratio := float64(s.Target) / float64(lastDuration)
+	if ratio > chunkMaxResize {
+		ratio = chunkMaxResize
+	} else if ratio < 1/chunkMaxResize {
+		ratio = 1 / chunkMaxResize
+	}
+	size := int64(float64(s.size) * ratio)
+	if ratio > 1 && size == s.size {
+		size++
+	}
+	s.size = s.clamp(size)
+	return s.size
+}
+
+func (s *ChunkSizer) clamp(size int64) int64 {
+	if size < s.Min {
+		return s.Min
+	}
+	if size > s.Max {
+		return s.Max
+	}
+	return size
+}
@@ -0,0 +1,67 @@
+/*
+ * Copyright (C) 2016-2018. ActionTech.
+ * Based on: github.com/hashicorp/nomad, github.com/github/gh-ost .
+ * License: MPL version 2: https://www.mozilla.org/en-US/MPL/2.0 .
+ */
+
+package mysql
+
+import (
+	"testing"
+	"time"
+)
+
+func TestChunkSizer_Next(t *testing.T) {
+	target := 100 * time.Millisecond
+	// rowTime is how long a row takes to read, so a chunk of n rows takes
+	// n*rowTime.
+	tests := []struct {
+		name    string
+		size    int64
+		rowTime time.Duration
+	}{
+		{"narrow rows grow the chunk", 1000, 10 * time.Microsecond},
+		{"wide rows shrink the chunk", 1000, time.Millisecond},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewChunkSizer(tt.size, 10, 100000, target)
+			if got := s.Next(0); got != tt.size {
+				t.Fatalf("Next(0) = %v, want the initial size %v", got, tt.size)
+			}
+			size := tt.size
+			for i := 0; i < 20; i++ {
+				size = s.Next(time.Duration(size) * tt.rowTime)
+			}
+			chunkTime := time.Duration(size) * tt.rowTime
+			if chunkTime < target*4/5 || chunkTime > target*6/5 {
+				t.Errorf("chunk of %v rows takes %v, want within 20%% of %v", size, chunkTime, target)
+			}
+			if size != s.Next(chunkTime) {
+				t.Errorf("Next() changed the size in the target band")
+			}
+		})
+	}
+}
+
+func TestChunkSizer_bounds(t *testing.T) {
+	s := NewChunkSizer(1000, 500, 1500, 100*time.Millisecond)
+	if got := s.Next(time.Second); got != 500 {
+		t.Errorf("Next() of a slow chunk = %v, want the min 500", got)
+	}
+	for i := 0; i < 10; i++ {
+		s.Next(time.Millisecond)
+	}
+	if got := s.Next(time.Millisecond); got != 1500 {
+		t.Errorf("Next() of fast chunks = %v, want the max 1500", got)
+	}
+
+	// A single outlier changes the size by at most 2x.
+	s = NewChunkSizer(1000, 0, 0, 100*time.Millisecond)
+	if got := s.Next(time.Microsecond); got != 2000 {
+		t.Errorf("Next() of an outlier = %v, want 2000", got)
+	}
+	if s.Min != 100 || s.Max != 10000 {
+		t.Errorf("default bounds = %v, %v, want 100, 10000", s.Min, s.Max)
+	}
+}
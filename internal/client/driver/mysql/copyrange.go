@@ -0,0 +1,293 @@
+/*
+ * Copyright (C) 2016-2018. ActionTech.
+ * Based on: github.com/hashicorp/nomad, github.com/github/gh-ost .
+ * License: MPL version 2: https://www.mozilla.org/en-US/MPL/2.0 .
+ */
+
+package mysql
+
+import (
+	gosql "database/sql"
+	"fmt"
+	"math/big"
+	"sync"
+
+	"github.com/actiontech/dtle/internal/client/driver/mysql/sql"
+	"github.com/actiontech/dtle/internal/config"
+	umconf "github.com/actiontech/dtle/internal/config/mysql"
+	log "github.com/actiontech/dtle/internal/logger"
+	"github.com/actiontech/dtle/internal/models"
+)
+
+// SplitKeyRanges splits the full copy of the tables of a src task into n
+// copies, each of a range of the first column of their unique keys, see
+// config.CopyRange. Only keys whose first column is an integer are split,
+// between the bounds of the key found by a scan of its index, or cached if
+// they still hold. The other tables are copied whole by the first copy. It
+// returns nil if no table can be split.
+func SplitKeyRanges(cfg *config.MySQLDriverConfig, n int, cached map[string]*models.KeyBounds, logger *log.Logger) ([]*config.CopyRange, error) {
+	e, err := NewExtractor("", models.TaskTypeSrc, 0, cfg, logger)
+	if err != nil {
+		return nil, err
+	}
+	defer e.Shutdown()
+	if err := e.initiateInspector(); err != nil {
+		return nil, err
+	}
+	defer sql.CloseDB(e.inspector.db)
+	if err := e.initDBConnections(); err != nil {
+		return nil, err
+	}
+
+	bounds := make(map[string]*models.KeyBounds)
+	where := make([]map[string]string, n)
+	for i := range where {
+		where[i] = make(map[string]string)
+	}
+	for _, db := range e.replicateDoDb {
+		for _, table := range db.Tables {
+			if table.UseUniqueKey == nil {
+				continue
+			}
+			name := sql.EscapeQualifiedName(table.TableSchema, table.TableName)
+			tableBounds, err := e.readKeyBounds(table, cached[name])
+			if err != nil {
+				return nil, err
+			}
+			if tableBounds == nil {
+				continue
+			}
+			bounds[name] = tableBounds
+			points := splitPoints(tableBounds.Min[0], tableBounds.Max[0], n)
+			if points == nil {
+				continue
+			}
+			column := sql.EscapeName(table.UseUniqueKey.Columns.Columns[0].Name)
+			for i, condition := range keyRangeConditions(column, points) {
+				where[i][name] = condition
+			}
+		}
+	}
+	if len(where[0]) == 0 {
+		return nil, nil
+	}
+
+	id := models.GenerateUUID()
+	ranges := make([]*config.CopyRange, n)
+	for i := range ranges {
+		ranges[i] = &config.CopyRange{ID: id, Index: i, Count: n, Where: where[i], Bounds: bounds}
+	}
+	return ranges, nil
+}
+
+// readKeyBounds returns the bounds of the unique key of a table: cached, if
+// they still hold, or else those read from its index. It returns nil for an
+// empty table.
+func (e *Extractor) readKeyBounds(table *config.Table, cached *models.KeyBounds) (*models.KeyBounds, error) {
+	keyColumns := &table.UseUniqueKey.Columns
+	if cached != nil && len(cached.Max) == keyColumns.Len() {
+		maxArgs := make([]interface{}, len(cached.Max))
+		for i, value := range cached.Max {
+			maxArgs[i] = value
+		}
+		query, args, err := sql.BuildKeyBoundsCheckQuery(table.TableSchema, table.TableName, keyColumns, maxArgs)
+		if err != nil {
+			return nil, err
+		}
+		var atOrPastMax int64
+		if err := e.db.QueryRow(query, args...).Scan(&atOrPastMax); err != nil {
+			return nil, err
+		}
+		if !sql.KeyBoundsStale(atOrPastMax, table.AppendOnly) {
+			e.logger.Debugf("mysql.extractor: Reusing the key bounds of %s.%s", table.TableSchema, table.TableName)
+			return cached, nil
+		}
+		e.logger.Printf("mysql.extractor: Key bounds of %s.%s changed, reading them again", table.TableSchema, table.TableName)
+	}
+
+	min, err := e.readUniqueKey(table, sql.BuildUniqueKeyMinValuesPreparedQuery)
+	if err != nil || min == nil {
+		return nil, err
+	}
+	max, err := e.readUniqueKey(table, sql.BuildUniqueKeyMaxValuesPreparedQuery)
+	if err != nil || max == nil {
+		return nil, err
+	}
+	return &models.KeyBounds{Min: min, Max: max, AppendOnly: table.AppendOnly}, nil
+}
+
+// readUniqueKey reads the unique key of the row of a table the query built
+// by build selects, as text. It returns nil for an empty table.
+func (e *Extractor) readUniqueKey(table *config.Table,
+	build func(databaseName, tableName string, uniqueKeyColumns *umconf.ColumnList) (string, error)) ([]string, error) {
+	query, err := build(table.TableSchema, table.TableName, &table.UseUniqueKey.Columns)
+	if err != nil {
+		return nil, err
+	}
+	values := make([]gosql.NullString, table.UseUniqueKey.Columns.Len())
+	dest := make([]interface{}, len(values))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+	if err := e.db.QueryRow(query).Scan(dest...); err == gosql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	key := make([]string, len(values))
+	for i := range values {
+		key[i] = values[i].String
+	}
+	return key, nil
+}
+
+// splitPoints returns the n-1 values at which the range of an integer column
+// from lo to hi, in either order, splits into n ranges of about the same
+// width. It returns nil if they aren't integers or the range has fewer than
+// n values.
+func splitPoints(lo, hi string, n int) []string {
+	low, ok := new(big.Int).SetString(lo, 10)
+	if !ok {
+		return nil
+	}
+	high, ok := new(big.Int).SetString(hi, 10)
+	if !ok {
+		return nil
+	}
+	if low.Cmp(high) > 0 {
+		low, high = high, low
+	}
+	width := new(big.Int).Sub(high, low)
+	width.Add(width, big.NewInt(1))
+	if n < 2 || width.Cmp(big.NewInt(int64(n))) < 0 {
+		return nil
+	}
+	points := make([]string, n-1)
+	for i := range points {
+		point := new(big.Int).Mul(width, big.NewInt(int64(i+1)))
+		point.Quo(point, big.NewInt(int64(n)))
+		points[i] = point.Add(point, low).String()
+	}
+	return points
+}
+
+// keyRangeConditions returns the conditions on column of the ranges the
+// points split it into. The first and the last are open, so that together
+// they cover rows added past the bounds too.
+func keyRangeConditions(column string, points []string) []string {
+	n := len(points) + 1
+	conditions := make([]string, n)
+	for i := range conditions {
+		switch i {
+		case 0:
+			conditions[i] = fmt.Sprintf("%s < %s", column, points[0])
+		case n - 1:
+			conditions[i] = fmt.Sprintf("%s >= %s", column, points[n-2])
+		default:
+			conditions[i] = fmt.Sprintf("%s >= %s and %s < %s", column, points[i-1], column, points[i])
+		}
+	}
+	return conditions
+}
+
+// copyGroup coordinates the copies of a split full copy, which the agent runs
+// side by side.
+type copyGroup struct {
+	// created is closed once the first copy took its snapshot and sent the
+	// tables to create. The others take theirs after it, so that the binlog
+	// it streams from its snapshot on has all they miss.
+	created chan struct{}
+	// copied receives from each of the others once it sent all its rows. The
+	// first copy streams the binlog only then, as replaying it on rows not
+	// yet copied would be lost.
+	copied chan struct{}
+}
+
+var (
+	copyGroups     = make(map[string]*copyGroup)
+	copyGroupsLock sync.Mutex
+)
+
+// joinCopyGroup returns the group of the copies of a split, which the first
+// of them to join creates.
+func joinCopyGroup(copyRange *config.CopyRange) *copyGroup {
+	copyGroupsLock.Lock()
+	defer copyGroupsLock.Unlock()
+	group, ok := copyGroups[copyRange.ID]
+	if !ok {
+		group = &copyGroup{
+			created: make(chan struct{}),
+			copied:  make(chan struct{}, copyRange.Count),
+		}
+		copyGroups[copyRange.ID] = group
+	}
+	return group
+}
+
+// leaveCopyGroup forgets the group of a split, once its first copy is done
+// with it.
+func leaveCopyGroup(copyRange *config.CopyRange) {
+	copyGroupsLock.Lock()
+	defer copyGroupsLock.Unlock()
+	delete(copyGroups, copyRange.ID)
+}
+
+// copyRangeWhere returns the condition restricting the rows of a table this
+// copy makes, or "" if it makes them all.
+func (e *Extractor) copyRangeWhere(table *config.Table) string {
+	if e.mysqlContext.CopyRange == nil {
+		return ""
+	}
+	return e.mysqlContext.CopyRange.Where[sql.EscapeQualifiedName(table.TableSchema, table.TableName)]
+}
+
+// copiesTable tells whether this copy makes any rows of a table. Those of a
+// table not split are made by the first copy.
+func (e *Extractor) copiesTable(table *config.Table) bool {
+	return !e.isLaterCopy() || e.copyRangeWhere(table) != ""
+}
+
+// isLaterCopy tells whether this is a copy of a split other than the first,
+// which only copies rows.
+func (e *Extractor) isLaterCopy() bool {
+	return e.mysqlContext.CopyRange != nil && e.mysqlContext.CopyRange.Index > 0
+}
+
+// KeyBounds returns the bounds of the tables split into the copies this one
+// is of, if any.
+func (e *Extractor) KeyBounds() map[string]*models.KeyBounds {
+	if e.mysqlContext.CopyRange == nil {
+		return nil
+	}
+	return e.mysqlContext.CopyRange.Bounds
+}
+
+// completeLaterCopy tells the destination and the first copy that this copy
+// of a split, not the first, sent all its rows.
+func (e *Extractor) completeLaterCopy() error {
+	dumpMsg, err := Encode(&dumpStatResult{TotalCount: e.mysqlContext.RowsEstimate, Partial: true})
+	if err != nil {
+		return err
+	}
+	if err := e.publish(fmt.Sprintf("%s_full_complete", e.subject), "", dumpMsg); err != nil {
+		return err
+	}
+	e.copyGroup.copied <- struct{}{}
+	return nil
+}
+
+// waitForLaterCopies waits, in the first copy of a split, until the others
+// sent all their rows.
+func (e *Extractor) waitForLaterCopies() error {
+	if e.copyGroup == nil {
+		return nil
+	}
+	for i := 1; i < e.mysqlContext.CopyRange.Count; i++ {
+		select {
+		case <-e.copyGroup.copied:
+		case <-e.shutdownCh:
+			return fmt.Errorf("shut down before the other copies sent their rows")
+		}
+	}
+	return nil
+}
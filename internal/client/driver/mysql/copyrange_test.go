@@ -0,0 +1,269 @@
+/*
+ * Copyright (C) 2016-2018. ActionTech.
+ * Based on: github.com/hashicorp/nomad, github.com/github/gh-ost .
+ * License: MPL version 2: https://www.mozilla.org/en-US/MPL/2.0 .
+ */
+
+package mysql
+
+import (
+	"context"
+	gosql "database/sql"
+	sqldriver "database/sql/driver"
+	"errors"
+	"io"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/actiontech/dtle/internal/config"
+	umconf "github.com/actiontech/dtle/internal/config/mysql"
+	log "github.com/actiontech/dtle/internal/logger"
+	"github.com/actiontech/dtle/internal/models"
+)
+
+func Test_splitPoints(t *testing.T) {
+	tests := []struct {
+		name   string
+		lo, hi string
+		n      int
+		want   []string
+	}{
+		{"even", "1", "100", 4, []string{"26", "51", "76"}},
+		{"reversed", "100", "1", 2, []string{"51"}},
+		{"negative", "-10", "9", 2, []string{"0"}},
+		{"past int64", "0", "18446744073709551615", 2, []string{"9223372036854775808"}},
+		{"as many values as copies", "1", "3", 3, []string{"2", "3"}},
+		{"fewer values than copies", "1", "2", 3, nil},
+		{"not integers", "a", "z", 2, nil},
+		{"one copy", "1", "100", 1, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := splitPoints(tt.lo, tt.hi, tt.n); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("splitPoints(%q, %q, %d) = %q, want %q", tt.lo, tt.hi, tt.n, got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_keyRangeConditions(t *testing.T) {
+	got := keyRangeConditions("`id`", []string{"26", "51", "76"})
+	want := []string{"`id` < 26", "`id` >= 26 and `id` < 51", "`id` >= 51 and `id` < 76", "`id` >= 76"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("keyRangeConditions() = %q, want %q", got, want)
+	}
+}
+
+// keyBoundsServer is a database/sql connector answering the queries reading
+// and checking the bounds of a unique key.
+type keyBoundsServer struct {
+	min, max    []string
+	atOrPastMax int64
+
+	queries []string
+}
+
+func (s *keyBoundsServer) Connect(context.Context) (sqldriver.Conn, error) {
+	return &keyBoundsConn{s}, nil
+}
+
+func (s *keyBoundsServer) Driver() sqldriver.Driver {
+	return nil
+}
+
+type keyBoundsConn struct {
+	server *keyBoundsServer
+}
+
+func (c *keyBoundsConn) Prepare(query string) (sqldriver.Stmt, error) {
+	return nil, errors.New("not supported")
+}
+
+func (c *keyBoundsConn) Close() error {
+	return nil
+}
+
+func (c *keyBoundsConn) Begin() (sqldriver.Tx, error) {
+	return nil, errors.New("not supported")
+}
+
+func (c *keyBoundsConn) Query(query string, args []sqldriver.Value) (sqldriver.Rows, error) {
+	c.server.queries = append(c.server.queries, query)
+	switch {
+	case strings.HasPrefix(query, "select count(*)"):
+		return &valueRows{values: [][]sqldriver.Value{{c.server.atOrPastMax}}}, nil
+	case strings.HasSuffix(query, "asc limit 1"):
+		return keyRows(c.server.min), nil
+	case strings.HasSuffix(query, "desc limit 1"):
+		return keyRows(c.server.max), nil
+	}
+	return nil, errors.New("unexpected query")
+}
+
+func keyRows(key []string) *valueRows {
+	if key == nil {
+		return &valueRows{}
+	}
+	row := make([]sqldriver.Value, len(key))
+	for i, value := range key {
+		row[i] = []byte(value)
+	}
+	return &valueRows{values: [][]sqldriver.Value{row}}
+}
+
+type valueRows struct {
+	names  []string
+	values [][]sqldriver.Value
+}
+
+func (r *valueRows) Columns() []string {
+	if r.names != nil {
+		return r.names
+	}
+	if len(r.values) == 0 {
+		return []string{"id"}
+	}
+	return make([]string, len(r.values[0]))
+}
+
+func (r *valueRows) Close() error {
+	return nil
+}
+
+func (r *valueRows) Next(dest []sqldriver.Value) error {
+	if len(r.values) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.values[0])
+	r.values = r.values[1:]
+	return nil
+}
+
+func TestExtractor_readKeyBounds(t *testing.T) {
+	cached := &models.KeyBounds{Min: []string{"1"}, Max: []string{"1000"}}
+	tests := []struct {
+		name        string
+		cached      *models.KeyBounds
+		appendOnly  bool
+		atOrPastMax int64
+		want        *models.KeyBounds
+		wantReads   bool
+	}{
+		{"none cached", nil, false, 0, &models.KeyBounds{Min: []string{"5"}, Max: []string{"2000"}}, true},
+		{"cached max is the last row", cached, false, 1, cached, false},
+		{"cached max was deleted", cached, false, 0, &models.KeyBounds{Min: []string{"5"}, Max: []string{"2000"}}, true},
+		{"rows past the cached max", cached, false, 2, &models.KeyBounds{Min: []string{"5"}, Max: []string{"2000"}}, true},
+		{"rows appended past the cached max", cached, true, 2, cached, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := &keyBoundsServer{min: []string{"5"}, max: []string{"2000"}, atOrPastMax: tt.atOrPastMax}
+			e, err := NewExtractor("job", "src", 0, &config.MySQLDriverConfig{ConnectionConfig: &umconf.ConnectionConfig{}}, log.New(ioutil.Discard, log.ErrorLevel))
+			if err != nil {
+				t.Fatal(err)
+			}
+			e.db = gosql.OpenDB(server)
+			defer e.db.Close()
+			table := &config.Table{
+				TableSchema:  "db",
+				TableName:    "tbl",
+				UseUniqueKey: &umconf.UniqueKey{Name: "PRIMARY", Columns: *umconf.NewColumnList(umconf.NewColumns([]string{"id"}))},
+				AppendOnly:   tt.appendOnly,
+			}
+			if tt.want != cached {
+				tt.want.AppendOnly = tt.appendOnly
+			}
+
+			got, err := e.readKeyBounds(table, tt.cached)
+			if err != nil {
+				t.Fatalf("readKeyBounds() = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("readKeyBounds() = %+v, want %+v", got, tt.want)
+			}
+			reads := len(server.queries) > 0 && !strings.HasPrefix(server.queries[len(server.queries)-1], "select count(*)")
+			if reads != tt.wantReads {
+				t.Errorf("ran %q, want reading the bounds: %v", server.queries, tt.wantReads)
+			}
+		})
+	}
+
+	// An empty table has no bounds.
+	server := &keyBoundsServer{}
+	e, err := NewExtractor("job", "src", 0, &config.MySQLDriverConfig{ConnectionConfig: &umconf.ConnectionConfig{}}, log.New(ioutil.Discard, log.ErrorLevel))
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.db = gosql.OpenDB(server)
+	defer e.db.Close()
+	table := &config.Table{
+		TableSchema:  "db",
+		TableName:    "tbl",
+		UseUniqueKey: &umconf.UniqueKey{Name: "PRIMARY", Columns: *umconf.NewColumnList(umconf.NewColumns([]string{"id"}))},
+	}
+	if got, err := e.readKeyBounds(table, nil); err != nil || got != nil {
+		t.Errorf("readKeyBounds() of an empty table = %+v, %v, want none", got, err)
+	}
+}
+
+func TestExtractor_copyRange(t *testing.T) {
+	split := &config.Table{TableSchema: "db", TableName: "split"}
+	whole := &config.Table{TableSchema: "db", TableName: "whole"}
+	newExtractor := func(index int) *Extractor {
+		cfg := &config.MySQLDriverConfig{ConnectionConfig: &umconf.ConnectionConfig{}, CopyRange: &config.CopyRange{
+			ID:    "split",
+			Index: index,
+			Count: 2,
+			Where: map[string]string{"`db`.`split`": []string{"`id` < 51", "`id` >= 51"}[index]},
+		}}
+		e, err := NewExtractor("job", "src", 0, cfg, log.New(ioutil.Discard, log.ErrorLevel))
+		if err != nil {
+			t.Fatal(err)
+		}
+		e.copyGroup = joinCopyGroup(cfg.CopyRange)
+		return e
+	}
+	first, second := newExtractor(0), newExtractor(1)
+	defer leaveCopyGroup(first.mysqlContext.CopyRange)
+	if first.copyGroup != second.copyGroup {
+		t.Fatal("the copies of a split are in different groups")
+	}
+
+	// The first copy makes the tables not split.
+	if !first.copiesTable(split) || !first.copiesTable(whole) || first.isLaterCopy() {
+		t.Error("the first copy doesn't copy all tables")
+	}
+	if !second.copiesTable(split) || second.copiesTable(whole) || !second.isLaterCopy() {
+		t.Error("a later copy doesn't copy only the tables split")
+	}
+	if got := second.copyRangeWhere(split); got != "`id` >= 51" {
+		t.Errorf("copyRangeWhere() = %q, want the range of the copy", got)
+	}
+
+	// The first copy waits for the others to send their rows.
+	done := make(chan error)
+	go func() {
+		done <- first.waitForLaterCopies()
+	}()
+	select {
+	case <-done:
+		t.Fatal("the first copy didn't wait for the others")
+	case <-time.After(10 * time.Millisecond):
+	}
+	second.copyGroup.copied <- struct{}{}
+	if err := <-done; err != nil {
+		t.Errorf("waitForLaterCopies() = %v", err)
+	}
+
+	// It gives up once shut down.
+	go func() {
+		done <- first.waitForLaterCopies()
+	}()
+	first.Shutdown()
+	if err := <-done; err == nil {
+		t.Error("waitForLaterCopies() didn't fail once shut down")
+	}
+}
@@ -10,6 +10,7 @@ import (
 	"bytes"
 	"fmt"
 	"math"
+	"strconv"
 	"strings"
 	"sync"
 
@@ -29,7 +30,30 @@ type dumper struct {
 	TableName      string
 	table          *config.Table
 	columns        string
+	// order of rows for tables without a unique key, see buildQueryOldWay
+	noKeyOrderBy   string
 	entriesCount   int
+	// sizer, if set, tunes chunkSize after every chunk of a table with a
+	// unique key. See tunedWorker.
+	sizer          *ChunkSizer
+	// lastChunkTime is how long getChunkData took to read its rows, not
+	// counting handing them on to resultsChannel.
+	lastChunkTime  time.Duration
+	// indexHintMissing is set once a chunk query failed as the index of
+	// table.IndexHint doesn't exist. Later queries are built without it.
+	indexHintMissing bool
+	indexHintLock    sync.Mutex
+	// flavor is the engine of the source, which renders the index hint.
+	flavor         usql.ServerFlavor
+	// comment, if set, tags the chunk queries with the chunk index added.
+	comment        string
+	// copyRangeWhere, if set, restricts the rows dumped to the key range of
+	// a copy, see config.CopyRange. Unlike table.Where, it doesn't filter the
+	// binlog.
+	copyRangeWhere string
+	// verify, if set, sends the checksum of the rows of every chunk of a table
+	// with a unique key with it, see DumpEntry.Checksum.
+	verify         bool
 	resultsChannel chan *DumpEntry
 	entriesChannel chan *DumpEntry
 	shutdown       bool
@@ -61,6 +85,9 @@ func NewDumper(db usql.QueryAble, table *config.Table, total, chunkSize int64,
 type dumpStatResult struct {
 	Gtid       string
 	TotalCount int64
+	// Partial is set by the copies of a split full copy but the first, which
+	// has the Gtid and is sent after the others.
+	Partial bool
 }
 
 type DumpEntry struct {
@@ -77,11 +104,22 @@ type DumpEntry struct {
 	TotalCount               int64
 	RowsCount                int64
 	Offset                   uint64 // only for 'no PK' table
+	// ChunkRange is the condition on the rows of the chunk, and Checksum their
+	// checksum on the source, see usql.BuildRangeChecksumQuery. Both are
+	// empty unless the chunk is verified.
+	ChunkRange               string
+	Checksum                 *ChunkChecksum
 	colBuffer                bytes.Buffer
 	err                      error
 	Table                    *config.Table
 }
 
+// ChunkChecksum is the row count and checksum of a chunk.
+type ChunkChecksum struct {
+	Count int64
+	Sum   uint64
+}
+
 func (e *DumpEntry) incrementCounter() {
 	e.RowsCount++
 }
@@ -118,6 +156,9 @@ func (d *dumper) getDumpEntries() ([]*DumpEntry, error) {
 	} else {
 		d.columns = "*"
 	}
+	if d.table.UseUniqueKey == nil {
+		d.noKeyOrderBy = buildNoKeyOrderBy(columnList)
+	}
 
 	sliceCount := int(math.Ceil(float64(d.total) / float64(d.chunkSize)))
 	if sliceCount == 0 {
@@ -133,62 +174,194 @@ func (d *dumper) getDumpEntries() ([]*DumpEntry, error) {
 	return entries, nil
 }
 
+// selectHints returns the optimizer hint comment put after SELECT and the index
+// hint put after the table name. Both are empty if not configured, and the
+// index hint is empty once its index turned out to be missing. The comment
+// of the chunk follows the optimizer hint, which has to come right after
+// SELECT.
+func (d *dumper) selectHints(chunk int64) (optimizerHint string, indexHint string) {
+	if d.table.OptimizerHint != "" {
+		optimizerHint = fmt.Sprintf("/*+ %s */ ", d.table.OptimizerHint)
+	}
+	if d.comment != "" {
+		optimizerHint += usql.QueryComment(fmt.Sprintf("%s chunk=%d", d.comment, chunk)) + " "
+	}
+	d.indexHintLock.Lock()
+	omit := d.indexHintMissing
+	d.indexHintLock.Unlock()
+	if s := d.flavor.IndexHint(d.table.IndexHint); s != "" && !omit {
+		indexHint = " " + s
+	}
+	return optimizerHint, indexHint
+}
+
+// omitIndexHint makes the chunk queries go without the index hint, when err
+// says its index doesn't exist, such as after it was dropped or renamed. It
+// returns whether the failed query should be retried without the hint.
+func (d *dumper) omitIndexHint(err error) bool {
+	if d.table.IndexHint.String() == "" || !usql.IsKeyDoesNotExistError(err) {
+		return false
+	}
+	d.indexHintLock.Lock()
+	defer d.indexHintLock.Unlock()
+	if !d.indexHintMissing {
+		d.logger.Warnf("mysql.dumper: index of hint %q is missing on %s.%s, querying without it: %v",
+			d.table.IndexHint.String(), d.TableSchema, d.TableName, err)
+		d.indexHintMissing = true
+	}
+	return true
+}
+
+// buildNoKeyOrderBy orders a table without a unique key by all its columns, so
+// that LIMIT/OFFSET chunks neither overlap nor skip rows.
+func buildNoKeyOrderBy(columns *umconf.ColumnList) string {
+	orderBy := make([]string, columns.Len())
+	for i := range columns.Columns {
+		orderBy[i] = fmt.Sprintf("%s asc", usql.EscapeName(columns.Columns[i].Name))
+	}
+	return strings.Join(orderBy, ", ")
+}
+
+// buildQueryOldWay pages a table without a unique key by LIMIT/OFFSET, ordered by
+// all columns. Each chunk sorts the whole table, so this is slow on big tables.
+// The offsets are only correct while the table does not change between chunks,
+// which the dump guarantees by reading in a consistent snapshot. Columns longer
+// than max_sort_length are only ordered by their prefix.
 func (d *dumper) buildQueryOldWay(e *DumpEntry) string {
-	return fmt.Sprintf(`SELECT %s FROM %s.%s where (%s) LIMIT %d OFFSET %d`,
+	orderBy := ""
+	if d.noKeyOrderBy != "" {
+		orderBy = fmt.Sprintf(" order by %s", d.noKeyOrderBy)
+	}
+	var chunk int64
+	if d.chunkSize > 0 {
+		chunk = int64(e.Offset) / d.chunkSize
+	}
+	optimizerHint, indexHint := d.selectHints(chunk)
+	return fmt.Sprintf(`SELECT %s%s FROM %s%s where (%s)%s LIMIT %d OFFSET %d`,
+		optimizerHint,
 		d.columns,
-		usql.EscapeName(d.TableSchema),
-		usql.EscapeName(d.TableName),
-		d.table.Where,
+		usql.EscapeQualifiedName(d.TableSchema, d.TableName),
+		indexHint,
+		d.where(),
+		orderBy,
 		d.chunkSize,
 		e.Offset,
 	)
 }
 
-func (d *dumper) buildQueryOnUniqueKey(e *DumpEntry) string {
-	nCol := len(d.table.UseUniqueKey.Columns.Columns)
-	uniqueKeyColumnAscending := make([]string, nCol, nCol)
-	for i, col := range d.table.UseUniqueKey.Columns.Columns {
-		colName := usql.EscapeName(col.Name)
-		switch col.Type {
-		case umconf.EnumColumnType:
-			// TODO try mysql enum type
-			uniqueKeyColumnAscending[i] = fmt.Sprintf("concat(%s) asc", colName)
+// where returns the condition on the rows dumped: table.Where, within the key
+// range of the copy if any.
+func (d *dumper) where() string {
+	if d.copyRangeWhere == "" {
+		return d.table.Where
+	}
+	return fmt.Sprintf("(%s) and (%s)", d.table.Where, d.copyRangeWhere)
+}
+
+// uniqueKeyColumnExpr returns the escaped column name, with a collate clause
+// if the column asks for an explicit collation.
+func uniqueKeyColumnExpr(col *umconf.Column) string {
+	colName := usql.EscapeName(col.Name)
+	if col.Collation != "" {
+		return fmt.Sprintf("%s collate %s", colName, col.Collation)
+	}
+	return colName
+}
+
+// uniqueKeyLastMaxVal renders a unique key value of the last row of a chunk, to
+// be compared against in the range of the next chunk.
+func uniqueKeyLastMaxVal(col *umconf.Column, value *interface{}) string {
+	if *value == nil {
+		return usql.EscapeColRawToString(value)
+	}
+	if col.Type == umconf.EnumColumnType {
+		// enums are ordered by index, so compare by index too
+		var enumValue string
+		switch v := (*value).(type) {
+		case []byte:
+			enumValue = string(v)
+		case string:
+			enumValue = v
 		default:
-			uniqueKeyColumnAscending[i] = fmt.Sprintf("%s asc", colName)
+			enumValue = fmt.Sprintf("%s", v)
+		}
+		if ordinal, ok := col.EnumOrdinal(enumValue); ok {
+			return strconv.Itoa(ordinal)
 		}
 	}
+	return usql.EscapeColumnValue(*value, col)
+}
 
-	var rangeStr string
-
+// afterLastChunk returns the condition on the unique keys after the last
+// chunk, true before the first.
+func (d *dumper) afterLastChunk() string {
 	if d.table.Iteration == 0 {
-		rangeStr = "true"
-	} else {
-		rangeItems := make([]string, nCol)
+		return "true"
+	}
+	nCol := len(d.table.UseUniqueKey.Columns.Columns)
+	rangeItems := make([]string, nCol)
 
-		// The form like: (A > a) or (A = a and B > b) or (A = a and B = b and C > c) or ...
-		for x := 0; x < nCol; x++ {
-			innerItems := make([]string, x+1)
+	// The form like: (A > a) or (A = a and B > b) or (A = a and B = b and C > c) or ...
+	// with < for descending columns
+	for x := 0; x < nCol; x++ {
+		innerItems := make([]string, x+1)
 
-			for y := 0; y < x; y++ {
-				colName := usql.EscapeName(d.table.UseUniqueKey.Columns.Columns[y].Name)
-				innerItems[y] = fmt.Sprintf("(%s = %s)", colName, d.table.UseUniqueKey.LastMaxVals[y])
-			}
+		for y := 0; y < x; y++ {
+			colName := uniqueKeyColumnExpr(&d.table.UseUniqueKey.Columns.Columns[y])
+			innerItems[y] = fmt.Sprintf("(%s = %s)", colName, d.table.UseUniqueKey.LastMaxVals[y])
+		}
+
+		col := &d.table.UseUniqueKey.Columns.Columns[x]
+		innerItems[x] = fmt.Sprintf("(%s %s %s)", uniqueKeyColumnExpr(col), usql.AfterComparisonSign(col), d.table.UseUniqueKey.LastMaxVals[x])
 
-			colName := usql.EscapeName(d.table.UseUniqueKey.Columns.Columns[x].Name)
-			innerItems[x] = fmt.Sprintf("(%s > %s)", colName, d.table.UseUniqueKey.LastMaxVals[x])
+		rangeItems[x] = fmt.Sprintf("(%s)", strings.Join(innerItems, " and "))
+	}
+
+	return strings.Join(rangeItems, " or ")
+}
 
-			rangeItems[x] = fmt.Sprintf("(%s)", strings.Join(innerItems, " and "))
+// checksumChunk sets the range of a chunk of a table with a unique key and
+// the checksum of its rows, from after afterPrevious to the last key read.
+// A chunk whose last key has a NULL isn't verified, as it can't be compared.
+func (d *dumper) checksumChunk(entry *DumpEntry, afterPrevious string, lastRow []*interface{}) error {
+	for i := range d.table.UseUniqueKey.Columns.Columns {
+		idx := d.table.OriginalTableColumns.Ordinals[d.table.UseUniqueKey.Columns.Columns[i].Name]
+		if *lastRow[idx] == nil {
+			return nil
 		}
+	}
+	chunkRange := fmt.Sprintf("(%s) and (%s) and not (%s)", d.where(), afterPrevious, d.afterLastChunk())
+	query, err := usql.BuildRangeChecksumQuery(d.TableSchema, d.TableName, d.table.OriginalTableColumns, chunkRange)
+	if err != nil {
+		return err
+	}
+	checksum := &ChunkChecksum{}
+	if err := d.db.QueryRow(query).Scan(&checksum.Count, &checksum.Sum); err != nil {
+		return fmt.Errorf("exec [%s] error: %v", query, err)
+	}
+	entry.ChunkRange, entry.Checksum = chunkRange, checksum
+	return nil
+}
 
-		rangeStr = strings.Join(rangeItems, " or ")
+func (d *dumper) buildQueryOnUniqueKey(e *DumpEntry) string {
+	nCol := len(d.table.UseUniqueKey.Columns.Columns)
+	uniqueKeyColumnAscending := make([]string, nCol, nCol)
+	for i := range d.table.UseUniqueKey.Columns.Columns {
+		col := &d.table.UseUniqueKey.Columns.Columns[i]
+		// enum columns are ordered by their index, the same as LastMaxVals compares them
+		uniqueKeyColumnAscending[i] = fmt.Sprintf("%s %s", uniqueKeyColumnExpr(col), col.SortDirection.Keyword())
 	}
 
-	return fmt.Sprintf(`SELECT %s FROM %s.%s where %s and (%s) order by %s LIMIT %d`,
+	rangeStr := d.afterLastChunk()
+
+	optimizerHint, indexHint := d.selectHints(d.table.Iteration)
+	return fmt.Sprintf(`SELECT %s%s FROM %s%s where %s and (%s) order by %s LIMIT %d`,
+		optimizerHint,
 		d.columns,
-		usql.EscapeName(d.TableSchema),
-		usql.EscapeName(d.TableName),
+		usql.EscapeQualifiedName(d.TableSchema, d.TableName),
+		indexHint,
 		// where
-		rangeStr, d.table.Where,
+		rangeStr, d.where(),
 		// order by
 		strings.Join(uniqueKeyColumnAscending, ", "),
 		// limit
@@ -196,8 +369,9 @@ func (d *dumper) buildQueryOnUniqueKey(e *DumpEntry) string {
 	)
 }
 
-// dumps a specific chunk, reading chunk info from the channel
-func (d *dumper) getChunkData(e *DumpEntry) (err error) {
+// dumps a specific chunk, reading chunk info from the channel. It returns the
+// number of rows read.
+func (d *dumper) getChunkData(e *DumpEntry) (nRows int, err error) {
 	entry := &DumpEntry{
 		TableSchema: d.TableSchema,
 		TableName:   d.TableName,
@@ -220,29 +394,38 @@ func (d *dumper) getChunkData(e *DumpEntry) (err error) {
 		d.logger.Debugf("mysql.dumper: resultsChannel: %v", len(d.resultsChannel))
 	}()
 
-	query := ""
-	if d.table.UseUniqueKey == nil {
-		query = d.buildQueryOldWay(e)
-	} else {
-		query = d.buildQueryOnUniqueKey(e)
+	buildQuery := func() string {
+		if d.table.UseUniqueKey == nil {
+			return d.buildQueryOldWay(e)
+		}
+		return d.buildQueryOnUniqueKey(e)
 	}
+	query := buildQuery()
 	d.logger.Debugf("getChunkData. query: %s", query)
+	var afterPrevious string
+	if d.table.UseUniqueKey != nil {
+		afterPrevious = d.afterLastChunk()
+	}
 
-	d.table.Iteration += 1
+	start := time.Now()
 	rows, err := d.db.Query(query)
+	if err != nil && d.omitIndexHint(err) {
+		query = buildQuery()
+		start = time.Now()
+		rows, err = d.db.Query(query)
+	}
+	d.table.Iteration += 1
 	if err != nil {
-		return fmt.Errorf("exec [%s] error: %v", query, err)
+		return 0, fmt.Errorf("exec [%s] error: %v", query, err)
 	}
 
 	columns, err := rows.Columns()
 	if err != nil {
-		return err
+		return 0, err
 	}
 
 	//packetLen := 0
 
-	nRows := 0
-
 	scanArgs := make([]interface{}, len(columns)) // tmp use, for casting `values` to `[]interface{}`
 
 	interfacePtrWithNil := new(interface{})
@@ -255,7 +438,7 @@ func (d *dumper) getChunkData(e *DumpEntry) (err error) {
 
 		err = rows.Scan(scanArgs...)
 		if err != nil {
-			return err
+			return nRows, err
 		}
 
 		for i := range rowValuesRaw {
@@ -269,32 +452,35 @@ func (d *dumper) getChunkData(e *DumpEntry) (err error) {
 		entry.incrementCounter()
 	}
 
+	d.lastChunkTime = time.Since(start)
 	d.logger.Debugf("getChunkData. n_row: %d", nRows)
 
 	// TODO getChunkData could get 0 rows. Esp after removing 'start transaction'.
 	if nRows == 0 {
-		return fmt.Errorf("getChunkData. GetLastMaxVal: no rows found")
+		return 0, fmt.Errorf("getChunkData. GetLastMaxVal: no rows found")
 	}
 
 	if nRows > 0 {
-		var lastVals []string
-
-		for _, col := range entry.ValuesX[len(entry.ValuesX)-1] {
-			lastVals = append(lastVals, usql.EscapeColRawToString(col))
-		}
+		lastRow := entry.ValuesX[len(entry.ValuesX)-1]
 
 		if d.table.UseUniqueKey != nil {
-			// lastVals must not be nil if len(data) > 0
-			for i, col := range d.table.UseUniqueKey.Columns.Columns {
+			// lastRow must not be nil if len(data) > 0
+			for i := range d.table.UseUniqueKey.Columns.Columns {
+				col := &d.table.UseUniqueKey.Columns.Columns[i]
 				// TODO save the idx
 				idx := d.table.OriginalTableColumns.Ordinals[col.Name]
-				if idx > len(lastVals) {
-					return fmt.Errorf("getChunkData. GetLastMaxVal: column index %v > n_column %v", idx, len(lastVals))
+				if idx >= len(lastRow) {
+					return nRows, fmt.Errorf("getChunkData. GetLastMaxVal: column index %v > n_column %v", idx, len(lastRow))
 				} else {
-					d.table.UseUniqueKey.LastMaxVals[i] = lastVals[idx]
+					d.table.UseUniqueKey.LastMaxVals[i] = uniqueKeyLastMaxVal(col, lastRow[idx])
 				}
 			}
 			d.logger.Debugf("GetLastMaxVal: got %v", d.table.UseUniqueKey.LastMaxVals)
+			if d.verify {
+				if err := d.checksumChunk(entry, afterPrevious, lastRow); err != nil {
+					return nRows, err
+				}
+			}
 		}
 	}
 
@@ -303,7 +489,7 @@ func (d *dumper) getChunkData(e *DumpEntry) (err error) {
 	// Values[i]: i-th chunk of rows
 	// Values[i][j]: j-th row (in paren-wrapped string)
 
-	return nil
+	return nRows, nil
 }
 
 func (d *dumper) worker() {
@@ -314,7 +500,7 @@ func (d *dumper) worker() {
 		default:
 		}
 		if e != nil {
-			err := d.getChunkData(e)
+			_, err := d.getChunkData(e)
 			//FIXME: useless err
 			if err != nil {
 				e.err = err
@@ -324,25 +510,70 @@ func (d *dumper) worker() {
 	}
 }
 
+// tunedWorker dumps a table with a unique key in chunks sized by d.sizer,
+// until total rows are read. Chunks start after the last key of the previous
+// one, so unlike the offsets of getDumpEntries they don't depend on the size.
+func (d *dumper) tunedWorker() {
+	defer close(d.resultsChannel)
+	var dumped int64
+	for dumped < d.total {
+		select {
+		case <-d.shutdownCh:
+			return
+		default:
+		}
+		d.chunkSize = d.sizer.Next(d.lastChunkTime)
+		nRows, err := d.getChunkData(&DumpEntry{})
+		if err != nil {
+			return
+		}
+		d.logger.Debugf("mysql.dumper: read %d rows in %v", nRows, d.lastChunkTime)
+		dumped += int64(nRows)
+		if int64(nRows) < d.chunkSize {
+			// the table is exhausted
+			return
+		}
+	}
+}
+
+// Dump starts dumping the table with w workers. The dumped entries are sent
+// to resultsChannel, which is closed once the dump is done.
 func (d *dumper) Dump(w int) error {
 	entries, err := d.getDumpEntries()
 	if err != nil {
+		close(d.resultsChannel)
 		return err
 	}
 
 	if len(entries) == 0 {
+		close(d.resultsChannel)
+		return nil
+	}
+
+	if d.sizer != nil && d.table.UseUniqueKey != nil {
+		go d.tunedWorker()
 		return nil
 	}
 
 	workersCount := int(math.Min(float64(w), float64(len(entries))))
 	if workersCount < 1 {
+		close(d.resultsChannel)
 		return nil
 	}
 
 	d.entriesCount = len(entries)
+	var workers sync.WaitGroup
+	workers.Add(workersCount)
 	for i := 0; i < workersCount; i++ {
-		go d.worker()
+		go func() {
+			defer workers.Done()
+			d.worker()
+		}()
 	}
+	go func() {
+		workers.Wait()
+		close(d.resultsChannel)
+	}()
 
 	go func() {
 		for _, e := range entries {
@@ -8,9 +8,16 @@ package mysql
 
 import (
 	"database/sql"
+	sqldriver "database/sql/driver"
+	"io/ioutil"
 	"reflect"
+	"strings"
 	"testing"
+
+	"github.com/go-sql-driver/mysql"
+	usql "github.com/actiontech/dtle/internal/client/driver/mysql/sql"
 	"github.com/actiontech/dtle/internal/config"
+	umconf "github.com/actiontech/dtle/internal/config/mysql"
 	log "github.com/actiontech/dtle/internal/logger"
 )
 
@@ -118,6 +125,64 @@ func Test_dumper_getChunkData(t *testing.T) {
 	}
 }
 
+func Test_dumper_checksumChunk(t *testing.T) {
+	server := &chunkServer{
+		rows:     [][]sqldriver.Value{{[]byte("1"), []byte("a")}, {[]byte("2"), []byte("b")}},
+		checksum: ChunkChecksum{Count: 2, Sum: 42},
+	}
+	db := sql.OpenDB(server)
+	defer db.Close()
+	table := &config.Table{
+		TableSchema:          "db",
+		TableName:            "tbl",
+		Where:                "true",
+		OriginalTableColumns: umconf.NewColumnList(umconf.NewColumns([]string{"id", "name"})),
+		UseUniqueKey:         &umconf.UniqueKey{Name: "PRIMARY", Columns: *umconf.NewColumnList(umconf.NewColumns([]string{"id"})), LastMaxVals: make([]string, 1)},
+	}
+	d := NewDumper(db, table, 4, 2, log.NewEntry(log.New(ioutil.Discard, log.ErrorLevel)))
+	d.verify = true
+
+	// Each chunk is sent with the checksum of the range from the chunk
+	// before it up to its last key.
+	want := []string{
+		"(true) and (true) and not (((`id` > '2')))",
+		"(true) and (((`id` > '2'))) and not (((`id` > '2')))",
+	}
+	for i, wantRange := range want {
+		if _, err := d.getChunkData(&DumpEntry{}); err != nil {
+			t.Fatalf("getChunkData() = %v", err)
+		}
+		entry := <-d.resultsChannel
+		if entry.ChunkRange != wantRange || entry.Checksum == nil || *entry.Checksum != server.checksum {
+			t.Errorf("chunk %d sent with range %q and checksum %+v, want %q and %+v", i, entry.ChunkRange, entry.Checksum, wantRange, server.checksum)
+		}
+	}
+	checksumQuery := "select count(*), coalesce(bit_xor(crc32(concat_ws('#', `id`, `name`, isnull(`id`), isnull(`name`)))), 0) from `db`.`tbl` where " + want[0]
+	if server.queries[1] != checksumQuery {
+		t.Errorf("checksum query = %q, want %q", server.queries[1], checksumQuery)
+	}
+
+	// A chunk whose last key is NULL isn't verified.
+	server.rows = [][]sqldriver.Value{{nil, []byte("a")}}
+	if _, err := d.getChunkData(&DumpEntry{}); err != nil {
+		t.Fatalf("getChunkData() = %v", err)
+	}
+	if entry := <-d.resultsChannel; entry.Checksum != nil {
+		t.Errorf("chunk ending at a NULL key sent with checksum %+v", entry.Checksum)
+	}
+
+	// Without verify, no checksum is read.
+	d.verify = false
+	server.queries = nil
+	server.rows = [][]sqldriver.Value{{[]byte("3"), []byte("c")}}
+	if _, err := d.getChunkData(&DumpEntry{}); err != nil {
+		t.Fatalf("getChunkData() = %v", err)
+	}
+	if entry := <-d.resultsChannel; entry.Checksum != nil || len(server.queries) != 1 {
+		t.Errorf("ran %q and sent checksum %+v without verify", server.queries, entry.Checksum)
+	}
+}
+
 func Test_dumper_worker(t *testing.T) {
 	tests := []struct {
 		name string
@@ -222,3 +287,311 @@ func Test_dumper_Close(t *testing.T) {
 		})
 	}
 }
+
+func Test_dumper_buildQueryOnUniqueKey(t *testing.T) {
+	newDumper := func(collation string) *dumper {
+		columns := umconf.NewColumns([]string{"name"})
+		columns[0].Collation = collation
+		table := config.NewTable("db1", "tb1")
+		table.UseUniqueKey = &umconf.UniqueKey{
+			Name:        "PRIMARY",
+			Columns:     *umconf.NewColumnList(columns),
+			LastMaxVals: []string{"'abc'"},
+		}
+		table.Iteration = 1
+		return &dumper{
+			TableSchema: "db1",
+			TableName:   "tb1",
+			table:       table,
+			columns:     "*",
+			chunkSize:   10,
+		}
+	}
+	tests := []struct {
+		name      string
+		d         *dumper
+		wantOrder string
+		wantRange string
+	}{
+		{
+			name:      "utf8mb4_bin",
+			d:         newDumper("utf8mb4_bin"),
+			wantOrder: "order by `name` collate utf8mb4_bin asc",
+			wantRange: "((`name` collate utf8mb4_bin > 'abc'))",
+		},
+		{
+			name:      "utf8mb4_general_ci",
+			d:         newDumper("utf8mb4_general_ci"),
+			wantOrder: "order by `name` collate utf8mb4_general_ci asc",
+			wantRange: "((`name` collate utf8mb4_general_ci > 'abc'))",
+		},
+		{
+			name:      "no collation",
+			d:         newDumper(""),
+			wantOrder: "order by `name` asc",
+			wantRange: "((`name` > 'abc'))",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.d.buildQueryOnUniqueKey(&DumpEntry{})
+			if !strings.Contains(got, tt.wantOrder) {
+				t.Errorf("dumper.buildQueryOnUniqueKey() = %v, want order %v", got, tt.wantOrder)
+			}
+			if !strings.Contains(got, tt.wantRange) {
+				t.Errorf("dumper.buildQueryOnUniqueKey() = %v, want range %v", got, tt.wantRange)
+			}
+		})
+	}
+
+	t.Run("asc, desc", func(t *testing.T) {
+		d := newDumper("")
+		columns := umconf.NewColumns([]string{"a", "b"})
+		columns[1].SortDirection = umconf.SortDescending
+		d.table.UseUniqueKey.Columns = *umconf.NewColumnList(columns)
+		d.table.UseUniqueKey.LastMaxVals = []string{"1", "5"}
+		got := d.buildQueryOnUniqueKey(&DumpEntry{})
+		if want := "order by `a` asc, `b` desc"; !strings.Contains(got, want) {
+			t.Errorf("dumper.buildQueryOnUniqueKey() = %v, want order %v", got, want)
+		}
+		if want := "((`a` > 1)) or ((`a` = 1) and (`b` < 5))"; !strings.Contains(got, want) {
+			t.Errorf("dumper.buildQueryOnUniqueKey() = %v, want range %v", got, want)
+		}
+	})
+
+	t.Run("copy range", func(t *testing.T) {
+		d := newDumper("")
+		d.copyRangeWhere = "`name` >= 51"
+		got := d.buildQueryOnUniqueKey(&DumpEntry{})
+		if want := "and ((true) and (`name` >= 51)) order by"; !strings.Contains(got, want) {
+			t.Errorf("dumper.buildQueryOnUniqueKey() = %v, want where %v", got, want)
+		}
+	})
+}
+
+func Test_dumper_selectHints(t *testing.T) {
+	newDumper := func(hint *umconf.IndexHint, optimizerHint string) *dumper {
+		table := config.NewTable("db1", "tb1")
+		table.Where = "true"
+		table.IndexHint = hint
+		table.OptimizerHint = optimizerHint
+		return &dumper{
+			TableSchema: "db1",
+			TableName:   "tb1",
+			table:       table,
+			columns:     "*",
+			chunkSize:   10,
+		}
+	}
+	tests := []struct {
+		name string
+		d    *dumper
+		want string
+	}{
+		{
+			name: "no hint",
+			d:    newDumper(nil, ""),
+			want: "SELECT * FROM `db1`.`tb1` where (true) LIMIT 10 OFFSET 0",
+		},
+		{
+			name: "empty index",
+			d:    newDumper(&umconf.IndexHint{Kind: umconf.ForceIndexHint}, ""),
+			want: "SELECT * FROM `db1`.`tb1` where (true) LIMIT 10 OFFSET 0",
+		},
+		{
+			name: "force",
+			d:    newDumper(&umconf.IndexHint{Kind: umconf.ForceIndexHint, Index: "PRIMARY"}, ""),
+			want: "SELECT * FROM `db1`.`tb1` force index (`PRIMARY`) where (true) LIMIT 10 OFFSET 0",
+		},
+		{
+			name: "use",
+			d:    newDumper(&umconf.IndexHint{Kind: umconf.UseIndexHint, Index: "idx_a"}, ""),
+			want: "SELECT * FROM `db1`.`tb1` use index (`idx_a`) where (true) LIMIT 10 OFFSET 0",
+		},
+		{
+			name: "ignore",
+			d:    newDumper(&umconf.IndexHint{Kind: umconf.IgnoreIndexHint, Index: "idx_a"}, ""),
+			want: "SELECT * FROM `db1`.`tb1` ignore index (`idx_a`) where (true) LIMIT 10 OFFSET 0",
+		},
+		{
+			name: "force on tidb",
+			d: func() *dumper {
+				d := newDumper(&umconf.IndexHint{Kind: umconf.ForceIndexHint, Index: "PRIMARY"}, "")
+				d.flavor = usql.FlavorTiDB
+				return d
+			}(),
+			want: "SELECT * FROM `db1`.`tb1` use index (`PRIMARY`) where (true) LIMIT 10 OFFSET 0",
+		},
+		{
+			name: "optimizer hint",
+			d:    newDumper(nil, "MAX_EXECUTION_TIME(1000)"),
+			want: "SELECT /*+ MAX_EXECUTION_TIME(1000) */ * FROM `db1`.`tb1` where (true) LIMIT 10 OFFSET 0",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.d.buildQueryOldWay(&DumpEntry{}); got != tt.want {
+				t.Errorf("dumper.buildQueryOldWay() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	d := newDumper(&umconf.IndexHint{Kind: umconf.ForceIndexHint, Index: "PRIMARY"}, "MAX_EXECUTION_TIME(1000)")
+	d.table.UseUniqueKey = &umconf.UniqueKey{
+		Name:    "PRIMARY",
+		Columns: *umconf.NewColumnList(umconf.NewColumns([]string{"id"})),
+	}
+	want := "SELECT /*+ MAX_EXECUTION_TIME(1000) */ * FROM `db1`.`tb1` force index (`PRIMARY`) where true"
+	if got := d.buildQueryOnUniqueKey(&DumpEntry{}); !strings.HasPrefix(got, want) {
+		t.Errorf("dumper.buildQueryOnUniqueKey() = %v, want prefix %v", got, want)
+	}
+
+	// The comment of the chunk follows the optimizer hint.
+	d.comment = "dtle job=job1 task=Src"
+	d.table.Iteration = 3
+	d.table.UseUniqueKey.LastMaxVals = []string{"5"}
+	want = "SELECT /*+ MAX_EXECUTION_TIME(1000) */ /* dtle job=job1 task=Src chunk=3 */ * FROM `db1`.`tb1` force index"
+	if got := d.buildQueryOnUniqueKey(&DumpEntry{}); !strings.HasPrefix(got, want) {
+		t.Errorf("dumper.buildQueryOnUniqueKey() = %v, want prefix %v", got, want)
+	}
+	d.table.UseUniqueKey = nil
+	want = "SELECT /*+ MAX_EXECUTION_TIME(1000) */ /* dtle job=job1 task=Src chunk=2 */ * FROM"
+	if got := d.buildQueryOldWay(&DumpEntry{Offset: 20}); !strings.HasPrefix(got, want) {
+		t.Errorf("dumper.buildQueryOldWay() = %v, want prefix %v", got, want)
+	}
+}
+
+func Test_dumper_omitIndexHint(t *testing.T) {
+	table := config.NewTable("db1", "tb1")
+	table.Where = "true"
+	table.IndexHint = &umconf.IndexHint{Kind: umconf.ForceIndexHint, Index: "idx_gone"}
+	table.UseUniqueKey = &umconf.UniqueKey{
+		Name:    "PRIMARY",
+		Columns: *umconf.NewColumnList(umconf.NewColumns([]string{"id"})),
+	}
+	d := &dumper{
+		logger:      log.NewEntry(log.New(ioutil.Discard, log.ErrorLevel)),
+		TableSchema: "db1",
+		TableName:   "tb1",
+		table:       table,
+		columns:     "*",
+		chunkSize:   10,
+	}
+
+	if d.omitIndexHint(&mysql.MySQLError{Number: 1146, Message: "Table 'db1.tb1' doesn't exist"}) {
+		t.Errorf("dumper.omitIndexHint() of another error = true")
+	}
+	if got := d.buildQueryOnUniqueKey(&DumpEntry{}); !strings.Contains(got, "force index (`idx_gone`)") {
+		t.Errorf("dumper.buildQueryOnUniqueKey() = %v, want the index hint", got)
+	}
+
+	if !d.omitIndexHint(&mysql.MySQLError{Number: 1176, Message: "Key 'idx_gone' doesn't exist in table 'tb1'"}) {
+		t.Fatalf("dumper.omitIndexHint() of a missing key = false")
+	}
+	want := "SELECT * FROM `db1`.`tb1` where true and (true) order by `id` asc LIMIT 10"
+	if got := d.buildQueryOnUniqueKey(&DumpEntry{}); got != want {
+		t.Errorf("dumper.buildQueryOnUniqueKey() without the hint = %v, want %v", got, want)
+	}
+	if got := d.buildQueryOldWay(&DumpEntry{}); strings.Contains(got, "index") {
+		t.Errorf("dumper.buildQueryOldWay() without the hint = %v", got)
+	}
+}
+
+func Test_dumper_buildQueryOldWay(t *testing.T) {
+	table := config.NewTable("db1", "tb1")
+	table.Where = "true"
+	d := &dumper{
+		TableSchema:  "db1",
+		TableName:    "tb1",
+		table:        table,
+		columns:      "*",
+		chunkSize:    2,
+		noKeyOrderBy: buildNoKeyOrderBy(umconf.NewColumnList(umconf.NewColumns([]string{"a", "b", "c"}))),
+	}
+	tests := []struct {
+		name   string
+		offset uint64
+		want   string
+	}{
+		{
+			name:   "first chunk",
+			offset: 0,
+			want:   "SELECT * FROM `db1`.`tb1` where (true) order by `a` asc, `b` asc, `c` asc LIMIT 2 OFFSET 0",
+		},
+		{
+			name:   "second chunk",
+			offset: 2,
+			want:   "SELECT * FROM `db1`.`tb1` where (true) order by `a` asc, `b` asc, `c` asc LIMIT 2 OFFSET 2",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := d.buildQueryOldWay(&DumpEntry{Offset: tt.offset}); got != tt.want {
+				t.Errorf("dumper.buildQueryOldWay() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_dumper_buildQueryOnUniqueKey_enum(t *testing.T) {
+	// 'b' sorts after 'a' as a string, but is declared first
+	columns := umconf.NewColumns([]string{"e"})
+	columns[0].Type = umconf.EnumColumnType
+	columns[0].ColumnType = "enum('b','a')"
+	table := config.NewTable("db1", "tb1")
+	table.UseUniqueKey = &umconf.UniqueKey{
+		Name:        "PRIMARY",
+		Columns:     *umconf.NewColumnList(columns),
+		LastMaxVals: make([]string, 1),
+	}
+	table.Iteration = 1
+	d := &dumper{
+		TableSchema: "db1",
+		TableName:   "tb1",
+		table:       table,
+		columns:     "*",
+		chunkSize:   10,
+	}
+
+	var value interface{} = []byte("b")
+	table.UseUniqueKey.LastMaxVals[0] = uniqueKeyLastMaxVal(&table.UseUniqueKey.Columns.Columns[0], &value)
+	if got, want := table.UseUniqueKey.LastMaxVals[0], "1"; got != want {
+		t.Errorf("uniqueKeyLastMaxVal() = %v, want %v", got, want)
+	}
+	got := d.buildQueryOnUniqueKey(&DumpEntry{})
+	if want := "((`e` > 1))"; !strings.Contains(got, want) {
+		t.Errorf("dumper.buildQueryOnUniqueKey() = %v, want range %v", got, want)
+	}
+	if want := "order by `e` asc"; !strings.Contains(got, want) {
+		t.Errorf("dumper.buildQueryOnUniqueKey() = %v, want order %v", got, want)
+	}
+
+	var null interface{}
+	if got, want := uniqueKeyLastMaxVal(&table.UseUniqueKey.Columns.Columns[0], &null), "NULL"; got != want {
+		t.Errorf("uniqueKeyLastMaxVal() = %v, want %v", got, want)
+	}
+}
+
+func Test_uniqueKeyLastMaxVal(t *testing.T) {
+	tests := []struct {
+		name       string
+		columnType umconf.ColumnType
+		declared   string
+		value      interface{}
+		want       string
+	}{
+		{"quoted string", umconf.VarcharColumnType, "varchar(16)", []byte(`o'brien\`), `'o\'brien\\'`},
+		{"int", umconf.IntColumnType, "int(11)", []byte("42"), "42"},
+		{"binary", umconf.VarbinaryColumnType, "varbinary(16)", []byte{0xff}, "x'ff'"},
+		{"enum", umconf.EnumColumnType, "enum('small','large')", []byte("large"), "2"},
+		{"enum string", umconf.EnumColumnType, "enum('small','large')", "small", "1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			col := &umconf.Column{Name: "k", Type: tt.columnType, ColumnType: tt.declared}
+			if got := uniqueKeyLastMaxVal(col, &tt.value); got != tt.want {
+				t.Errorf("uniqueKeyLastMaxVal() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -30,6 +30,7 @@ import (
 	"github.com/actiontech/dtle/internal/client/driver/mysql/binlog"
 	"github.com/actiontech/dtle/internal/client/driver/mysql/sql"
 	"github.com/actiontech/dtle/internal/config"
+	umconf "github.com/actiontech/dtle/internal/config/mysql"
 	log "github.com/actiontech/dtle/internal/logger"
 	"github.com/actiontech/dtle/internal/models"
 	"github.com/actiontech/dtle/utils"
@@ -50,6 +51,7 @@ type Extractor struct {
 	mysqlContext             *config.MySQLDriverConfig
 	db                       *gosql.DB
 	singletonDB              *gosql.DB
+	replicaDB                *gosql.DB // the full copy is read from it, if set
 	dumpers                  []*dumper
 	// db.tb exists when creating the job, for full-copy.
 	// vs e.mysqlContext.ReplicateDoDb: all user assigned db.tb
@@ -74,6 +76,22 @@ type Extractor struct {
 	shutdownCh   chan struct{}
 	shutdownLock sync.Mutex
 
+	// paused is set while the task is paused. publish holds back messages,
+	// which in turn stops reading from the binlog.
+	paused int64
+	// throttleFactor holds the math.Float64bits of the fraction of full speed
+	// publish runs at. Zero means not throttled.
+	throttleFactor uint64
+	// targetDelay is the replication delay of the destination in seconds,
+	// which it acks the binlog with, plus one. Zero until it is known.
+	targetDelay uint64
+	// publishLock is read locked by every publish in flight. Drain locks it
+	// to wait for them.
+	publishLock sync.RWMutex
+	// copyGroup is that of the copies of a split full copy this is one of,
+	// see config.CopyRange.
+	copyGroup *copyGroup
+
 	testStub1Delay int64
 }
 
@@ -166,6 +184,13 @@ func (e *Extractor) Run() {
 		}
 	}
 
+	if copyRange := e.mysqlContext.CopyRange; copyRange != nil {
+		e.copyGroup = joinCopyGroup(copyRange)
+		if copyRange.Index == 0 {
+			defer leaveCopyGroup(copyRange)
+		}
+	}
+
 	if err := e.initiateInspector(); err != nil {
 		e.onError(TaskStateDead, err)
 		return
@@ -208,6 +233,19 @@ func (e *Extractor) Run() {
 			e.onError(TaskStateDead, err)
 			return
 		}
+		if e.isLaterCopy() {
+			// The first copy streams the binlog for all.
+			if err := e.completeLaterCopy(); err != nil {
+				e.onError(TaskStateDead, err)
+				return
+			}
+			e.onDone()
+			return
+		}
+		if err := e.waitForLaterCopies(); err != nil {
+			e.onError(TaskStateDead, err)
+			return
+		}
 		dumpMsg, err := Encode(&dumpStatResult{Gtid: e.initialBinlogCoordinates.GtidSet, TotalCount: e.mysqlContext.RowsEstimate})
 		if err != nil {
 			e.onError(TaskStateDead, err)
@@ -436,6 +474,13 @@ func (e *Extractor) initDBConnections() (err error) {
 	if e.singletonDB, err = sql.CreateDB(dumpUri); err != nil {
 		return err
 	}
+	if replica := e.mysqlContext.ReplicaConnectionConfig; replica != nil {
+		replicaUri := fmt.Sprintf("%s&tx_isolation='REPEATABLE-READ'", e.dumpConnectionConfig().GetSingletonDBUri())
+		if e.replicaDB, err = sql.CreateDB(replicaUri); err != nil {
+			return err
+		}
+		e.logger.Printf("mysql.extractor: Will read the full copy from replica %s:%d", replica.Host, replica.Port)
+	}
 	if err := e.validateConnection(); err != nil {
 		return err
 	}
@@ -530,8 +575,11 @@ func (e *Extractor) CountTableRows(table *config.Table) (int64, error) {
 	defer atomic.StoreInt64(&e.mysqlContext.CountingRowsFlag, 0)
 	//e.logger.Debugf("mysql.extractor: As instructed, I'm issuing a SELECT COUNT(*) on the table. This may take a while")
 
-	query := fmt.Sprintf(`select count(*) as rows from %s.%s where (%s)`,
-		sql.EscapeName(table.TableSchema), sql.EscapeName(table.TableName), table.Where)
+	query := fmt.Sprintf(`select count(*) as rows from %s where (%s)`,
+		sql.EscapeQualifiedName(table.TableSchema, table.TableName), table.Where)
+	if where := e.copyRangeWhere(table); where != "" {
+		query = fmt.Sprintf("%s and (%s)", query, where)
+	}
 	var rowsEstimate int64
 	if err := e.db.QueryRow(query).Scan(&rowsEstimate); err != nil {
 		return 0, err
@@ -830,10 +878,24 @@ func (e *Extractor) StreamEvents() error {
 // retryOperation attempts up to `count` attempts at running given function,
 // exiting as soon as it returns with non-error.
 func (e *Extractor) publish(subject, gtid string, txMsg []byte) (err error) {
+	e.sleepWhileTrue(func() (bool, error) {
+		return atomic.LoadInt64(&e.paused) == 1 && !e.shutdown, nil
+	})
+	e.publishLock.RLock()
+	start := time.Now()
+	defer func() {
+		e.publishLock.RUnlock()
+		// Idle for long enough that publishing takes 1/factor of the time.
+		if factor := math.Float64frombits(atomic.LoadUint64(&e.throttleFactor)); factor > 0 && factor < 1 {
+			time.Sleep(time.Duration(float64(time.Since(start)) * (1/factor - 1)))
+		}
+	}()
 	for {
 		e.logger.Debugf("mysql.extractor: publish. gtid: %v, msg_len: %v", gtid, len(txMsg))
-		_, err = e.natsConn.Request(subject, txMsg, DefaultConnectWait)
+		var ack *gonats.Msg
+		ack, err = e.natsConn.Request(subject, txMsg, DefaultConnectWait)
 		if err == nil {
+			e.recordTargetDelay(ack.Data)
 			if gtid != "" {
 				e.mysqlContext.Gtid = gtid
 			}
@@ -863,6 +925,7 @@ func (e *Extractor) testStub1() {
 //Perform the snapshot using the same logic as the "mysqldump" utility.
 func (e *Extractor) mysqlDump() error {
 	defer e.singletonDB.Close()
+	defer sql.CloseDB(e.replicaDB)
 	var tx sql.QueryAble
 	var err error
 	step := 0
@@ -913,89 +976,43 @@ func (e *Extractor) mysqlDump() error {
 	// First, start a transaction and request that a consistent MVCC snapshot is obtained immediately.
 	// See http://dev.mysql.com/doc/refman/5.7/en/commit.html
 
+	if e.isLaterCopy() {
+		e.logger.Printf("mysql.extractor: Step %d: wait for the first of %d copies to create the tables", step, e.mysqlContext.CopyRange.Count)
+		select {
+		case <-e.copyGroup.created:
+		case <-e.shutdownCh:
+			return fmt.Errorf("shut down before the first copy created the tables")
+		}
+	}
+
 	var needConsistentSnapshot = true // TODO determine by table characteristic (has-PK or not)
 	if needConsistentSnapshot {
-		e.logger.Printf("mysql.extractor: Step %d: start transaction with consistent snapshot", step)
-		gtidMatch := false
-		gtidMatchRound := 0
-		delayBetweenRetries := 200 * time.Millisecond
-		for !gtidMatch {
-			gtidMatchRound += 1
-
-			// 1
-			rows1, err := e.singletonDB.Query("show master status")
-			if err != nil {
-				e.logger.Errorf("mysql.extractor: get gtid, round: %v, phase 1, err: %v", gtidMatchRound, err)
-				return err
-			}
-
-			e.testStub1()
-
-			// 2
-			// TODO it seems that two 'start transaction' will be sent.
-			// https://github.com/golang/go/issues/19981
-			realTx, err := e.singletonDB.Begin()
-			tx = realTx
-			if err != nil {
-				return err
-			}
-			query := "START TRANSACTION WITH CONSISTENT SNAPSHOT"
-			_, err = realTx.Exec(query)
-			if err != nil {
-				e.logger.Printf("[ERR] mysql.extractor: exec %+v, error: %v", query, err)
-				return err
-			}
+		realTx, binlogCoordinates, err := e.consistentSnapshot(step)
+		if err != nil {
+			return err
+		}
+		tx = realTx
 
-			e.testStub1()
+		// Obtain the binlog position and update the SourceInfo in the context. This means that all source records generated
+		// as part of the snapshot will contain the binlog position of the snapshot.
+		//binlogCoordinates, err := base.GetSelfBinlogCoordinatesWithTx(tx)
 
-			// 3
-			rows2, err := realTx.Query("show master status")
+		e.initialBinlogCoordinates = binlogCoordinates
+		e.logger.Printf("mysql.extractor: Step %d: read binlog coordinates of MySQL master: %+v", step, *e.initialBinlogCoordinates)
 
-			// 4
-			binlogCoordinates1, err := base.ParseBinlogCoordinatesFromRows(rows1)
-			if err != nil {
-				return err
-			}
-			binlogCoordinates2, err := base.ParseBinlogCoordinatesFromRows(rows2)
+		defer func() {
+			/*e.logger.Printf("mysql.extractor: Step %d: releasing global read lock to enable MySQL writes", step)
+			query := "UNLOCK TABLES"
+			_, err := tx.Exec(query)
 			if err != nil {
-				return err
+				e.logger.Printf("[ERR] mysql.extractor: exec %+v, error: %v", query, err)
 			}
-			e.logger.Debugf("mysql.extractor: binlog coordinates 1: %+v", binlogCoordinates1)
-			e.logger.Debugf("mysql.extractor: binlog coordinates 2: %+v", binlogCoordinates2)
-
-			if binlogCoordinates1.GtidSet == binlogCoordinates2.GtidSet {
-				gtidMatch = true
-				e.logger.Infof("Got gtid after %v rounds", gtidMatchRound)
-
-				// Obtain the binlog position and update the SourceInfo in the context. This means that all source records generated
-				// as part of the snapshot will contain the binlog position of the snapshot.
-				//binlogCoordinates, err := base.GetSelfBinlogCoordinatesWithTx(tx)
-
-				e.initialBinlogCoordinates = binlogCoordinates2
-				e.logger.Printf("mysql.extractor: Step %d: read binlog coordinates of MySQL master: %+v", step, *e.initialBinlogCoordinates)
-
-				defer func() {
-					/*e.logger.Printf("mysql.extractor: Step %d: releasing global read lock to enable MySQL writes", step)
-					query := "UNLOCK TABLES"
-					_, err := tx.Exec(query)
-					if err != nil {
-						e.logger.Printf("[ERR] mysql.extractor: exec %+v, error: %v", query, err)
-					}
-					step++*/
-					e.logger.Printf("mysql.extractor: Step %d: committing transaction", step)
-					if err := realTx.Commit(); err != nil {
-						e.onError(TaskStateDead, err)
-					}
-				}()
-			} else {
-				e.logger.Warningf("Failed got a consistenct TX with GTID in %v rounds. Will retry.", gtidMatchRound)
-				err = realTx.Rollback()
-				if err != nil {
-					return err
-				}
-				time.Sleep(delayBetweenRetries)
+			step++*/
+			e.logger.Printf("mysql.extractor: Step %d: committing transaction", step)
+			if err := realTx.Commit(); err != nil {
+				e.onError(TaskStateDead, err)
 			}
-		}
+		}()
 	} else {
 		e.logger.Debugf("mysql.extractor: no need to get consistent snapshot")
 		tx = e.singletonDB
@@ -1027,7 +1044,7 @@ func (e *Extractor) mysqlDump() error {
 	for _, db := range e.replicateDoDb {
 		if len(db.Tables) > 0 {
 			for _, tb := range db.Tables {
-				if tb.TableSchema != db.TableSchema {
+				if tb.TableSchema != db.TableSchema || !e.copiesTable(tb) {
 					continue
 				}
 				total, err := e.CountTableRows(tb)
@@ -1035,25 +1052,13 @@ func (e *Extractor) mysqlDump() error {
 					return err
 				}
 				tb.Counter = total
-				var dbSQL string
-				var tbSQL []string
-				if !e.mysqlContext.SkipCreateDbTable {
-					var err error
-					if strings.ToLower(tb.TableSchema) != "mysql" {
-						dbSQL = fmt.Sprintf("CREATE DATABASE IF NOT EXISTS %s", tb.TableSchema)
-					}
-
-					if strings.ToLower(tb.TableType) == "view" {
-						/*tbSQL, err = base.ShowCreateView(e.singletonDB, tb.TableSchema, tb.TableName, e.mysqlContext.DropTableIfExists)
-						if err != nil {
-							return err
-						}*/
-					} else if strings.ToLower(tb.TableSchema) != "mysql" {
-						tbSQL, err = base.ShowCreateTable(e.singletonDB, tb.TableSchema, tb.TableName, e.mysqlContext.DropTableIfExists)
-						if err != nil {
-							return err
-						}
-					}
+				if e.isLaterCopy() {
+					// the first copy creates the tables
+					continue
+				}
+				dbSQL, tbSQL, err := e.createTableStatements(tb)
+				if err != nil {
+					return err
 				}
 				entry := &DumpEntry{
 					SystemVariablesStatement: setSystemVariablesStatement,
@@ -1070,7 +1075,7 @@ func (e *Extractor) mysqlDump() error {
 				}
 			}
 			e.tableCount += len(db.Tables)
-		} else {
+		} else if !e.isLaterCopy() {
 			var dbSQL string
 			if !e.mysqlContext.SkipCreateDbTable {
 				if strings.ToLower(db.TableSchema) != "mysql" {
@@ -1091,6 +1096,9 @@ func (e *Extractor) mysqlDump() error {
 			}
 		}
 	}
+	if e.copyGroup != nil && !e.isLaterCopy() {
+		close(e.copyGroup.created)
+	}
 	step++
 
 	// ------
@@ -1103,6 +1111,9 @@ func (e *Extractor) mysqlDump() error {
 	//pool := models.NewPool(10)
 	for _, db := range e.replicateDoDb {
 		for _, t := range db.Tables {
+			if !e.copiesTable(t) {
+				continue
+			}
 			//pool.Add(1)
 			//go func(t *config.Table) {
 			counter++
@@ -1111,13 +1122,20 @@ func (e *Extractor) mysqlDump() error {
 			e.logger.Printf("mysql.extractor: Step %d: - scanning table '%s.%s' (%d of %d tables)", step, t.TableSchema, t.TableName, counter, e.tableCount)
 
 			d := NewDumper(tx, t, t.Counter, e.mysqlContext.ChunkSize, e.logger)
+			d.comment = e.mysqlContext.QueryComment
+			d.copyRangeWhere = e.copyRangeWhere(t)
+			d.verify = !e.mysqlContext.SkipResumeVerify
+			d.flavor = sql.ParseServerFlavor(e.mysqlContext.MySQLVersion)
+			if e.mysqlContext.ChunkTargetTime > 0 {
+				d.sizer = NewChunkSizer(e.mysqlContext.ChunkSize, e.mysqlContext.ChunkSizeMin, e.mysqlContext.ChunkSizeMax,
+					time.Duration(e.mysqlContext.ChunkTargetTime)*time.Millisecond)
+			}
 			if err := d.Dump(1); err != nil {
 				e.onError(TaskStateDead, err)
 			}
 			e.dumpers = append(e.dumpers, d)
 			// Scan the rows in the table ...
-			for i := 0; i < d.entriesCount; i++ {
-				entry := <-d.resultsChannel
+			for entry := range d.resultsChannel {
 				if entry.err != nil {
 					e.onError(TaskStateDead, entry.err)
 				}
@@ -1134,7 +1152,6 @@ func (e *Extractor) mysqlDump() error {
 				atomic.AddInt64(&e.mysqlContext.TotalRowsCopied, entry.RowsCount)
 			}
 
-			close(d.resultsChannel)
 			//pool.Done()
 			//}(tb)
 		}
@@ -1151,6 +1168,167 @@ func (e *Extractor) mysqlDump() error {
 
 	return nil
 }
+
+// beginConsistentSnapshot starts a transaction with a consistent snapshot on
+// db and returns it with the binlog coordinates of the snapshot. They are read
+// before and after starting it, which is retried until they match, so that no
+// transaction committed in between.
+func (e *Extractor) beginConsistentSnapshot(db *gosql.DB) (*gosql.Tx, *base.BinlogCoordinatesX, error) {
+	gtidMatchRound := 0
+	delayBetweenRetries := 200 * time.Millisecond
+	for {
+		gtidMatchRound += 1
+
+		// 1
+		rows1, err := db.Query("show master status")
+		if err != nil {
+			e.logger.Errorf("mysql.extractor: get gtid, round: %v, phase 1, err: %v", gtidMatchRound, err)
+			return nil, nil, err
+		}
+
+		e.testStub1()
+
+		// 2
+		// TODO it seems that two 'start transaction' will be sent.
+		// https://github.com/golang/go/issues/19981
+		realTx, err := db.Begin()
+		if err != nil {
+			return nil, nil, err
+		}
+		query := sql.BuildConsistentSnapshotStart(sql.ParseServerFlavor(e.mysqlContext.MySQLVersion))
+		_, err = realTx.Exec(query)
+		if err != nil {
+			e.logger.Printf("[ERR] mysql.extractor: exec %+v, error: %v", query, err)
+			realTx.Rollback()
+			return nil, nil, err
+		}
+
+		e.testStub1()
+
+		// 3
+		rows2, err := realTx.Query("show master status")
+		if err != nil {
+			realTx.Rollback()
+			return nil, nil, err
+		}
+
+		// 4
+		binlogCoordinates1, err := base.ParseBinlogCoordinatesFromRows(rows1)
+		if err != nil {
+			realTx.Rollback()
+			return nil, nil, err
+		}
+		binlogCoordinates2, err := base.ParseBinlogCoordinatesFromRows(rows2)
+		if err != nil {
+			realTx.Rollback()
+			return nil, nil, err
+		}
+		e.logger.Debugf("mysql.extractor: binlog coordinates 1: %+v", binlogCoordinates1)
+		e.logger.Debugf("mysql.extractor: binlog coordinates 2: %+v", binlogCoordinates2)
+
+		if binlogCoordinates1.GtidSet == binlogCoordinates2.GtidSet {
+			e.logger.Infof("Got gtid after %v rounds", gtidMatchRound)
+			return realTx, binlogCoordinates2, nil
+		}
+		e.logger.Warningf("Failed got a consistenct TX with GTID in %v rounds. Will retry.", gtidMatchRound)
+		if err := realTx.Rollback(); err != nil {
+			return nil, nil, err
+		}
+		time.Sleep(delayBetweenRetries)
+	}
+}
+
+// consistentSnapshot starts the transaction the rows of the full copy are
+// read in, on the replica once it caught up with the source if one is set,
+// and returns it with the coordinates the binlog is streamed from.
+func (e *Extractor) consistentSnapshot(step int) (*gosql.Tx, *base.BinlogCoordinatesX, error) {
+	snapshotDB := e.singletonDB
+	if e.replicaDB != nil {
+		e.logger.Printf("mysql.extractor: Step %d: wait for the replica to catch up", step)
+		if err := e.waitForReplica(); err != nil {
+			return nil, nil, err
+		}
+		snapshotDB = e.replicaDB
+	}
+
+	e.logger.Printf("mysql.extractor: Step %d: start transaction with consistent snapshot", step)
+	realTx, binlogCoordinates, err := e.beginConsistentSnapshot(snapshotDB)
+	if err != nil {
+		return nil, nil, err
+	}
+	if e.replicaDB != nil {
+		// The binlog of the source is streamed from where the replica
+		// is, by GTID. The binlog files of the replica are its own.
+		binlogCoordinates = &base.BinlogCoordinatesX{GtidSet: binlogCoordinates.GtidSet}
+	}
+	return realTx, binlogCoordinates, nil
+}
+
+// createTableStatements returns the statements creating the database and the
+// table of tb on the target, read from where the rows are, unless the task
+// skips creating them. Views and the tables of mysql are not created.
+func (e *Extractor) createTableStatements(tb *config.Table) (dbSQL string, tbSQL []string, err error) {
+	if e.mysqlContext.SkipCreateDbTable {
+		return "", nil, nil
+	}
+	if strings.ToLower(tb.TableSchema) != "mysql" {
+		dbSQL = fmt.Sprintf("CREATE DATABASE IF NOT EXISTS %s", tb.TableSchema)
+	}
+
+	if strings.ToLower(tb.TableType) == "view" {
+		/*tbSQL, err = base.ShowCreateView(e.singletonDB, tb.TableSchema, tb.TableName, e.mysqlContext.DropTableIfExists)
+		if err != nil {
+			return err
+		}*/
+	} else if strings.ToLower(tb.TableSchema) != "mysql" {
+		tbSQL, err = base.ShowCreateTable(e.dumpDB(), tb.TableSchema, tb.TableName, e.mysqlContext.DropTableIfExists)
+		if err != nil {
+			return "", nil, err
+		}
+	}
+	return dbSQL, tbSQL, nil
+}
+
+// waitForReplica waits for the replica the rows are read from to execute the
+// transactions the source has, so that the copy isn't behind the source by
+// more than the time it takes to start. It is consistent either way: the
+// binlog is streamed from where the snapshot of the replica is.
+func (e *Extractor) waitForReplica() error {
+	binlogCoordinates, err := base.GetSelfBinlogCoordinates(e.db)
+	if err != nil {
+		return err
+	}
+	timeout := time.Duration(e.mysqlContext.ReplicaWaitTimeout) * time.Second
+	query, args := sql.BuildWaitForGtidSetQuery(binlogCoordinates.GtidSet, timeout)
+	var timedOut int
+	if err := e.replicaDB.QueryRow(query, args...).Scan(&timedOut); err != nil {
+		return err
+	}
+	if timedOut != 0 {
+		replica := e.mysqlContext.ReplicaConnectionConfig
+		return fmt.Errorf("replica %s:%d didn't catch up with the source at %s within %v",
+			replica.Host, replica.Port, binlogCoordinates.GtidSet, timeout)
+	}
+	return nil
+}
+
+// dumpConnectionConfig returns the connection the rows of the full copy are
+// read from: the replica if one is set, else the source.
+func (e *Extractor) dumpConnectionConfig() *umconf.ConnectionConfig {
+	if e.mysqlContext.ReplicaConnectionConfig != nil {
+		return e.mysqlContext.ReplicaConnectionConfig
+	}
+	return e.mysqlContext.ConnectionConfig
+}
+
+// dumpDB returns the db the full copy is read from, see dumpConnectionConfig.
+func (e *Extractor) dumpDB() *gosql.DB {
+	if e.replicaDB != nil {
+		return e.replicaDB
+	}
+	return e.singletonDB
+}
+
 func (e *Extractor) encodeDumpEntry(entry *DumpEntry) error {
 	txMsg, err := Encode(entry)
 	if err != nil {
@@ -1237,9 +1415,28 @@ func (e *Extractor) Stats() (*models.TaskStatistics, error) {
 		}
 	}
 
+	if delay := atomic.LoadUint64(&e.targetDelay); delay > 0 {
+		taskResUsage.DelayCount = &models.DelayCount{Time: delay - 1}
+	}
+
 	return &taskResUsage, nil
 }
 
+// recordTargetDelay keeps the replication delay the destination acked a
+// message with, if any, for Stats to report: the delay the task is throttled
+// by.
+func (e *Extractor) recordTargetDelay(ack []byte) {
+	if len(ack) == 0 {
+		return
+	}
+	delay, err := strconv.ParseUint(string(ack), 10, 64)
+	if err != nil {
+		e.logger.Debugf("mysql.extractor: ack with bad delay %q: %v", ack, err)
+		return
+	}
+	atomic.StoreUint64(&e.targetDelay, delay+1)
+}
+
 func (e *Extractor) ID() string {
 	id := config.DriverCtx{
 		DriverConfig: &config.MySQLDriverConfig{
@@ -1281,6 +1478,48 @@ func (e *Extractor) WaitCh() chan *models.WaitResult {
 	return e.waitCh
 }
 
+// Checkpoint returns the GTID set sent to the destination so far
+func (e *Extractor) Checkpoint() []byte {
+	return []byte(e.mysqlContext.Gtid)
+}
+
+// PoolStats returns nil, the extractor only reads from its source.
+func (e *Extractor) PoolStats() *models.PoolStats {
+	return nil
+}
+
+// Throttle slows down publishing to the given fraction of full speed
+func (e *Extractor) Throttle(factor float64) {
+	if factor >= 1 {
+		factor = 0
+	}
+	atomic.StoreUint64(&e.throttleFactor, math.Float64bits(factor))
+	e.logger.Debugf("mysql.extractor: Throttle factor: %v", factor)
+}
+
+// Pause holds back sending to the destination until Resume is called
+func (e *Extractor) Pause() error {
+	atomic.StoreInt64(&e.paused, 1)
+	e.logger.Printf("mysql.extractor: Paused")
+	return nil
+}
+
+func (e *Extractor) Resume() error {
+	atomic.StoreInt64(&e.paused, 0)
+	e.logger.Printf("mysql.extractor: Resumed")
+	return nil
+}
+
+// Drain holds back new messages, waits for those being published and then
+// shuts the extractor down.
+func (e *Extractor) Drain() error {
+	atomic.StoreInt64(&e.paused, 1)
+	e.publishLock.Lock()
+	defer e.publishLock.Unlock()
+	e.logger.Printf("mysql.extractor: Drained")
+	return e.Shutdown()
+}
+
 // Shutdown is used to tear down the extractor
 func (e *Extractor) Shutdown() error {
 	e.shutdownLock.Lock()
@@ -1303,6 +1542,9 @@ func (e *Extractor) Shutdown() error {
 	if err := sql.CloseDB(e.singletonDB); err != nil {
 		return err
 	}
+	if err := sql.CloseDB(e.replicaDB); err != nil {
+		return err
+	}
 
 	if e.binlogReader != nil {
 		if err := e.binlogReader.Close(); err != nil {
@@ -7,10 +7,19 @@
 package mysql
 
 import (
+	"context"
+	gosql "database/sql"
+	sqldriver "database/sql/driver"
+	"errors"
+	"io"
+	"io/ioutil"
 	"reflect"
+	"strings"
+	"sync"
 	"testing"
 	"github.com/actiontech/dtle/internal/client/driver/mysql/base"
 	"github.com/actiontech/dtle/internal/config"
+	umconf "github.com/actiontech/dtle/internal/config/mysql"
 	log "github.com/actiontech/dtle/internal/logger"
 	"github.com/actiontech/dtle/internal/models"
 )
@@ -650,3 +659,272 @@ func TestExtractor_onDone(t *testing.T) {
 		})
 	}
 }
+
+func TestExtractor_dumpConnectionConfig(t *testing.T) {
+	source := &umconf.ConnectionConfig{Host: "primary", Port: 3306, User: "dtle", Charset: "latin1"}
+	replica := &umconf.ConnectionConfig{Host: "replica", Port: 3307, User: "dtle"}
+	newExtractor := func(replica *umconf.ConnectionConfig) *Extractor {
+		cfg := &config.MySQLDriverConfig{ConnectionConfig: source, ReplicaConnectionConfig: replica}
+		e, err := NewExtractor("job", "src", 0, cfg, log.New(ioutil.Discard, log.ErrorLevel))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return e
+	}
+
+	// The rows are read from the replica, the binlog from the source.
+	e := newExtractor(replica)
+	if got := e.dumpConnectionConfig(); got.Host != "replica" || got.Port != 3307 || got.Charset != "latin1" {
+		t.Errorf("dumpConnectionConfig() = %+v, want the replica with the charset of the source", got)
+	}
+	if uri := e.mysqlContext.ConnectionConfig.GetDBUri(); !strings.Contains(uri, "tcp(primary:3306)") {
+		t.Errorf("streaming from %s, want the source", uri)
+	}
+	if e.mysqlContext.ReplicaWaitTimeout != 60 {
+		t.Errorf("ReplicaWaitTimeout = %d, want the default 60", e.mysqlContext.ReplicaWaitTimeout)
+	}
+	if replica.Charset != "" {
+		t.Errorf("the configured replica was changed to %+v", replica)
+	}
+
+	// Without one, both are the source.
+	e = newExtractor(nil)
+	if got := e.dumpConnectionConfig(); got.Host != "primary" {
+		t.Errorf("dumpConnectionConfig() = %+v, want the source", got)
+	}
+}
+
+// snapshotServer is a database/sql connector recording the statements run on
+// it, whose master status is always at gtid. It has caught up with any GTID
+// set waited for, and has every table asked for. Starting a transaction fails
+// with startErr, if set.
+type snapshotServer struct {
+	gtid     string
+	startErr error
+
+	lock       sync.Mutex
+	statements []string
+}
+
+func (s *snapshotServer) Connect(context.Context) (sqldriver.Conn, error) {
+	return &snapshotConn{s}, nil
+}
+
+func (s *snapshotServer) Driver() sqldriver.Driver {
+	return nil
+}
+
+func (s *snapshotServer) record(statement string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.statements = append(s.statements, statement)
+}
+
+type snapshotConn struct {
+	server *snapshotServer
+}
+
+func (c *snapshotConn) Prepare(query string) (sqldriver.Stmt, error) {
+	return nil, errors.New("not supported")
+}
+
+func (c *snapshotConn) Close() error {
+	return nil
+}
+
+func (c *snapshotConn) Begin() (sqldriver.Tx, error) {
+	c.server.record("begin")
+	return c, nil
+}
+
+func (c *snapshotConn) Commit() error {
+	c.server.record("commit")
+	return nil
+}
+
+func (c *snapshotConn) Rollback() error {
+	c.server.record("rollback")
+	return nil
+}
+
+func (c *snapshotConn) Exec(query string, args []sqldriver.Value) (sqldriver.Result, error) {
+	c.server.record(query)
+	if c.server.startErr != nil && strings.HasPrefix(query, "start transaction") {
+		return nil, c.server.startErr
+	}
+	return sqldriver.RowsAffected(0), nil
+}
+
+func (c *snapshotConn) Query(query string, args []sqldriver.Value) (sqldriver.Rows, error) {
+	c.server.record(query)
+	switch {
+	case query == "show master status":
+		return &masterStatusRows{gtid: c.server.gtid}, nil
+	case strings.HasPrefix(query, "select wait_for_executed_gtid_set("):
+		return &valueRows{values: [][]sqldriver.Value{{int64(0)}}}, nil
+	case strings.HasPrefix(query, "show create table "):
+		return &valueRows{names: []string{"Table", "Create Table"}, values: [][]sqldriver.Value{{"tbl", "CREATE TABLE `tbl` (`id` int)"}}}, nil
+	}
+	return nil, errors.New("unexpected query")
+}
+
+func (s *snapshotServer) ran() []string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return append([]string(nil), s.statements...)
+}
+
+type masterStatusRows struct {
+	gtid string
+	read bool
+}
+
+func (r *masterStatusRows) Columns() []string {
+	return []string{"File", "Position", "Executed_Gtid_Set"}
+}
+
+func (r *masterStatusRows) Close() error {
+	return nil
+}
+
+func (r *masterStatusRows) Next(dest []sqldriver.Value) error {
+	if r.read {
+		return io.EOF
+	}
+	r.read = true
+	dest[0], dest[1], dest[2] = []byte("mysql-bin.000003"), []byte("154"), []byte(r.gtid)
+	return nil
+}
+
+func TestExtractor_beginConsistentSnapshot(t *testing.T) {
+	gtid := "3f2b1a4c-0000-0000-0000-000000000001:1-10"
+	tests := []struct {
+		version string
+		start   string
+	}{
+		{"5.7.25-log", "start transaction with consistent snapshot"},
+		{"5.7.25-TiDB-v4.0.0", "start transaction"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.version, func(t *testing.T) {
+			server := &snapshotServer{gtid: gtid}
+			db := gosql.OpenDB(server)
+			defer db.Close()
+			cfg := &config.MySQLDriverConfig{ConnectionConfig: &umconf.ConnectionConfig{Host: "primary", Port: 3306}}
+			e, err := NewExtractor("job", "src", 0, cfg, log.New(ioutil.Discard, log.ErrorLevel))
+			if err != nil {
+				t.Fatal(err)
+			}
+			e.mysqlContext.MySQLVersion = tt.version
+
+			tx, coordinates, err := e.beginConsistentSnapshot(db)
+			if err != nil {
+				t.Fatalf("beginConsistentSnapshot() = %v", err)
+			}
+			defer tx.Rollback()
+			// The GTID set is read in the transaction, right after the
+			// snapshot is taken.
+			want := []string{"show master status", "begin", tt.start, "show master status"}
+			server.lock.Lock()
+			got := server.statements
+			server.lock.Unlock()
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("ran %q, want %q", got, want)
+			}
+			if coordinates.GtidSet != gtid || coordinates.LogFile != "mysql-bin.000003" || coordinates.LogPos != 154 {
+				t.Errorf("captured %+v, want the master status at %s", coordinates, gtid)
+			}
+		})
+	}
+}
+
+func TestExtractor_beginConsistentSnapshotFailed(t *testing.T) {
+	server := &snapshotServer{gtid: "3f2b1a4c-0000-0000-0000-000000000001:1-10", startErr: errors.New("access denied")}
+	db := gosql.OpenDB(server)
+	defer db.Close()
+	cfg := &config.MySQLDriverConfig{ConnectionConfig: &umconf.ConnectionConfig{Host: "primary", Port: 3306}}
+	e, err := NewExtractor("job", "src", 0, cfg, log.New(ioutil.Discard, log.ErrorLevel))
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.mysqlContext.MySQLVersion = "5.7.25-log"
+
+	// The transaction the snapshot failed to start in is rolled back.
+	if _, _, err := e.beginConsistentSnapshot(db); err == nil {
+		t.Fatal("beginConsistentSnapshot() succeeded, want the error starting the transaction")
+	}
+	want := []string{"show master status", "begin", "start transaction with consistent snapshot", "rollback"}
+	if got := server.ran(); !reflect.DeepEqual(got, want) {
+		t.Errorf("ran %q, want %q", got, want)
+	}
+}
+
+func TestExtractor_consistentSnapshotReplica(t *testing.T) {
+	gtid := "3f2b1a4c-0000-0000-0000-000000000001:1-10"
+	source, replica := &snapshotServer{gtid: gtid}, &snapshotServer{gtid: gtid}
+	cfg := &config.MySQLDriverConfig{
+		ConnectionConfig:        &umconf.ConnectionConfig{Host: "primary", Port: 3306},
+		ReplicaConnectionConfig: &umconf.ConnectionConfig{Host: "replica", Port: 3307},
+	}
+	e, err := NewExtractor("job", "src", 0, cfg, log.New(ioutil.Discard, log.ErrorLevel))
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.mysqlContext.MySQLVersion = "5.7.25-log"
+	e.db, e.singletonDB, e.replicaDB = gosql.OpenDB(source), gosql.OpenDB(source), gosql.OpenDB(replica)
+	defer e.db.Close()
+	defer e.singletonDB.Close()
+	defer e.replicaDB.Close()
+
+	// The snapshot is taken on the replica once it has what the source has,
+	// and the binlog of the source is streamed from its GTID set.
+	tx, coordinates, err := e.consistentSnapshot(1)
+	if err != nil {
+		t.Fatalf("consistentSnapshot() = %v", err)
+	}
+	defer tx.Rollback()
+	if coordinates.GtidSet != gtid || coordinates.LogFile != "" {
+		t.Errorf("streaming from %+v, want GTID set %s of no binlog file", coordinates, gtid)
+	}
+
+	// The tables are created as they are on the replica.
+	dbSQL, tbSQL, err := e.createTableStatements(&config.Table{TableSchema: "db", TableName: "tbl"})
+	if err != nil {
+		t.Fatalf("createTableStatements() = %v", err)
+	}
+	if dbSQL != "CREATE DATABASE IF NOT EXISTS db" || !reflect.DeepEqual(tbSQL, []string{"USE db", "CREATE TABLE `tbl` (`id` int)"}) {
+		t.Errorf("createTableStatements() = %q, %q", dbSQL, tbSQL)
+	}
+
+	if got, want := source.ran(), []string{"show master status"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("ran %q on the source, want %q", got, want)
+	}
+	want := []string{
+		"select wait_for_executed_gtid_set(?, ?)",
+		"show master status", "begin", "start transaction with consistent snapshot", "show master status",
+		"show create table `db`.`tbl`",
+	}
+	if got := replica.ran(); !reflect.DeepEqual(got, want) {
+		t.Errorf("ran %q on the replica, want %q", got, want)
+	}
+}
+
+func TestExtractor_recordTargetDelay(t *testing.T) {
+	e, err := NewExtractor("job", "src", 0, &config.MySQLDriverConfig{ConnectionConfig: &umconf.ConnectionConfig{}}, log.New(ioutil.Discard, log.ErrorLevel))
+	if err != nil {
+		t.Fatal(err)
+	}
+	stats, err := e.Stats()
+	if err != nil || stats.DelayCount != nil {
+		t.Errorf("Stats() = %+v, %v before an ack with a delay, want no delay", stats.DelayCount, err)
+	}
+
+	// Acks without a delay, such as of the full copy, keep the last one.
+	for _, ack := range []string{"0", "12", "", "bad"} {
+		e.recordTargetDelay([]byte(ack))
+	}
+	stats, err = e.Stats()
+	if err != nil || stats.DelayCount == nil || stats.DelayCount.Time != 12 {
+		t.Errorf("Stats() = %+v, %v, want the delay of 12s acked", stats.DelayCount, err)
+	}
+}
@@ -372,10 +372,11 @@ func (i *Inspector) validateTableTriggers(databaseName, tableName string) error
 // candidate for chunking
 func (i *Inspector) getCandidateUniqueKeys(databaseName, tableName string) (uniqueKeys [](*umconf.UniqueKey), err error) {
 	query := `SELECT
-      UNIQUES.INDEX_NAME,UNIQUES.COLUMN_NAMES,LOCATE('auto_increment', EXTRA) > 0 as is_auto_increment,has_nullable
+      UNIQUES.INDEX_NAME,UNIQUES.COLUMN_NAMES,UNIQUES.COLUMN_DIRECTIONS,LOCATE('auto_increment', EXTRA) > 0 as is_auto_increment,has_nullable
     FROM INFORMATION_SCHEMA.COLUMNS INNER JOIN (
       SELECT
         TABLE_SCHEMA,TABLE_NAME,INDEX_NAME,GROUP_CONCAT(COLUMN_NAME ORDER BY SEQ_IN_INDEX ASC) AS COLUMN_NAMES,
+        GROUP_CONCAT(IFNULL(COLLATION, 'A') ORDER BY SEQ_IN_INDEX ASC) AS COLUMN_DIRECTIONS,
         SUBSTRING_INDEX(GROUP_CONCAT(COLUMN_NAME ORDER BY SEQ_IN_INDEX ASC), ',', 1) AS FIRST_COLUMN_NAME,
         SUM(NULLABLE='YES') > 0 AS has_nullable
       FROM INFORMATION_SCHEMA.STATISTICS
@@ -449,6 +450,12 @@ func (i *Inspector) getCandidateUniqueKeys(databaseName, tableName string) (uniq
 	  `*/
 	err = usql.QueryRowsMap(i.db, query, func(m usql.RowMap) error {
 		columns := umconf.ParseColumnList(m.GetString("COLUMN_NAMES"))
+		// descending key columns, as of MySQL 8.0, are read in that order
+		for i, direction := range strings.Split(m.GetString("COLUMN_DIRECTIONS"), ",") {
+			if i < len(columns.Columns) {
+				columns.Columns[i].SortDirection = umconf.ParseSortDirection(direction)
+			}
+		}
 		uniqueKey := &umconf.UniqueKey{
 			Name:            m.GetString("INDEX_NAME"),
 			Columns:         *columns,
@@ -8,10 +8,14 @@ package sql
 
 import (
 	"bytes"
+	"encoding/hex"
 	"fmt"
+	"regexp"
 	"strconv"
 	"strings"
+	"time"
 
+	"github.com/actiontech/dtle/internal/config"
 	umconf "github.com/actiontech/dtle/internal/config/mysql"
 )
 
@@ -27,9 +31,279 @@ const (
 	NotEqualsComparisonSign                               = "!="
 )
 
+// AfterComparisonSign returns the sign selecting the values of a key column
+// after a given one, in the order of its index: greater for an ascending
+// column, less for a descending one.
+func AfterComparisonSign(column *umconf.Column) ValueComparisonSign {
+	if column.SortDirection == umconf.SortDescending {
+		return LessThanComparisonSign
+	}
+	return GreaterThanComparisonSign
+}
+
+// beforeComparisonSign is the opposite of AfterComparisonSign.
+func beforeComparisonSign(column *umconf.Column) ValueComparisonSign {
+	if column.SortDirection == umconf.SortDescending {
+		return GreaterThanComparisonSign
+	}
+	return LessThanComparisonSign
+}
+
+// InsertMode selects how rows that collide with an existing row on the target
+// are written.
+type InsertMode int
+
+const (
+	// InsertModeReplace writes rows with `replace into`. REPLACE is a delete
+	// followed by an insert: it fires both delete and insert triggers, and can
+	// reset the auto-increment gaps of the rows it replaces.
+	InsertModeReplace InsertMode = iota
+	// InsertModeIgnore writes rows with `insert ignore into`, keeping the
+	// existing row on collision.
+	InsertModeIgnore
+	// InsertModeOnDuplicateUpdate writes rows with `insert into ... on duplicate
+	// key update`, updating the existing row in place.
+	InsertModeOnDuplicateUpdate
+)
+
+// ParseInsertMode parses an insert mode name as given in the job config. An
+// empty name means InsertModeReplace.
+func ParseInsertMode(name string) (InsertMode, error) {
+	switch strings.ToLower(name) {
+	case "", "replace":
+		return InsertModeReplace, nil
+	case "ignore":
+		return InsertModeIgnore, nil
+	case "update":
+		return InsertModeOnDuplicateUpdate, nil
+	default:
+		return InsertModeReplace, fmt.Errorf("unknown insert mode: %v", name)
+	}
+}
+
+// Verb returns the leading keywords of an insert statement in this mode.
+func (m InsertMode) Verb() string {
+	switch m {
+	case InsertModeIgnore:
+		return "insert ignore into"
+	case InsertModeOnDuplicateUpdate:
+		return "insert into"
+	default:
+		return "replace into"
+	}
+}
+
+// NameMapper maps a source database/table onto the target database/table the
+// rows are written to. A nil NameMapper maps every name onto itself.
+type NameMapper struct {
+	databases map[string]string
+	tables    map[string]map[string]string
+	columns   map[string]map[string]ColumnRenameMap
+	// lowerCase lowercases the target names, see SetLowerCaseTableNames.
+	lowerCase bool
+}
+
+func NewNameMapper() *NameMapper {
+	return &NameMapper{
+		databases: make(map[string]string),
+		tables:    make(map[string]map[string]string),
+		columns:   make(map[string]map[string]ColumnRenameMap),
+	}
+}
+
+// NewNameMapperFromDataSources builds a NameMapper from the renames configured
+// on the replicated databases and tables.
+func NewNameMapperFromDataSources(dataSources []*config.DataSource) *NameMapper {
+	m := NewNameMapper()
+	for _, ds := range dataSources {
+		if ds.TableSchemaRename != "" {
+			m.AddDatabase(ds.TableSchema, ds.TableSchemaRename)
+		}
+		for _, tb := range ds.Tables {
+			if tb.TableRename != "" {
+				m.AddTable(ds.TableSchema, tb.TableName, tb.TableRename)
+			}
+			if len(tb.ColumnRenames) > 0 {
+				m.AddColumns(ds.TableSchema, tb.TableName, tb.ColumnRenames)
+			}
+		}
+	}
+	return m
+}
+
+// AddDatabase maps all tables of the source database into the target database.
+func (m *NameMapper) AddDatabase(sourceDatabase, targetDatabase string) {
+	m.databases[sourceDatabase] = targetDatabase
+}
+
+// AddTable maps a source table onto a target table name. The database is still
+// mapped by AddDatabase.
+func (m *NameMapper) AddTable(sourceDatabase, sourceTable, targetTable string) {
+	if _, ok := m.tables[sourceDatabase]; !ok {
+		m.tables[sourceDatabase] = make(map[string]string)
+	}
+	m.tables[sourceDatabase][sourceTable] = targetTable
+}
+
+// AddColumns renames columns of a source table, by their source name, on the
+// target. A column may not be renamed onto the name of another column.
+func (m *NameMapper) AddColumns(sourceDatabase, sourceTable string, renames map[string]string) {
+	if _, ok := m.columns[sourceDatabase]; !ok {
+		m.columns[sourceDatabase] = make(map[string]ColumnRenameMap)
+	}
+	m.columns[sourceDatabase][sourceTable] = ColumnRenameMap(renames)
+}
+
+// SetLowerCaseTableNames sets the lower_case_table_names of the target server.
+// Unless it is 0, the server stores database and table names lowercase, and
+// Map lowercases the names it returns so that they match however the source
+// spells them. Column names are never changed: MySQL compares them without
+// regard to case whatever the setting.
+func (m *NameMapper) SetLowerCaseTableNames(value int) {
+	m.lowerCase = value != 0
+}
+
+// Map returns the target database and table for a source database and table.
+func (m *NameMapper) Map(databaseName, tableName string) (string, string) {
+	if m == nil {
+		return databaseName, tableName
+	}
+	targetTable := tableName
+	if tables, ok := m.tables[databaseName]; ok {
+		if t, ok := tables[tableName]; ok {
+			targetTable = t
+		}
+	}
+	targetDatabase := databaseName
+	if d, ok := m.databases[databaseName]; ok {
+		targetDatabase = d
+	}
+	if m.lowerCase {
+		return strings.ToLower(targetDatabase), strings.ToLower(targetTable)
+	}
+	return targetDatabase, targetTable
+}
+
+// ColumnRenames returns the renames of the columns of a source table, nil if
+// there are none.
+func (m *NameMapper) ColumnRenames(databaseName, tableName string) ColumnRenameMap {
+	if m == nil {
+		return nil
+	}
+	return m.columns[databaseName][tableName]
+}
+
+// MapColumns returns the columns of a source table under their target names.
+// The columns that AddColumns doesn't rename keep their name; without any
+// renames, columns is returned as is.
+func (m *NameMapper) MapColumns(databaseName, tableName string, columns *umconf.ColumnList) (*umconf.ColumnList, error) {
+	renames := m.ColumnRenames(databaseName, tableName)
+	if len(renames) == 0 {
+		return columns, nil
+	}
+	complete, err := renames.complete(columns)
+	if err != nil {
+		return nil, fmt.Errorf("%s of %s.%s", err, databaseName, tableName)
+	}
+	return complete.MapColumns(columns)
+}
+
+// SourceColumns is the reverse of MapColumns: it returns the columns of the
+// target of a source table, e.g. as read from the target, under the names of
+// the source columns.
+func (m *NameMapper) SourceColumns(databaseName, tableName string, targetColumns *umconf.ColumnList) (*umconf.ColumnList, error) {
+	renames := m.ColumnRenames(databaseName, tableName)
+	if len(renames) == 0 {
+		return targetColumns, nil
+	}
+	complete, err := renames.Reverse().complete(targetColumns)
+	if err != nil {
+		return nil, fmt.Errorf("%s of the target of %s.%s", err, databaseName, tableName)
+	}
+	return complete.MapColumns(targetColumns)
+}
+
+// ColumnRenameMap maps a source column name onto the target column name.
+type ColumnRenameMap map[string]string
+
+// MapColumns returns the mappedSharedColumns for sharedColumns, in the same order.
+// An empty map keeps the source names; otherwise every shared column must have
+// a target name. Everything but the name is kept from the source column.
+func (m ColumnRenameMap) MapColumns(sharedColumns *umconf.ColumnList) (*umconf.ColumnList, error) {
+	mapped := make([]umconf.Column, sharedColumns.Len())
+	copy(mapped, sharedColumns.ColumnList())
+	newColumnList := func() *umconf.ColumnList {
+		columns := umconf.NewColumnList(mapped)
+		columns.TimezonePolicy = sharedColumns.TimezonePolicy
+		columns.VersionColumn = sharedColumns.VersionColumn
+		columns.SourceOrdinals = sharedColumns.SourceOrdinals
+		return columns
+	}
+	if len(m) == 0 {
+		return newColumnList(), nil
+	}
+	seen := make(map[string]string)
+	for i := range mapped {
+		target, ok := m[mapped[i].Name]
+		if !ok || target == "" {
+			return nil, fmt.Errorf("No target column for source column %s in ColumnRenameMap", mapped[i].Name)
+		}
+		if source, ok := seen[target]; ok {
+			return nil, fmt.Errorf("Source columns %s and %s both map onto target column %s", source, mapped[i].Name, target)
+		}
+		seen[target] = mapped[i].Name
+		mapped[i].Name = target
+	}
+	columns := newColumnList()
+	// the version column and the ordinals of the rows are kept under the
+	// target names, for the builders to look them up by
+	if target, ok := m[columns.VersionColumn]; ok {
+		columns.VersionColumn = target
+	}
+	if sharedColumns.SourceOrdinals != nil {
+		columns.SourceOrdinals = make(umconf.ColumnsMap, len(sharedColumns.SourceOrdinals))
+		for name, ordinal := range sharedColumns.SourceOrdinals {
+			if target, ok := m[name]; ok {
+				name = target
+			}
+			columns.SourceOrdinals[name] = ordinal
+		}
+	}
+	return columns, nil
+}
+
+// Reverse returns the map of the target column names onto the source names.
+func (m ColumnRenameMap) Reverse() ColumnRenameMap {
+	reverse := make(ColumnRenameMap, len(m))
+	for source, target := range m {
+		reverse[target] = source
+	}
+	return reverse
+}
+
+// complete returns m with every other column of columns mapped onto itself,
+// so that a map of only the renamed columns can map all of them.
+func (m ColumnRenameMap) complete(columns *umconf.ColumnList) (ColumnRenameMap, error) {
+	complete := make(ColumnRenameMap, columns.Len())
+	for source, target := range m {
+		if columns.GetColumn(source) == nil {
+			return nil, fmt.Errorf("Column %s to rename onto %s not found", source, target)
+		}
+		complete[source] = target
+	}
+	for _, column := range columns.ColumnList() {
+		if _, ok := complete[column.Name]; !ok {
+			complete[column.Name] = column.Name
+		}
+	}
+	return complete, nil
+}
+
 // EscapeName will escape a db/table/column/... name by wrapping with backticks.
 // It is not fool proof. I'm just trying to do the right thing here, not solving
 // SQL injection issues, which should be irrelevant for this tool.
+// A dotted name is escaped as one identifier, use EscapeQualifiedName for
+// names qualified by their database.
 func EscapeName(name string) string {
 	if unquoted, err := strconv.Unquote(name); err == nil {
 		name = unquoted
@@ -37,6 +311,28 @@ func EscapeName(name string) string {
 	return fmt.Sprintf("`%s`", name)
 }
 
+// QueryComment renders text as a /* ... */ comment tagging a query, such as
+// with the job and chunk it is run for, for the logs of the target. A "*/"
+// in text is broken up so that it can't end the comment. It returns "" for
+// an empty text.
+func QueryComment(text string) string {
+	if text == "" {
+		return ""
+	}
+	return fmt.Sprintf("/* %s */", strings.Replace(text, "*/", "* /", -1))
+}
+
+// EscapeQualifiedName escapes each part of a qualified name, such as a
+// database and a table, and joins them with dots. A dot within a part is
+// kept as part of its name.
+func EscapeQualifiedName(parts ...string) string {
+	escaped := make([]string, len(parts))
+	for i, part := range parts {
+		escaped[i] = EscapeName(part)
+	}
+	return strings.Join(escaped, ".")
+}
+
 func EscapeColRawToString(col *interface{}) string {
 	if *col != nil {
 		return fmt.Sprintf("'%s'", EscapeValue(string((*col).([]byte))))
@@ -76,20 +372,182 @@ func EscapeValue(colValue string) string {
 	return colBuffer.String()
 }
 
-func buildColumnsPreparedValues(columns *umconf.ColumnList) []string {
-	values := make([]string, columns.Len(), columns.Len())
-	for i, column := range columns.ColumnList() {
-		var token string
-		if column.TimezoneConversion != nil {
-			token = fmt.Sprintf("convert_tz(?, '%s', '%s')", column.TimezoneConversion.ToTimezone, "+00:00")
-		} else {
-			token = "?"
+// EscapeBinaryValue renders a binary value as a hex literal (x'...'), so that
+// arbitrary bytes can be embedded in SQL text without breaking it.
+func EscapeBinaryValue(value interface{}) string {
+	var raw []byte
+	switch v := value.(type) {
+	case []byte:
+		raw = v
+	case string:
+		raw = []byte(v)
+	default:
+		raw = []byte(fmt.Sprintf("%v", v))
+	}
+	return fmt.Sprintf("x'%s'", hex.EncodeToString(raw))
+}
+
+// FormatLiteral renders an arg as a SQL literal, so that a query can be
+// written out with its args in place: NULL for nil, numbers as they are,
+// times and strings quoted with EscapeValue, and bytes as a hex literal,
+// which MySQL reads as a string where one is expected.
+func FormatLiteral(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return "NULL"
+	case *interface{}:
+		if v == nil {
+			return "NULL"
 		}
-		values[i] = token
+		return FormatLiteral(*v)
+	case bool:
+		if v {
+			return "1"
+		}
+		return "0"
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
+		return fmt.Sprintf("%d", v)
+	case float32:
+		return strconv.FormatFloat(float64(v), 'g', -1, 32)
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	case []byte:
+		return EscapeBinaryValue(v)
+	case string:
+		return fmt.Sprintf("'%s'", EscapeValue(v))
+	case time.Time:
+		return fmt.Sprintf("'%s'", v.Format("2006-01-02 15:04:05.999999"))
+	default:
+		return fmt.Sprintf("'%s'", EscapeValue(fmt.Sprintf("%v", v)))
+	}
+}
+
+// numericLiteral matches the decimal and float literals MySQL reads as numbers.
+var numericLiteral = regexp.MustCompile(`^[+-]?([0-9]+\.?[0-9]*|\.[0-9]+)([eE][+-]?[0-9]+)?$`)
+
+// EscapeColumnValue renders a value of a column as a SQL literal, by the
+// type of the column, for queries which inline values instead of binding
+// them: NULL for nil, numbers unquoted if they are numbers, binary and bit
+// values as hex literals, times in the format of their type and anything
+// else as a quoted string. A value read as text, as []byte or string, is
+// never put in a query unquoted unless it is a number.
+func EscapeColumnValue(value interface{}, column *umconf.Column) string {
+	if v, ok := value.(*interface{}); ok {
+		if v == nil {
+			return "NULL"
+		}
+		value = *v
+	}
+	if value == nil {
+		return "NULL"
+	}
+	switch column.Type {
+	case umconf.BinaryColumnType, umconf.VarbinaryColumnType, umconf.BlobColumnType, umconf.BitColumnType:
+		return EscapeBinaryValue(value)
+	case umconf.TinyintColumnType, umconf.SmallintColumnType, umconf.MediumIntColumnType, umconf.IntColumnType,
+		umconf.BigIntColumnType, umconf.FloatColumnType, umconf.DoubleColumnType, umconf.DecimalColumnType,
+		umconf.YearColumnType:
+		var text string
+		switch v := value.(type) {
+		case []byte:
+			text = string(v)
+		case string:
+			text = v
+		default:
+			return FormatLiteral(v)
+		}
+		if numericLiteral.MatchString(text) {
+			return text
+		}
+		return fmt.Sprintf("'%s'", EscapeValue(text))
+	}
+	switch v := value.(type) {
+	case []byte:
+		return fmt.Sprintf("'%s'", EscapeValue(string(v)))
+	case time.Time:
+		switch column.Type {
+		case umconf.DateColumnType:
+			return fmt.Sprintf("'%s'", v.Format("2006-01-02"))
+		case umconf.TimeColumnType:
+			return fmt.Sprintf("'%s'", v.Format("15:04:05.999999"))
+		}
+	}
+	return FormatLiteral(value)
+}
+
+// InlineArgs replaces the `?` of a query with its args rendered by
+// FormatLiteral, such as to write a statement out for replay. It fails if
+// the query doesn't have one `?` per arg.
+func InlineArgs(query string, args []interface{}) (string, error) {
+	var offsets []int
+	forEachPlaceholder(query, func(i int) {
+		offsets = append(offsets, i)
+	})
+	if len(offsets) != len(args) {
+		return "", fmt.Errorf("Got %d args for %d placeholders in InlineArgs", len(args), len(offsets))
+	}
+	var buf bytes.Buffer
+	last := 0
+	for i, offset := range offsets {
+		buf.WriteString(query[last:offset])
+		buf.WriteString(FormatLiteral(args[i]))
+		last = offset + 1
+	}
+	buf.WriteString(query[last:])
+	return buf.String(), nil
+}
+
+// buildColumnPreparedValue returns the placeholder of a column, converted to
+// another timezone by the column itself or by the list's TimezonePolicy.
+func buildColumnPreparedValue(d Dialect, columns *umconf.ColumnList, column *umconf.Column) string {
+	if column.TimezoneConversion != nil {
+		return d.TimezoneConvert(timezoneConvertArg(d, column), column.TimezoneConversion.ToTimezone, "+00:00")
+	}
+	if policy := columns.TimezonePolicy; policy.AppliesToColumn(column) {
+		return d.TimezoneConvert(timezoneConvertArg(d, column), policy.FromTimezone, policy.ToTimezone)
+	}
+	return "?"
+}
+
+// timezoneConvertArg returns the placeholder converted by buildColumnPreparedValue.
+// A value of a column with fractional seconds is cast to their precision
+// first, so that the conversion doesn't truncate them.
+func timezoneConvertArg(d Dialect, column *umconf.Column) string {
+	if column.Precision > 0 && column.Type != umconf.DecimalColumnType {
+		return d.DatetimeCast("?", column.Precision)
+	}
+	return "?"
+}
+
+func buildColumnsPreparedValues(d Dialect, columns *umconf.ColumnList) []string {
+	values := make([]string, columns.Len(), columns.Len())
+	for i := range columns.Columns {
+		values[i] = buildColumnPreparedValue(d, columns, &columns.Columns[i])
 	}
 	return values
 }
 
+// writableColumns returns sharedColumns and mappedSharedColumns without the
+// generated columns, which MySQL computes itself and refuses values for.
+func writableColumns(sharedColumns, mappedSharedColumns *umconf.ColumnList) (*umconf.ColumnList, *umconf.ColumnList) {
+	shared := []umconf.Column{}
+	mapped := []umconf.Column{}
+	for i, column := range sharedColumns.ColumnList() {
+		if column.Generated {
+			continue
+		}
+		shared = append(shared, column)
+		mapped = append(mapped, mappedSharedColumns.Columns[i])
+	}
+	if len(shared) == sharedColumns.Len() {
+		return sharedColumns, mappedSharedColumns
+	}
+	sharedList, mappedList := umconf.NewColumnList(shared), umconf.NewColumnList(mapped)
+	sharedList.TimezonePolicy = sharedColumns.TimezonePolicy
+	mappedList.TimezonePolicy = mappedSharedColumns.TimezonePolicy
+	return sharedList, mappedList
+}
+
 func duplicateNames(names []string) []string {
 	duplicate := make([]string, len(names), len(names))
 	copy(duplicate, names)
@@ -97,169 +555,900 @@ func duplicateNames(names []string) []string {
 }
 
 func BuildValueComparison(column string, value string, comparisonSign ValueComparisonSign) (result string, err error) {
+	return buildValueComparison(MySQLDialect{}, column, value, comparisonSign)
+}
+
+func buildValueComparison(d Dialect, column string, value string, comparisonSign ValueComparisonSign) (result string, err error) {
 	if column == "" {
 		return "", fmt.Errorf("Empty column in GetValueComparison")
 	}
 	if value == "" {
 		return "", fmt.Errorf("Empty value in GetValueComparison")
 	}
-	comparison := fmt.Sprintf("(%s %s %s)", EscapeName(column), string(comparisonSign), value)
+	comparison := fmt.Sprintf("(%s %s %s)", d.QuoteIdent(column), string(comparisonSign), value)
 	return comparison, err
 }
 
 func BuildSetPreparedClause(columns *umconf.ColumnList) (result string, err error) {
+	return buildSetPreparedClause(MySQLDialect{}, columns)
+}
+
+func buildSetPreparedClause(d Dialect, columns *umconf.ColumnList) (result string, err error) {
 	if columns.Len() == 0 {
 		return "", fmt.Errorf("Got 0 columns in BuildSetPreparedClause")
 	}
 	setTokens := []string{}
-	for _, column := range columns.ColumnList() {
-		var setToken string
-		if column.TimezoneConversion != nil {
-			setToken = fmt.Sprintf("%s=convert_tz(?, '%s', '%s')", EscapeName(column.Name), column.TimezoneConversion.ToTimezone, "+00:00")
-		} else {
-			setToken = fmt.Sprintf("%s=?", EscapeName(column.Name))
-		}
+	for i := range columns.Columns {
+		column := &columns.Columns[i]
+		setToken := fmt.Sprintf("%s=%s", d.QuoteIdent(column.Name), buildColumnPreparedValue(d, columns, column))
 		setTokens = append(setTokens, setToken)
 	}
 	return strings.Join(setTokens, ", "), nil
 }
 
+// BuildOnDuplicateUpdateClause builds the trailing clause of an insert in
+// InsertModeOnDuplicateUpdate, which overwrites every given column.
+func BuildOnDuplicateUpdateClause(columns *umconf.ColumnList) (result string, err error) {
+	if columns.Len() == 0 {
+		return "", fmt.Errorf("Got 0 columns in BuildOnDuplicateUpdateClause")
+	}
+	updateTokens := []string{}
+	for _, column := range columns.ColumnList() {
+		columnName := EscapeName(column.Name)
+		updateTokens = append(updateTokens, fmt.Sprintf("%s=values(%s)", columnName, columnName))
+	}
+	return fmt.Sprintf("on duplicate key update %s", strings.Join(updateTokens, ", ")), nil
+}
+
+// DMLStatement is a generated DML statement in a form that can be changed
+// before it is rendered by String, e.g. to route it to another table or to tag
+// it with a comment. Names are kept unescaped; Values, Set and Where hold
+// rendered SQL fragments.
+type DMLStatement struct {
+	// Dialect renders the names. A nil Dialect is MySQLDialect.
+	Dialect Dialect
+	// Comment, if set, is rendered as a /* ... */ comment right after
+	// Verb, see QueryComment.
+	Comment  string
+	Verb     string
+	Database string
+	Table    string
+	Columns  []string
+	Values   []string
+	// ValueRows is how many rows of Values a multi-row insert has, each with
+	// args of its own. Zero means one.
+	ValueRows int
+	Set       string
+	// Where comparisons are joined with "and".
+	Where  []string
+	Suffix string
+	// ArgColumns names the column of each `?` of the statement, in the order
+	// of its args. It is nil for statements not built from columns.
+	ArgColumns []string
+
+	inlined bool
+}
+
+// PreparedStmt describes a rendered query for preparing it.
+type PreparedStmt struct {
+	SQL string
+	// Placeholders is the number of `?` in SQL, i.e. the number of args to bind.
+	Placeholders int
+	// Reusable tells whether SQL is the same for all rows of the table, and can
+	// be prepared once. It is not when row values (NULL, binary keys) were
+	// inlined into the query.
+	Reusable bool
+	// ArgColumns is DMLStatement.ArgColumns.
+	ArgColumns []string
+}
+
+// Prepared renders the statement as a PreparedStmt, with the placeholders of
+// its Dialect.
+func (s *DMLStatement) Prepared() *PreparedStmt {
+	query := s.String()
+	return &PreparedStmt{
+		SQL:          dialectOrDefault(s.Dialect).Rebind(query),
+		Placeholders: countPlaceholders(query),
+		Reusable:     !s.inlined,
+		ArgColumns:   s.ArgColumns,
+	}
+}
+
+// FormatArgs renders the args of a statement as "column=value" pairs for
+// logging, naming each arg by its ArgColumns entry. Args without one are
+// named by their position.
+func FormatArgs(argColumns []string, args []interface{}) string {
+	pairs := make([]string, len(args))
+	for i, arg := range args {
+		name := fmt.Sprintf("$%d", i+1)
+		if i < len(argColumns) {
+			name = argColumns[i]
+		}
+		if b, ok := arg.([]byte); ok {
+			arg = string(b)
+		}
+		pairs[i] = fmt.Sprintf("%s=%v", name, arg)
+	}
+	return strings.Join(pairs, ", ")
+}
+
+// NamedArgs rewrites the `?` placeholders of a query into :p0, :p1, ... for
+// logging, returning the args by those names, so that the arg of each part
+// of a statement, such as of a nested range comparison, can be told apart.
+// The query is for reading only: it runs with its `?` and args.
+func NamedArgs(query string, args []interface{}) (string, map[string]interface{}, error) {
+	if n := countPlaceholders(query); n != len(args) {
+		return "", nil, fmt.Errorf("Got %d args for %d placeholders in NamedArgs", len(args), n)
+	}
+	var buf bytes.Buffer
+	named := make(map[string]interface{}, len(args))
+	n := 0
+	last := 0
+	forEachPlaceholder(query, func(i int) {
+		name := fmt.Sprintf("p%d", n)
+		named[name] = args[n]
+		n++
+		buf.WriteString(query[last:i])
+		buf.WriteString(":" + name)
+		last = i + 1
+	})
+	buf.WriteString(query[last:])
+	return buf.String(), named, nil
+}
+
+// countPlaceholders counts the `?` of a query, skipping comments, quoted strings
+// and names.
+func countPlaceholders(query string) int {
+	count := 0
+	forEachPlaceholder(query, func(int) {
+		count++
+	})
+	return count
+}
+
+// forEachPlaceholder calls f with the offset of every `?` of a query, skipping
+// comments, quoted strings and names.
+func forEachPlaceholder(query string, f func(i int)) {
+	var quote byte
+	for i := 0; i < len(query); i++ {
+		ch := query[i]
+		switch {
+		case quote == 0 && strings.HasPrefix(query[i:], "/*"):
+			if end := strings.Index(query[i+2:], "*/"); end >= 0 {
+				i += end + 3
+			} else {
+				i = len(query)
+			}
+		case quote != 0 && ch == '\\' && quote != '`':
+			i++
+		case quote != 0 && ch == quote:
+			quote = 0
+		case quote != 0:
+		case ch == '\'' || ch == '"' || ch == '`':
+			quote = ch
+		case ch == '?':
+			f(i)
+		}
+	}
+}
+
+func (s *DMLStatement) String() string {
+	d := dialectOrDefault(s.Dialect)
+	var buf bytes.Buffer
+	buf.WriteString(s.Verb)
+	if s.Comment != "" {
+		fmt.Fprintf(&buf, " %s", QueryComment(s.Comment))
+	}
+	fmt.Fprintf(&buf, " %s.%s", d.QuoteIdent(s.Database), d.QuoteIdent(s.Table))
+	if len(s.Columns) > 0 {
+		columns := duplicateNames(s.Columns)
+		for i := range columns {
+			columns[i] = d.QuoteIdent(columns[i])
+		}
+		fmt.Fprintf(&buf, " (%s)", strings.Join(columns, ", "))
+	}
+	if len(s.Values) > 0 {
+		row := fmt.Sprintf("(%s)", strings.Join(s.Values, ", "))
+		rows := make([]string, 1, s.ValueRows+1)
+		rows[0] = row
+		for i := 1; i < s.ValueRows; i++ {
+			rows = append(rows, row)
+		}
+		fmt.Fprintf(&buf, " values %s", strings.Join(rows, ", "))
+	}
+	if s.Set != "" {
+		fmt.Fprintf(&buf, " set %s", s.Set)
+	}
+	if len(s.Where) > 0 {
+		fmt.Fprintf(&buf, " where (%s)", strings.Join(s.Where, " and "))
+	}
+	if s.Suffix != "" {
+		fmt.Fprintf(&buf, " %s", s.Suffix)
+	}
+	return buf.String()
+}
+
+// ColumnArgMismatchError is returned by the DML builders when a row has fewer
+// args than the table has columns, which means the caller passed the wrong
+// row or column list.
+type ColumnArgMismatchError struct {
+	// Builder is the function that rejected the args and Args names the
+	// rejected ones, e.g. "where args".
+	Builder string
+	Args    string
+
+	Expected int
+	Actual   int
+}
+
+func (e *ColumnArgMismatchError) Error() string {
+	return fmt.Sprintf("%s count differs from table column count in %s %v, %v",
+		e.Args, e.Builder, e.Actual, e.Expected)
+}
+
+// checkArgCount rejects args shorter than tableColumns, unless the args are
+// laid out by its SourceOrdinals, which argOrdinal checks column by column.
+func checkArgCount(builder, argsName string, tableColumns *umconf.ColumnList, args []*interface{}) error {
+	if tableColumns.SourceOrdinals == nil && len(args) < tableColumns.Len() {
+		return &ColumnArgMismatchError{Builder: builder, Args: argsName,
+			Expected: tableColumns.Len(), Actual: len(args)}
+	}
+	return nil
+}
+
+// argOrdinal returns the index of the arg of a column within args. Args are
+// bound by column name, so that a row of a source table whose columns are in
+// another order is written to the right columns.
+func argOrdinal(builder string, tableColumns *umconf.ColumnList, args []*interface{}, name string) (int, error) {
+	ordinal, ok := tableColumns.ArgOrdinal(name)
+	if !ok {
+		return 0, fmt.Errorf("column %s not found in the source row in %s", name, builder)
+	}
+	if ordinal >= len(args) {
+		return 0, &ColumnArgMismatchError{Builder: builder, Args: "args", Expected: ordinal + 1, Actual: len(args)}
+	}
+	return ordinal, nil
+}
+
 func BuildDMLDeleteQuery(databaseName, tableName string, tableColumns *umconf.ColumnList, args []*interface{}) (result string, columnArgs []interface{}, err error) {
-	if len(args) < tableColumns.Len() {
-		return result, columnArgs, fmt.Errorf("args count differs from table column count in BuildDMLDeleteQuery %v, %v",
-			len(args), tableColumns.Len())
+	var stmt *DMLStatement
+	stmt, columnArgs, err = BuildDMLDeleteQueryAST(MySQLDialect{}, databaseName, tableName, tableColumns, args)
+	if err != nil {
+		return result, columnArgs, err
+	}
+	return stmt.String(), columnArgs, nil
+}
+
+// BuildDMLDeleteQueryAST is BuildDMLDeleteQuery, returning the statement unrendered
+// and rendering it in dialect d.
+func BuildDMLDeleteQueryAST(d Dialect, databaseName, tableName string, tableColumns *umconf.ColumnList, args []*interface{}) (stmt *DMLStatement, columnArgs []interface{}, err error) {
+	d = dialectOrDefault(d)
+	if err := checkArgCount("BuildDMLDeleteQuery", "args", tableColumns, args); err != nil {
+		return stmt, columnArgs, err
 	}
 	comparisons := []string{}
 	uniqueKeyComparisons := []string{}
 	uniqueKeyArgs := make([]interface{}, 0)
+	argColumns, uniqueKeyArgColumns := []string{}, []string{}
+	// whether values were inlined into the comparisons, making the query row specific
+	inlined, uniqueKeyInlined := false, false
 	for _, column := range tableColumns.ColumnList() {
-		tableOrdinal := tableColumns.Ordinals[column.Name]
+		tableOrdinal, err := argOrdinal("BuildDMLDeleteQuery", tableColumns, args, column.Name)
+		if err != nil {
+			return stmt, columnArgs, err
+		}
 		if *args[tableOrdinal] == nil {
-			comparison, err := BuildValueComparison(column.Name, "NULL", IsEqualsComparisonSign)
+			comparison, err := buildValueComparison(d, column.Name, "NULL", IsEqualsComparisonSign)
 			if err != nil {
-				return result, columnArgs, err
+				return stmt, columnArgs, err
 			}
+			inlined = true
 			comparisons = append(comparisons, comparison)
 		} else {
-			if strings.HasPrefix(column.ColumnType, "binary") {
-				arg := column.ConvertArg(*args[tableOrdinal])
-				comparison, err := BuildValueComparison(column.Name, fmt.Sprintf("cast('%v' as %s)", arg, column.ColumnType), EqualsComparisonSign)
+			if column.Type == umconf.BinaryColumnType {
+				arg := column.TransformArg(*args[tableOrdinal])
+				comparison, err := buildValueComparison(d, column.Name, d.BinaryLiteral(arg, column.ColumnType), EqualsComparisonSign)
 				if err != nil {
-					return result, columnArgs, err
+					return stmt, columnArgs, err
 				}
 				if strings.ToUpper(column.Key) == "PRI" {
+					uniqueKeyInlined = true
 					uniqueKeyComparisons = append(uniqueKeyComparisons, comparison)
 				} else {
+					inlined = true
 					comparisons = append(comparisons, comparison)
 				}
+			} else if column.Type == umconf.JSONColumnType {
+				// json can't be part of a key
+				columnArgs = append(columnArgs, column.TransformArg(*args[tableOrdinal]))
+				argColumns = append(argColumns, column.Name)
+				comparisons = append(comparisons, d.JSONEquals(column.Name))
 			} else {
-				arg := column.ConvertArg(*args[tableOrdinal])
-				comparison, err := BuildValueComparison(column.Name, "?", EqualsComparisonSign)
+				arg := column.TransformArg(*args[tableOrdinal])
+				comparison, err := buildValueComparison(d, column.Name, "?", EqualsComparisonSign)
 				if err != nil {
-					return result, columnArgs, err
+					return stmt, columnArgs, err
 				}
 				if strings.ToUpper(column.Key) == "PRI" {
 					uniqueKeyArgs = append(uniqueKeyArgs, arg)
+					uniqueKeyArgColumns = append(uniqueKeyArgColumns, column.Name)
 					uniqueKeyComparisons = append(uniqueKeyComparisons, comparison)
 				} else {
 					columnArgs = append(columnArgs, arg)
+					argColumns = append(argColumns, column.Name)
 					comparisons = append(comparisons, comparison)
 				}
 			}
 		}
 	}
 	if len(uniqueKeyComparisons) > 0 {
+		// binary key columns are inlined, so the key args may be empty
 		comparisons = uniqueKeyComparisons
-	}
-	if len(uniqueKeyArgs) > 0 {
 		columnArgs = uniqueKeyArgs
+		argColumns = uniqueKeyArgColumns
+		inlined = uniqueKeyInlined
+	}
+	stmt = &DMLStatement{
+		Dialect:  d,
+		Verb:     "delete from",
+		Database: databaseName,
+		Table:    tableName,
+		Where:    comparisons,
+		inlined:  inlined,
+
+		ArgColumns: argColumns,
+	}
+	return stmt, columnArgs, nil
+}
+
+// BuildBoundedDeleteQuery builds a delete of at most limit rows matching
+// whereComparison, taken in ascending order of orderColumns, so that a purge
+// can delete a big range in batches of small transactions. whereComparison is
+// a rendered predicate, e.g. of BuildValueComparison, whose `?` placeholders
+// are bound to whereArgs.
+func BuildBoundedDeleteQuery(databaseName, tableName string, whereComparison string, whereArgs []interface{}, orderColumns *umconf.ColumnList, limit int64) (result string, explodedArgs []interface{}, err error) {
+	if limit <= 0 {
+		return "", explodedArgs, fmt.Errorf("Got limit %d in BuildBoundedDeleteQuery, want > 0", limit)
+	}
+	if whereComparison == "" {
+		return "", explodedArgs, fmt.Errorf("Got no where comparison in BuildBoundedDeleteQuery")
+	}
+	if orderColumns.Len() == 0 {
+		return "", explodedArgs, fmt.Errorf("Got 0 order columns in BuildBoundedDeleteQuery")
+	}
+	if placeholders := countPlaceholders(whereComparison); placeholders != len(whereArgs) {
+		return "", explodedArgs, fmt.Errorf("Got %d args for %d placeholders in BuildBoundedDeleteQuery", len(whereArgs), placeholders)
+	}
+	orderBy := make([]string, orderColumns.Len())
+	for i, column := range orderColumns.ColumnList() {
+		orderBy[i] = fmt.Sprintf("%s asc", EscapeName(column.Name))
+	}
+	stmt := &DMLStatement{
+		Verb:     "delete from",
+		Database: databaseName,
+		Table:    tableName,
+		Where:    []string{whereComparison},
+		Suffix:   fmt.Sprintf("order by %s limit %d", strings.Join(orderBy, ", "), limit),
+	}
+	explodedArgs = append(explodedArgs, whereArgs...)
+	return stmt.String(), explodedArgs, nil
+}
+
+// BuildRangeDeleteQuery builds a delete of the rows of a table whose unique
+// key is after rangeStartArgs, or at it if includeStart is set, and up to
+// rangeEndArgs, such as to clear a chunk on the target before copying it
+// again.
+func BuildRangeDeleteQuery(databaseName, tableName string, uniqueKeyColumns *umconf.ColumnList, rangeStartArgs, rangeEndArgs []interface{}, includeStart bool) (result string, explodedArgs []interface{}, err error) {
+	n := uniqueKeyColumns.Len()
+	if n == 0 {
+		return "", explodedArgs, fmt.Errorf("Got 0 unique key columns in BuildRangeDeleteQuery")
+	}
+	if len(rangeStartArgs) != n || len(rangeEndArgs) != n {
+		return "", explodedArgs, fmt.Errorf("Got %d and %d range values for %d unique key columns in BuildRangeDeleteQuery",
+			len(rangeStartArgs), len(rangeEndArgs), n)
+	}
+	start, startArgs := buildKeyRangeComparison(uniqueKeyColumns, rangeStartArgs, false, includeStart)
+	end, endArgs := buildKeyRangeComparison(uniqueKeyColumns, rangeEndArgs, true, true)
+	stmt := &DMLStatement{
+		Verb:     "delete from",
+		Database: databaseName,
+		Table:    tableName,
+		Where:    []string{start, end},
+	}
+	explodedArgs = append(startArgs, endArgs...)
+	return stmt.String(), explodedArgs, nil
+}
+
+// buildKeyRangeComparison builds the comparison of the unique keys after
+// values, or before them if before is set, in the order of the key. If
+// inclusive is set, values itself is in the range.
+func buildKeyRangeComparison(uniqueKeyColumns *umconf.ColumnList, values []interface{}, before, inclusive bool) (string, []interface{}) {
+	var args []interface{}
+	n := uniqueKeyColumns.Len()
+	rangeItems := make([]string, 0, n+1)
+	for x := 0; x < n; x++ {
+		innerItems := make([]string, x+1)
+		for y := 0; y < x; y++ {
+			column := &uniqueKeyColumns.Columns[y]
+			innerItems[y] = fmt.Sprintf("(%s = ?)", EscapeName(column.Name))
+			args = append(args, column.ConvertArg(values[y]))
+		}
+		column := &uniqueKeyColumns.Columns[x]
+		sign := AfterComparisonSign(column)
+		if before {
+			sign = beforeComparisonSign(column)
+		}
+		innerItems[x] = fmt.Sprintf("(%s %s ?)", EscapeName(column.Name), sign)
+		args = append(args, column.ConvertArg(values[x]))
+		rangeItems = append(rangeItems, fmt.Sprintf("(%s)", strings.Join(innerItems, " and ")))
+	}
+	if inclusive {
+		// the key itself
+		equalItems := make([]string, n)
+		for y := 0; y < n; y++ {
+			column := &uniqueKeyColumns.Columns[y]
+			equalItems[y] = fmt.Sprintf("(%s = ?)", EscapeName(column.Name))
+			args = append(args, column.ConvertArg(values[y]))
+		}
+		rangeItems = append(rangeItems, fmt.Sprintf("(%s)", strings.Join(equalItems, " and ")))
+	}
+	return fmt.Sprintf("(%s)", strings.Join(rangeItems, " or ")), args
+}
+
+// BuildRangeChecksumQuery builds the query of the row count and checksum of
+// the rows of a table matching rangeCondition, such as the range of a chunk.
+// The checksum is the bit_xor of the crc32 of every row, so it doesn't depend
+// on the order rows are read in, and NULLs are told apart from empty strings.
+// Running it on the source and the target tells whether the rows differ.
+func BuildRangeChecksumQuery(databaseName, tableName string, columns *umconf.ColumnList, rangeCondition string) (string, error) {
+	if columns.Len() == 0 {
+		return "", fmt.Errorf("Got 0 columns in BuildRangeChecksumQuery")
+	}
+	if rangeCondition == "" {
+		return "", fmt.Errorf("Got no range condition in BuildRangeChecksumQuery")
+	}
+	values := make([]string, 0, 2*columns.Len())
+	for _, column := range columns.ColumnList() {
+		values = append(values, EscapeName(column.Name))
+	}
+	for _, column := range columns.ColumnList() {
+		values = append(values, fmt.Sprintf("isnull(%s)", EscapeName(column.Name)))
+	}
+	return fmt.Sprintf("select count(*), coalesce(bit_xor(crc32(concat_ws('#', %s))), 0) from %s where %s",
+		strings.Join(values, ", "), EscapeQualifiedName(databaseName, tableName), rangeCondition), nil
+}
+
+// BuildGapCheckQuery builds the query counting the rows whose unique key is
+// strictly between lowerArgs and upperArgs, such as the last key of a chunk
+// and the first of the next. A copy that saw nothing between them expects
+// zero, so any other count is a region it missed.
+func BuildGapCheckQuery(databaseName, tableName string, uniqueKeyColumns *umconf.ColumnList, lowerArgs, upperArgs []interface{}) (result string, explodedArgs []interface{}, err error) {
+	n := uniqueKeyColumns.Len()
+	if n == 0 {
+		return "", explodedArgs, fmt.Errorf("Got 0 unique key columns in BuildGapCheckQuery")
+	}
+	if len(lowerArgs) != n || len(upperArgs) != n {
+		return "", explodedArgs, fmt.Errorf("Got %d and %d boundary values for %d unique key columns in BuildGapCheckQuery",
+			len(lowerArgs), len(upperArgs), n)
+	}
+	lower, lowerExplodedArgs := buildKeyRangeComparison(uniqueKeyColumns, lowerArgs, false, false)
+	upper, upperExplodedArgs := buildKeyRangeComparison(uniqueKeyColumns, upperArgs, true, false)
+	explodedArgs = append(lowerExplodedArgs, upperExplodedArgs...)
+	result = fmt.Sprintf("select count(*) from %s where %s and %s",
+		EscapeQualifiedName(databaseName, tableName), lower, upper)
+	return result, explodedArgs, nil
+}
+
+// BuildUniqueKeyMinValuesPreparedQuery builds the query reading the unique key
+// of the first row of a table, in the order of the key.
+func BuildUniqueKeyMinValuesPreparedQuery(databaseName, tableName string, uniqueKeyColumns *umconf.ColumnList) (string, error) {
+	return buildUniqueKeyMinMaxValuesPreparedQuery(databaseName, tableName, uniqueKeyColumns, false)
+}
+
+// BuildUniqueKeyMaxValuesPreparedQuery builds the query reading the unique key
+// of the last row of a table, in the order of the key.
+func BuildUniqueKeyMaxValuesPreparedQuery(databaseName, tableName string, uniqueKeyColumns *umconf.ColumnList) (string, error) {
+	return buildUniqueKeyMinMaxValuesPreparedQuery(databaseName, tableName, uniqueKeyColumns, true)
+}
+
+func buildUniqueKeyMinMaxValuesPreparedQuery(databaseName, tableName string, uniqueKeyColumns *umconf.ColumnList, last bool) (string, error) {
+	if uniqueKeyColumns.Len() == 0 {
+		return "", fmt.Errorf("Got 0 columns in BuildUniqueKeyMinMaxValuesPreparedQuery")
 	}
-	databaseName = EscapeName(databaseName)
-	tableName = EscapeName(tableName)
+	names := make([]string, uniqueKeyColumns.Len())
+	order := make([]string, uniqueKeyColumns.Len())
+	for i := range uniqueKeyColumns.Columns {
+		column := &uniqueKeyColumns.Columns[i]
+		direction := column.SortDirection
+		if last {
+			if direction == umconf.SortDescending {
+				direction = umconf.SortAscending
+			} else {
+				direction = umconf.SortDescending
+			}
+		}
+		names[i] = EscapeName(column.Name)
+		order[i] = fmt.Sprintf("%s %s", names[i], direction.Keyword())
+	}
+	return fmt.Sprintf("select /* udup %s.%s */ %s from %s order by %s limit 1",
+		EscapeName(databaseName), EscapeName(tableName), strings.Join(names, ", "),
+		EscapeQualifiedName(databaseName, tableName), strings.Join(order, ", ")), nil
+}
+
+// BuildKeyBoundsCheckQuery builds the query counting, up to two, the rows
+// whose unique key is maxArgs or past it, to check cheaply whether the
+// cached bounds of a table still hold, see KeyBoundsStale.
+func BuildKeyBoundsCheckQuery(databaseName, tableName string, uniqueKeyColumns *umconf.ColumnList, maxArgs []interface{}) (result string, explodedArgs []interface{}, err error) {
+	n := uniqueKeyColumns.Len()
+	if n == 0 {
+		return "", explodedArgs, fmt.Errorf("Got 0 unique key columns in BuildKeyBoundsCheckQuery")
+	}
+	if len(maxArgs) != n {
+		return "", explodedArgs, fmt.Errorf("Got %d max values for %d unique key columns in BuildKeyBoundsCheckQuery", len(maxArgs), n)
+	}
+	atOrPast, explodedArgs := buildKeyRangeComparison(uniqueKeyColumns, maxArgs, false, true)
+	result = fmt.Sprintf("select count(*) from (select 1 from %s where %s limit 2) as bound",
+		EscapeQualifiedName(databaseName, tableName), atOrPast)
+	return result, explodedArgs, nil
+}
+
+// KeyBoundsStale tells whether the cached bounds of a table must be found
+// again, given the count of BuildKeyBoundsCheckQuery: the row of the max is
+// gone, so the table shrank, or there are rows past it, unless the table is
+// append-only and they were appended after the bounds were found.
+func KeyBoundsStale(atOrPastMax int64, appendOnly bool) bool {
+	if atOrPastMax == 0 {
+		return true
+	}
+	return atOrPastMax > 1 && !appendOnly
+}
+
+// BuildTupleInComparison builds the comparison of columns with rowCount
+// tuples of `?` placeholders, e.g. "((`a`, `b`) in ((?, ?), (?, ?)))". Its
+// placeholders take the values of the rows one after the other. It can be the
+// predicate of BuildBoundedDeleteQuery.
+func BuildTupleInComparison(columns *umconf.ColumnList, rowCount int) (result string, err error) {
+	if columns.Len() == 0 {
+		return "", fmt.Errorf("Got 0 columns in BuildTupleInComparison")
+	}
+	if rowCount <= 0 {
+		return "", fmt.Errorf("Got %d rows in BuildTupleInComparison, want > 0", rowCount)
+	}
+	names := make([]string, columns.Len())
+	placeholders := make([]string, columns.Len())
+	for i, column := range columns.ColumnList() {
+		names[i] = EscapeName(column.Name)
+		placeholders[i] = "?"
+	}
+	tuple := fmt.Sprintf("(%s)", strings.Join(placeholders, ", "))
+	tuples := make([]string, rowCount)
+	for i := range tuples {
+		tuples[i] = tuple
+	}
+	return fmt.Sprintf("((%s) in (%s))", strings.Join(names, ", "), strings.Join(tuples, ", ")), nil
+}
+
+// BuildKeysExistQuery builds a select of those unique keys in rowsArgs that
+// exist in the table, so that a chunk can be split into inserts and updates
+// before it is written. Every row of rowsArgs holds the values of
+// uniqueKeyColumns, in order; they are flattened into explodedArgs row by row.
+func BuildKeysExistQuery(databaseName, tableName string, uniqueKeyColumns *umconf.ColumnList, rowsArgs [][]interface{}) (result string, explodedArgs []interface{}, err error) {
+	comparison, err := BuildTupleInComparison(uniqueKeyColumns, len(rowsArgs))
 	if err != nil {
-		return result, columnArgs, err
+		return "", explodedArgs, err
+	}
+	if explodedArgs, err = explodeKeyRows("BuildKeysExistQuery", uniqueKeyColumns, rowsArgs); err != nil {
+		return "", explodedArgs, err
+	}
+	result = fmt.Sprintf("select %s from %s where %s",
+		strings.Join(escapeNames(uniqueKeyColumns), ", "), EscapeQualifiedName(databaseName, tableName), comparison)
+	return result, explodedArgs, nil
+}
+
+// BuildOrphanCheckQuery builds a select of the unique keys of the target in
+// the range of a chunk, after from and up to to, which are not among the
+// keys of the rows of the chunk on the source, sourceKeysArgs. Those are
+// orphans, such as left by an earlier run, which can then be deleted. A nil
+// from starts at the first row. Every row of sourceKeysArgs holds the values
+// of uniqueKeyColumns, in order, none of them NULL, as NOT IN never holds
+// against a NULL; they are flattened into explodedArgs after the range.
+func BuildOrphanCheckQuery(databaseName, tableName string, uniqueKeyColumns *umconf.ColumnList, sourceKeysArgs [][]interface{}, from, to []interface{}) (result string, explodedArgs []interface{}, err error) {
+	n := uniqueKeyColumns.Len()
+	if n == 0 {
+		return "", explodedArgs, fmt.Errorf("Got 0 unique key columns in BuildOrphanCheckQuery")
+	}
+	if from != nil && len(from) != n || len(to) != n {
+		return "", explodedArgs, fmt.Errorf("Got %d and %d range values for %d unique key columns in BuildOrphanCheckQuery",
+			len(from), len(to), n)
+	}
+
+	var where []string
+	if from != nil {
+		comparison, args := buildKeyRangeComparison(uniqueKeyColumns, from, false, false)
+		where = append(where, comparison)
+		explodedArgs = append(explodedArgs, args...)
+	}
+	comparison, args := buildKeyRangeComparison(uniqueKeyColumns, to, true, true)
+	where = append(where, comparison)
+	explodedArgs = append(explodedArgs, args...)
+
+	// Every target row of a chunk without source rows is an orphan.
+	if len(sourceKeysArgs) > 0 {
+		for i, rowArgs := range sourceKeysArgs {
+			for _, arg := range rowArgs {
+				if arg == nil {
+					return "", explodedArgs, fmt.Errorf("Got NULL in row %d in BuildOrphanCheckQuery", i)
+				}
+			}
+		}
+		keyArgs, err := explodeKeyRows("BuildOrphanCheckQuery", uniqueKeyColumns, sourceKeysArgs)
+		if err != nil {
+			return "", explodedArgs, err
+		}
+		in, err := BuildTupleInComparison(uniqueKeyColumns, len(sourceKeysArgs))
+		if err != nil {
+			return "", explodedArgs, err
+		}
+		where = append(where, fmt.Sprintf("(not %s)", in))
+		explodedArgs = append(explodedArgs, keyArgs...)
 	}
-	result = fmt.Sprintf(`
-			delete
-				from
-					%s.%s
-				where
-					%s
-		`, databaseName, tableName,
-		fmt.Sprintf("(%s)", strings.Join(comparisons, " and ")),
-	)
-	return result, columnArgs, nil
+
+	result = fmt.Sprintf("select %s from %s where %s",
+		strings.Join(escapeNames(uniqueKeyColumns), ", "), EscapeQualifiedName(databaseName, tableName), strings.Join(where, " and "))
+	return result, explodedArgs, nil
+}
+
+// explodeKeyRows flattens the unique key values of rows, row by row,
+// converting each by its column.
+func explodeKeyRows(builder string, uniqueKeyColumns *umconf.ColumnList, rowsArgs [][]interface{}) (explodedArgs []interface{}, err error) {
+	for i, rowArgs := range rowsArgs {
+		if len(rowArgs) != uniqueKeyColumns.Len() {
+			return nil, fmt.Errorf("Got %d args in row %d for %d unique key columns in %s",
+				len(rowArgs), i, uniqueKeyColumns.Len(), builder)
+		}
+		for j := range rowArgs {
+			explodedArgs = append(explodedArgs, uniqueKeyColumns.Columns[j].ConvertArg(rowArgs[j]))
+		}
+	}
+	return explodedArgs, nil
 }
 
-func BuildDMLInsertQuery(databaseName, tableName string, tableColumns, sharedColumns, mappedSharedColumns *umconf.ColumnList, args []*interface{}) (result string, sharedArgs []interface{}, err error) {
-	if len(args) < tableColumns.Len() {
-		return result, sharedArgs, fmt.Errorf("args count differs from table column count in BuildDMLInsertQuery %v, %v",
-			len(args), tableColumns.Len())
+// escapeNames returns the escaped names of columns.
+func escapeNames(columns *umconf.ColumnList) []string {
+	names := make([]string, columns.Len())
+	for i, column := range columns.ColumnList() {
+		names[i] = EscapeName(column.Name)
+	}
+	return names
+}
+
+func BuildDMLInsertQuery(databaseName, tableName string, tableColumns, sharedColumns, mappedSharedColumns *umconf.ColumnList, args []*interface{}, mode InsertMode) (result string, sharedArgs []interface{}, err error) {
+	var stmt *DMLStatement
+	stmt, sharedArgs, err = BuildDMLInsertQueryAST(MySQLDialect{}, databaseName, tableName, tableColumns, sharedColumns, mappedSharedColumns, args, mode)
+	if err != nil {
+		return result, sharedArgs, err
+	}
+	return stmt.String(), sharedArgs, nil
+}
+
+// BuildDMLInsertIgnoreQuery is BuildDMLInsertQuery with InsertModeIgnore: a
+// row colliding with an existing one is discarded, so that replaying an
+// insert already applied doesn't fail its batch.
+func BuildDMLInsertIgnoreQuery(databaseName, tableName string, tableColumns, sharedColumns, mappedSharedColumns *umconf.ColumnList, args []*interface{}) (result string, sharedArgs []interface{}, err error) {
+	return BuildDMLInsertQuery(databaseName, tableName, tableColumns, sharedColumns, mappedSharedColumns, args, InsertModeIgnore)
+}
+
+// BuildDMLInsertQueryAST is BuildDMLInsertQuery, returning the statement unrendered
+// and rendering it in dialect d.
+func BuildDMLInsertQueryAST(d Dialect, databaseName, tableName string, tableColumns, sharedColumns, mappedSharedColumns *umconf.ColumnList, args []*interface{}, mode InsertMode) (stmt *DMLStatement, sharedArgs []interface{}, err error) {
+	d = dialectOrDefault(d)
+	if err := checkArgCount("BuildDMLInsertQuery", "args", tableColumns, args); err != nil {
+		return stmt, sharedArgs, err
 	}
 
 	if !sharedColumns.IsSubsetOf(tableColumns) {
-		return result, sharedArgs, fmt.Errorf("shared columns is not a subset of table columns in BuildDMLInsertQuery")
+		return stmt, sharedArgs, fmt.Errorf("shared columns is not a subset of table columns in BuildDMLInsertQuery")
 	}
 	if sharedColumns.Len() == 0 {
-		return result, sharedArgs, fmt.Errorf("No shared columns found in BuildDMLInsertQuery")
+		return stmt, sharedArgs, fmt.Errorf("No shared columns found in BuildDMLInsertQuery")
 	}
-	databaseName = EscapeName(databaseName)
-	tableName = EscapeName(tableName)
-
-	for _, column := range tableColumns.ColumnList() {
-		tableOrdinal := tableColumns.Ordinals[column.Name]
+	if mappedSharedColumns.Len() != sharedColumns.Len() {
+		return stmt, sharedArgs, fmt.Errorf("mapped shared columns count differs from shared column count in BuildDMLInsertQuery %v, %v",
+			mappedSharedColumns.Len(), sharedColumns.Len())
+	}
+	sharedColumns, mappedSharedColumns = writableColumns(sharedColumns, mappedSharedColumns)
+	if sharedColumns.Len() == 0 {
+		return stmt, sharedArgs, fmt.Errorf("No writable shared columns found in BuildDMLInsertQuery")
+	}
+	for _, column := range sharedColumns.ColumnList() {
+		tableOrdinal, err := argOrdinal("BuildDMLInsertQuery", tableColumns, args, column.Name)
+		if err != nil {
+			return stmt, sharedArgs, err
+		}
 		if *args[tableOrdinal] == nil {
 			sharedArgs = append(sharedArgs, *args[tableOrdinal])
 		} else {
-			arg := column.ConvertArg(*args[tableOrdinal])
+			arg := column.TransformArg(*args[tableOrdinal])
 			sharedArgs = append(sharedArgs, arg)
 		}
 	}
 
-	mappedSharedColumnNames := duplicateNames(tableColumns.Names())
-	for i := range mappedSharedColumnNames {
-		mappedSharedColumnNames[i] = EscapeName(mappedSharedColumnNames[i])
+	upsertClause, err := d.UpsertClause(mode, mappedSharedColumns)
+	if err != nil {
+		return stmt, sharedArgs, err
+	}
+
+	stmt = &DMLStatement{
+		Dialect:  d,
+		Verb:     d.UpsertVerb(mode),
+		Database: databaseName,
+		Table:    tableName,
+		Columns:  duplicateNames(mappedSharedColumns.Names()),
+		Values:   buildColumnsPreparedValues(d, mappedSharedColumns),
+		Suffix:   upsertClause,
+
+		ArgColumns: mappedSharedColumns.Names(),
+	}
+	return stmt, sharedArgs, nil
+}
+
+// placeholderBytes is the size of the `?, ` a value adds to a statement.
+const placeholderBytes = 3
+
+// SplitColumnValues splits a batch of rows into batches of at most maxRows
+// rows and about approxMaxBytes bytes each, for them to be applied as
+// separate statements and so stay below max_allowed_packet and hold their
+// locks for less time. A limit of 0 or less is no limit, and a row larger
+// than approxMaxBytes is a batch of its own.
+func SplitColumnValues(values []*umconf.ColumnValues, maxRows int, approxMaxBytes int) [][]*umconf.ColumnValues {
+	var batches [][]*umconf.ColumnValues
+	var batch []*umconf.ColumnValues
+	batchBytes := 0
+	for _, row := range values {
+		rowBytes := estimateRowBytes(row)
+		full := maxRows > 0 && len(batch) >= maxRows ||
+			approxMaxBytes > 0 && batchBytes+rowBytes > approxMaxBytes
+		if len(batch) > 0 && full {
+			batches = append(batches, batch)
+			batch, batchBytes = nil, 0
+		}
+		batch = append(batch, row)
+		batchBytes += rowBytes
+	}
+	if len(batch) > 0 {
+		batches = append(batches, batch)
+	}
+	return batches
+}
+
+// estimateRowBytes estimates the bytes a row adds to a statement and its
+// args: a placeholder per value, and the length of the value.
+func estimateRowBytes(row *umconf.ColumnValues) int {
+	size := 0
+	for _, value := range row.GetAbstractValues() {
+		size += placeholderBytes
+		if value == nil || *value == nil {
+			continue
+		}
+		switch v := (*value).(type) {
+		case []byte:
+			size += len(v)
+		case string:
+			size += len(v)
+		default:
+			size += len(fmt.Sprint(v))
+		}
+	}
+	return size
+}
+
+// ShardRouter returns the target table of a row, given the values of its
+// unique key.
+type ShardRouter func(keyArgs []interface{}) (databaseName, tableName string)
+
+// BuildDMLInsertSharded builds the inserts of a batch of rows whose target
+// table is chosen per row by router, such as one of N tables by hash of the
+// key. Rows routed to the same table are written by one multi-row insert,
+// rendered as BuildDMLInsertQuery renders a single row. It doesn't split
+// the batch: one too large for a statement is split by SplitColumnValues
+// before it is passed in. It returns the args of each statement, the args of
+// its rows one after the other.
+func BuildDMLInsertSharded(router ShardRouter, tableColumns, sharedColumns, mappedSharedColumns, uniqueKeyColumns *umconf.ColumnList, rowsArgs [][]*interface{}, mode InsertMode) (statements map[string][]interface{}, err error) {
+	if uniqueKeyColumns.Len() == 0 {
+		return nil, fmt.Errorf("Got 0 unique key columns in BuildDMLInsertSharded")
+	}
+	type shard struct {
+		stmt *DMLStatement
+		args []interface{}
+	}
+	shards := make(map[[2]string]*shard)
+	for _, args := range rowsArgs {
+		keyArgs := make([]interface{}, uniqueKeyColumns.Len())
+		for i, column := range uniqueKeyColumns.ColumnList() {
+			tableOrdinal, err := argOrdinal("BuildDMLInsertSharded", tableColumns, args, column.Name)
+			if err != nil {
+				return nil, err
+			}
+			keyArgs[i] = column.ConvertArg(*args[tableOrdinal])
+		}
+		databaseName, tableName := router(keyArgs)
+
+		stmt, sharedArgs, err := BuildDMLInsertQueryAST(MySQLDialect{}, databaseName, tableName, tableColumns, sharedColumns, mappedSharedColumns, args, mode)
+		if err != nil {
+			return nil, err
+		}
+		target := [2]string{databaseName, tableName}
+		s, ok := shards[target]
+		if !ok {
+			shards[target] = &shard{stmt: stmt, args: sharedArgs}
+			continue
+		}
+		if s.stmt.ValueRows == 0 {
+			s.stmt.ValueRows = 1
+		}
+		s.stmt.ValueRows++
+		s.stmt.ArgColumns = append(s.stmt.ArgColumns, stmt.ArgColumns...)
+		s.args = append(s.args, sharedArgs...)
 	}
-	preparedValues := buildColumnsPreparedValues(tableColumns)
 
-	result = fmt.Sprintf(`
-			replace into
-				%s.%s
-					(%s)
-				values
-					(%s)
-		`, databaseName, tableName,
-		strings.Join(mappedSharedColumnNames, ", "),
-		strings.Join(preparedValues, ", "),
-	)
-	return result, sharedArgs, nil
+	statements = make(map[string][]interface{}, len(shards))
+	for _, s := range shards {
+		statements[s.stmt.String()] = s.args
+	}
+	return statements, nil
 }
 
 func BuildDMLUpdateQuery(databaseName, tableName string, tableColumns, sharedColumns, mappedSharedColumns, uniqueKeyColumns *umconf.ColumnList, valueArgs, whereArgs []*interface{}) (result string, sharedArgs, columnArgs []interface{}, err error) {
-	if len(valueArgs) < tableColumns.Len() {
-		return result, sharedArgs, columnArgs, fmt.Errorf("value args count differs from table column count in BuildDMLUpdateQuery %v, %v",
-			len(valueArgs), tableColumns.Len())
+	var stmt *DMLStatement
+	stmt, sharedArgs, columnArgs, err = BuildDMLUpdateQueryAST(MySQLDialect{}, databaseName, tableName, tableColumns, sharedColumns, mappedSharedColumns, uniqueKeyColumns, valueArgs, whereArgs)
+	if err != nil {
+		return result, sharedArgs, columnArgs, err
+	}
+	return stmt.String(), sharedArgs, columnArgs, nil
+}
+
+// BuildDMLUpdateQueryAST is BuildDMLUpdateQuery, returning the statement unrendered
+// and rendering it in dialect d.
+func BuildDMLUpdateQueryAST(d Dialect, databaseName, tableName string, tableColumns, sharedColumns, mappedSharedColumns, uniqueKeyColumns *umconf.ColumnList, valueArgs, whereArgs []*interface{}) (stmt *DMLStatement, sharedArgs, columnArgs []interface{}, err error) {
+	d = dialectOrDefault(d)
+	if err := checkArgCount("BuildDMLUpdateQuery", "value args", tableColumns, valueArgs); err != nil {
+		return stmt, sharedArgs, columnArgs, err
 	}
-	if len(whereArgs) < tableColumns.Len() {
-		return result, sharedArgs, columnArgs, fmt.Errorf("where args count differs from table column count in BuildDMLUpdateQuery %v, %v",
-			len(whereArgs), tableColumns.Len())
+	if err := checkArgCount("BuildDMLUpdateQuery", "where args", tableColumns, whereArgs); err != nil {
+		return stmt, sharedArgs, columnArgs, err
 	}
 	if !sharedColumns.IsSubsetOf(tableColumns) {
-		return result, sharedArgs, columnArgs, fmt.Errorf("shared columns is not a subset of table columns in BuildDMLUpdateQuery")
+		return stmt, sharedArgs, columnArgs, fmt.Errorf("shared columns is not a subset of table columns in BuildDMLUpdateQuery")
 	}
 	if sharedColumns.Len() == 0 {
-		return result, sharedArgs, columnArgs, fmt.Errorf("No shared columns found in BuildDMLUpdateQuery")
+		return stmt, sharedArgs, columnArgs, fmt.Errorf("No shared columns found in BuildDMLUpdateQuery")
 	}
-	databaseName = EscapeName(databaseName)
-	tableName = EscapeName(tableName)
-
-	for _, column := range tableColumns.ColumnList() {
-		tableOrdinal := tableColumns.Ordinals[column.Name]
+	if mappedSharedColumns.Len() != sharedColumns.Len() {
+		return stmt, sharedArgs, columnArgs, fmt.Errorf("mapped shared columns count differs from shared column count in BuildDMLUpdateQuery %v, %v",
+			mappedSharedColumns.Len(), sharedColumns.Len())
+	}
+	// the where clause names the shared columns by their target name too
+	targetNames := make(map[string]string, sharedColumns.Len())
+	for i := range sharedColumns.Columns {
+		targetNames[sharedColumns.Columns[i].Name] = mappedSharedColumns.Columns[i].Name
+	}
+	targetName := func(name string) string {
+		if target, ok := targetNames[name]; ok {
+			return target
+		}
+		return name
+	}
+	sharedColumns, mappedSharedColumns = writableColumns(sharedColumns, mappedSharedColumns)
+	for _, column := range sharedColumns.ColumnList() {
+		tableOrdinal, err := argOrdinal("BuildDMLUpdateQuery", tableColumns, valueArgs, column.Name)
+		if err != nil {
+			return stmt, sharedArgs, columnArgs, err
+		}
 		if *valueArgs[tableOrdinal] == nil || *valueArgs[tableOrdinal] == "NULL" ||
 			fmt.Sprintf("%v", *valueArgs[tableOrdinal]) == "" {
 			sharedArgs = append(sharedArgs, *valueArgs[tableOrdinal])
 		} else {
-			arg := column.ConvertArg(*valueArgs[tableOrdinal])
+			arg := column.TransformArg(*valueArgs[tableOrdinal])
 			sharedArgs = append(sharedArgs, arg)
 		}
 	}
@@ -267,61 +1456,200 @@ func BuildDMLUpdateQuery(databaseName, tableName string, tableColumns, sharedCol
 	comparisons := []string{}
 	uniqueKeyComparisons := []string{}
 	uniqueKeyArgs := make([]interface{}, 0)
+	whereArgColumns, uniqueKeyArgColumns := []string{}, []string{}
+	// whether values were inlined into the comparisons, making the query row specific
+	inlined, uniqueKeyInlined := false, false
 	for _, column := range tableColumns.ColumnList() {
-		tableOrdinal := tableColumns.Ordinals[column.Name]
+		tableOrdinal, err := argOrdinal("BuildDMLUpdateQuery", tableColumns, whereArgs, column.Name)
+		if err != nil {
+			return stmt, sharedArgs, columnArgs, err
+		}
 		if *whereArgs[tableOrdinal] == nil {
-			comparison, err := BuildValueComparison(column.Name, "NULL", IsEqualsComparisonSign)
+			comparison, err := buildValueComparison(d, targetName(column.Name), "NULL", IsEqualsComparisonSign)
 			if err != nil {
-				return result, sharedArgs, columnArgs, err
+				return stmt, sharedArgs, columnArgs, err
 			}
+			inlined = true
 			comparisons = append(comparisons, comparison)
 		} else {
-			if strings.HasPrefix(column.ColumnType, "binary") {
-				arg := column.ConvertArg(*whereArgs[tableOrdinal])
-				comparison, err := BuildValueComparison(column.Name, fmt.Sprintf("cast('%v' as %s)", arg, column.ColumnType), EqualsComparisonSign)
+			if column.Type == umconf.BinaryColumnType {
+				arg := column.TransformArg(*whereArgs[tableOrdinal])
+				comparison, err := buildValueComparison(d, targetName(column.Name), d.BinaryLiteral(arg, column.ColumnType), EqualsComparisonSign)
 				if err != nil {
-					return result, sharedArgs, columnArgs, err
+					return stmt, sharedArgs, columnArgs, err
 				}
 				if strings.ToUpper(column.Key) == "PRI" {
+					uniqueKeyInlined = true
 					uniqueKeyComparisons = append(uniqueKeyComparisons, comparison)
 				} else {
+					inlined = true
 					comparisons = append(comparisons, comparison)
 				}
+			} else if column.Type == umconf.JSONColumnType {
+				// json can't be part of a key
+				columnArgs = append(columnArgs, column.TransformArg(*whereArgs[tableOrdinal]))
+				whereArgColumns = append(whereArgColumns, targetName(column.Name))
+				comparisons = append(comparisons, d.JSONEquals(targetName(column.Name)))
 			} else {
-				arg := column.ConvertArg(*whereArgs[tableOrdinal])
-				comparison, err := BuildValueComparison(column.Name, "?", EqualsComparisonSign)
+				arg := column.TransformArg(*whereArgs[tableOrdinal])
+				comparison, err := buildValueComparison(d, targetName(column.Name), "?", EqualsComparisonSign)
 				if err != nil {
-					return result, sharedArgs, columnArgs, err
+					return stmt, sharedArgs, columnArgs, err
 				}
 				if strings.ToUpper(column.Key) == "PRI" {
 					uniqueKeyArgs = append(uniqueKeyArgs, arg)
+					uniqueKeyArgColumns = append(uniqueKeyArgColumns, targetName(column.Name))
 					uniqueKeyComparisons = append(uniqueKeyComparisons, comparison)
 				} else {
 					columnArgs = append(columnArgs, arg)
+					whereArgColumns = append(whereArgColumns, targetName(column.Name))
 					comparisons = append(comparisons, comparison)
 				}
 			}
 		}
 	}
 	if len(uniqueKeyComparisons) > 0 {
+		// binary key columns are inlined, so the key args may be empty
 		comparisons = uniqueKeyComparisons
-	}
-	if len(uniqueKeyArgs) > 0 {
 		columnArgs = uniqueKeyArgs
+		whereArgColumns = uniqueKeyArgColumns
+		inlined = uniqueKeyInlined
+	}
+	if name := tableColumns.VersionColumn; name != "" {
+		column := tableColumns.GetColumn(name)
+		if column == nil {
+			return stmt, sharedArgs, columnArgs, fmt.Errorf("Version column %s not found in BuildDMLUpdateQuery", name)
+		}
+		tableOrdinal, err := argOrdinal("BuildDMLUpdateQuery", tableColumns, valueArgs, name)
+		if err != nil {
+			return stmt, sharedArgs, columnArgs, err
+		}
+		// a row without a version can't be ordered, so it is updated as is
+		if *valueArgs[tableOrdinal] != nil {
+			comparison, err := buildValueComparison(d, targetName(name), "?", LessThanOrEqualsComparisonSign)
+			if err != nil {
+				return stmt, sharedArgs, columnArgs, err
+			}
+			comparisons = append(comparisons, comparison)
+			columnArgs = append(columnArgs, column.TransformArg(*valueArgs[tableOrdinal]))
+			whereArgColumns = append(whereArgColumns, targetName(name))
+		} else {
+			// the query of such a row lacks the guard, so it can't be reused
+			inlined = true
+		}
+	}
+	setClause, err := buildSetPreparedClause(d, mappedSharedColumns)
+	if err != nil {
+		return stmt, sharedArgs, columnArgs, err
+	}
+
+	stmt = &DMLStatement{
+		Dialect:  d,
+		Verb:     "update",
+		Database: databaseName,
+		Table:    tableName,
+		Set:      setClause,
+		Where:    comparisons,
+		inlined:  inlined,
+		Suffix:   d.SingleRowSuffix(),
+
+		// the set args come before the where args
+		ArgColumns: append(mappedSharedColumns.Names(), whereArgColumns...),
 	}
-	setClause, err := BuildSetPreparedClause(mappedSharedColumns)
-
-	result = fmt.Sprintf(`
- 			update
- 					%s.%s
-				set
-					%s
-				where
- 					%s
- 				limit 1
- 		`, databaseName, tableName,
-		setClause,
-		fmt.Sprintf("(%s)", strings.Join(comparisons, " and ")),
-	)
-	return result, sharedArgs, columnArgs, nil
+	return stmt, sharedArgs, columnArgs, nil
+}
+
+// BuildExplain returns the EXPLAIN form of a generated query. Surrounding
+// whitespace and trailing semicolons are dropped; the query may span lines.
+func BuildExplain(query string) string {
+	return fmt.Sprintf("explain %s", trimQuery(query))
+}
+
+// BuildExplainJSON is BuildExplain with `format=json`.
+func BuildExplainJSON(query string) string {
+	return fmt.Sprintf("explain format=json %s", trimQuery(query))
+}
+
+// BuildExplainQuery takes the query and args returned by a builder and returns
+// its EXPLAIN form. The args are returned as is, since EXPLAIN binds the same
+// placeholders.
+func BuildExplainQuery(query string, args []interface{}) (string, []interface{}) {
+	return BuildExplain(query), args
+}
+
+func trimQuery(query string) string {
+	return strings.TrimRight(strings.TrimSpace(query), "; \t\r\n")
+}
+
+// BuildTransactionWrapper returns statements framed by `start transaction`
+// and `commit`, for a chunk and its bookkeeping, such as the checkpoint of
+// its boundary, to be applied all or nothing. The statements are run one by
+// one on the same connection, in order.
+func BuildTransactionWrapper(statements []string) []string {
+	wrapped := make([]string, 0, len(statements)+2)
+	wrapped = append(wrapped, "start transaction")
+	for _, statement := range statements {
+		wrapped = append(wrapped, trimQuery(statement))
+	}
+	return append(wrapped, "commit")
+}
+
+// SavepointName returns the name of the savepoint of the n-th chunk of a
+// transaction.
+func SavepointName(n int) string {
+	return fmt.Sprintf("dtle_chunk_%d", n)
+}
+
+// BuildSavepointWrapper returns statements framed by a savepoint and its
+// release, to be run within a transaction. If one of them fails, the
+// statement of BuildRollbackToSavepoint undoes those already run, so that
+// they can be retried without rolling back the whole transaction.
+func BuildSavepointWrapper(name string, statements []string) []string {
+	wrapped := make([]string, 0, len(statements)+2)
+	wrapped = append(wrapped, fmt.Sprintf("savepoint %s", EscapeName(name)))
+	for _, statement := range statements {
+		wrapped = append(wrapped, trimQuery(statement))
+	}
+	return append(wrapped, fmt.Sprintf("release savepoint %s", EscapeName(name)))
+}
+
+// BuildRollbackToSavepoint returns the statement undoing what was run within
+// a transaction after its savepoint name.
+func BuildRollbackToSavepoint(name string) string {
+	return fmt.Sprintf("rollback to savepoint %s", EscapeName(name))
+}
+
+// BuildSessionInit returns the statements that set up the sql_mode and
+// time_zone of a new session, in that order. An empty value skips its setting,
+// so an empty sql_mode cannot be set this way.
+func BuildSessionInit(sqlMode string, timeZone string) []string {
+	statements := []string{}
+	if sqlMode != "" {
+		statements = append(statements, fmt.Sprintf("set session sql_mode='%s'", EscapeValue(sqlMode)))
+	}
+	if timeZone != "" {
+		statements = append(statements, fmt.Sprintf("set session time_zone='%s'", EscapeValue(timeZone)))
+	}
+	return statements
+}
+
+// BuildConsistentSnapshotStart builds the statement starting the transaction
+// a full copy reads all its chunks in, so that they show the tables at one
+// point in time, that of the GTID set read right after it. MySQL and MariaDB
+// take the snapshot with the statement rather than at the first read. TiDB
+// transactions read the snapshot of their start anyway, so it starts a plain
+// one.
+func BuildConsistentSnapshotStart(flavor ServerFlavor) string {
+	if flavor == FlavorTiDB {
+		return "start transaction"
+	}
+	return "start transaction with consistent snapshot"
+}
+
+// BuildWaitForGtidSetQuery builds the query waiting up to timeout for a
+// server to have executed the transactions of gtidSet, such as a replica to
+// catch up with its source. Its one column is 0 once they have been, and 1 if
+// the timeout passed first.
+func BuildWaitForGtidSetQuery(gtidSet string, timeout time.Duration) (string, []interface{}) {
+	return "select wait_for_executed_gtid_set(?, ?)", []interface{}{gtidSet, timeout.Seconds()}
 }
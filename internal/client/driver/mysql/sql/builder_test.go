@@ -7,13 +7,20 @@
 package sql
 
 import (
+	"errors"
+	"fmt"
 	"testing"
 
 	"reflect"
 	"regexp"
 	"strings"
+	"time"
 
+	"github.com/actiontech/dtle/internal/config"
+	umconf "github.com/actiontech/dtle/internal/config/mysql"
 	test "github.com/outbrain/golib/tests"
+	"github.com/pingcap/tidb/ast"
+	"github.com/pingcap/tidb/parser"
 )
 
 var (
@@ -35,6 +42,79 @@ func TestEscapeName(t *testing.T) {
 	}
 }
 
+func TestEscapeQualifiedName(t *testing.T) {
+	test.S(t).ExpectEquals(EscapeQualifiedName("tbl"), "`tbl`")
+	test.S(t).ExpectEquals(EscapeQualifiedName("db", "tbl"), "`db`.`tbl`")
+	test.S(t).ExpectEquals(EscapeQualifiedName("db", "tbl", "col"), "`db`.`tbl`.`col`")
+	test.S(t).ExpectEquals(EscapeQualifiedName("`db`", "tbl"), "`db`.`tbl`")
+	// A dot within a part is part of its name.
+	test.S(t).ExpectEquals(EscapeQualifiedName("my.db", "tbl"), "`my.db`.`tbl`")
+	test.S(t).ExpectEquals(EscapeName("db.tbl"), "`db.tbl`")
+}
+
+func TestFormatLiteral(t *testing.T) {
+	test.S(t).ExpectEquals(FormatLiteral("it's"), `'it\'s'`)
+	test.S(t).ExpectEquals(FormatLiteral("a\nb"), `'a\nb'`)
+	test.S(t).ExpectEquals(FormatLiteral(42), "42")
+	test.S(t).ExpectEquals(FormatLiteral(int64(-7)), "-7")
+	test.S(t).ExpectEquals(FormatLiteral(uint64(18446744073709551615)), "18446744073709551615")
+	test.S(t).ExpectEquals(FormatLiteral(1.5), "1.5")
+	test.S(t).ExpectEquals(FormatLiteral(nil), "NULL")
+	var null interface{}
+	test.S(t).ExpectEquals(FormatLiteral(&null), "NULL")
+	test.S(t).ExpectEquals(FormatLiteral([]byte{0x00, 0xff, '\''}), "x'00ff27'")
+	test.S(t).ExpectEquals(FormatLiteral(true), "1")
+	test.S(t).ExpectEquals(FormatLiteral(time.Date(2018, 1, 2, 3, 4, 5, 600000000, time.UTC)), "'2018-01-02 03:04:05.6'")
+}
+
+func TestEscapeColumnValue(t *testing.T) {
+	column := func(columnType umconf.ColumnType) *umconf.Column {
+		return &umconf.Column{Name: "c", Type: columnType}
+	}
+	var null interface{}
+	tests := []struct {
+		name   string
+		value  interface{}
+		column *umconf.Column
+		want   string
+	}{
+		{"string", []byte("abc"), column(umconf.VarcharColumnType), "'abc'"},
+		{"embedded quote", []byte("it's"), column(umconf.VarcharColumnType), `'it\'s'`},
+		{"backslash", "C:\\dir", column(umconf.TextColumnType), `'C:\\dir'`},
+		{"injection", []byte("x' or '1'='1"), column(umconf.CharColumnType), `'x\' or \'1\'=\'1'`},
+		{"int", []byte("42"), column(umconf.IntColumnType), "42"},
+		{"negative bigint", int64(-9000000000), column(umconf.BigIntColumnType), "-9000000000"},
+		{"decimal", []byte("-12.50"), column(umconf.DecimalColumnType), "-12.50"},
+		{"double", []byte("1.5e-3"), column(umconf.DoubleColumnType), "1.5e-3"},
+		{"not a number", []byte("1; drop table t"), column(umconf.IntColumnType), "'1; drop table t'"},
+		{"datetime", []byte("2018-01-02 03:04:05"), column(umconf.DateTimeColumnType), "'2018-01-02 03:04:05'"},
+		{"date", time.Date(2018, 1, 2, 0, 0, 0, 0, time.UTC), column(umconf.DateColumnType), "'2018-01-02'"},
+		{"timestamp", time.Date(2018, 1, 2, 3, 4, 5, 0, time.UTC), column(umconf.TimestampColumnType), "'2018-01-02 03:04:05'"},
+		{"binary", []byte{0x00, '\''}, column(umconf.VarbinaryColumnType), "x'0027'"},
+		{"bit", []byte{0x05}, column(umconf.BitColumnType), "x'05'"},
+		{"null", nil, column(umconf.IntColumnType), "NULL"},
+		{"null raw", &null, column(umconf.VarcharColumnType), "NULL"},
+		{"unknown type", []byte("abc"), column(umconf.UnknownColumnType), "'abc'"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := EscapeColumnValue(tt.value, tt.column); got != tt.want {
+				t.Errorf("EscapeColumnValue(%v) = %v, want %v", tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestInlineArgs(t *testing.T) {
+	query, err := InlineArgs("insert /* chunk ? */ into `db`.`t?` (`id`, `name`, `note`) values (?, ?, ?)",
+		[]interface{}{1, "what?", nil})
+	test.S(t).ExpectNil(err)
+	test.S(t).ExpectEquals(query, "insert /* chunk ? */ into `db`.`t?` (`id`, `name`, `note`) values (1, 'what?', NULL)")
+
+	_, err = InlineArgs("delete from `db`.`t` where `id` = ?", nil)
+	test.S(t).ExpectNotNil(err)
+}
+
 func TestBuildSetPreparedClause(t *testing.T) {
 	{
 		columns := NewColumnList([]string{"c1"})
@@ -205,7 +285,7 @@ func TestBuildUniqueKeyRangeEndPreparedQuery(t *testing.T) {
 func TestBuildUniqueKeyMinValuesPreparedQuery(t *testing.T) {
 	databaseName := "mydb"
 	originalTableName := "tbl"
-	uniqueKeyColumns := NewColumnList([]string{"name", "position"})
+	uniqueKeyColumns := umconf.NewColumnList(umconf.NewColumns([]string{"name", "position"}))
 	{
 		query, err := BuildUniqueKeyMinValuesPreparedQuery(databaseName, originalTableName, uniqueKeyColumns)
 		test.S(t).ExpectNil(err)
@@ -232,6 +312,20 @@ func TestBuildUniqueKeyMinValuesPreparedQuery(t *testing.T) {
 		`
 		test.S(t).ExpectEquals(normalizeQuery(query), normalizeQuery(expected))
 	}
+	// A descending column is first at its highest value.
+	uniqueKeyColumns.Columns[1].SortDirection = umconf.SortDescending
+	{
+		query, err := BuildUniqueKeyMinValuesPreparedQuery(databaseName, originalTableName, uniqueKeyColumns)
+		test.S(t).ExpectNil(err)
+		test.S(t).ExpectEquals(query, "select /* udup `mydb`.`tbl` */ `name`, `position` from `mydb`.`tbl` order by `name` asc, `position` desc limit 1")
+	}
+	{
+		query, err := BuildUniqueKeyMaxValuesPreparedQuery(databaseName, originalTableName, uniqueKeyColumns)
+		test.S(t).ExpectNil(err)
+		test.S(t).ExpectEquals(query, "select /* udup `mydb`.`tbl` */ `name`, `position` from `mydb`.`tbl` order by `name` desc, `position` asc limit 1")
+	}
+	_, err := BuildUniqueKeyMinValuesPreparedQuery(databaseName, originalTableName, umconf.NewColumnList(nil))
+	test.S(t).ExpectNotNil(err)
 }
 
 func TestBuildDMLDeleteQuery(t *testing.T) {
@@ -573,3 +667,1085 @@ func TestBuildDMLUpdateQuerySignedUnsigned(t *testing.T) {
 		test.S(t).ExpectTrue(reflect.DeepEqual(uniqueKeyArgs, []interface{}{uint8(253)}))
 	}
 }
+
+func TestBuildDMLDeleteQueryBinaryColumn(t *testing.T) {
+	databaseName := "mydb"
+	tableName := "tbl"
+	columns := umconf.NewColumns([]string{"id", "name"})
+	columns[0].Type = umconf.BinaryColumnType
+	columns[0].ColumnType = "binary(4)"
+	columns[0].Key = "PRI"
+	tableColumns := umconf.NewColumnList(columns)
+	args := umconf.ToColumnValues([]interface{}{[]byte{0x00, '\'', 0x01, 'a'}, "testname"}).GetAbstractValues()
+
+	query, uniqueKeyArgs, err := BuildDMLDeleteQuery(databaseName, tableName, tableColumns, args)
+	test.S(t).ExpectNil(err)
+	expected := `
+			delete
+				from
+					mydb.tbl
+				where
+					((id = cast(x'00270161' as binary(4))))
+		`
+	test.S(t).ExpectEquals(normalizeQuery(query), normalizeQuery(expected))
+	test.S(t).ExpectEquals(len(uniqueKeyArgs), 0)
+}
+
+func TestBuildDMLInsertQueryInsertMode(t *testing.T) {
+	databaseName := "mydb"
+	tableName := "tbl"
+	tableColumns := umconf.NewColumnList(umconf.NewColumns([]string{"id", "name"}))
+	args := umconf.ToColumnValues([]interface{}{3, "testname"}).GetAbstractValues()
+	{
+		query, sharedArgs, err := BuildDMLInsertQuery(databaseName, tableName, tableColumns, tableColumns, tableColumns, args, InsertModeReplace)
+		test.S(t).ExpectNil(err)
+		expected := `
+			replace into
+				mydb.tbl
+					(id, name)
+				values
+					(?, ?)
+		`
+		test.S(t).ExpectEquals(normalizeQuery(query), normalizeQuery(expected))
+		test.S(t).ExpectTrue(reflect.DeepEqual(sharedArgs, []interface{}{3, "testname"}))
+	}
+	{
+		query, _, err := BuildDMLInsertQuery(databaseName, tableName, tableColumns, tableColumns, tableColumns, args, InsertModeIgnore)
+		test.S(t).ExpectNil(err)
+		expected := `
+			insert ignore into
+				mydb.tbl
+					(id, name)
+				values
+					(?, ?)
+		`
+		test.S(t).ExpectEquals(normalizeQuery(query), normalizeQuery(expected))
+	}
+	{
+		query, _, err := BuildDMLInsertQuery(databaseName, tableName, tableColumns, tableColumns, tableColumns, args, InsertModeOnDuplicateUpdate)
+		test.S(t).ExpectNil(err)
+		expected := `
+			insert into
+				mydb.tbl
+					(id, name)
+				values
+					(?, ?)
+				on duplicate key update id=values(id), name=values(name)
+		`
+		test.S(t).ExpectEquals(normalizeQuery(query), normalizeQuery(expected))
+	}
+}
+
+func TestBuildDMLInsertIgnoreQuery(t *testing.T) {
+	databaseName := "mydb"
+	tableName := "tbl"
+	tableColumns := umconf.NewColumnList(umconf.NewColumns([]string{"id", "name", "position"}))
+	sharedColumns := umconf.NewColumnList(umconf.NewColumns([]string{"position", "id"}))
+	args := umconf.ToColumnValues([]interface{}{3, "testname", 17}).GetAbstractValues()
+
+	query, sharedArgs, err := BuildDMLInsertIgnoreQuery(databaseName, tableName, tableColumns, sharedColumns, sharedColumns, args)
+	test.S(t).ExpectNil(err)
+	expected := `
+		insert ignore into
+			mydb.tbl
+				(position, id)
+			values
+				(?, ?)
+	`
+	test.S(t).ExpectEquals(normalizeQuery(query), normalizeQuery(expected))
+
+	plainQuery, plainArgs, err := BuildDMLInsertQuery(databaseName, tableName, tableColumns, sharedColumns, sharedColumns, args, InsertModeOnDuplicateUpdate)
+	test.S(t).ExpectNil(err)
+	test.S(t).ExpectTrue(strings.HasPrefix(plainQuery, "insert into"))
+	test.S(t).ExpectTrue(reflect.DeepEqual(sharedArgs, plainArgs))
+	test.S(t).ExpectTrue(reflect.DeepEqual(sharedArgs, []interface{}{17, 3}))
+}
+
+func TestBuildDMLInsertSharded(t *testing.T) {
+	tableColumns := umconf.NewColumnList(umconf.NewColumns([]string{"id", "name", "position"}))
+	tableColumns.Columns[2].IsUnsigned = true
+	uniqueKeyColumns := umconf.NewColumnList(umconf.NewColumns([]string{"id"}))
+	router := func(keyArgs []interface{}) (string, string) {
+		return "mydb", fmt.Sprintf("tbl_%d", keyArgs[0].(int)%2)
+	}
+	var rowsArgs [][]*interface{}
+	for _, row := range [][]interface{}{{1, "a", int8(-1)}, {2, "b", int8(2)}, {3, "c", int8(3)}} {
+		rowsArgs = append(rowsArgs, umconf.ToColumnValues(row).GetAbstractValues())
+	}
+
+	statements, err := BuildDMLInsertSharded(router, tableColumns, tableColumns, tableColumns, uniqueKeyColumns, rowsArgs, InsertModeReplace)
+	test.S(t).ExpectNil(err)
+	test.S(t).ExpectEquals(len(statements), 2)
+	odd := "replace into `mydb`.`tbl_1` (`id`, `name`, `position`) values (?, ?, ?), (?, ?, ?)"
+	even := "replace into `mydb`.`tbl_0` (`id`, `name`, `position`) values (?, ?, ?)"
+	// The args of every row are converted for their column.
+	test.S(t).ExpectTrue(reflect.DeepEqual(statements[odd], []interface{}{1, "a", uint8(255), 3, "c", uint8(3)}))
+	test.S(t).ExpectTrue(reflect.DeepEqual(statements[even], []interface{}{2, "b", uint8(2)}))
+
+	_, err = BuildDMLInsertSharded(router, tableColumns, tableColumns, tableColumns, umconf.NewColumnList(nil), rowsArgs, InsertModeReplace)
+	test.S(t).ExpectNotNil(err)
+}
+
+func TestSplitColumnValues(t *testing.T) {
+	var values []*umconf.ColumnValues
+	for i := 0; i < 5; i++ {
+		// 2 placeholders and 10 bytes of args: 16 bytes a row
+		values = append(values, umconf.ToColumnValues([]interface{}{"12345", []byte("abcde")}))
+	}
+	sizes := func(batches [][]*umconf.ColumnValues) []int {
+		n := make([]int, len(batches))
+		for i, batch := range batches {
+			n[i] = len(batch)
+		}
+		return n
+	}
+
+	test.S(t).ExpectTrue(reflect.DeepEqual(sizes(SplitColumnValues(values, 2, 0)), []int{2, 2, 1}))
+	test.S(t).ExpectTrue(reflect.DeepEqual(sizes(SplitColumnValues(values, 0, 48)), []int{3, 2}))
+	test.S(t).ExpectTrue(reflect.DeepEqual(sizes(SplitColumnValues(values, 2, 20)), []int{1, 1, 1, 1, 1}))
+	test.S(t).ExpectTrue(reflect.DeepEqual(sizes(SplitColumnValues(values, 0, 0)), []int{5}))
+	test.S(t).ExpectEquals(len(SplitColumnValues(nil, 2, 48)), 0)
+
+	// A wide row is a batch of its own rather than dropped.
+	wide := append([]*umconf.ColumnValues{umconf.ToColumnValues([]interface{}{strings.Repeat("x", 100), nil})}, values[:2]...)
+	test.S(t).ExpectTrue(reflect.DeepEqual(sizes(SplitColumnValues(wide, 0, 48)), []int{1, 2}))
+}
+
+func TestBuildGapCheckQuery(t *testing.T) {
+	uniqueKeyColumns := umconf.NewColumnList(umconf.NewColumns([]string{"id"}))
+	query, args, err := BuildGapCheckQuery("mydb", "tbl", uniqueKeyColumns, []interface{}{10}, []interface{}{20})
+	test.S(t).ExpectNil(err)
+	test.S(t).ExpectEquals(query, "select count(*) from `mydb`.`tbl` where (((`id` > ?))) and (((`id` < ?)))")
+	test.S(t).ExpectTrue(reflect.DeepEqual(args, []interface{}{10, 20}))
+
+	uniqueKeyColumns = umconf.NewColumnList(umconf.NewColumns([]string{"a", "b"}))
+	query, args, err = BuildGapCheckQuery("mydb", "tbl", uniqueKeyColumns, []interface{}{1, 2}, []interface{}{3, 4})
+	test.S(t).ExpectNil(err)
+	test.S(t).ExpectEquals(query, "select count(*) from `mydb`.`tbl` where (((`a` > ?)) or ((`a` = ?) and (`b` > ?))) and (((`a` < ?)) or ((`a` = ?) and (`b` < ?)))")
+	test.S(t).ExpectTrue(reflect.DeepEqual(args, []interface{}{1, 1, 2, 3, 3, 4}))
+
+	_, _, err = BuildGapCheckQuery("mydb", "tbl", uniqueKeyColumns, []interface{}{1}, []interface{}{3, 4})
+	test.S(t).ExpectNotNil(err)
+}
+
+func TestBuildRangeChecksumQuery(t *testing.T) {
+	columns := umconf.NewColumnList(umconf.NewColumns([]string{"id", "name"}))
+	query, err := BuildRangeChecksumQuery("mydb", "tbl", columns, "(`id` > 10) and not (`id` > 20)")
+	test.S(t).ExpectNil(err)
+	test.S(t).ExpectEquals(query, "select count(*), coalesce(bit_xor(crc32(concat_ws('#', `id`, `name`, isnull(`id`), isnull(`name`)))), 0) "+
+		"from `mydb`.`tbl` where (`id` > 10) and not (`id` > 20)")
+
+	_, err = BuildRangeChecksumQuery("mydb", "tbl", umconf.NewColumnList(nil), "true")
+	test.S(t).ExpectNotNil(err)
+	_, err = BuildRangeChecksumQuery("mydb", "tbl", columns, "")
+	test.S(t).ExpectNotNil(err)
+}
+
+func TestBuildKeyBoundsCheckQuery(t *testing.T) {
+	keyColumns := umconf.NewColumnList(umconf.NewColumns([]string{"a", "b"}))
+	query, args, err := BuildKeyBoundsCheckQuery("mydb", "tbl", keyColumns, []interface{}{3, 7})
+	test.S(t).ExpectNil(err)
+	test.S(t).ExpectEquals(query, "select count(*) from (select 1 from `mydb`.`tbl` where (((`a` > ?)) or ((`a` = ?) and (`b` > ?)) or ((`a` = ?) and (`b` = ?))) limit 2) as bound")
+	test.S(t).ExpectTrue(reflect.DeepEqual(args, []interface{}{3, 3, 7, 3, 7}))
+
+	_, _, err = BuildKeyBoundsCheckQuery("mydb", "tbl", keyColumns, []interface{}{3})
+	test.S(t).ExpectNotNil(err)
+
+	// Only the row of the max is left: the bounds hold.
+	test.S(t).ExpectFalse(KeyBoundsStale(1, false))
+	// The row of the max was deleted.
+	test.S(t).ExpectTrue(KeyBoundsStale(0, false))
+	test.S(t).ExpectTrue(KeyBoundsStale(0, true))
+	// Rows were added past the max.
+	test.S(t).ExpectTrue(KeyBoundsStale(2, false))
+	test.S(t).ExpectFalse(KeyBoundsStale(2, true))
+}
+func TestBuildDMLQueryReorderedColumns(t *testing.T) {
+	databaseName := "mydb"
+	tableName := "tbl"
+	// The rows are of a source table with columns id, name, position. The
+	// target has them in another order.
+	source := umconf.NewColumnList(umconf.NewColumns([]string{"id", "name", "position"}))
+	target := umconf.NewColumnList(umconf.NewColumns([]string{"position", "id", "name"}))
+	target.Columns[1].Key = "PRI"
+	target.SourceOrdinals = source.Ordinals
+	args := umconf.ToColumnValues([]interface{}{3, "testname", 17}).GetAbstractValues()
+	{
+		query, sharedArgs, err := BuildDMLInsertQuery(databaseName, tableName, target, target, target, args, InsertModeReplace)
+		test.S(t).ExpectNil(err)
+		test.S(t).ExpectEquals(normalizeQuery(query), normalizeQuery("replace into mydb.tbl (position, id, name) values (?, ?, ?)"))
+		test.S(t).ExpectTrue(reflect.DeepEqual(sharedArgs, []interface{}{17, 3, "testname"}))
+	}
+	{
+		newArgs := umconf.ToColumnValues([]interface{}{3, "newname", 18}).GetAbstractValues()
+		query, sharedArgs, uniqueKeyArgs, err := BuildDMLUpdateQuery(databaseName, tableName, target, target, target, target, newArgs, args)
+		test.S(t).ExpectNil(err)
+		test.S(t).ExpectEquals(normalizeQuery(query), normalizeQuery("update mydb.tbl set position=?, id=?, name=? where ((id = ?)) limit 1"))
+		test.S(t).ExpectTrue(reflect.DeepEqual(sharedArgs, []interface{}{18, 3, "newname"}))
+		test.S(t).ExpectTrue(reflect.DeepEqual(uniqueKeyArgs, []interface{}{3}))
+	}
+	{
+		query, uniqueKeyArgs, err := BuildDMLDeleteQuery(databaseName, tableName, target, args)
+		test.S(t).ExpectNil(err)
+		test.S(t).ExpectEquals(normalizeQuery(query), normalizeQuery("delete from mydb.tbl where ((id = ?))"))
+		test.S(t).ExpectTrue(reflect.DeepEqual(uniqueKeyArgs, []interface{}{3}))
+	}
+	{
+		// A target column the source rows lack can't be bound.
+		extra := umconf.NewColumnList(umconf.NewColumns([]string{"id", "name", "note"}))
+		extra.SourceOrdinals = source.Ordinals
+		_, _, err := BuildDMLInsertQuery(databaseName, tableName, extra, extra, extra, args, InsertModeReplace)
+		test.S(t).ExpectNotNil(err)
+	}
+}
+
+func TestBuildDMLQueryColumnArgMismatch(t *testing.T) {
+	databaseName := "mydb"
+	tableName := "tbl"
+	tableColumns := umconf.NewColumnList(umconf.NewColumns([]string{"id", "name", "rank"}))
+	args := umconf.ToColumnValues([]interface{}{3, "testname", "first"}).GetAbstractValues()
+	short := args[:2]
+
+	expectMismatch := func(err error, builder, argsName string) {
+		var mismatch *ColumnArgMismatchError
+		test.S(t).ExpectTrue(errors.As(err, &mismatch))
+		test.S(t).ExpectEquals(mismatch.Builder, builder)
+		test.S(t).ExpectEquals(mismatch.Args, argsName)
+		test.S(t).ExpectEquals(mismatch.Expected, 3)
+		test.S(t).ExpectEquals(mismatch.Actual, 2)
+	}
+	{
+		_, _, err := BuildDMLDeleteQuery(databaseName, tableName, tableColumns, short)
+		expectMismatch(err, "BuildDMLDeleteQuery", "args")
+		test.S(t).ExpectEquals(err.Error(), "args count differs from table column count in BuildDMLDeleteQuery 2, 3")
+	}
+	{
+		_, _, err := BuildDMLInsertQuery(databaseName, tableName, tableColumns, tableColumns, tableColumns, short, InsertModeReplace)
+		expectMismatch(err, "BuildDMLInsertQuery", "args")
+	}
+	{
+		_, _, _, err := BuildDMLUpdateQuery(databaseName, tableName, tableColumns, tableColumns, tableColumns, tableColumns, short, args)
+		expectMismatch(err, "BuildDMLUpdateQuery", "value args")
+	}
+	{
+		_, _, _, err := BuildDMLUpdateQuery(databaseName, tableName, tableColumns, tableColumns, tableColumns, tableColumns, args, short)
+		expectMismatch(err, "BuildDMLUpdateQuery", "where args")
+	}
+	{
+		_, _, err := BuildDMLInsertQuery(databaseName, tableName, tableColumns, umconf.NewColumnList(nil), tableColumns, args, InsertModeReplace)
+		var mismatch *ColumnArgMismatchError
+		test.S(t).ExpectFalse(errors.As(err, &mismatch))
+	}
+}
+
+func TestBuildDMLUpdateQueryNoSetColumns(t *testing.T) {
+	databaseName := "mydb"
+	tableName := "tbl"
+	tableColumns := umconf.NewColumnList(umconf.NewColumns([]string{"id", "name"}))
+	args := umconf.ToColumnValues([]interface{}{3, "testname"}).GetAbstractValues()
+	empty := umconf.NewColumnList(nil)
+	{
+		query, _, _, err := BuildDMLUpdateQuery(databaseName, tableName, tableColumns, tableColumns, empty, tableColumns, args, args)
+		test.S(t).ExpectNotNil(err)
+		test.S(t).ExpectEquals(query, "")
+	}
+	{
+		query, _, _, err := BuildDMLUpdateQuery(databaseName, tableName, tableColumns, empty, empty, tableColumns, args, args)
+		test.S(t).ExpectNotNil(err)
+		test.S(t).ExpectEquals(query, "")
+	}
+}
+
+func TestBuildDMLQueryGeneratedColumn(t *testing.T) {
+	databaseName := "mydb"
+	tableName := "tbl"
+	columns := umconf.NewColumns([]string{"id", "name", "name_upper"})
+	columns[0].Key = "PRI"
+	columns[2].Generated = true
+	tableColumns := umconf.NewColumnList(columns)
+	args := umconf.ToColumnValues([]interface{}{3, "testname", "TESTNAME"}).GetAbstractValues()
+	{
+		query, sharedArgs, err := BuildDMLInsertQuery(databaseName, tableName, tableColumns, tableColumns, tableColumns, args, InsertModeOnDuplicateUpdate)
+		test.S(t).ExpectNil(err)
+		expected := `
+			insert into
+				mydb.tbl
+					(id, name)
+				values
+					(?, ?)
+				on duplicate key update id=values(id), name=values(name)
+		`
+		test.S(t).ExpectEquals(normalizeQuery(query), normalizeQuery(expected))
+		test.S(t).ExpectTrue(reflect.DeepEqual(sharedArgs, []interface{}{3, "testname"}))
+	}
+	{
+		query, sharedArgs, uniqueKeyArgs, err := BuildDMLUpdateQuery(databaseName, tableName, tableColumns, tableColumns, tableColumns, tableColumns, args, args)
+		test.S(t).ExpectNil(err)
+		expected := `
+			update
+				mydb.tbl
+					set id=?, name=?
+				where
+					((id = ?))
+				limit 1
+		`
+		test.S(t).ExpectEquals(normalizeQuery(query), normalizeQuery(expected))
+		test.S(t).ExpectTrue(reflect.DeepEqual(sharedArgs, []interface{}{3, "testname"}))
+		test.S(t).ExpectTrue(reflect.DeepEqual(uniqueKeyArgs, []interface{}{3}))
+	}
+	{
+		// Without a key every column is compared, the generated one included.
+		columns := umconf.NewColumns([]string{"id", "name", "name_upper"})
+		columns[2].Generated = true
+		tableColumns := umconf.NewColumnList(columns)
+		query, _, columnArgs, err := BuildDMLUpdateQuery(databaseName, tableName, tableColumns, tableColumns, tableColumns, tableColumns, args, args)
+		test.S(t).ExpectNil(err)
+		test.S(t).ExpectTrue(strings.Contains(normalizeQuery(query), "where ((id = ?) and (name = ?) and (name_upper = ?))"))
+		test.S(t).ExpectTrue(reflect.DeepEqual(columnArgs, []interface{}{3, "testname", "TESTNAME"}))
+	}
+	{
+		generated := umconf.NewColumns([]string{"name_upper"})
+		generated[0].Generated = true
+		sharedColumns := umconf.NewColumnList(generated)
+		_, _, err := BuildDMLInsertQuery(databaseName, tableName, tableColumns, sharedColumns, sharedColumns, args, InsertModeReplace)
+		test.S(t).ExpectNotNil(err)
+	}
+}
+
+func TestBuildDMLQueryJSONColumn(t *testing.T) {
+	databaseName := "mydb"
+	tableName := "tbl"
+	args := umconf.ToColumnValues([]interface{}{3, `{"a": 1}`}).GetAbstractValues()
+	newColumns := func() []umconf.Column {
+		columns := umconf.NewColumns([]string{"id", "doc"})
+		columns[1].Type = umconf.JSONColumnType
+		return columns
+	}
+	{
+		tableColumns := umconf.NewColumnList(newColumns())
+		query, columnArgs, err := BuildDMLDeleteQuery(databaseName, tableName, tableColumns, args)
+		test.S(t).ExpectNil(err)
+		expected := `
+			delete from
+				mydb.tbl
+				where
+					((id = ?) and (doc = cast(? as json)))
+		`
+		test.S(t).ExpectEquals(normalizeQuery(query), normalizeQuery(expected))
+		test.S(t).ExpectTrue(reflect.DeepEqual(columnArgs, []interface{}{3, `{"a": 1}`}))
+
+		query, _, columnArgs, err = BuildDMLUpdateQuery(databaseName, tableName, tableColumns, tableColumns, tableColumns, tableColumns, args, args)
+		test.S(t).ExpectNil(err)
+		test.S(t).ExpectTrue(strings.Contains(normalizeQuery(query), "where ((id = ?) and (doc = cast(? as json)))"))
+		test.S(t).ExpectEquals(len(columnArgs), 2)
+	}
+	{
+		columns := newColumns()
+		columns[0].Key = "PRI"
+		tableColumns := umconf.NewColumnList(columns)
+		query, columnArgs, err := BuildDMLDeleteQuery(databaseName, tableName, tableColumns, args)
+		test.S(t).ExpectNil(err)
+		test.S(t).ExpectEquals(normalizeQuery(query), "delete from mydb.tbl where ((id = ?))")
+		test.S(t).ExpectTrue(reflect.DeepEqual(columnArgs, []interface{}{3}))
+	}
+}
+
+func TestParseInsertMode(t *testing.T) {
+	for name, expected := range map[string]InsertMode{"": InsertModeReplace, "REPLACE": InsertModeReplace, "ignore": InsertModeIgnore, "update": InsertModeOnDuplicateUpdate} {
+		mode, err := ParseInsertMode(name)
+		test.S(t).ExpectNil(err)
+		test.S(t).ExpectEquals(mode, expected)
+	}
+	_, err := ParseInsertMode("upsert")
+	test.S(t).ExpectNotNil(err)
+}
+
+func TestNameMapper(t *testing.T) {
+	{
+		var mapper *NameMapper
+		databaseName, tableName := mapper.Map("stg", "orders")
+		test.S(t).ExpectEquals(databaseName, "stg")
+		test.S(t).ExpectEquals(tableName, "orders")
+	}
+	{
+		mapper := NewNameMapperFromDataSources([]*config.DataSource{
+			{
+				TableSchema:       "stg",
+				TableSchemaRename: "prod",
+				Tables:            []*config.Table{{TableSchema: "stg", TableName: "orders", TableRename: "orders_archive"}},
+			},
+			{
+				TableSchema: "app",
+				Tables:      []*config.Table{{TableSchema: "app", TableName: "users", TableRename: "users_v2"}},
+			},
+		})
+		databaseName, tableName := mapper.Map("stg", "orders")
+		test.S(t).ExpectEquals(databaseName, "prod")
+		test.S(t).ExpectEquals(tableName, "orders_archive")
+
+		databaseName, tableName = mapper.Map("stg", "items")
+		test.S(t).ExpectEquals(databaseName, "prod")
+		test.S(t).ExpectEquals(tableName, "items")
+
+		databaseName, tableName = mapper.Map("app", "users")
+		test.S(t).ExpectEquals(databaseName, "app")
+		test.S(t).ExpectEquals(tableName, "users_v2")
+
+		tableColumns := umconf.NewColumnList(umconf.NewColumns([]string{"id"}))
+		args := umconf.ToColumnValues([]interface{}{3}).GetAbstractValues()
+		databaseName, tableName = mapper.Map("stg", "orders")
+		query, _, err := BuildDMLInsertQuery(databaseName, tableName, tableColumns, tableColumns, tableColumns, args, InsertModeReplace)
+		test.S(t).ExpectNil(err)
+		test.S(t).ExpectTrue(strings.Contains(query, "`prod`.`orders_archive`"))
+	}
+	{
+		// lower_case_table_names=1 on the target
+		mapper := NewNameMapper()
+		mapper.AddTable("Shop", "Orders", "OrdersArchive")
+		databaseName, tableName := mapper.Map("Shop", "Orders")
+		test.S(t).ExpectEquals(databaseName, "Shop")
+		test.S(t).ExpectEquals(tableName, "OrdersArchive")
+
+		mapper.SetLowerCaseTableNames(1)
+		databaseName, tableName = mapper.Map("Shop", "Orders")
+		test.S(t).ExpectEquals(databaseName, "shop")
+		test.S(t).ExpectEquals(tableName, "ordersarchive")
+		databaseName, tableName = mapper.Map("Shop", "Items")
+		test.S(t).ExpectEquals(databaseName, "shop")
+		test.S(t).ExpectEquals(tableName, "items")
+
+		// column names are kept
+		tableColumns := umconf.NewColumnList(umconf.NewColumns([]string{"OrderId"}))
+		args := umconf.ToColumnValues([]interface{}{3}).GetAbstractValues()
+		query, _, err := BuildDMLInsertQuery(databaseName, tableName, tableColumns, tableColumns, tableColumns, args, InsertModeReplace)
+		test.S(t).ExpectNil(err)
+		test.S(t).ExpectTrue(strings.Contains(query, "`shop`.`items`"))
+		test.S(t).ExpectTrue(strings.Contains(query, "`OrderId`"))
+
+		mapper.SetLowerCaseTableNames(0)
+		databaseName, tableName = mapper.Map("Shop", "Orders")
+		test.S(t).ExpectEquals(databaseName, "Shop")
+		test.S(t).ExpectEquals(tableName, "OrdersArchive")
+	}
+}
+
+func TestColumnRenameMap(t *testing.T) {
+	sharedColumns := umconf.NewColumnList(umconf.NewColumns([]string{"id", "UserId", "name"}))
+	{
+		mapped, err := ColumnRenameMap(nil).MapColumns(sharedColumns)
+		test.S(t).ExpectNil(err)
+		test.S(t).ExpectTrue(reflect.DeepEqual(mapped.Names(), []string{"id", "UserId", "name"}))
+	}
+	{
+		renames := ColumnRenameMap{"id": "id", "UserId": "user_id", "name": "full_name"}
+		mapped, err := renames.MapColumns(sharedColumns)
+		test.S(t).ExpectNil(err)
+		test.S(t).ExpectTrue(reflect.DeepEqual(mapped.Names(), []string{"id", "user_id", "full_name"}))
+		test.S(t).ExpectTrue(reflect.DeepEqual(sharedColumns.Names(), []string{"id", "UserId", "name"}))
+	}
+	{
+		_, err := ColumnRenameMap{"id": "id", "UserId": "user_id"}.MapColumns(sharedColumns)
+		test.S(t).ExpectNotNil(err)
+	}
+	{
+		_, err := ColumnRenameMap{"id": "id", "UserId": "name", "name": "name"}.MapColumns(sharedColumns)
+		test.S(t).ExpectNotNil(err)
+	}
+}
+
+func TestNameMapperColumns(t *testing.T) {
+	sourceColumns := umconf.NewColumnList(umconf.NewColumns([]string{"ID", "UserId", "updated"}))
+	sourceColumns.VersionColumn = "UserId"
+	sourceColumns.SourceOrdinals = umconf.ColumnsMap{"updated": 0, "UserId": 1, "ID": 2}
+	{
+		var mapper *NameMapper
+		mapped, err := mapper.MapColumns("app", "users", sourceColumns)
+		test.S(t).ExpectNil(err)
+		test.S(t).ExpectTrue(mapped == sourceColumns)
+	}
+	mapper := NewNameMapperFromDataSources([]*config.DataSource{{
+		TableSchema: "app",
+		Tables:      []*config.Table{{TableSchema: "app", TableName: "users", ColumnRenames: map[string]string{"ID": "id", "UserId": "user_id"}}},
+	}})
+	{
+		mapped, err := mapper.MapColumns("app", "users", sourceColumns)
+		test.S(t).ExpectNil(err)
+		test.S(t).ExpectTrue(reflect.DeepEqual(mapped.Names(), []string{"id", "user_id", "updated"}))
+		test.S(t).ExpectEquals(mapped.VersionColumn, "user_id")
+		test.S(t).ExpectTrue(reflect.DeepEqual(mapped.SourceOrdinals, umconf.ColumnsMap{"updated": 0, "user_id": 1, "id": 2}))
+
+		source, err := mapper.SourceColumns("app", "users", mapped)
+		test.S(t).ExpectNil(err)
+		test.S(t).ExpectTrue(reflect.DeepEqual(source.Names(), sourceColumns.Names()))
+
+		// other tables keep their columns
+		mapped, err = mapper.MapColumns("app", "orders", sourceColumns)
+		test.S(t).ExpectNil(err)
+		test.S(t).ExpectTrue(mapped == sourceColumns)
+	}
+	{
+		// a renamed column the table doesn't have
+		_, err := mapper.MapColumns("app", "users", umconf.NewColumnList(umconf.NewColumns([]string{"ID", "updated"})))
+		test.S(t).ExpectNotNil(err)
+		// a rename onto a column kept
+		mapper.AddColumns("app", "users", map[string]string{"UserId": "updated"})
+		_, err = mapper.MapColumns("app", "users", sourceColumns)
+		test.S(t).ExpectNotNil(err)
+	}
+}
+
+func TestBuildDMLQueryRenamedColumns(t *testing.T) {
+	databaseName := "mydb"
+	tableName := "tbl"
+	tableColumns := umconf.NewColumnList(umconf.NewColumns([]string{"id", "UserId"}))
+	tableColumns.Columns[0].Key = "PRI"
+	mappedColumns, err := ColumnRenameMap{"id": "id", "UserId": "user_id"}.MapColumns(tableColumns)
+	test.S(t).ExpectNil(err)
+	args := umconf.ToColumnValues([]interface{}{3, 17}).GetAbstractValues()
+	{
+		query, sharedArgs, err := BuildDMLInsertQuery(databaseName, tableName, tableColumns, tableColumns, mappedColumns, args, InsertModeReplace)
+		test.S(t).ExpectNil(err)
+		expected := `
+			replace into
+				mydb.tbl
+					(id, user_id)
+				values
+					(?, ?)
+		`
+		test.S(t).ExpectEquals(normalizeQuery(query), normalizeQuery(expected))
+		test.S(t).ExpectTrue(strings.Contains(query, "`id`, `user_id`"))
+		test.S(t).ExpectTrue(reflect.DeepEqual(sharedArgs, []interface{}{3, 17}))
+	}
+	{
+		query, sharedArgs, _, err := BuildDMLUpdateQuery(databaseName, tableName, tableColumns, tableColumns, mappedColumns, tableColumns, args, args)
+		test.S(t).ExpectNil(err)
+		test.S(t).ExpectTrue(strings.Contains(query, "`id`=?, `user_id`=?"))
+		test.S(t).ExpectTrue(strings.HasSuffix(query, "where ((`id` = ?)) limit 1"))
+		test.S(t).ExpectTrue(reflect.DeepEqual(sharedArgs, []interface{}{3, 17}))
+	}
+	{
+		// renamed key columns are named on the target in the where clause too
+		mappedColumns, err := ColumnRenameMap{"id": "ID", "UserId": "user_id"}.MapColumns(tableColumns)
+		test.S(t).ExpectNil(err)
+		query, _, _, err := BuildDMLUpdateQuery(databaseName, tableName, tableColumns, tableColumns, mappedColumns, tableColumns, args, args)
+		test.S(t).ExpectNil(err)
+		test.S(t).ExpectTrue(strings.Contains(query, "`ID`=?, `user_id`=? where ((`ID` = ?))"))
+	}
+	{
+		_, _, err := BuildDMLInsertQuery(databaseName, tableName, tableColumns, tableColumns, umconf.NewColumnList(umconf.NewColumns([]string{"id"})), args, InsertModeReplace)
+		test.S(t).ExpectNotNil(err)
+	}
+}
+
+func TestBuildDMLQueryAST(t *testing.T) {
+	databaseName := "mydb"
+	tableName := "tbl"
+	tableColumns := umconf.NewColumnList(umconf.NewColumns([]string{"id", "name"}))
+	tableColumns.Columns[0].Key = "PRI"
+	args := umconf.ToColumnValues([]interface{}{3, "testname"}).GetAbstractValues()
+	{
+		stmt, _, err := BuildDMLInsertQueryAST(MySQLDialect{}, databaseName, tableName, tableColumns, tableColumns, tableColumns, args, InsertModeOnDuplicateUpdate)
+		test.S(t).ExpectNil(err)
+		stmt.Comment = "dtle job=job1 chunk=7"
+		query := stmt.String()
+		test.S(t).ExpectTrue(strings.HasPrefix(query, "insert into /* dtle job=job1 chunk=7 */ `mydb`.`tbl`"))
+		node, err := parser.New().ParseOneStmt(query, "", "")
+		test.S(t).ExpectNil(err)
+		insert, ok := node.(*ast.InsertStmt)
+		test.S(t).ExpectTrue(ok)
+		test.S(t).ExpectFalse(insert.IsReplace)
+		tableSource := insert.Table.TableRefs.Left.(*ast.TableSource).Source.(*ast.TableName)
+		test.S(t).ExpectEquals(tableSource.Schema.O, stmt.Database)
+		test.S(t).ExpectEquals(tableSource.Name.O, stmt.Table)
+		test.S(t).ExpectEquals(len(insert.Columns), len(stmt.Columns))
+		for i := range insert.Columns {
+			test.S(t).ExpectEquals(insert.Columns[i].Name.O, stmt.Columns[i])
+		}
+		test.S(t).ExpectEquals(len(insert.OnDuplicate), len(stmt.Columns))
+	}
+	{
+		stmt, _, _, err := BuildDMLUpdateQueryAST(MySQLDialect{}, databaseName, tableName, tableColumns, tableColumns, tableColumns, tableColumns, args, args)
+		test.S(t).ExpectNil(err)
+		stmt.Table = "tbl_shard_1"
+		node, err := parser.New().ParseOneStmt(stmt.String(), "", "")
+		test.S(t).ExpectNil(err)
+		update, ok := node.(*ast.UpdateStmt)
+		test.S(t).ExpectTrue(ok)
+		tableSource := update.TableRefs.TableRefs.Left.(*ast.TableSource).Source.(*ast.TableName)
+		test.S(t).ExpectEquals(tableSource.Name.O, "tbl_shard_1")
+		test.S(t).ExpectEquals(len(update.List), tableColumns.Len())
+		for i := range update.List {
+			test.S(t).ExpectEquals(update.List[i].Column.Name.O, tableColumns.Columns[i].Name)
+		}
+	}
+	{
+		stmt, _, err := BuildDMLDeleteQueryAST(MySQLDialect{}, databaseName, tableName, tableColumns, args)
+		test.S(t).ExpectNil(err)
+		query, _, err := BuildDMLDeleteQuery(databaseName, tableName, tableColumns, args)
+		test.S(t).ExpectNil(err)
+		test.S(t).ExpectEquals(query, stmt.String())
+		node, err := parser.New().ParseOneStmt(query, "", "")
+		test.S(t).ExpectNil(err)
+		_, ok := node.(*ast.DeleteStmt)
+		test.S(t).ExpectTrue(ok)
+	}
+}
+
+func TestBuildExplain(t *testing.T) {
+	test.S(t).ExpectEquals(BuildExplain("select 1"), "explain select 1")
+	test.S(t).ExpectEquals(BuildExplain("\n\t\tselect *\n\t\tfrom tbl\n\t;  \n"), "explain select *\n\t\tfrom tbl")
+	test.S(t).ExpectEquals(BuildExplainJSON("select 1;;"), "explain format=json select 1")
+	{
+		tableColumns := umconf.NewColumnList(umconf.NewColumns([]string{"id", "name"}))
+		args := umconf.ToColumnValues([]interface{}{3, "testname"}).GetAbstractValues()
+		query, columnArgs, err := BuildDMLDeleteQuery("mydb", "tbl", tableColumns, args)
+		test.S(t).ExpectNil(err)
+		explain, explainArgs := BuildExplainQuery(query, columnArgs)
+		test.S(t).ExpectTrue(strings.HasPrefix(explain, "explain delete from"))
+		test.S(t).ExpectTrue(reflect.DeepEqual(explainArgs, columnArgs))
+	}
+}
+
+func TestBuildSessionInit(t *testing.T) {
+	test.S(t).ExpectTrue(reflect.DeepEqual(BuildSessionInit("STRICT_TRANS_TABLES,NO_ZERO_DATE", "+00:00"), []string{
+		"set session sql_mode='STRICT_TRANS_TABLES,NO_ZERO_DATE'",
+		"set session time_zone='+00:00'",
+	}))
+	test.S(t).ExpectTrue(reflect.DeepEqual(BuildSessionInit("it's", ""), []string{
+		`set session sql_mode='it\'s'`,
+	}))
+	test.S(t).ExpectTrue(reflect.DeepEqual(BuildSessionInit("", "SYSTEM"), []string{
+		"set session time_zone='SYSTEM'",
+	}))
+	test.S(t).ExpectEquals(len(BuildSessionInit("", "")), 0)
+}
+
+func TestBuildConsistentSnapshotStart(t *testing.T) {
+	test.S(t).ExpectEquals(BuildConsistentSnapshotStart(FlavorMySQL), "start transaction with consistent snapshot")
+	test.S(t).ExpectEquals(BuildConsistentSnapshotStart(ParseServerFlavor("10.3.9-MariaDB")), "start transaction with consistent snapshot")
+	test.S(t).ExpectEquals(BuildConsistentSnapshotStart(ParseServerFlavor("5.7.25-TiDB-v4.0.0")), "start transaction")
+}
+
+func TestBuildWaitForGtidSetQuery(t *testing.T) {
+	query, args := BuildWaitForGtidSetQuery("3f2b1a4c-0000-0000-0000-000000000001:1-5", 90*time.Second)
+	test.S(t).ExpectEquals(query, "select wait_for_executed_gtid_set(?, ?)")
+	test.S(t).ExpectTrue(reflect.DeepEqual(args, []interface{}{"3f2b1a4c-0000-0000-0000-000000000001:1-5", float64(90)}))
+}
+
+func TestBuildTransactionWrapper(t *testing.T) {
+	statements := []string{"replace into `mydb`.`tbl` (`id`) values (?);", "update `mydb`.`checkpoint` set `pos` = ?"}
+	test.S(t).ExpectTrue(reflect.DeepEqual(BuildTransactionWrapper(statements), []string{
+		"start transaction",
+		"replace into `mydb`.`tbl` (`id`) values (?)",
+		"update `mydb`.`checkpoint` set `pos` = ?",
+		"commit",
+	}))
+	test.S(t).ExpectTrue(reflect.DeepEqual(BuildTransactionWrapper(nil), []string{"start transaction", "commit"}))
+
+	name := SavepointName(3)
+	test.S(t).ExpectEquals(name, "dtle_chunk_3")
+	test.S(t).ExpectTrue(reflect.DeepEqual(BuildSavepointWrapper(name, statements[:1]), []string{
+		"savepoint `dtle_chunk_3`",
+		"replace into `mydb`.`tbl` (`id`) values (?)",
+		"release savepoint `dtle_chunk_3`",
+	}))
+	test.S(t).ExpectEquals(BuildRollbackToSavepoint(name), "rollback to savepoint `dtle_chunk_3`")
+}
+
+func TestTimezoneConversionPolicy(t *testing.T) {
+	columns := umconf.NewColumnList(umconf.NewColumns([]string{"id", "created", "updated"}))
+	columns.Columns[0].Type = umconf.IntColumnType
+	columns.Columns[1].Type = umconf.DateTimeColumnType
+	columns.Columns[2].Type = umconf.DateTimeColumnType
+	columns.SetConvertDatetimeToTimestamp("updated", "+02:00")
+	columns.TimezonePolicy = &umconf.TimezoneConversionPolicy{
+		AppliesTo:    []umconf.ColumnType{umconf.DateTimeColumnType, umconf.TimestampColumnType},
+		FromTimezone: "+08:00",
+		ToTimezone:   "+00:00",
+	}
+	test.S(t).ExpectTrue(reflect.DeepEqual(buildColumnsPreparedValues(MySQLDialect{}, columns), []string{
+		"?",
+		"convert_tz(?, '+08:00', '+00:00')",
+		"convert_tz(?, '+02:00', '+00:00')",
+	}))
+	clause, err := BuildSetPreparedClause(columns)
+	test.S(t).ExpectNil(err)
+	test.S(t).ExpectEquals(clause, "`id`=?, `created`=convert_tz(?, '+08:00', '+00:00'), `updated`=convert_tz(?, '+02:00', '+00:00')")
+
+	mapped, err := ColumnRenameMap(nil).MapColumns(columns)
+	test.S(t).ExpectNil(err)
+	test.S(t).ExpectTrue(mapped.TimezonePolicy == columns.TimezonePolicy)
+}
+
+func TestTimezoneConversionPrecision(t *testing.T) {
+	columns := umconf.NewColumnList(umconf.NewColumns([]string{"id", "created", "updated"}))
+	columns.Columns[0].Type = umconf.IntColumnType
+	columns.Columns[1].Type = umconf.DateTimeColumnType
+	columns.Columns[1].Precision = 6
+	columns.Columns[2].Type = umconf.DateTimeColumnType
+	columns.SetConvertDatetimeToTimestamp("created", "+02:00")
+	columns.TimezonePolicy = &umconf.TimezoneConversionPolicy{
+		AppliesTo:    []umconf.ColumnType{umconf.DateTimeColumnType},
+		FromTimezone: "+08:00",
+		ToTimezone:   "+00:00",
+	}
+	test.S(t).ExpectTrue(reflect.DeepEqual(buildColumnsPreparedValues(MySQLDialect{}, columns), []string{
+		"?",
+		"convert_tz(cast(? as datetime(6)), '+02:00', '+00:00')",
+		"convert_tz(?, '+08:00', '+00:00')",
+	}))
+	clause, err := BuildSetPreparedClause(columns)
+	test.S(t).ExpectNil(err)
+	test.S(t).ExpectEquals(clause, "`id`=?, `created`=convert_tz(cast(? as datetime(6)), '+02:00', '+00:00'), `updated`=convert_tz(?, '+08:00', '+00:00')")
+
+	// Without a conversion the precision doesn't change the placeholder.
+	columns.Columns[1].TimezoneConversion = nil
+	columns.TimezonePolicy = nil
+	test.S(t).ExpectEquals(buildColumnPreparedValue(MySQLDialect{}, columns, &columns.Columns[1]), "?")
+}
+
+func TestQueryComment(t *testing.T) {
+	test.S(t).ExpectEquals(QueryComment(""), "")
+	test.S(t).ExpectEquals(QueryComment("dtle job=job1"), "/* dtle job=job1 */")
+
+	tableColumns := umconf.NewColumnList(umconf.NewColumns([]string{"id", "name"}))
+	tableColumns.Columns[0].Key = "PRI"
+	args := umconf.ToColumnValues([]interface{}{3, "testname"}).GetAbstractValues()
+	stmt, _, _, err := BuildDMLUpdateQueryAST(MySQLDialect{}, "mydb", "tbl", tableColumns, tableColumns, tableColumns, tableColumns, args, args)
+	test.S(t).ExpectNil(err)
+	stmt.Comment = "job=x */ drop table t; /*"
+	query := stmt.String()
+	test.S(t).ExpectTrue(strings.HasPrefix(query, "update /* job=x * / drop table t; /* */ `mydb`.`tbl` set "))
+	// The comment ends where it should, so the query is still one update.
+	node, err := parser.New().ParseOneStmt(query, "", "")
+	test.S(t).ExpectNil(err)
+	_, ok := node.(*ast.UpdateStmt)
+	test.S(t).ExpectTrue(ok)
+	test.S(t).ExpectEquals(stmt.Prepared().Placeholders, 3)
+
+	c, err := NewCursor("mydb", "tbl", tableColumns, 10)
+	test.S(t).ExpectNil(err)
+	c.Comment = "dtle job=job1 chunk=0"
+	query, _ = c.Next()
+	test.S(t).ExpectTrue(strings.HasPrefix(query, "select /* dtle job=job1 chunk=0 */ * from `mydb`.`tbl` "))
+}
+
+func TestDMLStatementPrepared(t *testing.T) {
+	databaseName := "mydb"
+	tableName := "tbl"
+	tableColumns := umconf.NewColumnList(umconf.NewColumns([]string{"id", "name", "note"}))
+	tableColumns.Columns[0].Key = "PRI"
+	args := umconf.ToColumnValues([]interface{}{3, "what?", nil}).GetAbstractValues()
+	{
+		stmt, sharedArgs, err := BuildDMLInsertQueryAST(MySQLDialect{}, databaseName, tableName, tableColumns, tableColumns, tableColumns, args, InsertModeOnDuplicateUpdate)
+		test.S(t).ExpectNil(err)
+		ps := stmt.Prepared()
+		test.S(t).ExpectEquals(ps.SQL, stmt.String())
+		test.S(t).ExpectEquals(ps.Placeholders, len(sharedArgs))
+		test.S(t).ExpectTrue(ps.Reusable)
+	}
+	{
+		stmt, sharedArgs, columnArgs, err := BuildDMLUpdateQueryAST(MySQLDialect{}, databaseName, tableName, tableColumns, tableColumns, tableColumns, tableColumns, args, args)
+		test.S(t).ExpectNil(err)
+		ps := stmt.Prepared()
+		test.S(t).ExpectEquals(ps.Placeholders, len(sharedArgs)+len(columnArgs))
+		test.S(t).ExpectTrue(ps.Reusable)
+	}
+	{
+		stmt, columnArgs, err := BuildDMLDeleteQueryAST(MySQLDialect{}, databaseName, tableName, tableColumns, args)
+		test.S(t).ExpectNil(err)
+		stmt.Comment = "chunk?"
+		ps := stmt.Prepared()
+		test.S(t).ExpectEquals(ps.Placeholders, len(columnArgs))
+		test.S(t).ExpectTrue(ps.Reusable)
+	}
+	{
+		// without a key, the NULL of `note` is inlined
+		noKeyColumns := umconf.NewColumnList(umconf.NewColumns([]string{"id", "name", "note"}))
+		stmt, columnArgs, err := BuildDMLDeleteQueryAST(MySQLDialect{}, databaseName, tableName, noKeyColumns, args)
+		test.S(t).ExpectNil(err)
+		ps := stmt.Prepared()
+		test.S(t).ExpectEquals(ps.Placeholders, len(columnArgs))
+		test.S(t).ExpectFalse(ps.Reusable)
+	}
+	{
+		binaryColumns := umconf.NewColumnList(umconf.NewColumns([]string{"id", "name"}))
+		binaryColumns.Columns[0].Key = "PRI"
+		binaryColumns.Columns[0].Type = umconf.BinaryColumnType
+		binaryColumns.Columns[0].ColumnType = "binary(2)"
+		binaryArgs := umconf.ToColumnValues([]interface{}{[]byte("?'"), "n"}).GetAbstractValues()
+		stmt, columnArgs, err := BuildDMLDeleteQueryAST(MySQLDialect{}, databaseName, tableName, binaryColumns, binaryArgs)
+		test.S(t).ExpectNil(err)
+		ps := stmt.Prepared()
+		test.S(t).ExpectEquals(ps.Placeholders, len(columnArgs))
+		test.S(t).ExpectFalse(ps.Reusable)
+	}
+	test.S(t).ExpectEquals(countPlaceholders("select '?', `?`, \"a\\\"?\", ? /* ? */ from t where a = ?"), 2)
+}
+
+func TestBuildBoundedDeleteQuery(t *testing.T) {
+	databaseName := "mydb"
+	tableName := "tbl"
+	orderColumns := umconf.NewColumnList(umconf.NewColumns([]string{"id", "sub"}))
+	where, err := BuildValueComparison("id", "?", LessThanComparisonSign)
+	test.S(t).ExpectNil(err)
+	{
+		query, explodedArgs, err := BuildBoundedDeleteQuery(databaseName, tableName, where, []interface{}{100}, orderColumns, 500)
+		test.S(t).ExpectNil(err)
+		expected := "delete from mydb.tbl where ((id < ?)) order by id asc, sub asc limit 500"
+		test.S(t).ExpectEquals(normalizeQuery(query), normalizeQuery(expected))
+		test.S(t).ExpectTrue(reflect.DeepEqual(explodedArgs, []interface{}{100}))
+	}
+	{
+		_, _, err := BuildBoundedDeleteQuery(databaseName, tableName, where, []interface{}{100}, orderColumns, 0)
+		test.S(t).ExpectNotNil(err)
+	}
+	{
+		_, _, err := BuildBoundedDeleteQuery(databaseName, tableName, where, nil, orderColumns, 500)
+		test.S(t).ExpectNotNil(err)
+	}
+	{
+		_, _, err := BuildBoundedDeleteQuery(databaseName, tableName, where, []interface{}{100}, umconf.NewColumnList(nil), 500)
+		test.S(t).ExpectNotNil(err)
+	}
+}
+
+func TestDMLStatementArgColumns(t *testing.T) {
+	databaseName := "mydb"
+	tableName := "tbl"
+	tableColumns := umconf.NewColumnList(umconf.NewColumns([]string{"id", "name", "note"}))
+	tableColumns.Columns[0].Key = "PRI"
+	noKeyColumns := umconf.NewColumnList(umconf.NewColumns([]string{"id", "name", "note"}))
+	args := umconf.ToColumnValues([]interface{}{3, "testname", "a note"}).GetAbstractValues()
+	{
+		stmt, sharedArgs, err := BuildDMLInsertQueryAST(MySQLDialect{}, databaseName, tableName, tableColumns, tableColumns, tableColumns, args, InsertModeReplace)
+		test.S(t).ExpectNil(err)
+		ps := stmt.Prepared()
+		test.S(t).ExpectEquals(len(ps.ArgColumns), ps.Placeholders)
+		test.S(t).ExpectTrue(reflect.DeepEqual(ps.ArgColumns, []string{"id", "name", "note"}))
+		test.S(t).ExpectEquals(FormatArgs(ps.ArgColumns, sharedArgs), "id=3, name=testname, note=a note")
+	}
+	{
+		stmt, sharedArgs, columnArgs, err := BuildDMLUpdateQueryAST(MySQLDialect{}, databaseName, tableName, tableColumns, tableColumns, tableColumns, tableColumns, args, args)
+		test.S(t).ExpectNil(err)
+		ps := stmt.Prepared()
+		test.S(t).ExpectEquals(len(ps.ArgColumns), ps.Placeholders)
+		test.S(t).ExpectEquals(len(ps.ArgColumns), len(sharedArgs)+len(columnArgs))
+		test.S(t).ExpectTrue(reflect.DeepEqual(ps.ArgColumns, []string{"id", "name", "note", "id"}))
+	}
+	{
+		stmt, _, err := BuildDMLDeleteQueryAST(MySQLDialect{}, databaseName, tableName, tableColumns, args)
+		test.S(t).ExpectNil(err)
+		test.S(t).ExpectTrue(reflect.DeepEqual(stmt.Prepared().ArgColumns, []string{"id"}))
+	}
+	{
+		stmt, _, err := BuildDMLDeleteQueryAST(MySQLDialect{}, databaseName, tableName, noKeyColumns, args)
+		test.S(t).ExpectNil(err)
+		ps := stmt.Prepared()
+		test.S(t).ExpectEquals(len(ps.ArgColumns), ps.Placeholders)
+		test.S(t).ExpectTrue(reflect.DeepEqual(ps.ArgColumns, []string{"id", "name", "note"}))
+	}
+	test.S(t).ExpectEquals(FormatArgs([]string{"id"}, []interface{}{1, []byte("x")}), "id=1, $2=x")
+}
+
+func TestBuildDMLUpdateQueryVersionColumn(t *testing.T) {
+	databaseName := "mydb"
+	tableName := "tbl"
+	tableColumns := umconf.NewColumnList(umconf.NewColumns([]string{"id", "name", "updated_at"}))
+	tableColumns.Columns[0].Key = "PRI"
+	tableColumns.VersionColumn = "updated_at"
+	valueArgs := umconf.ToColumnValues([]interface{}{3, "newname", "2018-01-02 00:00:00"}).GetAbstractValues()
+	whereArgs := umconf.ToColumnValues([]interface{}{3, "oldname", "2018-01-01 00:00:00"}).GetAbstractValues()
+	{
+		stmt, sharedArgs, columnArgs, err := BuildDMLUpdateQueryAST(MySQLDialect{}, databaseName, tableName, tableColumns, tableColumns, tableColumns, tableColumns, valueArgs, whereArgs)
+		test.S(t).ExpectNil(err)
+		expected := "update mydb.tbl set id=?, name=?, updated_at=? where ((id = ?) and (updated_at <= ?)) limit 1"
+		test.S(t).ExpectEquals(normalizeQuery(stmt.String()), normalizeQuery(expected))
+		test.S(t).ExpectEquals(len(sharedArgs), 3)
+		test.S(t).ExpectTrue(reflect.DeepEqual(columnArgs, []interface{}{3, "2018-01-02 00:00:00"}))
+		test.S(t).ExpectTrue(reflect.DeepEqual(stmt.ArgColumns, []string{"id", "name", "updated_at", "id", "updated_at"}))
+		test.S(t).ExpectTrue(stmt.Prepared().Reusable)
+	}
+	{
+		// without a new version there is nothing to compare with, and the
+		// query differs from that of other rows
+		nullValueArgs := umconf.ToColumnValues([]interface{}{3, "newname", nil}).GetAbstractValues()
+		stmt, _, columnArgs, err := BuildDMLUpdateQueryAST(MySQLDialect{}, databaseName, tableName, tableColumns, tableColumns, tableColumns, tableColumns, nullValueArgs, whereArgs)
+		test.S(t).ExpectNil(err)
+		test.S(t).ExpectFalse(strings.Contains(stmt.String(), "<="))
+		test.S(t).ExpectEquals(len(columnArgs), 1)
+		test.S(t).ExpectFalse(stmt.Prepared().Reusable)
+	}
+	{
+		tableColumns.VersionColumn = "version"
+		_, _, _, err := BuildDMLUpdateQueryAST(MySQLDialect{}, databaseName, tableName, tableColumns, tableColumns, tableColumns, tableColumns, valueArgs, whereArgs)
+		test.S(t).ExpectNotNil(err)
+	}
+}
+
+func TestBuildDMLQueryTransformer(t *testing.T) {
+	databaseName := "mydb"
+	tableName := "tbl"
+	tableColumns := umconf.NewColumnList(umconf.NewColumns([]string{"id", "name"}))
+	tableColumns.Columns[0].Key = "PRI"
+	tableColumns.Columns[1].Transformer = func(value interface{}) interface{} {
+		return strings.ToUpper(value.(string))
+	}
+	{
+		args := umconf.ToColumnValues([]interface{}{3, "alice"}).GetAbstractValues()
+		_, sharedArgs, err := BuildDMLInsertQuery(databaseName, tableName, tableColumns, tableColumns, tableColumns, args, InsertModeReplace)
+		test.S(t).ExpectNil(err)
+		test.S(t).ExpectTrue(reflect.DeepEqual(sharedArgs, []interface{}{3, "ALICE"}))
+	}
+	{
+		// the rows matched are those a transformed value was written to
+		noKeyColumns := umconf.NewColumnList(umconf.NewColumns([]string{"id", "name"}))
+		noKeyColumns.Columns[1].Transformer = tableColumns.Columns[1].Transformer
+		valueArgs := umconf.ToColumnValues([]interface{}{3, "bob"}).GetAbstractValues()
+		whereArgs := umconf.ToColumnValues([]interface{}{3, "alice"}).GetAbstractValues()
+		_, sharedArgs, columnArgs, err := BuildDMLUpdateQuery(databaseName, tableName, noKeyColumns, noKeyColumns, noKeyColumns, noKeyColumns, valueArgs, whereArgs)
+		test.S(t).ExpectNil(err)
+		test.S(t).ExpectTrue(reflect.DeepEqual(sharedArgs, []interface{}{3, "BOB"}))
+		test.S(t).ExpectTrue(reflect.DeepEqual(columnArgs, []interface{}{3, "ALICE"}))
+		_, columnArgs, err = BuildDMLDeleteQuery(databaseName, tableName, noKeyColumns, whereArgs)
+		test.S(t).ExpectNil(err)
+		test.S(t).ExpectTrue(reflect.DeepEqual(columnArgs, []interface{}{3, "ALICE"}))
+	}
+	{
+		// NULLs are written as is
+		args := umconf.ToColumnValues([]interface{}{3, nil}).GetAbstractValues()
+		_, sharedArgs, err := BuildDMLInsertQuery(databaseName, tableName, tableColumns, tableColumns, tableColumns, args, InsertModeReplace)
+		test.S(t).ExpectNil(err)
+		test.S(t).ExpectTrue(reflect.DeepEqual(sharedArgs, []interface{}{3, nil}))
+	}
+	// reading the source leaves the values as they are
+	test.S(t).ExpectEquals(tableColumns.Columns[1].ConvertArg("alice"), "alice")
+}
+
+func TestBuildRangeDeleteQuery(t *testing.T) {
+	databaseName := "mydb"
+	tableName := "tbl"
+	uniqueKeyColumns := umconf.NewColumnList(umconf.NewColumns([]string{"name", "position"}))
+	{
+		query, explodedArgs, err := BuildRangeDeleteQuery(databaseName, tableName, uniqueKeyColumns, []interface{}{"a", 3}, []interface{}{"b", 7}, false)
+		test.S(t).ExpectNil(err)
+		expected := "delete from mydb.tbl where ((((name > ?)) or ((name = ?) and (position > ?))) and (((name < ?)) or ((name = ?) and (position < ?)) or ((name = ?) and (position = ?))))"
+		test.S(t).ExpectEquals(normalizeQuery(query), normalizeQuery(expected))
+		test.S(t).ExpectTrue(reflect.DeepEqual(explodedArgs, []interface{}{"a", "a", 3, "b", "b", 7, "b", 7}))
+	}
+	{
+		query, explodedArgs, err := BuildRangeDeleteQuery(databaseName, tableName, uniqueKeyColumns, []interface{}{"a", 3}, []interface{}{"b", 7}, true)
+		test.S(t).ExpectNil(err)
+		test.S(t).ExpectTrue(strings.HasPrefix(normalizeQuery(query), normalizeQuery("delete from mydb.tbl where ((((name > ?)) or ((name = ?) and (position > ?)) or ((name = ?) and (position = ?))) and")))
+		test.S(t).ExpectEquals(len(explodedArgs), 10)
+	}
+	{
+		_, _, err := BuildRangeDeleteQuery(databaseName, tableName, umconf.NewColumnList(nil), nil, nil, false)
+		test.S(t).ExpectNotNil(err)
+		_, _, err = BuildRangeDeleteQuery(databaseName, tableName, uniqueKeyColumns, []interface{}{"a"}, []interface{}{"b", 7}, false)
+		test.S(t).ExpectNotNil(err)
+	}
+}
+
+func TestNamedArgs(t *testing.T) {
+	uniqueKeyColumns := umconf.NewColumnList(umconf.NewColumns([]string{"name", "position"}))
+	query, explodedArgs, err := BuildGapCheckQuery("mydb", "tbl", uniqueKeyColumns, []interface{}{"a", 3}, []interface{}{"b", 7})
+	test.S(t).ExpectNil(err)
+	named, namedArgs, err := NamedArgs(query, explodedArgs)
+	test.S(t).ExpectNil(err)
+	expected := "select count(*) from `mydb`.`tbl` where (((`name` > :p0)) or ((`name` = :p1) and (`position` > :p2))) and " +
+		"(((`name` < :p3)) or ((`name` = :p4) and (`position` < :p5)))"
+	test.S(t).ExpectEquals(named, expected)
+	test.S(t).ExpectEquals(len(namedArgs), len(explodedArgs))
+	for i, arg := range explodedArgs {
+		name := fmt.Sprintf("p%d", i)
+		test.S(t).ExpectEquals(namedArgs[name], arg)
+		named = strings.Replace(named, ":"+name+")", "?)", 1)
+	}
+	test.S(t).ExpectEquals(named, query)
+
+	// quoted question marks aren't placeholders
+	named, namedArgs, err = NamedArgs("select * from t where `a?` = ? and b = '?'", []interface{}{1})
+	test.S(t).ExpectNil(err)
+	test.S(t).ExpectEquals(named, "select * from t where `a?` = :p0 and b = '?'")
+	test.S(t).ExpectTrue(reflect.DeepEqual(namedArgs, map[string]interface{}{"p0": 1}))
+	_, _, err = NamedArgs(query, explodedArgs[1:])
+	test.S(t).ExpectNotNil(err)
+}
+
+func TestBuildKeysExistQuery(t *testing.T) {
+	databaseName := "mydb"
+	tableName := "tbl"
+	{
+		uniqueKeyColumns := umconf.NewColumnList(umconf.NewColumns([]string{"id"}))
+		query, explodedArgs, err := BuildKeysExistQuery(databaseName, tableName, uniqueKeyColumns, [][]interface{}{{3}, {5}, {7}})
+		test.S(t).ExpectNil(err)
+		expected := "select id from mydb.tbl where ((id) in ((?), (?), (?)))"
+		test.S(t).ExpectEquals(normalizeQuery(query), normalizeQuery(expected))
+		test.S(t).ExpectTrue(reflect.DeepEqual(explodedArgs, []interface{}{3, 5, 7}))
+	}
+	{
+		uniqueKeyColumns := umconf.NewColumnList(umconf.NewColumns([]string{"name", "position"}))
+		uniqueKeyColumns.Columns[1].IsUnsigned = true
+		query, explodedArgs, err := BuildKeysExistQuery(databaseName, tableName, uniqueKeyColumns, [][]interface{}{{"a", int8(-1)}, {"b", int8(2)}})
+		test.S(t).ExpectNil(err)
+		expected := "select name, position from mydb.tbl where ((name, position) in ((?, ?), (?, ?)))"
+		test.S(t).ExpectEquals(normalizeQuery(query), normalizeQuery(expected))
+		test.S(t).ExpectTrue(reflect.DeepEqual(explodedArgs, []interface{}{"a", uint8(255), "b", uint8(2)}))
+	}
+	{
+		uniqueKeyColumns := umconf.NewColumnList(umconf.NewColumns([]string{"name", "position"}))
+		_, _, err := BuildKeysExistQuery(databaseName, tableName, uniqueKeyColumns, [][]interface{}{{"a"}})
+		test.S(t).ExpectNotNil(err)
+		_, _, err = BuildKeysExistQuery(databaseName, tableName, uniqueKeyColumns, nil)
+		test.S(t).ExpectNotNil(err)
+	}
+	{
+		// the comparison is a predicate for a bounded delete too
+		uniqueKeyColumns := umconf.NewColumnList(umconf.NewColumns([]string{"id"}))
+		where, err := BuildTupleInComparison(uniqueKeyColumns, 2)
+		test.S(t).ExpectNil(err)
+		query, _, err := BuildBoundedDeleteQuery(databaseName, tableName, where, []interface{}{3, 5}, uniqueKeyColumns, 2)
+		test.S(t).ExpectNil(err)
+		test.S(t).ExpectEquals(normalizeQuery(query), "delete from mydb.tbl where (((id) in ((?), (?)))) order by id asc limit 2")
+	}
+}
+
+func TestBuildOrphanCheckQuery(t *testing.T) {
+	databaseName := "mydb"
+	tableName := "tbl"
+	{
+		uniqueKeyColumns := umconf.NewColumnList(umconf.NewColumns([]string{"id"}))
+		query, explodedArgs, err := BuildOrphanCheckQuery(databaseName, tableName, uniqueKeyColumns, [][]interface{}{{3}, {5}}, []interface{}{2}, []interface{}{7})
+		test.S(t).ExpectNil(err)
+		expected := "select id from mydb.tbl where (((id > ?))) and (((id < ?)) or ((id = ?))) and (not ((id) in ((?), (?))))"
+		test.S(t).ExpectEquals(normalizeQuery(query), normalizeQuery(expected))
+		test.S(t).ExpectTrue(reflect.DeepEqual(explodedArgs, []interface{}{2, 7, 7, 3, 5}))
+	}
+	{
+		uniqueKeyColumns := umconf.NewColumnList(umconf.NewColumns([]string{"name", "position"}))
+		uniqueKeyColumns.Columns[1].IsUnsigned = true
+		query, explodedArgs, err := BuildOrphanCheckQuery(databaseName, tableName, uniqueKeyColumns, [][]interface{}{{"a", int8(-1)}, {"b", int8(2)}}, nil, []interface{}{"c", 1})
+		test.S(t).ExpectNil(err)
+		test.S(t).ExpectTrue(strings.HasPrefix(query, "select `name`, `position` from `mydb`.`tbl` where "))
+		test.S(t).ExpectTrue(strings.HasSuffix(normalizeQuery(query), "and (not ((name, position) in ((?, ?), (?, ?))))"))
+		test.S(t).ExpectTrue(reflect.DeepEqual(explodedArgs[len(explodedArgs)-4:], []interface{}{"a", uint8(255), "b", uint8(2)}))
+	}
+	{
+		// a chunk without source rows has only orphans
+		uniqueKeyColumns := umconf.NewColumnList(umconf.NewColumns([]string{"id"}))
+		query, explodedArgs, err := BuildOrphanCheckQuery(databaseName, tableName, uniqueKeyColumns, nil, []interface{}{2}, []interface{}{7})
+		test.S(t).ExpectNil(err)
+		test.S(t).ExpectFalse(strings.Contains(query, " in "))
+		test.S(t).ExpectTrue(reflect.DeepEqual(explodedArgs, []interface{}{2, 7, 7}))
+	}
+	{
+		uniqueKeyColumns := umconf.NewColumnList(umconf.NewColumns([]string{"name", "position"}))
+		_, _, err := BuildOrphanCheckQuery(databaseName, tableName, uniqueKeyColumns, [][]interface{}{{"a"}}, nil, []interface{}{"c", 1})
+		test.S(t).ExpectNotNil(err)
+		_, _, err = BuildOrphanCheckQuery(databaseName, tableName, uniqueKeyColumns, [][]interface{}{{"a", nil}}, nil, []interface{}{"c", 1})
+		test.S(t).ExpectNotNil(err)
+		_, _, err = BuildOrphanCheckQuery(databaseName, tableName, uniqueKeyColumns, nil, nil, []interface{}{"c"})
+		test.S(t).ExpectNotNil(err)
+	}
+}
@@ -0,0 +1,385 @@
+/*
+ * Copyright (C) 2016-2018. ActionTech.
+ * Based on: github.com/hashicorp/nomad, github.com/github/gh-ost .
+ * License: MPL version 2: https://www.mozilla.org/en-US/MPL/2.0 .
+ */
+
+package sql
+
+import (
+	"fmt"
+	"strings"
+
+	umconf "github.com/actiontech/dtle/internal/config/mysql"
+)
+
+// Cursor scans a table in chunks ordered by a unique key, each column in its
+// SortDirection, or the reverse of it if Descending. Each chunk query selects
+// the rows after Position, the key of the last row read, so a chunk costs the
+// same wherever it is in the table:
+//
+//	for !c.Done() {
+//		query, args := c.Next()
+//		// run the query, then pass its rows on
+//		err = c.Advance(rowCount, lastRowKey)
+//	}
+type Cursor struct {
+	DatabaseName     string
+	TableName        string
+	UniqueKeyColumns *umconf.ColumnList
+	ChunkSize        int64
+	// Comment, if set, tags the chunk queries, see QueryComment.
+	Comment string
+	// Filter, if set, is a condition the rows read must also meet, such as
+	// "`tenant_id` = ?". It is used as is, so it must be escaped by the
+	// caller; FilterArgs are the args of its placeholders.
+	Filter     string
+	FilterArgs []interface{}
+	// Columns, if set, are the columns the chunk queries select instead of
+	// all of them, see SetProjection.
+	Columns []string
+	// Index, if set, is the index the chunk queries are forced to scan, one
+	// ordered by UniqueKeyColumns.
+	Index string
+	// IdentityKeyColumns, if set, are the columns matching a row on the
+	// target, when the table is chunked by another index, see NewIndexCursor.
+	// They are among UniqueKeyColumns.
+	IdentityKeyColumns *umconf.ColumnList
+	// RowNumbers, if set, makes the chunks ranges of row numbers in the order
+	// of the unique key rather than ranges of the key, see UseRowNumbers.
+	RowNumbers bool
+	// Descending, if set, walks the table from its last row to its first,
+	// each key column in the reverse of its SortDirection, such as to stay
+	// away from rows being inserted at the end of an auto-increment key.
+	// Position, the last row of a chunk, is then its lowest key.
+	Descending bool
+
+	// Position is the unique key of the last row read, or nil before the
+	// first chunk. A scan is resumed by saving it and setting it on a new
+	// Cursor.
+	Position []interface{}
+
+	done bool
+	// rowsRead is the number of rows read, the row number the next chunk
+	// starts after when RowNumbers is set.
+	rowsRead int64
+}
+
+// NewCursor returns a Cursor at the start of the table.
+func NewCursor(databaseName, tableName string, uniqueKeyColumns *umconf.ColumnList, chunkSize int64) (*Cursor, error) {
+	if uniqueKeyColumns.Len() == 0 {
+		return nil, fmt.Errorf("Got 0 unique key columns in NewCursor")
+	}
+	if chunkSize <= 0 {
+		return nil, fmt.Errorf("Got chunk size %d in NewCursor, want > 0", chunkSize)
+	}
+	return &Cursor{
+		DatabaseName:     databaseName,
+		TableName:        tableName,
+		UniqueKeyColumns: uniqueKeyColumns,
+		ChunkSize:        chunkSize,
+	}, nil
+}
+
+// NewIndexCursor returns a Cursor at the start of the table which chunks it by
+// the columns of a secondary index, such as created_at, whose order follows
+// that of the rows on disk better than the identity key, such as a random
+// UUID primary key. The identity key columns which aren't in the index are
+// added to the chunk key, so that it is unique and rows with equal index
+// values are read in the order of their identity.
+func NewIndexCursor(databaseName, tableName, indexName string, chunkKeyColumns, identityKeyColumns *umconf.ColumnList, chunkSize int64) (*Cursor, error) {
+	if chunkKeyColumns.Len() == 0 || identityKeyColumns.Len() == 0 {
+		return nil, fmt.Errorf("Got %d chunk key and %d identity key columns in NewIndexCursor",
+			chunkKeyColumns.Len(), identityKeyColumns.Len())
+	}
+	columns := append([]umconf.Column(nil), chunkKeyColumns.ColumnList()...)
+	for _, column := range identityKeyColumns.ColumnList() {
+		if chunkKeyColumns.GetColumn(column.Name) == nil {
+			columns = append(columns, column)
+		}
+	}
+	c, err := NewCursor(databaseName, tableName, umconf.NewColumnList(columns), chunkSize)
+	if err != nil {
+		return nil, err
+	}
+	c.Index = indexName
+	c.IdentityKeyColumns = identityKeyColumns
+	return c, nil
+}
+
+// IdentityKey returns the values of the identity key columns in key, a
+// unique key such as Position, for matching its row on the target.
+func (c *Cursor) IdentityKey(key []interface{}) []interface{} {
+	if c.IdentityKeyColumns == nil || key == nil {
+		return key
+	}
+	names := c.UniqueKeyColumns.Names()
+	identity := make([]interface{}, 0, c.IdentityKeyColumns.Len())
+	for _, name := range c.IdentityKeyColumns.Names() {
+		for i := range names {
+			if names[i] == name {
+				identity = append(identity, key[i])
+			}
+		}
+	}
+	return identity
+}
+
+// SetProjection makes the chunk queries select only the projection columns,
+// such as to repair a few columns of a range of rows, which must be among
+// sharedColumns. The unique key columns are added to them unless requested,
+// so that the rows read can be matched on the target.
+func (c *Cursor) SetProjection(sharedColumns, projection *umconf.ColumnList) error {
+	if projection.Len() == 0 {
+		return fmt.Errorf("Got 0 projection columns in Cursor.SetProjection")
+	}
+	var columns []string
+	for _, name := range projection.Names() {
+		if sharedColumns.GetColumn(name) == nil {
+			return fmt.Errorf("Got projection column %s not among the shared columns in Cursor.SetProjection", name)
+		}
+		columns = append(columns, name)
+	}
+	for _, name := range c.UniqueKeyColumns.Names() {
+		if projection.GetColumn(name) == nil {
+			columns = append(columns, name)
+		}
+	}
+	c.Columns = columns
+	return nil
+}
+
+// UseRowNumbers makes the chunk queries select rows by their row number, see
+// BuildWindowedRangeQuery, if the server of version has window functions and
+// the cursor is at the start of the table. The cursor must also have
+// Columns, set by SetProjection: the rows are numbered in a subquery whose
+// columns the chunk queries name, so that they don't select the number.
+// Row numbers are simpler to check by hand than the comparisons of a
+// composite key, such as for an audit, though slower, as every chunk numbers
+// the rows before it again. Rows inserted or deleted during the scan shift
+// the numbers, so the rows must be read in a snapshot. Otherwise the chunks
+// stay ranges of the key. It tells which it is.
+func (c *Cursor) UseRowNumbers(version string) bool {
+	c.RowNumbers = len(c.Columns) > 0 && c.Position == nil && SupportsWindowFunctions(version)
+	return c.RowNumbers
+}
+
+// Done tells whether the whole table has been read.
+func (c *Cursor) Done() bool {
+	return c.done
+}
+
+// Next returns the query of the next chunk and its args. It returns "" once
+// the cursor is Done.
+func (c *Cursor) Next() (query string, args []interface{}) {
+	if c.done {
+		return "", nil
+	}
+	n := c.UniqueKeyColumns.Len()
+	names := make([]string, n)
+	orderBy := make([]string, n)
+	for i, column := range c.UniqueKeyColumns.ColumnList() {
+		names[i] = EscapeName(column.Name)
+		orderBy[i] = fmt.Sprintf("%s %s", names[i], c.direction(&column).Keyword())
+	}
+	if c.RowNumbers {
+		return c.nextWindowed(orderBy)
+	}
+
+	where := "true"
+	if c.Position != nil {
+		// The form like: (A > a) or ((A = a) and (B > b)) or ..., with < for
+		// the columns walked in descending order. A NULL in the position is
+		// compared with is null, as = and > never hold for it.
+		var rangeItems []string
+		for x := 0; x < n; x++ {
+			after, afterArgs, ok := c.afterItem(x, names[x])
+			if !ok {
+				continue
+			}
+			innerItems := make([]string, x+1)
+			for y := 0; y < x; y++ {
+				var equalArgs []interface{}
+				innerItems[y], equalArgs = c.equalItem(y, names[y])
+				args = append(args, equalArgs...)
+			}
+			innerItems[x] = after
+			args = append(args, afterArgs...)
+			rangeItems = append(rangeItems, fmt.Sprintf("(%s)", strings.Join(innerItems, " and ")))
+		}
+		where = "false"
+		if len(rangeItems) > 0 {
+			where = fmt.Sprintf("(%s)", strings.Join(rangeItems, " or "))
+		}
+	}
+	if c.Filter != "" {
+		// The filter comes after the range, as do its args.
+		if c.Position == nil {
+			where = fmt.Sprintf("(%s)", c.Filter)
+		} else {
+			where = fmt.Sprintf("%s and (%s)", where, c.Filter)
+		}
+		args = append(args, c.FilterArgs...)
+	}
+	verb := "select"
+	if c.Comment != "" {
+		verb += " " + QueryComment(c.Comment)
+	}
+	selected := "*"
+	if len(c.Columns) > 0 {
+		escaped := make([]string, len(c.Columns))
+		for i, name := range c.Columns {
+			escaped[i] = EscapeName(name)
+		}
+		selected = strings.Join(escaped, ", ")
+	}
+	from := EscapeQualifiedName(c.DatabaseName, c.TableName)
+	if c.Index != "" {
+		from += fmt.Sprintf(" force index (%s)", EscapeName(c.Index))
+	}
+	query = fmt.Sprintf("%s %s from %s where %s order by %s limit %d",
+		verb, selected, from, where, strings.Join(orderBy, ", "), c.ChunkSize)
+	return query, args
+}
+
+// nextWindowed returns the query of the next chunk when RowNumbers is set.
+func (c *Cursor) nextWindowed(orderBy []string) (string, []interface{}) {
+	from := EscapeQualifiedName(c.DatabaseName, c.TableName)
+	if c.Index != "" {
+		from += fmt.Sprintf(" force index (%s)", EscapeName(c.Index))
+	}
+	var args []interface{}
+	if c.Filter != "" {
+		from += fmt.Sprintf(" where (%s)", c.Filter)
+		args = append(args, c.FilterArgs...)
+	}
+	query := buildWindowedRangeQuery(c.Columns, orderBy, from, c.Comment)
+	return query, append(args, c.rowsRead, c.rowsRead+c.ChunkSize)
+}
+
+// direction returns the order the chunk queries walk a key column in.
+func (c *Cursor) direction(column *umconf.Column) umconf.SortDirection {
+	if !c.Descending {
+		return column.SortDirection
+	}
+	if column.SortDirection == umconf.SortDescending {
+		return umconf.SortAscending
+	}
+	return umconf.SortDescending
+}
+
+// positionArg returns the value of the i-th key column in Position as the
+// arg it is compared with. Enums are sorted by their index, so they are
+// compared by index too, whichever way they are walked.
+func (c *Cursor) positionArg(i int) interface{} {
+	column := &c.UniqueKeyColumns.Columns[i]
+	if column.Type == umconf.EnumColumnType {
+		var value string
+		switch v := c.Position[i].(type) {
+		case []byte:
+			value = string(v)
+		case string:
+			value = v
+		}
+		if ordinal, ok := column.EnumOrdinal(value); ok {
+			return ordinal
+		}
+	}
+	return column.ConvertArg(c.Position[i])
+}
+
+// equalItem compares the i-th key column with its value in Position.
+func (c *Cursor) equalItem(i int, name string) (string, []interface{}) {
+	if c.Position[i] == nil {
+		return fmt.Sprintf("(%s is null)", name), nil
+	}
+	return fmt.Sprintf("(%s = ?)", name), []interface{}{c.positionArg(i)}
+}
+
+// afterItem selects the values of the i-th key column after its value in
+// Position, in the order of the chunk queries: MySQL sorts NULLs first in
+// ascending order and last in descending order. It returns false if no
+// value is after, as for a NULL of a column walked in descending order.
+func (c *Cursor) afterItem(i int, name string) (string, []interface{}, bool) {
+	column := &c.UniqueKeyColumns.Columns[i]
+	value := c.Position[i]
+	descending := c.direction(column) == umconf.SortDescending
+	switch {
+	case value == nil && descending:
+		return "", nil, false
+	case value == nil:
+		return fmt.Sprintf("(%s is not null)", name), nil, true
+	}
+	var sign ValueComparisonSign = GreaterThanComparisonSign
+	if descending {
+		sign = LessThanComparisonSign
+	}
+	args := []interface{}{c.positionArg(i)}
+	if descending && column.Nullable {
+		return fmt.Sprintf("((%s %s ?) or (%s is null))", name, sign, name), args, true
+	}
+	return fmt.Sprintf("(%s %s ?)", name, sign), args, true
+}
+
+// Advance moves the cursor past the chunk of the last Next, given the number
+// of rows it returned and the unique key of its last row. A chunk short of
+// ChunkSize is the last one.
+func (c *Cursor) Advance(rowCount int, lastKey []interface{}) error {
+	if rowCount > 0 && len(lastKey) != c.UniqueKeyColumns.Len() {
+		return fmt.Errorf("Got %d key values for %d unique key columns in Cursor.Advance", len(lastKey), c.UniqueKeyColumns.Len())
+	}
+	if rowCount > 0 {
+		c.Position = append([]interface{}(nil), lastKey...)
+	}
+	c.rowsRead += int64(rowCount)
+	if int64(rowCount) < c.ChunkSize {
+		c.done = true
+	}
+	return nil
+}
+
+// BuildWindowedRangeQuery builds the query of the rows of a table numbered
+// after fromRow and up to toRow in the order of the unique key, counting from
+// one, with row_number() in a common table expression:
+//
+//	with `ranked` as (select `a`, `b`, `c`, row_number() over (order by `a` asc, `b` asc) as `dtle_row_number`
+//		from `db`.`tbl`) select `a`, `b`, `c` from `ranked`
+//		where `dtle_row_number` > ? and `dtle_row_number` <= ? order by `dtle_row_number`
+//
+// It needs a server with window functions, see SupportsWindowFunctions; a
+// Cursor falls back to ranges of the key on the others.
+func BuildWindowedRangeQuery(databaseName, tableName string, columns, uniqueKeyColumns *umconf.ColumnList, fromRow, toRow int64) (string, []interface{}, error) {
+	if columns.Len() == 0 {
+		return "", nil, fmt.Errorf("Got 0 columns in BuildWindowedRangeQuery")
+	}
+	if uniqueKeyColumns.Len() == 0 {
+		return "", nil, fmt.Errorf("Got 0 unique key columns in BuildWindowedRangeQuery")
+	}
+	if fromRow < 0 || toRow <= fromRow {
+		return "", nil, fmt.Errorf("Got rows after %d up to %d in BuildWindowedRangeQuery", fromRow, toRow)
+	}
+	orderBy := make([]string, uniqueKeyColumns.Len())
+	for i, column := range uniqueKeyColumns.ColumnList() {
+		orderBy[i] = fmt.Sprintf("%s %s", EscapeName(column.Name), column.SortDirection.Keyword())
+	}
+	query := buildWindowedRangeQuery(columns.Names(), orderBy, EscapeQualifiedName(databaseName, tableName), "")
+	return query, []interface{}{fromRow, toRow}, nil
+}
+
+// buildWindowedRangeQuery builds the query of BuildWindowedRangeQuery,
+// numbering the rows of from, a table possibly followed by an index hint and
+// a where clause.
+func buildWindowedRangeQuery(columns, orderBy []string, from, comment string) string {
+	escaped := make([]string, len(columns))
+	for i, name := range columns {
+		escaped[i] = EscapeName(name)
+	}
+	selected := strings.Join(escaped, ", ")
+	verb := "with"
+	if comment != "" {
+		verb += " " + QueryComment(comment)
+	}
+	return fmt.Sprintf("%s `ranked` as (select %s, row_number() over (order by %s) as `dtle_row_number` from %s) "+
+		"select %s from `ranked` where `dtle_row_number` > ? and `dtle_row_number` <= ? order by `dtle_row_number`",
+		verb, selected, strings.Join(orderBy, ", "), from, selected)
+}
@@ -0,0 +1,303 @@
+/*
+ * Copyright (C) 2016-2018. ActionTech.
+ * Based on: github.com/hashicorp/nomad, github.com/github/gh-ost .
+ * License: MPL version 2: https://www.mozilla.org/en-US/MPL/2.0 .
+ */
+
+package sql
+
+import (
+	"reflect"
+	"testing"
+
+	umconf "github.com/actiontech/dtle/internal/config/mysql"
+	test "github.com/outbrain/golib/tests"
+)
+
+func TestCursor(t *testing.T) {
+	keyColumns := umconf.NewColumnList(umconf.NewColumns([]string{"a", "b"}))
+	// rows of the table in unique key order
+	rows := [][]interface{}{{1, 1}, {1, 2}, {2, 1}, {3, 1}, {3, 2}}
+	after := func(row, position []interface{}) bool {
+		if position == nil {
+			return true
+		}
+		return row[0].(int) > position[0].(int) || row[0] == position[0] && row[1].(int) > position[1].(int)
+	}
+
+	c, err := NewCursor("mydb", "tbl", keyColumns, 2)
+	test.S(t).ExpectNil(err)
+	var read [][]interface{}
+	var queries []string
+	for !c.Done() {
+		query, args := c.Next()
+		queries = append(queries, query)
+		if c.Position == nil {
+			test.S(t).ExpectEquals(len(args), 0)
+		} else {
+			test.S(t).ExpectTrue(reflect.DeepEqual(args, []interface{}{c.Position[0], c.Position[0], c.Position[1]}))
+		}
+		var chunk [][]interface{}
+		for _, row := range rows {
+			if after(row, c.Position) && int64(len(chunk)) < c.ChunkSize {
+				chunk = append(chunk, row)
+			}
+		}
+		read = append(read, chunk...)
+		var lastKey []interface{}
+		if len(chunk) > 0 {
+			lastKey = chunk[len(chunk)-1]
+		}
+		test.S(t).ExpectNil(c.Advance(len(chunk), lastKey))
+	}
+	test.S(t).ExpectTrue(reflect.DeepEqual(read, rows))
+	test.S(t).ExpectEquals(len(queries), 3)
+	test.S(t).ExpectEquals(queries[0], "select * from `mydb`.`tbl` where true order by `a` asc, `b` asc limit 2")
+	test.S(t).ExpectEquals(queries[1], "select * from `mydb`.`tbl` where (((`a` > ?)) or ((`a` = ?) and (`b` > ?))) order by `a` asc, `b` asc limit 2")
+	query, args := c.Next()
+	test.S(t).ExpectEquals(query, "")
+	test.S(t).ExpectEquals(len(args), 0)
+
+	// A cursor resumes from a saved position.
+	resumed, err := NewCursor("mydb", "tbl", keyColumns, 10)
+	test.S(t).ExpectNil(err)
+	resumed.Position = []interface{}{2, 1}
+	_, args = resumed.Next()
+	test.S(t).ExpectTrue(reflect.DeepEqual(args, []interface{}{2, 2, 1}))
+
+	test.S(t).ExpectNotNil(resumed.Advance(1, []interface{}{3}))
+	_, err = NewCursor("mydb", "tbl", umconf.NewColumnList(nil), 10)
+	test.S(t).ExpectNotNil(err)
+}
+
+func TestCursor_Filter(t *testing.T) {
+	keyColumns := umconf.NewColumnList(umconf.NewColumns([]string{"a", "b"}))
+	c, err := NewCursor("mydb", "tbl", keyColumns, 2)
+	test.S(t).ExpectNil(err)
+	c.Filter = "`tenant_id` = ?"
+	c.FilterArgs = []interface{}{42}
+
+	query, args := c.Next()
+	test.S(t).ExpectEquals(query, "select * from `mydb`.`tbl` where (`tenant_id` = ?) order by `a` asc, `b` asc limit 2")
+	test.S(t).ExpectTrue(reflect.DeepEqual(args, []interface{}{42}))
+
+	test.S(t).ExpectNil(c.Advance(2, []interface{}{1, 2}))
+	query, args = c.Next()
+	test.S(t).ExpectEquals(query, "select * from `mydb`.`tbl` where (((`a` > ?)) or ((`a` = ?) and (`b` > ?))) and (`tenant_id` = ?) order by `a` asc, `b` asc limit 2")
+	test.S(t).ExpectTrue(reflect.DeepEqual(args, []interface{}{1, 1, 2, 42}))
+}
+
+func TestCursor_SetProjection(t *testing.T) {
+	sharedColumns := umconf.NewColumnList(umconf.NewColumns([]string{"id", "tenant", "status", "note"}))
+	keyColumns := umconf.NewColumnList(umconf.NewColumns([]string{"tenant", "id"}))
+	c, err := NewCursor("mydb", "tbl", keyColumns, 2)
+	test.S(t).ExpectNil(err)
+
+	// The key columns not requested come after the requested ones.
+	test.S(t).ExpectNil(c.SetProjection(sharedColumns, umconf.NewColumnList(umconf.NewColumns([]string{"status", "id"}))))
+	test.S(t).ExpectTrue(reflect.DeepEqual(c.Columns, []string{"status", "id", "tenant"}))
+	query, _ := c.Next()
+	test.S(t).ExpectEquals(query, "select `status`, `id`, `tenant` from `mydb`.`tbl` where true order by `tenant` asc, `id` asc limit 2")
+
+	test.S(t).ExpectNotNil(c.SetProjection(sharedColumns, umconf.NewColumnList(umconf.NewColumns([]string{"status", "missing"}))))
+	test.S(t).ExpectNotNil(c.SetProjection(sharedColumns, umconf.NewColumnList(nil)))
+	// A failed projection leaves the last one.
+	test.S(t).ExpectTrue(reflect.DeepEqual(c.Columns, []string{"status", "id", "tenant"}))
+}
+
+func TestCursor_mixedDirections(t *testing.T) {
+	keyColumns := umconf.NewColumnList(umconf.NewColumns([]string{"a", "b"}))
+	keyColumns.Columns[1].SortDirection = umconf.SortDescending
+	c, err := NewCursor("mydb", "tbl", keyColumns, 2)
+	test.S(t).ExpectNil(err)
+
+	query, _ := c.Next()
+	test.S(t).ExpectEquals(query, "select * from `mydb`.`tbl` where true order by `a` asc, `b` desc limit 2")
+	test.S(t).ExpectNil(c.Advance(2, []interface{}{1, 5}))
+	query, args := c.Next()
+	test.S(t).ExpectEquals(query, "select * from `mydb`.`tbl` where (((`a` > ?)) or ((`a` = ?) and (`b` < ?))) order by `a` asc, `b` desc limit 2")
+	test.S(t).ExpectTrue(reflect.DeepEqual(args, []interface{}{1, 1, 5}))
+}
+
+func TestCursor_nullableKey(t *testing.T) {
+	keyColumns := umconf.NewColumnList(umconf.NewColumns([]string{"a", "b"}))
+	keyColumns.Columns[0].Nullable = true
+	c, err := NewCursor("mydb", "tbl", keyColumns, 2)
+	test.S(t).ExpectNil(err)
+
+	// The last row of the chunk has a NULL a: the rows after it have a NULL a
+	// and a greater b, or any a.
+	c.Position = []interface{}{nil, 3}
+	query, args := c.Next()
+	test.S(t).ExpectEquals(query, "select * from `mydb`.`tbl` where (((`a` is not null)) or ((`a` is null) and (`b` > ?))) order by `a` asc, `b` asc limit 2")
+	test.S(t).ExpectTrue(reflect.DeepEqual(args, []interface{}{3}))
+
+	// NULLs are before any value, so a row with a value is compared as usual.
+	c.Position = []interface{}{1, 3}
+	query, args = c.Next()
+	test.S(t).ExpectEquals(query, "select * from `mydb`.`tbl` where (((`a` > ?)) or ((`a` = ?) and (`b` > ?))) order by `a` asc, `b` asc limit 2")
+	test.S(t).ExpectTrue(reflect.DeepEqual(args, []interface{}{1, 1, 3}))
+
+	// Descending, NULLs are last: they are after any value, and nothing is
+	// after them but a greater b.
+	keyColumns.Columns[0].SortDirection = umconf.SortDescending
+	c.Position = []interface{}{1, 3}
+	query, args = c.Next()
+	test.S(t).ExpectEquals(query, "select * from `mydb`.`tbl` where ((((`a` < ?) or (`a` is null))) or ((`a` = ?) and (`b` > ?))) order by `a` desc, `b` asc limit 2")
+	test.S(t).ExpectTrue(reflect.DeepEqual(args, []interface{}{1, 1, 3}))
+	c.Position = []interface{}{nil, 3}
+	query, args = c.Next()
+	test.S(t).ExpectEquals(query, "select * from `mydb`.`tbl` where (((`a` is null) and (`b` > ?))) order by `a` desc, `b` asc limit 2")
+	test.S(t).ExpectTrue(reflect.DeepEqual(args, []interface{}{3}))
+}
+
+func TestCursor_Descending(t *testing.T) {
+	keyColumns := umconf.NewColumnList(umconf.NewColumns([]string{"a", "b", "c"}))
+	// rows of the table in unique key order
+	rows := [][]interface{}{{1, 1, 1}, {1, 1, 2}, {1, 2, 1}, {2, 1, 1}, {2, 1, 3}, {2, 2, 2}, {3, 1, 1}}
+	before := func(row, position []interface{}) bool {
+		if position == nil {
+			return true
+		}
+		for i := range row {
+			if row[i] != position[i] {
+				return row[i].(int) < position[i].(int)
+			}
+		}
+		return false
+	}
+
+	c, err := NewCursor("mydb", "tbl", keyColumns, 2)
+	test.S(t).ExpectNil(err)
+	c.Descending = true
+	var read, boundaries [][]interface{}
+	for !c.Done() {
+		_, args := c.Next()
+		if c.Position != nil {
+			p := c.Position
+			test.S(t).ExpectTrue(reflect.DeepEqual(args, []interface{}{p[0], p[0], p[1], p[0], p[1], p[2]}))
+		}
+		// the rows before the position, from the last one
+		var chunk [][]interface{}
+		for i := len(rows) - 1; i >= 0; i-- {
+			if before(rows[i], c.Position) && int64(len(chunk)) < c.ChunkSize {
+				chunk = append(chunk, rows[i])
+			}
+		}
+		read = append(read, chunk...)
+		var lastKey []interface{}
+		if len(chunk) > 0 {
+			lastKey = chunk[len(chunk)-1]
+			boundaries = append(boundaries, lastKey)
+		}
+		test.S(t).ExpectNil(c.Advance(len(chunk), lastKey))
+	}
+	test.S(t).ExpectEquals(len(read), len(rows))
+	for i := range read {
+		test.S(t).ExpectTrue(reflect.DeepEqual(read[i], rows[len(rows)-1-i]))
+	}
+	// each chunk ends at a lower key than the one before
+	test.S(t).ExpectEquals(len(boundaries), 4)
+	for i := 1; i < len(boundaries); i++ {
+		test.S(t).ExpectTrue(before(boundaries[i], boundaries[i-1]))
+	}
+
+	c, err = NewCursor("mydb", "tbl", keyColumns, 2)
+	test.S(t).ExpectNil(err)
+	c.Descending = true
+	c.Position = []interface{}{2, 1, 3}
+	query, _ := c.Next()
+	test.S(t).ExpectEquals(query, "select * from `mydb`.`tbl` where (((`a` < ?)) or ((`a` = ?) and (`b` < ?)) or ((`a` = ?) and (`b` = ?) and (`c` < ?))) order by `a` desc, `b` desc, `c` desc limit 2")
+
+	// A descending column is walked ascending, and an enum is compared by
+	// its index either way.
+	keyColumns.Columns[1].SortDirection = umconf.SortDescending
+	keyColumns.Columns[2].Type = umconf.EnumColumnType
+	keyColumns.Columns[2].ColumnType = "enum('low','high')"
+	c.Position = []interface{}{2, 1, []byte("high")}
+	query, args := c.Next()
+	test.S(t).ExpectEquals(query, "select * from `mydb`.`tbl` where (((`a` < ?)) or ((`a` = ?) and (`b` > ?)) or ((`a` = ?) and (`b` = ?) and (`c` < ?))) order by `a` desc, `b` asc, `c` desc limit 2")
+	test.S(t).ExpectTrue(reflect.DeepEqual(args, []interface{}{2, 2, 1, 2, 1, 2}))
+}
+
+func TestIndexCursor(t *testing.T) {
+	columns := umconf.NewColumns([]string{"id", "created_at", "name"})
+	columns[0].Key = "PRI"
+	tableColumns := umconf.NewColumnList(columns)
+	chunkKeyColumns := umconf.NewColumnList(umconf.NewColumns([]string{"created_at"}))
+	identityKeyColumns := umconf.NewColumnList(umconf.NewColumns([]string{"id"}))
+
+	// The chunks are ranges of created_at, with the UUID breaking ties.
+	c, err := NewIndexCursor("mydb", "tbl", "idx_created_at", chunkKeyColumns, identityKeyColumns, 2)
+	test.S(t).ExpectNil(err)
+	test.S(t).ExpectTrue(reflect.DeepEqual(c.UniqueKeyColumns.Names(), []string{"created_at", "id"}))
+	query, _ := c.Next()
+	test.S(t).ExpectEquals(query, "select * from `mydb`.`tbl` force index (`idx_created_at`) where true order by `created_at` asc, `id` asc limit 2")
+	lastRow := []interface{}{"9f3c2b1e-0000-4000-8000-000000000002", "2018-01-01 00:00:00", "b"}
+	test.S(t).ExpectNil(c.Advance(2, []interface{}{lastRow[1], lastRow[0]}))
+	query, args := c.Next()
+	test.S(t).ExpectEquals(query, "select * from `mydb`.`tbl` force index (`idx_created_at`) where (((`created_at` > ?)) or ((`created_at` = ?) and (`id` > ?))) order by `created_at` asc, `id` asc limit 2")
+	test.S(t).ExpectTrue(reflect.DeepEqual(args, []interface{}{lastRow[1], lastRow[1], lastRow[0]}))
+	test.S(t).ExpectTrue(reflect.DeepEqual(c.IdentityKey(c.Position), []interface{}{lastRow[0]}))
+
+	// A row read is still matched on the target by its UUID.
+	query, deleteArgs, err := BuildDMLDeleteQuery("mydb", "tbl", tableColumns, umconf.ToColumnValues(lastRow).GetAbstractValues())
+	test.S(t).ExpectNil(err)
+	test.S(t).ExpectEquals(normalizeQuery(query), normalizeQuery("delete from mydb.tbl where ((id = ?))"))
+	test.S(t).ExpectTrue(reflect.DeepEqual(deleteArgs, []interface{}{lastRow[0]}))
+
+	// An index which already has the identity columns is used as is.
+	c, err = NewIndexCursor("mydb", "tbl", "idx_created_at_id", umconf.NewColumnList(umconf.NewColumns([]string{"created_at", "id"})), identityKeyColumns, 2)
+	test.S(t).ExpectNil(err)
+	test.S(t).ExpectEquals(c.UniqueKeyColumns.Len(), 2)
+	_, err = NewIndexCursor("mydb", "tbl", "idx", chunkKeyColumns, umconf.NewColumnList(nil), 2)
+	test.S(t).ExpectNotNil(err)
+}
+
+func TestBuildWindowedRangeQuery(t *testing.T) {
+	columns := umconf.NewColumnList(umconf.NewColumns([]string{"a", "b", "c"}))
+	keyColumns := umconf.NewColumnList(umconf.NewColumns([]string{"a", "b"}))
+	keyColumns.Columns[1].SortDirection = umconf.SortDescending
+	query, args, err := BuildWindowedRangeQuery("mydb", "tbl", columns, keyColumns, 100, 200)
+	test.S(t).ExpectNil(err)
+	test.S(t).ExpectEquals(query, "with `ranked` as (select `a`, `b`, `c`, row_number() over (order by `a` asc, `b` desc) as `dtle_row_number` from `mydb`.`tbl`) "+
+		"select `a`, `b`, `c` from `ranked` where `dtle_row_number` > ? and `dtle_row_number` <= ? order by `dtle_row_number`")
+	test.S(t).ExpectTrue(reflect.DeepEqual(args, []interface{}{int64(100), int64(200)}))
+
+	_, _, err = BuildWindowedRangeQuery("mydb", "tbl", columns, umconf.NewColumnList(nil), 0, 10)
+	test.S(t).ExpectNotNil(err)
+	_, _, err = BuildWindowedRangeQuery("mydb", "tbl", columns, keyColumns, 10, 10)
+	test.S(t).ExpectNotNil(err)
+}
+
+func TestCursor_UseRowNumbers(t *testing.T) {
+	columns := umconf.NewColumnList(umconf.NewColumns([]string{"a", "b", "c"}))
+	keyColumns := umconf.NewColumnList(umconf.NewColumns([]string{"a", "b"}))
+	c, err := NewCursor("mydb", "tbl", keyColumns, 2)
+	test.S(t).ExpectNil(err)
+	test.S(t).ExpectNil(c.SetProjection(columns, columns))
+
+	// Older servers are read by ranges of the key.
+	test.S(t).ExpectFalse(c.UseRowNumbers("5.7.25-log"))
+	query, _ := c.Next()
+	test.S(t).ExpectEquals(query, "select `a`, `b`, `c` from `mydb`.`tbl` where true order by `a` asc, `b` asc limit 2")
+
+	test.S(t).ExpectTrue(c.UseRowNumbers("8.0.19"))
+	c.Filter, c.FilterArgs = "`c` = ?", []interface{}{"x"}
+	query, args := c.Next()
+	test.S(t).ExpectEquals(query, "with `ranked` as (select `a`, `b`, `c`, row_number() over (order by `a` asc, `b` asc) as `dtle_row_number` from `mydb`.`tbl` where (`c` = ?)) "+
+		"select `a`, `b`, `c` from `ranked` where `dtle_row_number` > ? and `dtle_row_number` <= ? order by `dtle_row_number`")
+	test.S(t).ExpectTrue(reflect.DeepEqual(args, []interface{}{"x", int64(0), int64(2)}))
+	test.S(t).ExpectNil(c.Advance(2, []interface{}{1, 2}))
+	_, args = c.Next()
+	test.S(t).ExpectTrue(reflect.DeepEqual(args, []interface{}{"x", int64(2), int64(4)}))
+	test.S(t).ExpectNil(c.Advance(1, []interface{}{3, 1}))
+	test.S(t).ExpectTrue(c.Done())
+
+	// A resumed cursor only knows the key it is at.
+	resumed, err := NewCursor("mydb", "tbl", keyColumns, 2)
+	test.S(t).ExpectNil(err)
+	test.S(t).ExpectNil(resumed.SetProjection(columns, columns))
+	resumed.Position = []interface{}{2, 1}
+	test.S(t).ExpectFalse(resumed.UseRowNumbers("8.0.19"))
+}
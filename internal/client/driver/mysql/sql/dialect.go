@@ -0,0 +1,286 @@
+/*
+ * Copyright (C) 2016-2018. ActionTech.
+ * Based on: github.com/hashicorp/nomad, github.com/github/gh-ost .
+ * License: MPL version 2: https://www.mozilla.org/en-US/MPL/2.0 .
+ */
+
+package sql
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+
+	umconf "github.com/actiontech/dtle/internal/config/mysql"
+)
+
+// Dialect renders the parts of a generated query that differ between target
+// databases. Builders render MySQL unless given another Dialect.
+type Dialect interface {
+	// QuoteIdent quotes a database, table or column name.
+	QuoteIdent(name string) string
+	// RangeHint renders an index hint of a chunk query, or "" if the target
+	// has no index hints.
+	RangeHint(hint *umconf.IndexHint) string
+	// TimezoneConvert renders expr converted from fromTimezone to toTimezone.
+	TimezoneConvert(expr, fromTimezone, toTimezone string) string
+	// DatetimeCast renders expr cast to a datetime keeping precision digits
+	// of fractional seconds.
+	DatetimeCast(expr string, precision int) string
+	// UpsertVerb returns the leading keywords of an insert in mode.
+	UpsertVerb(mode InsertMode) string
+	// UpsertClause returns the trailing clause of an insert of columns in
+	// mode, or "" if the verb is enough.
+	UpsertClause(mode InsertMode, columns *umconf.ColumnList) (string, error)
+	// SingleRowSuffix returns the trailing clause limiting an update to one
+	// row, or "" if the target has none.
+	SingleRowSuffix() string
+	// JSONEquals renders the comparison of a json column with a `?`
+	// placeholder by json value rather than by text.
+	JSONEquals(column string) string
+	// BinaryLiteral renders a binary value inlined into a query as a value
+	// of columnType.
+	BinaryLiteral(value interface{}, columnType string) string
+	// Rebind rewrites the `?` placeholders of a rendered query into the
+	// target's placeholders.
+	Rebind(query string) string
+}
+
+// MySQLDialect renders MySQL, which is what dtle replicates into, and the
+// engines speaking its protocol. Flavor adjusts the clauses they differ in.
+type MySQLDialect struct {
+	Flavor ServerFlavor
+}
+
+func (MySQLDialect) QuoteIdent(name string) string {
+	return EscapeName(name)
+}
+
+func (d MySQLDialect) RangeHint(hint *umconf.IndexHint) string {
+	return d.Flavor.IndexHint(hint)
+}
+
+func (MySQLDialect) TimezoneConvert(expr, fromTimezone, toTimezone string) string {
+	return fmt.Sprintf("convert_tz(%s, '%s', '%s')", expr, fromTimezone, toTimezone)
+}
+
+func (MySQLDialect) DatetimeCast(expr string, precision int) string {
+	return fmt.Sprintf("cast(%s as datetime(%d))", expr, precision)
+}
+
+func (MySQLDialect) UpsertVerb(mode InsertMode) string {
+	return mode.Verb()
+}
+
+func (MySQLDialect) UpsertClause(mode InsertMode, columns *umconf.ColumnList) (string, error) {
+	if mode != InsertModeOnDuplicateUpdate {
+		return "", nil
+	}
+	return BuildOnDuplicateUpdateClause(columns)
+}
+
+func (MySQLDialect) SingleRowSuffix() string {
+	return "limit 1"
+}
+
+func (MySQLDialect) JSONEquals(column string) string {
+	return fmt.Sprintf("(%s = cast(? as json))", EscapeName(column))
+}
+
+func (MySQLDialect) BinaryLiteral(value interface{}, columnType string) string {
+	return fmt.Sprintf("cast(%s as %s)", EscapeBinaryValue(value), columnType)
+}
+
+func (MySQLDialect) Rebind(query string) string {
+	return query
+}
+
+// ServerFlavor is the engine behind a MySQL connection. The engines read
+// the queries that dtle builds the same way but for a few clauses, which
+// the methods of ServerFlavor render.
+type ServerFlavor int
+
+const (
+	FlavorMySQL ServerFlavor = iota
+	FlavorMariaDB
+	FlavorTiDB
+)
+
+// ParseServerFlavor tells the flavor of a server from its version, as
+// selected from @@version, e.g. "10.3.9-MariaDB" or "5.7.25-TiDB-v4.0.0".
+// Any other version is MySQL.
+func ParseServerFlavor(version string) ServerFlavor {
+	lower := strings.ToLower(version)
+	switch {
+	case strings.Contains(lower, "tidb"):
+		return FlavorTiDB
+	case strings.Contains(lower, "mariadb"):
+		return FlavorMariaDB
+	}
+	return FlavorMySQL
+}
+
+// SupportsWindowFunctions tells whether a server has window functions such
+// as row_number(), from its version as for ParseServerFlavor: MySQL 8.0,
+// MariaDB 10.2 and TiDB 3.0 on.
+func SupportsWindowFunctions(version string) bool {
+	lower := strings.ToLower(version)
+	minMajor, minMinor := 8, 0
+	switch ParseServerFlavor(version) {
+	case FlavorTiDB:
+		// e.g. 5.7.25-TiDB-v4.0.0
+		if i := strings.Index(lower, "tidb-v"); i >= 0 {
+			lower = lower[i+len("tidb-v"):]
+		}
+		minMajor, minMinor = 3, 0
+	case FlavorMariaDB:
+		// replication clients are sent 5.5.5- before the version
+		lower = strings.TrimPrefix(lower, "5.5.5-")
+		minMajor, minMinor = 10, 2
+	}
+	var major, minor int
+	if n, _ := fmt.Sscanf(lower, "%d.%d", &major, &minor); n < 1 {
+		return false
+	}
+	return major > minMajor || major == minMajor && minor >= minMinor
+}
+
+func (f ServerFlavor) String() string {
+	switch f {
+	case FlavorMariaDB:
+		return "MariaDB"
+	case FlavorTiDB:
+		return "TiDB"
+	}
+	return "MySQL"
+}
+
+// IndexHint renders an index hint of a chunk query. TiDB doesn't force an
+// index the way MySQL does, so a force hint is rendered as the use hint it
+// follows. An empty hint renders as an empty string.
+func (f ServerFlavor) IndexHint(hint *umconf.IndexHint) string {
+	if f == FlavorTiDB && hint != nil && (hint.Kind == "" || hint.Kind == umconf.ForceIndexHint) {
+		hint = &umconf.IndexHint{Kind: umconf.UseIndexHint, Index: hint.Index}
+	}
+	return hint.String()
+}
+
+// SharedLockClause returns the trailing clause of a select locking the rows
+// it reads in share mode, or "" if the flavor has none. MySQL and MariaDB
+// take lock in share mode, which every version of them reads. TiDB rejects
+// it, its reads being of a snapshot anyway.
+func (f ServerFlavor) SharedLockClause() string {
+	if f == FlavorTiDB {
+		return ""
+	}
+	return "lock in share mode"
+}
+
+// PostgresDialect renders PostgreSQL. Names are quoted with double quotes,
+// there are no index hints, and collisions are handled with `on conflict` on
+// the primary key. InsertModeReplace overwrites the existing row like
+// InsertModeOnDuplicateUpdate, without deleting it first.
+type PostgresDialect struct{}
+
+func (PostgresDialect) QuoteIdent(name string) string {
+	if unquoted, err := strconv.Unquote(name); err == nil {
+		name = unquoted
+	}
+	return fmt.Sprintf(`"%s"`, strings.Replace(name, `"`, `""`, -1))
+}
+
+func (PostgresDialect) RangeHint(hint *umconf.IndexHint) string {
+	return ""
+}
+
+// TimezoneConvert renders an `at time zone` conversion. MySQL style offsets
+// such as "+08:00" are given as intervals, since postgres reads a bare offset
+// string as a POSIX zone with the sign inverted.
+func (PostgresDialect) TimezoneConvert(expr, fromTimezone, toTimezone string) string {
+	zone := func(tz string) string {
+		if strings.HasPrefix(tz, "+") || strings.HasPrefix(tz, "-") {
+			return fmt.Sprintf("interval '%s'", EscapeValue(tz))
+		}
+		return fmt.Sprintf("'%s'", EscapeValue(tz))
+	}
+	return fmt.Sprintf("((%s at time zone %s) at time zone %s)", expr, zone(fromTimezone), zone(toTimezone))
+}
+
+func (PostgresDialect) DatetimeCast(expr string, precision int) string {
+	return fmt.Sprintf("cast(%s as timestamp(%d))", expr, precision)
+}
+
+func (PostgresDialect) UpsertVerb(mode InsertMode) string {
+	return "insert into"
+}
+
+func (d PostgresDialect) UpsertClause(mode InsertMode, columns *umconf.ColumnList) (string, error) {
+	if mode == InsertModeIgnore {
+		return "on conflict do nothing", nil
+	}
+	if columns.Len() == 0 {
+		return "", fmt.Errorf("Got 0 columns in PostgresDialect.UpsertClause")
+	}
+	keys := []string{}
+	updateTokens := []string{}
+	for _, column := range columns.ColumnList() {
+		columnName := d.QuoteIdent(column.Name)
+		if strings.ToUpper(column.Key) == "PRI" {
+			keys = append(keys, columnName)
+		}
+		updateTokens = append(updateTokens, fmt.Sprintf("%s=excluded.%s", columnName, columnName))
+	}
+	if len(keys) == 0 {
+		return "", fmt.Errorf("No primary key columns to detect conflicts on in PostgresDialect.UpsertClause")
+	}
+	return fmt.Sprintf("on conflict (%s) do update set %s", strings.Join(keys, ", "), strings.Join(updateTokens, ", ")), nil
+}
+
+// SingleRowSuffix returns "", postgres updates have no limit. The where clause
+// is on the primary key when the table has one.
+func (PostgresDialect) SingleRowSuffix() string {
+	return ""
+}
+
+// JSONEquals compares as jsonb, json has no equality operator.
+func (d PostgresDialect) JSONEquals(column string) string {
+	return fmt.Sprintf("(cast(%s as jsonb) = cast(? as jsonb))", d.QuoteIdent(column))
+}
+
+func (PostgresDialect) BinaryLiteral(value interface{}, columnType string) string {
+	var raw []byte
+	switch v := value.(type) {
+	case []byte:
+		raw = v
+	case string:
+		raw = []byte(v)
+	default:
+		raw = []byte(fmt.Sprintf("%v", v))
+	}
+	return fmt.Sprintf(`'\x%s'::bytea`, hex.EncodeToString(raw))
+}
+
+// Rebind numbers the placeholders as $1, $2, ...
+func (PostgresDialect) Rebind(query string) string {
+	var buf bytes.Buffer
+	n := 0
+	last := 0
+	forEachPlaceholder(query, func(i int) {
+		n++
+		buf.WriteString(query[last:i])
+		fmt.Fprintf(&buf, "$%d", n)
+		last = i + 1
+	})
+	buf.WriteString(query[last:])
+	return buf.String()
+}
+
+// dialectOrDefault returns d, or MySQLDialect if d is nil.
+func dialectOrDefault(d Dialect) Dialect {
+	if d == nil {
+		return MySQLDialect{}
+	}
+	return d
+}
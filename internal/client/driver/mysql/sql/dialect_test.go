@@ -0,0 +1,107 @@
+/*
+ * Copyright (C) 2016-2018. ActionTech.
+ * Based on: github.com/hashicorp/nomad, github.com/github/gh-ost .
+ * License: MPL version 2: https://www.mozilla.org/en-US/MPL/2.0 .
+ */
+
+package sql
+
+import (
+	"reflect"
+	"testing"
+
+	umconf "github.com/actiontech/dtle/internal/config/mysql"
+	test "github.com/outbrain/golib/tests"
+)
+
+func TestPostgresDialect(t *testing.T) {
+	d := PostgresDialect{}
+	test.S(t).ExpectEquals(d.QuoteIdent("my\"tbl"), `"my""tbl"`)
+	test.S(t).ExpectEquals(d.QuoteIdent("`tbl`"), `"tbl"`)
+	test.S(t).ExpectEquals(d.RangeHint(&umconf.IndexHint{Index: "PRIMARY"}), "")
+	test.S(t).ExpectEquals(d.TimezoneConvert("?", "+08:00", "UTC"), `((? at time zone interval '+08:00') at time zone 'UTC')`)
+	test.S(t).ExpectEquals(d.BinaryLiteral([]byte{0x00, 'a'}, "binary(2)"), `'\x0061'::bytea`)
+	test.S(t).ExpectEquals(d.Rebind("select '?', ? from t where a = ?"), "select '?', $1 from t where a = $2")
+
+	m := MySQLDialect{}
+	test.S(t).ExpectEquals(m.RangeHint(&umconf.IndexHint{Index: "PRIMARY"}), "force index (`PRIMARY`)")
+	test.S(t).ExpectEquals(m.TimezoneConvert("?", "+08:00", "+00:00"), "convert_tz(?, '+08:00', '+00:00')")
+	test.S(t).ExpectEquals(m.DatetimeCast("?", 3), "cast(? as datetime(3))")
+	test.S(t).ExpectEquals(d.DatetimeCast("?", 3), "cast(? as timestamp(3))")
+	test.S(t).ExpectEquals(m.Rebind("select ?"), "select ?")
+}
+
+func TestServerFlavor(t *testing.T) {
+	test.S(t).ExpectEquals(ParseServerFlavor("5.7.25-log"), FlavorMySQL)
+	test.S(t).ExpectEquals(ParseServerFlavor("8.0.19"), FlavorMySQL)
+	test.S(t).ExpectEquals(ParseServerFlavor("10.3.9-MariaDB-1:10.3.9+maria~bionic"), FlavorMariaDB)
+	test.S(t).ExpectEquals(ParseServerFlavor("5.5.5-10.4.12-MariaDB"), FlavorMariaDB)
+	test.S(t).ExpectEquals(ParseServerFlavor("5.7.25-TiDB-v4.0.0"), FlavorTiDB)
+
+	for version, want := range map[string]bool{
+		"5.7.25-log": false, "8.0.19": true, "10.1.44-MariaDB": false, "5.5.5-10.4.12-MariaDB": true,
+		"5.7.25-TiDB-v2.1.0": false, "5.7.25-TiDB-v4.0.0": true, "": false,
+	} {
+		test.S(t).ExpectEquals(SupportsWindowFunctions(version), want)
+	}
+
+	force := &umconf.IndexHint{Index: "PRIMARY"}
+	ignore := &umconf.IndexHint{Kind: umconf.IgnoreIndexHint, Index: "idx_a"}
+	tests := []struct {
+		flavor     ServerFlavor
+		wantForce  string
+		wantIgnore string
+		wantLock   string
+	}{
+		{FlavorMySQL, "force index (`PRIMARY`)", "ignore index (`idx_a`)", "lock in share mode"},
+		{FlavorMariaDB, "force index (`PRIMARY`)", "ignore index (`idx_a`)", "lock in share mode"},
+		{FlavorTiDB, "use index (`PRIMARY`)", "ignore index (`idx_a`)", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.flavor.String(), func(t *testing.T) {
+			test.S(t).ExpectEquals(tt.flavor.IndexHint(force), tt.wantForce)
+			test.S(t).ExpectEquals(tt.flavor.IndexHint(ignore), tt.wantIgnore)
+			test.S(t).ExpectEquals(tt.flavor.IndexHint(nil), "")
+			test.S(t).ExpectEquals(tt.flavor.SharedLockClause(), tt.wantLock)
+			test.S(t).ExpectEquals(MySQLDialect{Flavor: tt.flavor}.RangeHint(force), tt.wantForce)
+		})
+	}
+}
+
+func TestBuildDMLQueryPostgres(t *testing.T) {
+	d := PostgresDialect{}
+	databaseName := "mydb"
+	tableName := "tbl"
+	tableColumns := umconf.NewColumnList(umconf.NewColumns([]string{"id", "name"}))
+	tableColumns.Columns[0].Key = "PRI"
+	args := umconf.ToColumnValues([]interface{}{3, "testname"}).GetAbstractValues()
+	{
+		stmt, sharedArgs, err := BuildDMLInsertQueryAST(d, databaseName, tableName, tableColumns, tableColumns, tableColumns, args, InsertModeOnDuplicateUpdate)
+		test.S(t).ExpectNil(err)
+		ps := stmt.Prepared()
+		test.S(t).ExpectEquals(ps.SQL, `insert into "mydb"."tbl" ("id", "name") values ($1, $2) on conflict ("id") do update set "id"=excluded."id", "name"=excluded."name"`)
+		test.S(t).ExpectEquals(ps.Placeholders, 2)
+		test.S(t).ExpectTrue(reflect.DeepEqual(sharedArgs, []interface{}{3, "testname"}))
+	}
+	{
+		stmt, _, err := BuildDMLInsertQueryAST(d, databaseName, tableName, tableColumns, tableColumns, tableColumns, args, InsertModeIgnore)
+		test.S(t).ExpectNil(err)
+		test.S(t).ExpectEquals(stmt.String(), `insert into "mydb"."tbl" ("id", "name") values (?, ?) on conflict do nothing`)
+	}
+	{
+		stmt, sharedArgs, keyArgs, err := BuildDMLUpdateQueryAST(d, databaseName, tableName, tableColumns, tableColumns, tableColumns, tableColumns, args, args)
+		test.S(t).ExpectNil(err)
+		test.S(t).ExpectEquals(stmt.Prepared().SQL, `update "mydb"."tbl" set "id"=$1, "name"=$2 where (("id" = $3))`)
+		test.S(t).ExpectEquals(len(sharedArgs)+len(keyArgs), 3)
+	}
+	{
+		stmt, _, err := BuildDMLDeleteQueryAST(d, databaseName, tableName, tableColumns, args)
+		test.S(t).ExpectNil(err)
+		test.S(t).ExpectEquals(stmt.Prepared().SQL, `delete from "mydb"."tbl" where (("id" = $1))`)
+	}
+	{
+		noKey := umconf.NewColumnList(umconf.NewColumns([]string{"id", "name"}))
+		_, _, err := BuildDMLInsertQueryAST(d, databaseName, tableName, noKey, noKey, noKey, args, InsertModeReplace)
+		test.S(t).ExpectNotNil(err)
+	}
+}
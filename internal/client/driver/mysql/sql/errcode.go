@@ -925,3 +925,10 @@ func IgnoreExistsError(err error) bool {
 		return false
 	}
 }
+
+// IsKeyDoesNotExistError tells whether err is MySQL refusing an index hint
+// on an index the table doesn't have.
+func IsKeyDoesNotExistError(err error) bool {
+	mysqlErr, ok := err.(*mysql.MySQLError)
+	return ok && mysqlErr.Number == ErrKeyDoesNotExits
+}
@@ -0,0 +1,182 @@
+/*
+ * Copyright (C) 2016-2018. ActionTech.
+ * Based on: github.com/hashicorp/nomad, github.com/github/gh-ost .
+ * License: MPL version 2: https://www.mozilla.org/en-US/MPL/2.0 .
+ */
+
+package sql
+
+import (
+	"fmt"
+	"strings"
+
+	umconf "github.com/actiontech/dtle/internal/config/mysql"
+)
+
+// BuildShowCreateTable builds the query whose second column is the create
+// statement of the table.
+func BuildShowCreateTable(databaseName, tableName string) string {
+	return fmt.Sprintf("show create table %s", EscapeQualifiedName(databaseName, tableName))
+}
+
+// BuildCreateLikeTable builds the statement creating a staging table with the
+// definition of a table, indexes included, in the same database. Rows are
+// loaded into it and swapped in with BuildAtomicRename.
+func BuildCreateLikeTable(databaseName, stagingTableName, tableName string) string {
+	return fmt.Sprintf("create table %s like %s",
+		EscapeQualifiedName(databaseName, stagingTableName), EscapeQualifiedName(databaseName, tableName))
+}
+
+// BuildDisableKeys builds the statement that stops updating the non-unique
+// indexes of a table until BuildEnableKeys rebuilds them, to load it faster.
+// Only MyISAM tables support it; InnoDB ignores it with a warning.
+func BuildDisableKeys(databaseName, tableName string) string {
+	return fmt.Sprintf("alter table %s disable keys", EscapeQualifiedName(databaseName, tableName))
+}
+
+// BuildEnableKeys builds the statement that rebuilds the indexes disabled by
+// BuildDisableKeys.
+func BuildEnableKeys(databaseName, tableName string) string {
+	return fmt.Sprintf("alter table %s enable keys", EscapeQualifiedName(databaseName, tableName))
+}
+
+// TableRename renames a table, possibly into another database.
+type TableRename struct {
+	DatabaseName    string
+	TableName       string
+	NewDatabaseName string
+	NewTableName    string
+}
+
+// BuildAtomicRename builds the statement doing all renames at once, in order,
+// so that no session sees some done and others not. Swapping a loaded staging
+// table in keeps the old table:
+//
+//	rename table `db`.`a` to `db`.`a_old`, `db`.`a_new` to `db`.`a`
+func BuildAtomicRename(renames []TableRename) (string, error) {
+	if len(renames) == 0 {
+		return "", fmt.Errorf("Got 0 renames in BuildAtomicRename")
+	}
+	pairs := make([]string, len(renames))
+	for i, r := range renames {
+		pairs[i] = fmt.Sprintf("%s to %s",
+			EscapeQualifiedName(r.DatabaseName, r.TableName), EscapeQualifiedName(r.NewDatabaseName, r.NewTableName))
+	}
+	return fmt.Sprintf("rename table %s", strings.Join(pairs, ", ")), nil
+}
+
+// ColumnTypeChange is a column whose type differs between two tables.
+type ColumnTypeChange struct {
+	Name   string
+	Source string
+	Target string
+}
+
+// ColumnMove is a column at a different position in two tables, counting
+// only the columns both have.
+type ColumnMove struct {
+	Name   string
+	Source int
+	Target int
+}
+
+// SchemaDiff is how the columns of a target table differ from those of its
+// source. The builders pair values with columns by ordinal, so any of them
+// means rows would be written to the wrong columns or not at all.
+type SchemaDiff struct {
+	// Missing are the source columns the target lacks, Extra those of the
+	// target the source lacks, both in the order of their table.
+	Missing []string
+	Extra   []string
+	Changed []ColumnTypeChange
+	Moved   []ColumnMove
+}
+
+// Empty tells whether the tables have the same columns in the same order.
+func (d *SchemaDiff) Empty() bool {
+	return len(d.Missing) == 0 && len(d.Extra) == 0 && len(d.Changed) == 0 && len(d.Moved) == 0
+}
+
+func (d *SchemaDiff) String() string {
+	var parts []string
+	if len(d.Missing) > 0 {
+		parts = append(parts, fmt.Sprintf("missing %s", strings.Join(d.Missing, ", ")))
+	}
+	if len(d.Extra) > 0 {
+		parts = append(parts, fmt.Sprintf("extra %s", strings.Join(d.Extra, ", ")))
+	}
+	for _, c := range d.Changed {
+		parts = append(parts, fmt.Sprintf("%s is %s, was %s", c.Name, c.Target, c.Source))
+	}
+	for _, m := range d.Moved {
+		parts = append(parts, fmt.Sprintf("%s moved from %d to %d", m.Name, m.Source, m.Target))
+	}
+	return strings.Join(parts, "; ")
+}
+
+// DiffColumnLists compares the columns of a source and a target table by
+// name, type and ordinal. Types are compared by their full definition, such
+// as int(11) unsigned, if both columns have it, and else by kind. A column
+// missing from one table doesn't move the others: ordinals are compared
+// among the columns both tables have.
+func DiffColumnLists(source, target *umconf.ColumnList) *SchemaDiff {
+	diff := &SchemaDiff{}
+	var sourceShared, targetShared []string
+	for _, column := range source.ColumnList() {
+		if target.GetColumn(column.Name) == nil {
+			diff.Missing = append(diff.Missing, column.Name)
+		} else {
+			sourceShared = append(sourceShared, column.Name)
+		}
+	}
+	for _, column := range target.ColumnList() {
+		if source.GetColumn(column.Name) == nil {
+			diff.Extra = append(diff.Extra, column.Name)
+		} else {
+			targetShared = append(targetShared, column.Name)
+		}
+	}
+
+	targetOrdinals := make(map[string]int, len(targetShared))
+	for i, name := range targetShared {
+		targetOrdinals[name] = i
+	}
+	for i, name := range sourceShared {
+		s, t := source.GetColumn(name), target.GetColumn(name)
+		if sourceType, targetType := columnTypeName(s, t), columnTypeName(t, s); sourceType != targetType {
+			diff.Changed = append(diff.Changed, ColumnTypeChange{Name: name, Source: sourceType, Target: targetType})
+		}
+		if targetOrdinals[name] != i {
+			diff.Moved = append(diff.Moved, ColumnMove{Name: name, Source: i, Target: targetOrdinals[name]})
+		}
+	}
+	return diff
+}
+
+// SchemaMismatchError is a target table whose columns differ from those of
+// its source.
+type SchemaMismatchError struct {
+	Table string
+	Diff  *SchemaDiff
+}
+
+func (e *SchemaMismatchError) Error() string {
+	return fmt.Sprintf("columns of %s differ from its source: %s", e.Table, e.Diff)
+}
+
+// CheckColumnLists returns a SchemaMismatchError if the columns of the target
+// table differ from those of its source, see DiffColumnLists.
+func CheckColumnLists(databaseName, tableName string, source, target *umconf.ColumnList) error {
+	if diff := DiffColumnLists(source, target); !diff.Empty() {
+		return &SchemaMismatchError{Table: EscapeQualifiedName(databaseName, tableName), Diff: diff}
+	}
+	return nil
+}
+
+// columnTypeName returns the type of c to compare with that of other.
+func columnTypeName(c, other *umconf.Column) string {
+	if c.ColumnType != "" && other.ColumnType != "" {
+		return strings.ToLower(c.ColumnType)
+	}
+	return fmt.Sprintf("type %d", c.Type)
+}
@@ -0,0 +1,97 @@
+/*
+ * Copyright (C) 2016-2018. ActionTech.
+ * Based on: github.com/hashicorp/nomad, github.com/github/gh-ost .
+ * License: MPL version 2: https://www.mozilla.org/en-US/MPL/2.0 .
+ */
+
+package sql
+
+import (
+	"reflect"
+	"testing"
+
+	umconf "github.com/actiontech/dtle/internal/config/mysql"
+	test "github.com/outbrain/golib/tests"
+)
+
+func TestBuildShowCreateTable(t *testing.T) {
+	test.S(t).ExpectEquals(BuildShowCreateTable("mydb", "tbl"), "show create table `mydb`.`tbl`")
+}
+
+func TestBuildCreateLikeTable(t *testing.T) {
+	test.S(t).ExpectEquals(BuildCreateLikeTable("mydb", "tbl_new", "tbl"), "create table `mydb`.`tbl_new` like `mydb`.`tbl`")
+	test.S(t).ExpectEquals(BuildDisableKeys("mydb", "tbl_new"), "alter table `mydb`.`tbl_new` disable keys")
+	test.S(t).ExpectEquals(BuildEnableKeys("mydb", "tbl_new"), "alter table `mydb`.`tbl_new` enable keys")
+}
+
+func TestBuildAtomicRename(t *testing.T) {
+	{
+		statement, err := BuildAtomicRename([]TableRename{
+			{DatabaseName: "mydb", TableName: "tbl", NewDatabaseName: "mydb", NewTableName: "tbl_old"},
+			{DatabaseName: "mydb", TableName: "tbl_new", NewDatabaseName: "mydb", NewTableName: "tbl"},
+		})
+		test.S(t).ExpectNil(err)
+		test.S(t).ExpectEquals(statement, "rename table `mydb`.`tbl` to `mydb`.`tbl_old`, `mydb`.`tbl_new` to `mydb`.`tbl`")
+	}
+	{
+		statement, err := BuildAtomicRename([]TableRename{
+			{DatabaseName: "stg", TableName: "orders", NewDatabaseName: "prod", NewTableName: "orders"},
+		})
+		test.S(t).ExpectNil(err)
+		test.S(t).ExpectEquals(statement, "rename table `stg`.`orders` to `prod`.`orders`")
+	}
+	{
+		_, err := BuildAtomicRename(nil)
+		test.S(t).ExpectNotNil(err)
+	}
+}
+
+func newTypedColumnList(columns ...string) *umconf.ColumnList {
+	names := make([]string, len(columns)/2)
+	for i := range names {
+		names[i] = columns[2*i]
+	}
+	list := umconf.NewColumnList(umconf.NewColumns(names))
+	for i := range names {
+		list.Columns[i].ColumnType = columns[2*i+1]
+	}
+	return list
+}
+
+func TestDiffColumnLists(t *testing.T) {
+	source := newTypedColumnList("id", "int(11)", "name", "varchar(64)", "email", "varchar(255)", "created", "datetime")
+	same := newTypedColumnList("id", "INT(11)", "name", "varchar(64)", "email", "varchar(255)", "created", "datetime")
+	test.S(t).ExpectTrue(DiffColumnLists(source, same).Empty())
+
+	target := newTypedColumnList("id", "bigint(20)", "email", "varchar(255)", "name", "varchar(64)", "note", "text")
+	diff := DiffColumnLists(source, target)
+	test.S(t).ExpectFalse(diff.Empty())
+	test.S(t).ExpectTrue(reflect.DeepEqual(diff.Missing, []string{"created"}))
+	test.S(t).ExpectTrue(reflect.DeepEqual(diff.Extra, []string{"note"}))
+	test.S(t).ExpectTrue(reflect.DeepEqual(diff.Changed, []ColumnTypeChange{{Name: "id", Source: "int(11)", Target: "bigint(20)"}}))
+	test.S(t).ExpectTrue(reflect.DeepEqual(diff.Moved, []ColumnMove{{Name: "name", Source: 1, Target: 2}, {Name: "email", Source: 2, Target: 1}}))
+	test.S(t).ExpectEquals(diff.String(), "missing created; extra note; id is bigint(20), was int(11); name moved from 1 to 2; email moved from 2 to 1")
+
+	// A missing column alone doesn't move those after it.
+	diff = DiffColumnLists(source, newTypedColumnList("id", "int(11)", "email", "varchar(255)", "created", "datetime"))
+	test.S(t).ExpectTrue(reflect.DeepEqual(diff.Missing, []string{"name"}))
+	test.S(t).ExpectEquals(len(diff.Moved), 0)
+	test.S(t).ExpectEquals(len(diff.Changed), 0)
+
+	// Without full definitions, types are compared by kind.
+	kinds := umconf.NewColumnList(umconf.NewColumns([]string{"id"}))
+	other := umconf.NewColumnList(umconf.NewColumns([]string{"id"}))
+	other.SetColumnType("id", umconf.BigIntColumnType)
+	test.S(t).ExpectEquals(len(DiffColumnLists(kinds, other).Changed), 1)
+}
+
+func TestCheckColumnLists(t *testing.T) {
+	source := newTypedColumnList("id", "int(11)", "email", "varchar(255)")
+	test.S(t).ExpectNil(CheckColumnLists("mydb", "tbl", source, newTypedColumnList("id", "int(11)", "email", "varchar(255)")))
+
+	err := CheckColumnLists("mydb", "tbl", source, newTypedColumnList("id", "int(11)"))
+	mismatch, ok := err.(*SchemaMismatchError)
+	test.S(t).ExpectTrue(ok)
+	test.S(t).ExpectTrue(reflect.DeepEqual(mismatch.Diff.Missing, []string{"email"}))
+	test.S(t).ExpectEquals(err.Error(), "columns of `mydb`.`tbl` differ from its source: missing email")
+}
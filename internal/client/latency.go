@@ -0,0 +1,106 @@
+/*
+ * Copyright (C) 2016-2018. ActionTech.
+ * Based on: github.com/hashicorp/nomad, github.com/github/gh-ost .
+ * License: MPL version 2: https://www.mozilla.org/en-US/MPL/2.0 .
+ */
+
+package client
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+// maxChunkSamples bounds the chunk durations kept per stats window.
+const maxChunkSamples = 1024
+
+// latencyHistogram keeps the durations of the chunks a task executed within a
+// stats window, up to a bound past which the oldest are dropped, so that a
+// window of many fast chunks doesn't grow it without limit. It is used by the
+// stats collection only and so isn't locked.
+type latencyHistogram struct {
+	samples []time.Duration
+	next    int
+	full    bool
+}
+
+func newLatencyHistogram(size int) *latencyHistogram {
+	return &latencyHistogram{samples: make([]time.Duration, size)}
+}
+
+// Add records the durations of chunks.
+func (h *latencyHistogram) Add(durations ...time.Duration) {
+	for _, d := range durations {
+		h.samples[h.next] = d
+		h.next = (h.next + 1) % len(h.samples)
+		if h.next == 0 {
+			h.full = true
+		}
+	}
+}
+
+// Len returns the number of durations recorded.
+func (h *latencyHistogram) Len() int {
+	if h.full {
+		return len(h.samples)
+	}
+	return h.next
+}
+
+// Reset drops the durations recorded, to start a new window.
+func (h *latencyHistogram) Reset() {
+	h.next = 0
+	h.full = false
+}
+
+// Percentile returns the nearest-rank p-th percentile, p in (0, 1], of the
+// durations recorded, or 0 if there are none.
+func (h *latencyHistogram) Percentile(p float64) time.Duration {
+	n := h.Len()
+	if n == 0 {
+		return 0
+	}
+	sorted := append([]time.Duration(nil), h.samples[:n]...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	rank := int(math.Ceil(p*float64(n))) - 1
+	if rank < 0 {
+		rank = 0
+	}
+	return sorted[rank]
+}
+
+// Percentiles returns the chunk latency of the window, or nil if no chunk
+// was executed in it.
+func (h *latencyHistogram) Percentiles() *chunkLatency {
+	if h.Len() == 0 {
+		return nil
+	}
+	return &chunkLatency{
+		Chunks: h.Len(),
+		P50:    h.Percentile(0.50),
+		P95:    h.Percentile(0.95),
+		P99:    h.Percentile(0.99),
+	}
+}
+
+// chunkLatency is the distribution of chunk durations over a stats window.
+type chunkLatency struct {
+	Chunks        int
+	P50, P95, P99 time.Duration
+}
+
+// gauges returns the percentiles as gauges, none if l is nil.
+func (l *chunkLatency) gauges() []MetricsGauge {
+	if l == nil {
+		return nil
+	}
+	gauge := func(d time.Duration, name string) MetricsGauge {
+		return MetricsGauge{Name: []string{"chunk", name}, Value: float32(d.Seconds())}
+	}
+	return []MetricsGauge{
+		gauge(l.P50, "latency_p50_seconds"),
+		gauge(l.P95, "latency_p95_seconds"),
+		gauge(l.P99, "latency_p99_seconds"),
+	}
+}
@@ -0,0 +1,45 @@
+/*
+ * Copyright (C) 2016-2018. ActionTech.
+ * Based on: github.com/hashicorp/nomad, github.com/github/gh-ost .
+ * License: MPL version 2: https://www.mozilla.org/en-US/MPL/2.0 .
+ */
+
+package client
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLatencyHistogram(t *testing.T) {
+	h := newLatencyHistogram(maxChunkSamples)
+	if got := h.Percentiles(); got != nil {
+		t.Errorf("Percentiles() of no chunks = %+v, want nil", got)
+	}
+
+	// 1ms to 100ms, out of order
+	for i := 100; i >= 1; i-- {
+		h.Add(time.Duration(i) * time.Millisecond)
+	}
+	got := h.Percentiles()
+	want := &chunkLatency{Chunks: 100, P50: 50 * time.Millisecond, P95: 95 * time.Millisecond, P99: 99 * time.Millisecond}
+	if got == nil || *got != *want {
+		t.Errorf("Percentiles() = %+v, want %+v", got, want)
+	}
+	gauges := got.gauges()
+	if len(gauges) != 3 || gauges[2].Name[1] != "latency_p99_seconds" || gauges[2].Value != float32(0.099) {
+		t.Errorf("gauges() = %+v, want p50, p95 and p99 in seconds", gauges)
+	}
+
+	h.Reset()
+	if got := h.Percentiles(); got != nil {
+		t.Errorf("Percentiles() after Reset = %+v, want nil", got)
+	}
+
+	// A full histogram keeps the latest durations.
+	small := newLatencyHistogram(4)
+	small.Add(time.Hour, time.Hour, 1, 2, 3, 4)
+	if got := small.Percentiles(); got.Chunks != 4 || got.P99 != 4 {
+		t.Errorf("Percentiles() of a full histogram = %+v, want 4 chunks up to 4ns", got)
+	}
+}
@@ -0,0 +1,111 @@
+/*
+ * Copyright (C) 2016-2018. ActionTech.
+ * Based on: github.com/hashicorp/nomad, github.com/github/gh-ost .
+ * License: MPL version 2: https://www.mozilla.org/en-US/MPL/2.0 .
+ */
+
+package client
+
+import (
+	"fmt"
+
+	log "github.com/actiontech/dtle/internal/logger"
+)
+
+// Logger is a structured logger. fields are key-value pairs, such as
+// "alloc_id", id, "error", err.
+type Logger interface {
+	Debug(msg string, fields ...interface{})
+	Info(msg string, fields ...interface{})
+	Warn(msg string, fields ...interface{})
+	Error(msg string, fields ...interface{})
+}
+
+// NewLogger wraps the package logger as a Logger.
+func NewLogger(logger *log.Logger) Logger {
+	return &entryLogger{logger: logger}
+}
+
+// entryLogger writes to the package logger, one entry with fields per call.
+type entryLogger struct {
+	logger *log.Logger
+}
+
+func (l *entryLogger) Debug(msg string, fields ...interface{}) {
+	l.logger.WithFields(toLogFields(fields)).Debug(msg)
+}
+
+func (l *entryLogger) Info(msg string, fields ...interface{}) {
+	l.logger.WithFields(toLogFields(fields)).Info(msg)
+}
+
+func (l *entryLogger) Warn(msg string, fields ...interface{}) {
+	l.logger.WithFields(toLogFields(fields)).Warn(msg)
+}
+
+func (l *entryLogger) Error(msg string, fields ...interface{}) {
+	l.logger.WithFields(toLogFields(fields)).Error(msg)
+}
+
+// toLogFields pairs up key-value fields. A key without a value is kept under
+// "EXTRA_VALUE".
+func toLogFields(fields []interface{}) log.Fields {
+	lf := make(log.Fields, (len(fields)+1)/2)
+	for i := 0; i < len(fields); i += 2 {
+		if i+1 == len(fields) {
+			lf["EXTRA_VALUE"] = fields[i]
+			break
+		}
+		key, ok := fields[i].(string)
+		if !ok {
+			key = fmt.Sprint(fields[i])
+		}
+		lf[key] = fields[i+1]
+	}
+	return lf
+}
+
+// withFields returns a Logger adding fields to every call of logger.
+func withFields(logger Logger, fields ...interface{}) Logger {
+	if l, ok := logger.(*fieldLogger); ok {
+		return &fieldLogger{logger: l.logger, fields: append(append([]interface{}{}, l.fields...), fields...)}
+	}
+	return &fieldLogger{logger: logger, fields: fields}
+}
+
+type fieldLogger struct {
+	logger Logger
+	fields []interface{}
+}
+
+func (l *fieldLogger) with(fields []interface{}) []interface{} {
+	return append(append(make([]interface{}, 0, len(l.fields)+len(fields)), l.fields...), fields...)
+}
+
+func (l *fieldLogger) Debug(msg string, fields ...interface{}) {
+	l.logger.Debug(msg, l.with(fields)...)
+}
+
+func (l *fieldLogger) Info(msg string, fields ...interface{}) {
+	l.logger.Info(msg, l.with(fields)...)
+}
+
+func (l *fieldLogger) Warn(msg string, fields ...interface{}) {
+	l.logger.Warn(msg, l.with(fields)...)
+}
+
+func (l *fieldLogger) Error(msg string, fields ...interface{}) {
+	l.logger.Error(msg, l.with(fields)...)
+}
+
+// stdLogger returns the package logger behind logger, for the drivers which
+// still take one, or fallback if logger doesn't wrap one.
+func stdLogger(logger Logger, fallback *log.Logger) *log.Logger {
+	switch l := logger.(type) {
+	case *entryLogger:
+		return l.logger
+	case *fieldLogger:
+		return stdLogger(l.logger, fallback)
+	}
+	return fallback
+}
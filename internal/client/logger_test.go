@@ -0,0 +1,108 @@
+/*
+ * Copyright (C) 2016-2018. ActionTech.
+ * Based on: github.com/hashicorp/nomad, github.com/github/gh-ost .
+ * License: MPL version 2: https://www.mozilla.org/en-US/MPL/2.0 .
+ */
+
+package client
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/actiontech/dtle/internal/config"
+	log "github.com/actiontech/dtle/internal/logger"
+	"github.com/actiontech/dtle/internal/models"
+)
+
+// recordingLogger is a Logger keeping every entry in memory.
+type recordingLogger struct {
+	lock    sync.Mutex
+	entries []recordedEntry
+}
+
+type recordedEntry struct {
+	level  string
+	msg    string
+	fields log.Fields
+}
+
+func (l *recordingLogger) record(level, msg string, fields []interface{}) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.entries = append(l.entries, recordedEntry{level, msg, toLogFields(fields)})
+}
+
+func (l *recordingLogger) Debug(msg string, fields ...interface{}) { l.record("debug", msg, fields) }
+func (l *recordingLogger) Info(msg string, fields ...interface{})  { l.record("info", msg, fields) }
+func (l *recordingLogger) Warn(msg string, fields ...interface{})  { l.record("warn", msg, fields) }
+func (l *recordingLogger) Error(msg string, fields ...interface{}) { l.record("error", msg, fields) }
+
+func Test_toLogFields(t *testing.T) {
+	tests := []struct {
+		name   string
+		fields []interface{}
+		want   log.Fields
+	}{
+		{"empty", nil, log.Fields{}},
+		{"pairs", []interface{}{"a", 1, "b", "x"}, log.Fields{"a": 1, "b": "x"}},
+		{"odd", []interface{}{"a", 1, "b"}, log.Fields{"a": 1, "EXTRA_VALUE": "b"}},
+		{"non-string key", []interface{}{1, 2}, log.Fields{"1": 2}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := toLogFields(tt.fields); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("toLogFields() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewLogger(t *testing.T) {
+	var buf bytes.Buffer
+	logger := withFields(NewLogger(log.New(&buf, log.DebugLevel)), "alloc_id", "a1")
+	logger.Warn("agent: something happened", "error", errors.New("boom"))
+
+	out := buf.String()
+	for _, want := range []string{"agent: something happened", "[a1]", "[boom]"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("log output %q does not contain %q", out, want)
+		}
+	}
+}
+
+func TestWorker_logFields(t *testing.T) {
+	logger := &recordingLogger{}
+	task := &models.Task{Type: models.TaskTypeSrc}
+	alloc := &models.Allocation{ID: "alloc", JobID: "job", Task: task.Type, Job: &models.Job{Tasks: []*models.Task{task}}}
+	cfg := &config.ClientConfig{KillBackoffBaseline: time.Millisecond, KillFailureLimit: 1}
+	r := NewWorker(logger, cfg, nil, alloc, task, nil)
+	r.handle = &mockHandle{failShutdowns: 1}
+
+	if destroyed, _ := r.handleDestroy(); destroyed {
+		t.Fatalf("handleDestroy() succeeded with a failing handle")
+	}
+
+	want := log.Fields{
+		"alloc_id": "alloc",
+		"job":      "job",
+		"task":     models.TaskTypeSrc,
+		"backoff":  time.Millisecond,
+		"error":    errors.New("target busy"),
+	}
+	for _, e := range logger.entries {
+		if e.level == "error" && e.msg == "agent: Failed to kill task. Retrying" {
+			if fmt.Sprint(e.fields) != fmt.Sprint(want) {
+				t.Errorf("fields = %v, want %v", e.fields, want)
+			}
+			return
+		}
+	}
+	t.Errorf("no kill failure logged: %v", logger.entries)
+}
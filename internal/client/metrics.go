@@ -0,0 +1,294 @@
+/*
+ * Copyright (C) 2016-2018. ActionTech.
+ * Based on: github.com/hashicorp/nomad, github.com/github/gh-ost .
+ * License: MPL version 2: https://www.mozilla.org/en-US/MPL/2.0 .
+ */
+
+package client
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/armon/go-metrics"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/actiontech/dtle/internal/models"
+)
+
+// MetricsGauge is a single value of a MetricsSample.
+type MetricsGauge struct {
+	Name  []string
+	Value float32
+
+	// Counter marks a value which only grows, such as a total.
+	Counter bool
+	// Labels are emitted besides those of the sample, by sinks with free
+	// form labels such as go-metrics.
+	Labels map[string]string
+}
+
+// MetricsSample is the resource usage of a task at one point in time. The
+// job, alloc and task identify it and are emitted as labels.
+type MetricsSample struct {
+	Job    string
+	Alloc  string
+	Task   string
+	Gauges []MetricsGauge
+}
+
+// MetricsSink receives the samples emitted by workers.
+type MetricsSink interface {
+	EmitSample(sample *MetricsSample)
+}
+
+var (
+	metricsSinks     = []MetricsSink{goMetricsSink{}}
+	metricsSinksLock sync.RWMutex
+)
+
+// RegisterMetricsSink adds a sink every worker emits its samples to, besides
+// go-metrics.
+func RegisterMetricsSink(sink MetricsSink) {
+	metricsSinksLock.Lock()
+	defer metricsSinksLock.Unlock()
+	metricsSinks = append(metricsSinks, sink)
+}
+
+func getMetricsSinks() []MetricsSink {
+	metricsSinksLock.RLock()
+	defer metricsSinksLock.RUnlock()
+	return metricsSinks
+}
+
+// forgetMetrics tells the sinks which keep the latest sample of a task, such
+// as PrometheusSink, that the task is gone.
+func forgetMetrics(job, alloc, task string) {
+	for _, sink := range getMetricsSinks() {
+		if s, ok := sink.(interface {
+			Forget(job, alloc, task string)
+		}); ok {
+			s.Forget(job, alloc, task)
+		}
+	}
+}
+
+// newMetricsSample builds the sample of the given stats.
+func newMetricsSample(job, alloc, task string, ru *models.TaskStatistics) *MetricsSample {
+	gauge := func(value float32, name ...string) MetricsGauge {
+		return MetricsGauge{Name: name, Value: value}
+	}
+	sample := &MetricsSample{
+		Job:   job,
+		Alloc: alloc,
+		Task:  task,
+		Gauges: []MetricsGauge{
+			gauge(float32(ru.MsgStat.InMsgs), "network", "in_msgs"),
+			gauge(float32(ru.MsgStat.OutMsgs), "network", "out_msgs"),
+			gauge(float32(ru.MsgStat.InBytes), "network", "in_bytes"),
+			gauge(float32(ru.MsgStat.OutBytes), "network", "out_bytes"),
+			gauge(float32(ru.BufferStat.ExtractorTxQueueSize), "buffer", "src_queue_size"),
+			gauge(float32(ru.BufferStat.ApplierGroupTxQueueSize), "buffer", "dest_group_queue_size"),
+			gauge(float32(ru.BufferStat.ApplierTxQueueSize), "buffer", "dest_queue_size"),
+			gauge(float32(ru.BufferStat.SendByTimeout), "buffer", "send_by_timeout"),
+			gauge(float32(ru.BufferStat.SendBySizeFull), "buffer", "send_by_size_full"),
+		},
+	}
+	if ru.TableStats != nil {
+		sample.Gauges = append(sample.Gauges,
+			gauge(float32(ru.TableStats.InsertCount), "table", "insert"),
+			gauge(float32(ru.TableStats.UpdateCount), "table", "update"),
+			gauge(float32(ru.TableStats.DelCount), "table", "delete"))
+	}
+	if ru.DelayCount != nil {
+		sample.Gauges = append(sample.Gauges,
+			gauge(float32(ru.DelayCount.Num), "delay", "num"),
+			gauge(float32(ru.DelayCount.Time), "delay", "time"))
+	}
+	if ru.ThroughputStat != nil {
+		sample.Gauges = append(sample.Gauges,
+			gauge(float32(ru.ThroughputStat.Num), "throughput", "num"),
+			gauge(float32(ru.ThroughputStat.Time), "throughput", "time"))
+	}
+	return sample
+}
+
+// addRestartGauges adds the restarts of a task to sample: their total,
+// labeled by the reason of the last one, and the seconds since the last one
+// if there was one.
+func addRestartGauges(sample *MetricsSample, total int, last time.Time, reason string) {
+	g := MetricsGauge{Name: []string{"restart", "total"}, Value: float32(total), Counter: true}
+	if reason != "" {
+		g.Labels = map[string]string{"reason": reason}
+	}
+	sample.Gauges = append(sample.Gauges, g)
+	if !last.IsZero() {
+		sample.Gauges = append(sample.Gauges, MetricsGauge{
+			Name:  []string{"restart", "since_last_seconds"},
+			Value: float32(time.Since(last).Seconds()),
+		})
+	}
+}
+
+// addControlGauges adds to sample how long the latest Restart or Kill of a
+// task waited for its run loop to accept it, and the total of the restarts
+// dropped as its restart queue was full.
+func addControlGauges(sample *MetricsSample, wait time.Duration, dropped int) {
+	sample.Gauges = append(sample.Gauges,
+		MetricsGauge{Name: []string{"control", "accept_wait_seconds"}, Value: float32(wait.Seconds())},
+		MetricsGauge{Name: []string{"control", "restarts_dropped"}, Value: float32(dropped), Counter: true})
+}
+
+// completionGauge is the seconds a task which completed ran for.
+func completionGauge(elapsed time.Duration) MetricsGauge {
+	return MetricsGauge{Name: []string{"completed", "elapsed_seconds"}, Value: float32(elapsed.Seconds())}
+}
+
+// heartbeatGauges are the liveness of a task and, unless it has emitted no
+// sample yet, the seconds since its latest one.
+func heartbeatGauges(alive bool, lastSampleAt time.Time) []MetricsGauge {
+	liveness := MetricsGauge{Name: []string{"heartbeat", "liveness"}}
+	if alive {
+		liveness.Value = 1
+	}
+	gauges := []MetricsGauge{liveness}
+	if !lastSampleAt.IsZero() {
+		gauges = append(gauges, MetricsGauge{
+			Name:  []string{"heartbeat", "last_sample_age_seconds"},
+			Value: float32(time.Since(lastSampleAt).Seconds()),
+		})
+	}
+	return gauges
+}
+
+// withGauges returns a copy of sample with gauges added, leaving sample as it
+// is for sinks which keep it.
+func withGauges(sample *MetricsSample, gauges ...MetricsGauge) *MetricsSample {
+	copied := *sample
+	copied.Gauges = append(append([]MetricsGauge(nil), sample.Gauges...), gauges...)
+	return &copied
+}
+
+// goMetricsSink sets a go-metrics gauge per value.
+type goMetricsSink struct{}
+
+func (goMetricsSink) EmitSample(sample *MetricsSample) {
+	labels := []metrics.Label{
+		{Name: "job", Value: sample.Job},
+		{Name: "alloc", Value: sample.Alloc},
+		{Name: "task", Value: sample.Task},
+	}
+	for _, g := range sample.Gauges {
+		gaugeLabels := labels
+		if len(g.Labels) > 0 {
+			gaugeLabels = append([]metrics.Label{}, labels...)
+			for name, value := range g.Labels {
+				gaugeLabels = append(gaugeLabels, metrics.Label{Name: name, Value: value})
+			}
+		}
+		metrics.SetGaugeWithLabels(g.Name, g.Value, gaugeLabels)
+	}
+}
+
+// taskMetricNames are the names of all gauges in a MetricsSample.
+var taskMetricNames = [][]string{
+	{"network", "in_msgs"}, {"network", "out_msgs"}, {"network", "in_bytes"}, {"network", "out_bytes"},
+	{"buffer", "src_queue_size"}, {"buffer", "dest_group_queue_size"}, {"buffer", "dest_queue_size"},
+	{"buffer", "send_by_timeout"}, {"buffer", "send_by_size_full"},
+	{"table", "insert"}, {"table", "update"}, {"table", "delete"},
+	{"delay", "num"}, {"delay", "time"},
+	{"throughput", "num"}, {"throughput", "time"},
+}
+
+// restartMetricNames are the names of the gauges added by addRestartGauges.
+var restartMetricNames = [][]string{
+	{"restart", "total"}, {"restart", "since_last_seconds"},
+}
+
+// controlMetricNames are the names of the gauges added by addControlGauges.
+var controlMetricNames = [][]string{
+	{"control", "accept_wait_seconds"}, {"control", "restarts_dropped"},
+}
+
+// completionMetricNames are the names of the gauges of a completed task.
+var completionMetricNames = [][]string{
+	{"completed", "elapsed_seconds"},
+}
+
+// heartbeatMetricNames are the names of the gauges of heartbeatGauges.
+var heartbeatMetricNames = [][]string{
+	{"heartbeat", "liveness"}, {"heartbeat", "last_sample_age_seconds"},
+}
+
+// chunkMetricNames are the names of the gauges of chunkLatency.
+var chunkMetricNames = [][]string{
+	{"chunk", "latency_p50_seconds"}, {"chunk", "latency_p95_seconds"}, {"chunk", "latency_p99_seconds"},
+}
+
+// PrometheusSink is a prometheus.Collector exposing the latest sample of
+// every task, labeled by job, alloc and task. The Labels of a value are left
+// out, its metric has a fixed set of labels.
+type PrometheusSink struct {
+	descs map[string]*prometheus.Desc
+
+	lock    sync.Mutex
+	samples map[[3]string]*MetricsSample
+}
+
+// NewPrometheusSink returns a sink to register with both RegisterMetricsSink
+// and a prometheus registry.
+func NewPrometheusSink() *PrometheusSink {
+	s := &PrometheusSink{
+		descs:   make(map[string]*prometheus.Desc, len(taskMetricNames)),
+		samples: make(map[[3]string]*MetricsSample),
+	}
+	names := append(append([][]string{}, taskMetricNames...), restartMetricNames...)
+	names = append(names, controlMetricNames...)
+	names = append(names, completionMetricNames...)
+	names = append(names, heartbeatMetricNames...)
+	for _, name := range append(names, chunkMetricNames...) {
+		key := strings.Join(name, "_")
+		s.descs[key] = prometheus.NewDesc("dtle_task_"+key, "Task "+strings.Join(name, " ")+".",
+			[]string{"job", "alloc", "task"}, nil)
+	}
+	return s
+}
+
+func (s *PrometheusSink) EmitSample(sample *MetricsSample) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.samples[[3]string{sample.Job, sample.Alloc, sample.Task}] = sample
+}
+
+// Forget drops the sample of a task which is gone.
+func (s *PrometheusSink) Forget(job, alloc, task string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	delete(s.samples, [3]string{job, alloc, task})
+}
+
+func (s *PrometheusSink) Describe(ch chan<- *prometheus.Desc) {
+	for _, desc := range s.descs {
+		ch <- desc
+	}
+}
+
+func (s *PrometheusSink) Collect(ch chan<- prometheus.Metric) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for _, sample := range s.samples {
+		for _, g := range sample.Gauges {
+			desc, ok := s.descs[strings.Join(g.Name, "_")]
+			if !ok {
+				continue
+			}
+			valueType := prometheus.GaugeValue
+			if g.Counter {
+				valueType = prometheus.CounterValue
+			}
+			ch <- prometheus.MustNewConstMetric(desc, valueType, float64(g.Value),
+				sample.Job, sample.Alloc, sample.Task)
+		}
+	}
+}
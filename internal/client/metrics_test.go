@@ -0,0 +1,101 @@
+/*
+ * Copyright (C) 2016-2018. ActionTech.
+ * Based on: github.com/hashicorp/nomad, github.com/github/gh-ost .
+ * License: MPL version 2: https://www.mozilla.org/en-US/MPL/2.0 .
+ */
+
+package client
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/actiontech/dtle/internal/models"
+)
+
+func testTaskStatistics() *models.TaskStatistics {
+	ru := &models.TaskStatistics{
+		TableStats:     &models.TableStats{InsertCount: 10, UpdateCount: 20, DelCount: 30},
+		DelayCount:     &models.DelayCount{Num: 4, Time: 5},
+		ThroughputStat: &models.ThroughputStat{Num: 600, Time: 7},
+	}
+	ru.MsgStat.InMsgs = 1
+	ru.BufferStat.ExtractorTxQueueSize = 2
+	return ru
+}
+
+func Test_newMetricsSample(t *testing.T) {
+	sample := newMetricsSample("job", "alloc", "Src", testTaskStatistics())
+	if sample.Job != "job" || sample.Alloc != "alloc" || sample.Task != "Src" {
+		t.Errorf("labels = %v %v %v", sample.Job, sample.Alloc, sample.Task)
+	}
+
+	got := make(map[string]float32)
+	for _, g := range sample.Gauges {
+		got[strings.Join(g.Name, ".")] = g.Value
+	}
+	want := map[string]float32{
+		"network.in_msgs":       1,
+		"buffer.src_queue_size": 2,
+		"table.insert":          10,
+		"table.update":          20,
+		"table.delete":          30,
+		"delay.num":             4,
+		"delay.time":            5,
+		"throughput.num":        600,
+		"throughput.time":       7,
+	}
+	for name, value := range want {
+		if got[name] != value {
+			t.Errorf("%v = %v, want %v", name, got[name], value)
+		}
+	}
+	if len(got) != len(taskMetricNames) {
+		t.Errorf("sample has %v gauges, want %v", len(got), len(taskMetricNames))
+	}
+
+	// Stats a driver doesn't report are left out.
+	sample = newMetricsSample("job", "alloc", "Src", &models.TaskStatistics{})
+	for _, g := range sample.Gauges {
+		if g.Name[0] == "table" || g.Name[0] == "delay" || g.Name[0] == "throughput" {
+			t.Errorf("unexpected gauge %v", g.Name)
+		}
+	}
+}
+
+func TestPrometheusSink(t *testing.T) {
+	sink := NewPrometheusSink()
+	registry := prometheus.NewPedanticRegistry()
+	if err := registry.Register(sink); err != nil {
+		t.Fatalf("Register() = %v", err)
+	}
+	sink.EmitSample(newMetricsSample("job", "alloc", "Src", testTaskStatistics()))
+
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatalf("Gather() = %v", err)
+	}
+	values := make(map[string]float64)
+	for _, f := range families {
+		for _, m := range f.GetMetric() {
+			labels := make(map[string]string)
+			for _, l := range m.GetLabel() {
+				labels[l.GetName()] = l.GetValue()
+			}
+			if labels["job"] != "job" || labels["alloc"] != "alloc" || labels["task"] != "Src" {
+				t.Errorf("%v labels = %v", f.GetName(), labels)
+			}
+			values[f.GetName()] = m.GetGauge().GetValue()
+		}
+	}
+	if values["dtle_task_table_update"] != 20 || values["dtle_task_delay_time"] != 5 || values["dtle_task_throughput_num"] != 600 {
+		t.Errorf("values = %v", values)
+	}
+
+	sink.Forget("job", "alloc", "Src")
+	if families, _ := registry.Gather(); len(families) != 0 {
+		t.Errorf("Gather() after Forget = %v", families)
+	}
+}
@@ -0,0 +1,274 @@
+/*
+ * Copyright (C) 2016-2018. ActionTech.
+ * Based on: github.com/hashicorp/nomad, github.com/github/gh-ost .
+ * License: MPL version 2: https://www.mozilla.org/en-US/MPL/2.0 .
+ */
+
+package client
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+
+	"github.com/hashicorp/go-multierror"
+
+	"github.com/actiontech/dtle/internal/client/driver"
+	"github.com/actiontech/dtle/internal/models"
+)
+
+// parallelHandle drives the sub-copies of a task started with a Parallelism
+// above one as a single DriverHandle. Calls fan out to all of them, and their
+// stats are aggregated. A sub-copy failing shuts the others down, so that the
+// task restarts as a whole.
+type parallelHandle struct {
+	handles []driver.DriverHandle
+	waitCh  chan *models.WaitResult
+	// others is the number of sub-copies but the first still running.
+	others int32
+}
+
+func newParallelHandle(handles []driver.DriverHandle) *parallelHandle {
+	h := &parallelHandle{
+		handles: handles,
+		waitCh:  make(chan *models.WaitResult, 1),
+		others:  int32(len(handles) - 1),
+	}
+	go h.wait()
+	return h
+}
+
+// wait sends the result of the task once all sub-copies have exited: the
+// first failure, or success if none failed.
+func (h *parallelHandle) wait() {
+	results := make(chan *models.WaitResult, len(h.handles))
+	for i, handle := range h.handles {
+		go func(i int, handle driver.DriverHandle) {
+			res := <-handle.WaitCh()
+			if i > 0 {
+				atomic.AddInt32(&h.others, -1)
+			}
+			results <- res
+		}(i, handle)
+	}
+
+	var failure *models.WaitResult
+	for range h.handles {
+		res := <-results
+		if res != nil && !res.Successful() && failure == nil {
+			failure = res
+			h.Shutdown()
+		}
+	}
+	if failure == nil {
+		failure = models.NewWaitResult(0, nil)
+	}
+	h.waitCh <- failure
+}
+
+// ID returns the ID of the first sub-copy. Handle IDs are the driver context
+// the worker saves its state from, of which the sub-copies share all but the
+// key range.
+func (h *parallelHandle) ID() string {
+	return h.handles[0].ID()
+}
+
+func (h *parallelHandle) WaitCh() chan *models.WaitResult {
+	return h.waitCh
+}
+
+func (h *parallelHandle) Shutdown() error {
+	return h.each(driver.DriverHandle.Shutdown)
+}
+
+// Drain drains the sub-copies concurrently, so that it takes as long as the
+// slowest of them.
+func (h *parallelHandle) Drain() error {
+	var lock sync.Mutex
+	var mErr multierror.Error
+	var wg sync.WaitGroup
+	for _, handle := range h.handles {
+		wg.Add(1)
+		go func(handle driver.DriverHandle) {
+			defer wg.Done()
+			if err := handle.Drain(); err != nil {
+				lock.Lock()
+				multierror.Append(&mErr, err)
+				lock.Unlock()
+			}
+		}(handle)
+	}
+	wg.Wait()
+	return mErr.ErrorOrNil()
+}
+
+func (h *parallelHandle) Stats() (*models.TaskStatistics, error) {
+	stats := make([]*models.TaskStatistics, 0, len(h.handles))
+	for _, handle := range h.handles {
+		ru, err := handle.Stats()
+		if err != nil {
+			return nil, err
+		}
+		stats = append(stats, ru)
+	}
+	return aggregateTaskStatistics(stats), nil
+}
+
+func (h *parallelHandle) Pause() error {
+	return h.each(driver.DriverHandle.Pause)
+}
+
+func (h *parallelHandle) Resume() error {
+	return h.each(driver.DriverHandle.Resume)
+}
+
+// Signal forwards a signal to every sub-copy. It fails if they don't
+// support signals.
+func (h *parallelHandle) Signal(s os.Signal) error {
+	return h.each(func(handle driver.DriverHandle) error {
+		signaler, ok := handle.(driver.Signaler)
+		if !ok {
+			return fmt.Errorf("copy %s does not support signals", handle.ID())
+		}
+		return signaler.Signal(s)
+	})
+}
+
+func (h *parallelHandle) Throttle(factor float64) {
+	for _, handle := range h.handles {
+		handle.Throttle(factor)
+	}
+}
+
+// Checkpoint returns that of the first sub-copy, which streams the binlog,
+// once the others have exited. Until then it returns nil: the sub-copies are
+// at different positions, none of which the task as a whole can be started
+// from again.
+func (h *parallelHandle) Checkpoint() []byte {
+	if atomic.LoadInt32(&h.others) > 0 {
+		return nil
+	}
+	return h.handles[0].Checkpoint()
+}
+
+// PoolStats returns the pools of the sub-copies added up, or nil if none
+// has a pool.
+func (h *parallelHandle) PoolStats() *models.PoolStats {
+	var total *models.PoolStats
+	for _, handle := range h.handles {
+		pool := handle.PoolStats()
+		if pool == nil {
+			continue
+		}
+		if total == nil {
+			total = &models.PoolStats{}
+		}
+		total.MaxOpen += pool.MaxOpen
+		total.InUse += pool.InUse
+		total.Idle += pool.Idle
+	}
+	return total
+}
+
+// KeyBounds returns the bounds found by the sub-copies which are
+// driver.KeyBoundsCacher, merged.
+func (h *parallelHandle) KeyBounds() map[string]*models.KeyBounds {
+	var bounds map[string]*models.KeyBounds
+	for _, handle := range h.handles {
+		cacher, ok := handle.(driver.KeyBoundsCacher)
+		if !ok {
+			continue
+		}
+		for name, tableBounds := range cacher.KeyBounds() {
+			if bounds == nil {
+				bounds = make(map[string]*models.KeyBounds)
+			}
+			bounds[name] = tableBounds
+		}
+	}
+	return bounds
+}
+
+// each calls f on every sub-copy, returning the errors of all that failed.
+func (h *parallelHandle) each(f func(driver.DriverHandle) error) error {
+	var mErr multierror.Error
+	for _, handle := range h.handles {
+		if err := f(handle); err != nil {
+			multierror.Append(&mErr, err)
+		}
+	}
+	return mErr.ErrorOrNil()
+}
+
+// aggregateTaskStatistics combines the stats of sub-copies running side by
+// side. Counts are summed. Throughput sums the rows and takes the longest
+// time, so that its rate is that of the sub-copies together, and the delay
+// is that of the sub-copy furthest behind. The chunk durations of all are
+// kept, and their resource usage is summed. The position, progress and
+// stage are those of the first sub-copy reporting stats. Stats no sub-copy
+// reports stay nil.
+func aggregateTaskStatistics(stats []*models.TaskStatistics) *models.TaskStatistics {
+	total := &models.TaskStatistics{}
+	first := true
+	for _, ru := range stats {
+		if ru == nil {
+			continue
+		}
+		if first {
+			first = false
+			total.CurrentCoordinates = ru.CurrentCoordinates
+			total.ProgressPct = ru.ProgressPct
+			total.ETA = ru.ETA
+			total.Backlog = ru.Backlog
+			total.Stage = ru.Stage
+		}
+		if ru.TableStats != nil {
+			if total.TableStats == nil {
+				total.TableStats = &models.TableStats{}
+			}
+			total.TableStats.InsertCount += ru.TableStats.InsertCount
+			total.TableStats.UpdateCount += ru.TableStats.UpdateCount
+			total.TableStats.DelCount += ru.TableStats.DelCount
+		}
+		if ru.DelayCount != nil {
+			if total.DelayCount == nil {
+				total.DelayCount = &models.DelayCount{}
+			}
+			total.DelayCount.Num += ru.DelayCount.Num
+			if ru.DelayCount.Time > total.DelayCount.Time {
+				total.DelayCount.Time = ru.DelayCount.Time
+			}
+		}
+		if ru.ThroughputStat != nil {
+			if total.ThroughputStat == nil {
+				total.ThroughputStat = &models.ThroughputStat{}
+			}
+			total.ThroughputStat.Num += ru.ThroughputStat.Num
+			if ru.ThroughputStat.Time > total.ThroughputStat.Time {
+				total.ThroughputStat.Time = ru.ThroughputStat.Time
+			}
+		}
+		total.ExecMasterRowCount += ru.ExecMasterRowCount
+		total.ExecMasterTxCount += ru.ExecMasterTxCount
+		total.ReadMasterRowCount += ru.ReadMasterRowCount
+		total.ReadMasterTxCount += ru.ReadMasterTxCount
+		total.MsgStat.InMsgs += ru.MsgStat.InMsgs
+		total.MsgStat.OutMsgs += ru.MsgStat.OutMsgs
+		total.MsgStat.InBytes += ru.MsgStat.InBytes
+		total.MsgStat.OutBytes += ru.MsgStat.OutBytes
+		total.MsgStat.Reconnects += ru.MsgStat.Reconnects
+		total.BufferStat.ExtractorTxQueueSize += ru.BufferStat.ExtractorTxQueueSize
+		total.BufferStat.ApplierTxQueueSize += ru.BufferStat.ApplierTxQueueSize
+		total.BufferStat.ApplierGroupTxQueueSize += ru.BufferStat.ApplierGroupTxQueueSize
+		total.BufferStat.SendByTimeout += ru.BufferStat.SendByTimeout
+		total.BufferStat.SendBySizeFull += ru.BufferStat.SendBySizeFull
+		total.ChunkDurations = append(total.ChunkDurations, ru.ChunkDurations...)
+		total.MemoryBytes += ru.MemoryBytes
+		total.CPUPercent += ru.CPUPercent
+		if ru.Timestamp > total.Timestamp {
+			total.Timestamp = ru.Timestamp
+		}
+	}
+	return total
+}
@@ -0,0 +1,218 @@
+/*
+ * Copyright (C) 2016-2018. ActionTech.
+ * Based on: github.com/hashicorp/nomad, github.com/github/gh-ost .
+ * License: MPL version 2: https://www.mozilla.org/en-US/MPL/2.0 .
+ */
+
+package client
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/actiontech/dtle/internal/client/driver"
+	"github.com/actiontech/dtle/internal/config"
+	"github.com/actiontech/dtle/internal/models"
+)
+
+// rangeHandle is a sub-copy over the keys [from, to), inserting one row per
+// key.
+type rangeHandle struct {
+	*mockHandle
+	from, to int64
+}
+
+func (h *rangeHandle) Stats() (*models.TaskStatistics, error) {
+	rows := h.to - h.from
+	return &models.TaskStatistics{
+		TableStats:         &models.TableStats{InsertCount: rows},
+		ExecMasterRowCount: rows,
+		ThroughputStat:     &models.ThroughputStat{Num: uint64(rows), Time: uint64(rows / 10)},
+		DelayCount:         &models.DelayCount{Num: 1, Time: uint64(rows)},
+	}, nil
+}
+
+// splitDriver splits the keys [0, keys) of a task into equal ranges.
+type splitDriver struct {
+	keys int64
+
+	lock    sync.Mutex
+	handles []*rangeHandle
+}
+
+func (d *splitDriver) Split(ctx *driver.ExecContext, task *models.Task, n int) ([]*models.Task, error) {
+	tasks := make([]*models.Task, n)
+	for i := range tasks {
+		tasks[i] = task.Copy()
+		tasks[i].Config = map[string]interface{}{
+			"From": d.keys * int64(i) / int64(n),
+			"To":   d.keys * int64(i+1) / int64(n),
+		}
+	}
+	return tasks, nil
+}
+
+func (d *splitDriver) Start(ctx *driver.ExecContext, task *models.Task) (driver.DriverHandle, error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	h := &rangeHandle{mockHandle: newMockHandle(), from: task.Config["From"].(int64), to: task.Config["To"].(int64)}
+	d.handles = append(d.handles, h)
+	return h, nil
+}
+
+func (d *splitDriver) Validate(task *models.Task) (*models.TaskValidateResponse, error) {
+	return &models.TaskValidateResponse{}, nil
+}
+
+func TestWorker_parallel(t *testing.T) {
+	drv := &splitDriver{keys: 1000}
+	driver.BuiltinDrivers["split-test"] = func(*driver.DriverContext) driver.Driver { return drv }
+	defer delete(driver.BuiltinDrivers, "split-test")
+
+	task := models.NewTask()
+	task.Type = models.TaskTypeSrc
+	task.Driver = "split-test"
+	task.Config = map[string]interface{}{}
+	task.Parallelism = 2
+	alloc := &models.Allocation{ID: "alloc", Task: task.Type, Job: &models.Job{Tasks: []*models.Task{task}}}
+	updater := func(taskName, state string, event *models.TaskEvent) {}
+	r := NewWorker(&recordingLogger{}, &config.ClientConfig{}, updater, alloc, task, make(chan *models.TaskUpdate, 100))
+	go r.Run(context.Background())
+
+	deadline := time.After(5 * time.Second)
+	for !r.Health().Running {
+		select {
+		case <-deadline:
+			t.Fatal("task didn't start")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+
+	drv.lock.Lock()
+	handles := append([]*rangeHandle(nil), drv.handles...)
+	drv.lock.Unlock()
+	if len(handles) != 2 {
+		t.Fatalf("started %d copies, want 2", len(handles))
+	}
+	if handles[0].to != handles[1].from || handles[0].from != 0 || handles[1].to != 1000 {
+		t.Errorf("copies over [%d, %d) and [%d, %d), want them to split [0, 1000)",
+			handles[0].from, handles[0].to, handles[1].from, handles[1].to)
+	}
+
+	r.handleLock.Lock()
+	stats, err := r.handle.Stats()
+	r.handleLock.Unlock()
+	if err != nil {
+		t.Fatalf("Stats() failed: %v", err)
+	}
+	if stats.TableStats.InsertCount != 1000 || stats.ExecMasterRowCount != 1000 {
+		t.Errorf("aggregated %d inserts and %d rows, want 1000", stats.TableStats.InsertCount, stats.ExecMasterRowCount)
+	}
+	if got := *stats.ThroughputStat; got != (models.ThroughputStat{Num: 1000, Time: 50}) {
+		t.Errorf("aggregated throughput %+v, want 1000 rows in 50", got)
+	}
+	if got := *stats.DelayCount; got != (models.DelayCount{Num: 2, Time: 500}) {
+		t.Errorf("aggregated delay %+v, want the longest, 500", got)
+	}
+
+	r.Destroy(models.NewTaskEvent(models.TaskKilled))
+	select {
+	case <-r.WaitCh():
+	case <-time.After(5 * time.Second):
+		t.Fatal("task didn't finish")
+	}
+	for i, h := range handles {
+		h.lock.Lock()
+		if h.shutdowns != 1 {
+			t.Errorf("copy %d shut down %d times, want 1", i, h.shutdowns)
+		}
+		h.lock.Unlock()
+	}
+}
+
+func TestParallelHandle_failure(t *testing.T) {
+	handles := []*mockHandle{newMockHandle(), newMockHandle()}
+	h := newParallelHandle([]driver.DriverHandle{handles[0], handles[1]})
+
+	// One copy failing shuts down the other, and fails the task.
+	handles[0].waitCh <- models.NewWaitResult(1, fmt.Errorf("lost connection"))
+	select {
+	case res := <-h.WaitCh():
+		if res.Successful() || res.Err == nil || res.Err.Error() != "lost connection" {
+			t.Errorf("got %+v, want the failure of the copy", res)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("handle didn't finish")
+	}
+	handles[1].lock.Lock()
+	defer handles[1].lock.Unlock()
+	if handles[1].shutdowns != 1 {
+		t.Errorf("other copy shut down %d times, want 1", handles[1].shutdowns)
+	}
+}
+
+func TestParallelHandle_Checkpoint(t *testing.T) {
+	handles := []*mockHandle{newMockHandle(), newMockHandle(), newMockHandle()}
+	handles[0].checkpoint = []byte("uuid:1-10")
+	handles[1].checkpoint = []byte("uuid:1-5")
+	h := newParallelHandle([]driver.DriverHandle{handles[0], handles[1], handles[2]})
+	defer h.Shutdown()
+
+	// The copies are at different positions until the others than the
+	// first, which streams the binlog, are done.
+	if got := h.Checkpoint(); got != nil {
+		t.Errorf("Checkpoint() = %q while copying, want none", got)
+	}
+	handles[1].waitCh <- models.NewWaitResult(0, nil)
+	handles[2].waitCh <- models.NewWaitResult(0, nil)
+	deadline := time.Now().Add(5 * time.Second)
+	for string(h.Checkpoint()) != "uuid:1-10" {
+		if time.Now().After(deadline) {
+			t.Fatalf("Checkpoint() = %q after the copies, want that of the first", h.Checkpoint())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestAggregateTaskStatistics_firstReporting(t *testing.T) {
+	// The first sub-copy reporting no stats doesn't lose the position of
+	// the others.
+	total := aggregateTaskStatistics([]*models.TaskStatistics{
+		nil,
+		{Stage: models.StageSendingData, ETA: "1m", ProgressPct: "50.0", ExecMasterRowCount: 10},
+		{Stage: models.StageSearchingRowsForUpdate, ETA: "2m", ExecMasterRowCount: 5},
+	})
+	if total.Stage != models.StageSendingData || total.ETA != "1m" || total.ProgressPct != "50.0" {
+		t.Errorf("got stage %q, ETA %q and progress %q, want those of the second copy", total.Stage, total.ETA, total.ProgressPct)
+	}
+	if total.ExecMasterRowCount != 15 {
+		t.Errorf("aggregated %d rows, want 15", total.ExecMasterRowCount)
+	}
+}
+
+func TestParallelHandle_KeyBounds(t *testing.T) {
+	first := &boundsHandle{mockHandle: newMockHandle(), bounds: map[string]*models.KeyBounds{
+		"`db`.`a`": {Min: []string{"1"}, Max: []string{"100"}},
+	}}
+	second := &boundsHandle{mockHandle: newMockHandle(), bounds: map[string]*models.KeyBounds{
+		"`db`.`b`": {Min: []string{"5"}, Max: []string{"50"}},
+	}}
+	h := newParallelHandle([]driver.DriverHandle{first, newMockHandle(), second})
+	defer h.Shutdown()
+
+	// The bounds found by the copies are saved together.
+	want := map[string]*models.KeyBounds{
+		"`db`.`a`": first.bounds["`db`.`a`"],
+		"`db`.`b`": second.bounds["`db`.`b`"],
+	}
+	if got := h.KeyBounds(); !reflect.DeepEqual(got, want) {
+		t.Errorf("KeyBounds() = %v, want %v", got, want)
+	}
+	if got := newParallelHandle([]driver.DriverHandle{newMockHandle()}).KeyBounds(); got != nil {
+		t.Errorf("KeyBounds() = %v, want none of a copy finding none", got)
+	}
+}
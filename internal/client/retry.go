@@ -0,0 +1,81 @@
+/*
+ * Copyright (C) 2016-2018. ActionTech.
+ * Based on: github.com/hashicorp/nomad, github.com/github/gh-ost .
+ * License: MPL version 2: https://www.mozilla.org/en-US/MPL/2.0 .
+ */
+
+package client
+
+import (
+	sqldriver "database/sql/driver"
+	"errors"
+	"io"
+	"net"
+	"net/rpc"
+
+	"github.com/go-sql-driver/mysql"
+
+	usql "github.com/actiontech/dtle/internal/client/driver/mysql/sql"
+	"github.com/actiontech/dtle/internal/models"
+)
+
+// IsRetryable tells whether err is transient, such as a deadlock or a dropped
+// connection, so that the failed operation may succeed if tried again. Other
+// errors, such as a duplicate key or an unknown column, fail again the same
+// way. Errors marked with models.NewRecoverableError are classified as marked.
+func IsRetryable(err error) bool {
+	if err == nil {
+		return false
+	}
+	var recoverable models.Recoverable
+	if errors.As(err, &recoverable) {
+		return recoverable.IsRecoverable()
+	}
+
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) {
+		switch mysqlErr.Number {
+		case usql.ErrLockDeadlock, usql.ErrLockWaitTimeout, usql.ErrQueryInterrupted,
+			usql.ErrConCount, usql.ErrServerShutdown,
+			usql.ErrNetRead, usql.ErrNetReadInterrupted, usql.ErrNetErrorOnWrite, usql.ErrNetWriteInterrupted:
+			return true
+		default:
+			return false
+		}
+	}
+
+	switch {
+	case errors.Is(err, mysql.ErrInvalidConn), errors.Is(err, sqldriver.ErrBadConn),
+		errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		return true
+	// A plugin's connection shutting down, such as while the agent stops.
+	// The error crosses the plugin boundary as text.
+	case errors.Is(err, rpc.ErrShutdown), err.Error() == rpc.ErrShutdown.Error():
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+// IsSchemaError tells whether err is the target schema not matching what a
+// task writes, such as an unknown table or column, which no restart fixes
+// until the schema is changed. Errors it doesn't know are not.
+func IsSchemaError(err error) bool {
+	if err == nil {
+		return false
+	}
+	var mismatch *usql.SchemaMismatchError
+	var argMismatch *usql.ColumnArgMismatchError
+	if errors.As(err, &mismatch) || errors.As(err, &argMismatch) {
+		return true
+	}
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) {
+		switch mysqlErr.Number {
+		case usql.ErrBadDB, usql.ErrBadTable, usql.ErrNoSuchTable, usql.ErrBadField,
+			usql.ErrWrongValueCount, usql.ErrWrongValueCountOnRow, usql.ErrFieldSpecifiedTwice, usql.ErrBadNull:
+			return true
+		}
+	}
+	return false
+}
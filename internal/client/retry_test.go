@@ -0,0 +1,81 @@
+/*
+ * Copyright (C) 2016-2018. ActionTech.
+ * Based on: github.com/hashicorp/nomad, github.com/github/gh-ost .
+ * License: MPL version 2: https://www.mozilla.org/en-US/MPL/2.0 .
+ */
+
+package client
+
+import (
+	sqldriver "database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/rpc"
+	"testing"
+
+	"github.com/go-sql-driver/mysql"
+
+	usql "github.com/actiontech/dtle/internal/client/driver/mysql/sql"
+	"github.com/actiontech/dtle/internal/models"
+)
+
+func TestIsRetryable(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"nil", nil, false},
+		{"deadlock", &mysql.MySQLError{Number: 1213, Message: "Deadlock found when trying to get lock"}, true},
+		{"lock wait timeout", &mysql.MySQLError{Number: 1205, Message: "Lock wait timeout exceeded"}, true},
+		{"too many connections", &mysql.MySQLError{Number: 1040, Message: "Too many connections"}, true},
+		{"wrapped deadlock", fmt.Errorf("apply chunk: %w", &mysql.MySQLError{Number: 1213}), true},
+		{"duplicate key", &mysql.MySQLError{Number: 1062, Message: "Duplicate entry '1' for key 'PRIMARY'"}, false},
+		{"unknown column", &mysql.MySQLError{Number: 1054, Message: "Unknown column 'x' in 'field list'"}, false},
+		{"invalid connection", mysql.ErrInvalidConn, true},
+		{"bad connection", sqldriver.ErrBadConn, true},
+		{"unexpected eof", io.ErrUnexpectedEOF, true},
+		{"network", &net.OpError{Op: "read", Net: "tcp", Err: errors.New("connection reset by peer")}, true},
+		{"plugin shut down", rpc.ErrShutdown, true},
+		{"plugin shut down across rpc", errors.New("connection is shut down"), true},
+		{"recoverable", models.NewRecoverableError(errors.New("target busy"), true), true},
+		{"unrecoverable", models.NewRecoverableError(&mysql.MySQLError{Number: 1213}, false), false},
+		{"other", errors.New("bad config"), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsRetryable(tt.err); got != tt.want {
+				t.Errorf("IsRetryable(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsSchemaError(t *testing.T) {
+	diff := &usql.SchemaDiff{Missing: []string{"email"}}
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"nil", nil, false},
+		{"unknown column", &mysql.MySQLError{Number: 1054, Message: "Unknown column 'x' in 'field list'"}, true},
+		{"no such table", &mysql.MySQLError{Number: 1146, Message: "Table 'db.t' doesn't exist"}, true},
+		{"wrapped unknown database", fmt.Errorf("open target: %w", &mysql.MySQLError{Number: 1049}), true},
+		{"column count", &mysql.MySQLError{Number: 1136, Message: "Column count doesn't match value count at row 1"}, true},
+		{"schema mismatch", fmt.Errorf("check target: %w", &usql.SchemaMismatchError{Table: "`db`.`t`", Diff: diff}), true},
+		{"deadlock", &mysql.MySQLError{Number: 1213}, false},
+		{"duplicate key", &mysql.MySQLError{Number: 1062}, false},
+		{"connection lost", io.EOF, false},
+		{"other", errors.New("bad config"), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsSchemaError(tt.err); got != tt.want {
+				t.Errorf("IsSchemaError(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
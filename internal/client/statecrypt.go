@@ -0,0 +1,109 @@
+/*
+ * Copyright (C) 2016-2018. ActionTech.
+ * Based on: github.com/hashicorp/nomad, github.com/github/gh-ost .
+ * License: MPL version 2: https://www.mozilla.org/en-US/MPL/2.0 .
+ */
+
+package client
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"os"
+
+	"github.com/actiontech/dtle/internal/config"
+)
+
+// stateKeyEnv is the environment variable holding the state encryption key
+// when the client config has none.
+const stateKeyEnv = "UDUP_STATE_KEY"
+
+// stateMagic starts an encrypted state file. It is followed by a byte naming
+// the cipher, the nonce and the sealed state.
+var stateMagic = []byte("UDUPSTATE")
+
+// stateCipherAESGCM is the cipher byte of AES-GCM with a 12 byte nonce.
+const stateCipherAESGCM byte = 1
+
+// stateKey returns the key state files are encrypted with, or nil if the
+// client has none configured. The key is base64 of 16, 24 or 32 bytes.
+func stateKey(cfg *config.ClientConfig) ([]byte, error) {
+	encoded := cfg.StateEncryptionKey
+	if encoded == "" {
+		encoded = os.Getenv(stateKeyEnv)
+	}
+	if encoded == "" {
+		return nil, nil
+	}
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode state encryption key: %v", err)
+	}
+	switch len(key) {
+	case 16, 24, 32:
+	default:
+		return nil, fmt.Errorf("state encryption key is %d bytes, want 16, 24 or 32", len(key))
+	}
+	return key, nil
+}
+
+func newStateAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// encryptState seals buf with key behind the encrypted state header.
+func encryptState(buf, key []byte) ([]byte, error) {
+	aead, err := newStateAEAD(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encrypt state: %v", err)
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("failed to encrypt state: %v", err)
+	}
+
+	out := make([]byte, 0, len(stateMagic)+1+len(nonce)+len(buf)+aead.Overhead())
+	out = append(out, stateMagic...)
+	out = append(out, stateCipherAESGCM)
+	out = append(out, nonce...)
+	return aead.Seal(out, nonce, buf, stateMagic), nil
+}
+
+// decryptState opens a state file read from disk. A file without the
+// encrypted state header is plaintext and returned as is, so state saved
+// before a key was configured still restores.
+func decryptState(buf, key []byte) ([]byte, error) {
+	if !bytes.HasPrefix(buf, stateMagic) {
+		return buf, nil
+	}
+	if key == nil {
+		return nil, fmt.Errorf("state is encrypted but no state encryption key is configured")
+	}
+	buf = buf[len(stateMagic):]
+	if len(buf) == 0 || buf[0] != stateCipherAESGCM {
+		return nil, fmt.Errorf("state is encrypted with an unknown cipher")
+	}
+	buf = buf[1:]
+
+	aead, err := newStateAEAD(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt state: %v", err)
+	}
+	if len(buf) < aead.NonceSize() {
+		return nil, fmt.Errorf("failed to decrypt state: truncated")
+	}
+	nonce, sealed := buf[:aead.NonceSize()], buf[aead.NonceSize():]
+	plain, err := aead.Open(nil, nonce, sealed, stateMagic)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt state: %v", err)
+	}
+	return plain, nil
+}
@@ -0,0 +1,117 @@
+/*
+ * Copyright (C) 2016-2018. ActionTech.
+ * Based on: github.com/hashicorp/nomad, github.com/github/gh-ost .
+ * License: MPL version 2: https://www.mozilla.org/en-US/MPL/2.0 .
+ */
+
+package client
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/actiontech/dtle/internal/config"
+)
+
+func newStateTestPath(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "dtle-state")
+	if err != nil {
+		t.Fatalf("TempDir() error = %v", err)
+	}
+	return filepath.Join(dir, "store.json"), func() { os.RemoveAll(dir) }
+}
+
+func TestState_encryptedRoundTrip(t *testing.T) {
+	path, cleanup := newStateTestPath(t)
+	defer cleanup()
+	key := bytes.Repeat([]byte{7}, 32)
+
+	saved := workerState{Schema: workerStateSchema, Checkpoint: []byte("user:secret@tcp(db)")}
+	if err := persistState(path, &saved, false, key); err != nil {
+		t.Fatalf("persistState() error = %v", err)
+	}
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile() error = %v", err)
+	}
+	if !bytes.HasPrefix(buf, stateMagic) || bytes.Contains(buf, []byte("secret")) {
+		t.Errorf("state file is not encrypted: %q", buf)
+	}
+
+	var got workerState
+	if err := restoreState(path, &got, key); err != nil {
+		t.Fatalf("restoreState() error = %v", err)
+	}
+	if string(got.Checkpoint) != string(saved.Checkpoint) {
+		t.Errorf("restoreState() checkpoint = %q, want %q", got.Checkpoint, saved.Checkpoint)
+	}
+}
+
+func TestState_wrongKey(t *testing.T) {
+	path, cleanup := newStateTestPath(t)
+	defer cleanup()
+
+	if err := persistState(path, &workerState{Schema: workerStateSchema}, false, bytes.Repeat([]byte{1}, 16)); err != nil {
+		t.Fatalf("persistState() error = %v", err)
+	}
+	var got workerState
+	if err := restoreState(path, &got, bytes.Repeat([]byte{2}, 16)); err == nil || !strings.Contains(err.Error(), "failed to decrypt state") {
+		t.Errorf("restoreState() with the wrong key error = %v", err)
+	}
+	if err := restoreState(path, &got, nil); err == nil || !strings.Contains(err.Error(), "no state encryption key") {
+		t.Errorf("restoreState() without a key error = %v", err)
+	}
+}
+
+func TestState_plaintextWithKey(t *testing.T) {
+	path, cleanup := newStateTestPath(t)
+	defer cleanup()
+	key := bytes.Repeat([]byte{7}, 24)
+
+	if err := persistState(path, &workerState{Checkpoint: []byte("uuid:1-5")}, false, nil); err != nil {
+		t.Fatalf("persistState() error = %v", err)
+	}
+	var got workerState
+	if err := restoreState(path, &got, key); err != nil {
+		t.Fatalf("restoreState() of plaintext state error = %v", err)
+	}
+	if string(got.Checkpoint) != "uuid:1-5" {
+		t.Errorf("restoreState() checkpoint = %q, want %q", got.Checkpoint, "uuid:1-5")
+	}
+}
+
+func Test_stateKey(t *testing.T) {
+	key := bytes.Repeat([]byte{3}, 32)
+	tests := []struct {
+		name    string
+		cfg     string
+		env     string
+		want    []byte
+		wantErr bool
+	}{
+		{"none", "", "", nil, false},
+		{"config", base64.StdEncoding.EncodeToString(key), "", key, false},
+		{"env", "", base64.StdEncoding.EncodeToString(key[:16]), key[:16], false},
+		{"config over env", base64.StdEncoding.EncodeToString(key), "not base64!", key, false},
+		{"not base64", "not base64!", "", nil, true},
+		{"bad length", base64.StdEncoding.EncodeToString(key[:10]), "", nil, true},
+	}
+	defer os.Unsetenv(stateKeyEnv)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			os.Setenv(stateKeyEnv, tt.env)
+			got, err := stateKey(&config.ClientConfig{StateEncryptionKey: tt.cfg})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("stateKey() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !bytes.Equal(got, tt.want) {
+				t.Errorf("stateKey() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,175 @@
+/*
+ * Copyright (C) 2016-2018. ActionTech.
+ * Based on: github.com/hashicorp/nomad, github.com/github/gh-ost .
+ * License: MPL version 2: https://www.mozilla.org/en-US/MPL/2.0 .
+ */
+
+package client
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/actiontech/dtle/internal/config"
+	"github.com/actiontech/dtle/internal/models"
+)
+
+const (
+	// throttleIncrease is added to the throttle factor for every stats sample
+	// within the allowed delay.
+	throttleIncrease = 0.1
+
+	// throttleDecrease multiplies the throttle factor for every stats sample
+	// over the allowed delay.
+	throttleDecrease = 0.5
+
+	// defaultThrottleFloor is the lowest throttle factor unless configured.
+	defaultThrottleFloor = 0.1
+
+	// defaultBackpressureRelease is the fraction of the backpressure
+	// threshold below which backpressure is released unless configured.
+	defaultBackpressureRelease = 0.75
+
+	// defaultBreachSamples is how many stats samples in a row a task may be
+	// over its resource limits before it is killed unless configured.
+	defaultBreachSamples = 3
+)
+
+// throttleController adapts the rate at which a task copies to the delay of
+// its target, using additive-increase/multiplicative-decrease. A factor of 1
+// is full speed.
+type throttleController struct {
+	maxDelay time.Duration
+	floor    float64
+	factor   float64
+}
+
+// newThrottleController returns a throttle controller for the client config,
+// or nil if throttling is disabled.
+func newThrottleController(cfg *config.ClientConfig) *throttleController {
+	if cfg == nil || cfg.ThrottleMaxDelay <= 0 {
+		return nil
+	}
+	floor := cfg.ThrottleFloor
+	if floor <= 0 || floor > 1 {
+		floor = defaultThrottleFloor
+	}
+	return &throttleController{
+		maxDelay: cfg.ThrottleMaxDelay,
+		floor:    floor,
+		factor:   1,
+	}
+}
+
+// Sample feeds the delay observed by the latest stats collection. It returns
+// the new factor and whether it changed.
+func (t *throttleController) Sample(delay time.Duration) (factor float64, changed bool) {
+	old := t.factor
+	if delay > t.maxDelay {
+		t.factor *= throttleDecrease
+		if t.factor < t.floor {
+			t.factor = t.floor
+		}
+	} else {
+		t.factor += throttleIncrease
+		if t.factor > 1 {
+			t.factor = 1
+		}
+	}
+	return t.factor, t.factor != old
+}
+
+// backpressureController holds a task back while the connection pool to its
+// target is nearly exhausted. It applies at the threshold and releases only
+// once the pool is below the release point, so that it doesn't flap.
+type backpressureController struct {
+	threshold float64
+	release   float64
+	applied   bool
+}
+
+// newBackpressureController returns a backpressure controller for the client
+// config, or nil if backpressure is disabled.
+func newBackpressureController(cfg *config.ClientConfig) *backpressureController {
+	if cfg == nil || cfg.BackpressureThreshold <= 0 {
+		return nil
+	}
+	threshold := cfg.BackpressureThreshold
+	if threshold > 1 {
+		threshold = 1
+	}
+	release := cfg.BackpressureRelease
+	if release <= 0 || release >= threshold {
+		release = threshold * defaultBackpressureRelease
+	}
+	return &backpressureController{threshold: threshold, release: release}
+}
+
+// Sample feeds the pool stats of the latest stats collection. It returns
+// whether backpressure is applied and whether that changed.
+func (b *backpressureController) Sample(pool *models.PoolStats) (applied, changed bool) {
+	old := b.applied
+	utilization := pool.Utilization()
+	if utilization >= b.threshold {
+		b.applied = true
+	} else if utilization < b.release {
+		b.applied = false
+	}
+	return b.applied, b.applied != old
+}
+
+// resourceLimiter holds a task to its ResourceLimits. It throttles the task
+// the way throttleController does while its usage is over a limit, and
+// tells when the usage has been over for BreachSamples in a row.
+type resourceLimiter struct {
+	limits   models.ResourceLimits
+	samples  int
+	breaches int
+	factor   float64
+}
+
+// newResourceLimiter returns a resource limiter for the limits of a task, or
+// nil if it has none.
+func newResourceLimiter(limits *models.ResourceLimits) *resourceLimiter {
+	if limits == nil || (limits.MemoryBytes == 0 && limits.CPUPercent <= 0) {
+		return nil
+	}
+	samples := limits.BreachSamples
+	if samples <= 0 {
+		samples = defaultBreachSamples
+	}
+	return &resourceLimiter{limits: *limits, samples: samples, factor: 1}
+}
+
+// Sample feeds the stats of the latest stats collection. It returns the new
+// factor and whether it changed, and the limit the usage is over, or "" if
+// it is within its limits.
+func (l *resourceLimiter) Sample(ru *models.TaskStatistics) (factor float64, changed bool, exceeded string) {
+	old := l.factor
+	switch {
+	case l.limits.MemoryBytes > 0 && ru.MemoryBytes > l.limits.MemoryBytes:
+		exceeded = fmt.Sprintf("memory %d bytes over %d", ru.MemoryBytes, l.limits.MemoryBytes)
+	case l.limits.CPUPercent > 0 && ru.CPUPercent > l.limits.CPUPercent:
+		exceeded = fmt.Sprintf("cpu %.0f%% over %.0f%%", ru.CPUPercent, l.limits.CPUPercent)
+	}
+	if exceeded != "" {
+		l.breaches++
+		l.factor *= throttleDecrease
+		if l.factor < defaultThrottleFloor {
+			l.factor = defaultThrottleFloor
+		}
+	} else {
+		l.breaches = 0
+		l.factor += throttleIncrease
+		if l.factor > 1 {
+			l.factor = 1
+		}
+	}
+	return l.factor, l.factor != old, exceeded
+}
+
+// Sustained tells whether the usage has been over a limit for BreachSamples
+// samples in a row.
+func (l *resourceLimiter) Sustained() bool {
+	return l.breaches >= l.samples
+}
@@ -0,0 +1,140 @@
+/*
+ * Copyright (C) 2016-2018. ActionTech.
+ * Based on: github.com/hashicorp/nomad, github.com/github/gh-ost .
+ * License: MPL version 2: https://www.mozilla.org/en-US/MPL/2.0 .
+ */
+
+package client
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/actiontech/dtle/internal/config"
+	"github.com/actiontech/dtle/internal/models"
+)
+
+func Test_newThrottleController(t *testing.T) {
+	tests := []struct {
+		name      string
+		cfg       *config.ClientConfig
+		wantNil   bool
+		wantFloor float64
+	}{
+		{"nil config", nil, true, 0},
+		{"disabled", &config.ClientConfig{}, true, 0},
+		{"default floor", &config.ClientConfig{ThrottleMaxDelay: time.Second}, false, defaultThrottleFloor},
+		{"invalid floor", &config.ClientConfig{ThrottleMaxDelay: time.Second, ThrottleFloor: 2}, false, defaultThrottleFloor},
+		{"floor", &config.ClientConfig{ThrottleMaxDelay: time.Second, ThrottleFloor: 0.3}, false, 0.3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := newThrottleController(tt.cfg)
+			if (got == nil) != tt.wantNil {
+				t.Fatalf("newThrottleController() = %v, wantNil %v", got, tt.wantNil)
+			}
+			if got != nil && (got.floor != tt.wantFloor || got.factor != 1) {
+				t.Errorf("newThrottleController() floor = %v, factor = %v", got.floor, got.factor)
+			}
+		})
+	}
+}
+
+func TestThrottleController_Sample(t *testing.T) {
+	throttle := newThrottleController(&config.ClientConfig{
+		ThrottleMaxDelay: 10 * time.Second,
+		ThrottleFloor:    0.2,
+	})
+
+	samples := []struct {
+		delay       time.Duration
+		wantFactor  float64
+		wantChanged bool
+	}{
+		{5 * time.Second, 1, false},    // within the delay at full speed
+		{20 * time.Second, 0.5, true},  // multiplicative decrease
+		{30 * time.Second, 0.25, true}, // and again
+		{40 * time.Second, 0.2, true},  // clamped at the floor
+		{50 * time.Second, 0.2, false}, // never stalls
+		{10 * time.Second, 0.3, true},  // additive increase
+		{0, 0.4, true},
+		{0, 0.5, true},
+		{time.Minute, 0.25, true},
+		{0, 0.35, true},
+		{0, 0.45, true},
+		{0, 0.55, true},
+		{0, 0.65, true},
+		{0, 0.75, true},
+		{0, 0.85, true},
+		{0, 0.95, true},
+		{0, 1, true}, // capped at full speed
+		{0, 1, false},
+	}
+	for i, s := range samples {
+		factor, changed := throttle.Sample(s.delay)
+		if math.Abs(factor-s.wantFactor) > 1e-9 || changed != s.wantChanged {
+			t.Errorf("sample %d (%v): factor = %v, changed = %v, want %v, %v",
+				i, s.delay, factor, changed, s.wantFactor, s.wantChanged)
+		}
+	}
+}
+
+func TestBackpressureController_Sample(t *testing.T) {
+	if newBackpressureController(&config.ClientConfig{}) != nil {
+		t.Errorf("newBackpressureController() without a threshold is enabled")
+	}
+	backpressure := newBackpressureController(&config.ClientConfig{BackpressureThreshold: 0.8})
+	pool := func(inUse int) *models.PoolStats {
+		return &models.PoolStats{MaxOpen: 10, InUse: inUse}
+	}
+
+	samples := []struct {
+		pool        *models.PoolStats
+		wantApplied bool
+		wantChanged bool
+	}{
+		{pool(5), false, false},
+		{pool(8), true, true},   // at the threshold
+		{pool(10), true, false}, // exhausted
+		{pool(7), true, false},  // below the threshold, not yet drained
+		{pool(5), false, true},  // below three quarters of it
+		{pool(9), true, true},
+		{nil, false, true},                            // no pool is never exhausted
+		{&models.PoolStats{InUse: 100}, false, false}, // nor is an unlimited one
+	}
+	for i, s := range samples {
+		applied, changed := backpressure.Sample(s.pool)
+		if applied != s.wantApplied || changed != s.wantChanged {
+			t.Errorf("sample %d (%+v): applied = %v, changed = %v, want %v, %v",
+				i, s.pool, applied, changed, s.wantApplied, s.wantChanged)
+		}
+	}
+}
+
+func TestResourceLimiter_Sample(t *testing.T) {
+	if newResourceLimiter(nil) != nil || newResourceLimiter(&models.ResourceLimits{BreachSamples: 2}) != nil {
+		t.Errorf("newResourceLimiter() without limits is enabled")
+	}
+	limiter := newResourceLimiter(&models.ResourceLimits{MemoryBytes: 100, CPUPercent: 80, BreachSamples: 2})
+
+	samples := []struct {
+		ru            *models.TaskStatistics
+		wantFactor    float64
+		wantExceeded  string
+		wantSustained bool
+	}{
+		{&models.TaskStatistics{MemoryBytes: 50, CPUPercent: 10}, 1, "", false},
+		{&models.TaskStatistics{MemoryBytes: 150}, 0.5, "memory 150 bytes over 100", false},
+		{&models.TaskStatistics{MemoryBytes: 50}, 0.6, "", false}, // a spike
+		{&models.TaskStatistics{CPUPercent: 95}, 0.3, "cpu 95% over 80%", false},
+		{&models.TaskStatistics{CPUPercent: 90}, 0.15, "cpu 90% over 80%", true},
+	}
+	for i, s := range samples {
+		factor, _, exceeded := limiter.Sample(s.ru)
+		if math.Abs(factor-s.wantFactor) > 1e-9 || exceeded != s.wantExceeded || limiter.Sustained() != s.wantSustained {
+			t.Errorf("sample %d: factor = %v, exceeded = %q, sustained = %v, want %v, %q, %v",
+				i, factor, exceeded, limiter.Sustained(), s.wantFactor, s.wantExceeded, s.wantSustained)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+/*
+ * Copyright (C) 2016-2018. ActionTech.
+ * Based on: github.com/hashicorp/nomad, github.com/github/gh-ost .
+ * License: MPL version 2: https://www.mozilla.org/en-US/MPL/2.0 .
+ */
+
+package client
+
+// Tracer starts the spans of the lifecycle of tasks, such as to export them
+// as OpenTelemetry spans. attrs are key-value pairs, like the fields of
+// Logger.
+type Tracer interface {
+	StartSpan(name string, attrs ...interface{}) Span
+}
+
+// Span is one operation on a task, such as starting it, ended once it is
+// done.
+type Span interface {
+	SetAttributes(attrs ...interface{})
+	// RecordError marks the span as failed with err.
+	RecordError(err error)
+	End()
+}
+
+// The names of the spans of a worker.
+const (
+	spanStart   = "task.start"
+	spanRestart = "task.restart"
+	spanKill    = "task.kill"
+	spanDestroy = "task.destroy"
+)
+
+// noopSpan is the span of a worker without a tracer.
+type noopSpan struct{}
+
+func (noopSpan) SetAttributes(attrs ...interface{}) {}
+func (noopSpan) RecordError(err error)              {}
+func (noopSpan) End()                               {}
+
+// SetTracer makes the worker trace the lifecycle of its task. It must be
+// called before Run.
+func (r *Worker) SetTracer(tracer Tracer) {
+	r.tracer = tracer
+}
+
+// startSpan starts a span of the task, with its alloc, job and task as
+// attributes. It is a no-op if the worker has no tracer.
+func (r *Worker) startSpan(name string) Span {
+	if r.tracer == nil {
+		return noopSpan{}
+	}
+	return r.tracer.StartSpan(name, "alloc_id", r.alloc.ID, "job", r.alloc.JobID, "task", r.task.Type)
+}
+
+// endSpan ends a span, recording err on it unless it is nil.
+func endSpan(span Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+	}
+	span.End()
+}
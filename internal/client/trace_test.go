@@ -0,0 +1,132 @@
+/*
+ * Copyright (C) 2016-2018. ActionTech.
+ * Based on: github.com/hashicorp/nomad, github.com/github/gh-ost .
+ * License: MPL version 2: https://www.mozilla.org/en-US/MPL/2.0 .
+ */
+
+package client
+
+import (
+	"context"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/actiontech/dtle/internal/client/driver"
+	"github.com/actiontech/dtle/internal/config"
+	"github.com/actiontech/dtle/internal/models"
+)
+
+// recordedSpan is a span of a recordingTracer.
+type recordedSpan struct {
+	tracer *recordingTracer
+	name   string
+	attrs  map[string]interface{}
+	errs   []error
+	ended  bool
+}
+
+func (s *recordedSpan) SetAttributes(attrs ...interface{}) {
+	s.tracer.lock.Lock()
+	defer s.tracer.lock.Unlock()
+	for i := 0; i+1 < len(attrs); i += 2 {
+		s.attrs[attrs[i].(string)] = attrs[i+1]
+	}
+}
+
+func (s *recordedSpan) RecordError(err error) {
+	s.tracer.lock.Lock()
+	defer s.tracer.lock.Unlock()
+	s.errs = append(s.errs, err)
+}
+
+func (s *recordedSpan) End() {
+	s.tracer.lock.Lock()
+	defer s.tracer.lock.Unlock()
+	s.ended = true
+}
+
+// recordingTracer keeps the spans it starts, in order.
+type recordingTracer struct {
+	lock  sync.Mutex
+	spans []*recordedSpan
+}
+
+func (t *recordingTracer) StartSpan(name string, attrs ...interface{}) Span {
+	span := &recordedSpan{tracer: t, name: name, attrs: make(map[string]interface{})}
+	span.SetAttributes(attrs...)
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.spans = append(t.spans, span)
+	return span
+}
+
+// ended returns copies of the spans ended so far.
+func (t *recordingTracer) ended() []recordedSpan {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	var spans []recordedSpan
+	for _, span := range t.spans {
+		if span.ended {
+			spans = append(spans, *span)
+		}
+	}
+	return spans
+}
+
+func TestWorker_SetTracer(t *testing.T) {
+	driver.BuiltinDrivers["fail-test"] = func(*driver.DriverContext) driver.Driver { return &failDriver{err: io.EOF} }
+	defer delete(driver.BuiltinDrivers, "fail-test")
+
+	task := models.NewTask()
+	task.Type = models.TaskTypeSrc
+	task.Driver = "fail-test"
+	task.Config = map[string]interface{}{}
+	alloc := &models.Allocation{ID: "alloc", JobID: "job", Task: task.Type, Job: &models.Job{Tasks: []*models.Task{task}}}
+	updater := func(taskName, state string, event *models.TaskEvent) {}
+	r := NewWorker(&recordingLogger{}, &config.ClientConfig{}, updater, alloc, task, make(chan *models.TaskUpdate, 100))
+	tracer := &recordingTracer{}
+	r.SetTracer(tracer)
+	go r.Run(context.Background())
+
+	// The start fails, and the task restarts after a delay.
+	deadline := time.After(5 * time.Second)
+	for r.RestartInfo().State != models.TaskRestarting {
+		select {
+		case <-deadline:
+			t.Fatal("task didn't restart")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	r.Destroy(models.NewTaskEvent(models.TaskKilled))
+	select {
+	case <-r.WaitCh():
+	case <-time.After(5 * time.Second):
+		t.Fatal("task didn't finish")
+	}
+
+	spans := tracer.ended()
+	if len(spans) < 2 {
+		t.Fatalf("ended %d spans, want a start and a restart", len(spans))
+	}
+	start, restart := spans[0], spans[1]
+	if start.name != spanStart || len(start.errs) != 1 {
+		t.Errorf("first span %s with errors %v, want a failed %s", start.name, start.errs, spanStart)
+	}
+	for _, span := range spans {
+		if span.attrs["alloc_id"] != "alloc" || span.attrs["job"] != "job" || span.attrs["task"] != models.TaskTypeSrc {
+			t.Errorf("span %s has attributes %v, want those of the task", span.name, span.attrs)
+		}
+	}
+	if restart.name != spanRestart || restart.attrs["state"] != models.TaskRestarting || len(restart.errs) != 0 {
+		t.Errorf("second span %s with attributes %v, want a %s restarting", restart.name, restart.attrs, spanRestart)
+	}
+}
+
+func TestWorker_startSpan_noTracer(t *testing.T) {
+	r := &Worker{}
+	if _, ok := r.startSpan(spanStart).(noopSpan); !ok {
+		t.Errorf("startSpan() without a tracer isn't a no-op")
+	}
+}
@@ -18,17 +18,25 @@ const (
 	// jitter is the percent of jitter added to restart delays.
 	jitter = 0.25
 
+	// restartDelayFloor and restartDelayMax bound a restart delay after
+	// RestartJitter is applied to it. The max is the restart interval.
+	restartDelayFloor = 1 * time.Second
+	restartDelayMax   = 1 * time.Minute
+
 	ReasonUnrecoverableErrror = "Error was unrecoverable"
 	ReasonWithinPolicy        = "Restart within policy"
 	ReasonDelay               = "Exceeded allowed attempts, applying a delay"
 )
 
-func newRestartTracker() *RestartTracker {
+// newRestartTracker returns a tracker that forgets the failures of a task
+// once it has run for resetWindow, or never if it is zero.
+func newRestartTracker(resetWindow time.Duration) *RestartTracker {
 	onSuccess := true
 	return &RestartTracker{
-		startTime: time.Now(),
-		onSuccess: onSuccess,
-		rand:      rand.New(rand.NewSource(time.Now().Unix())),
+		startTime:   time.Now(),
+		onSuccess:   onSuccess,
+		resetWindow: resetWindow,
+		rand:        rand.New(rand.NewSource(time.Now().Unix())),
 	}
 }
 
@@ -40,6 +48,11 @@ type RestartTracker struct {
 	onSuccess        bool      // Whether to restart on successful exit code.
 	startTime        time.Time // When the interval began
 	reason           string    // The reason for the last store
+	total            int       // Restarts since the tracker was created
+	lastRestart      time.Time // When the last restart was returned
+	lastReason       string    // The reason of the last restart
+	resetWindow      time.Duration
+	runningSince     time.Time // When the task last started running
 	rand             *rand.Rand
 	lock             sync.Mutex
 }
@@ -70,6 +83,30 @@ func (r *RestartTracker) SetRestartTriggered() *RestartTracker {
 	return r
 }
 
+// SetRunning is used to mark when the task started running. If it then runs
+// for the reset window before exiting, the count of the interval is reset.
+func (r *RestartTracker) SetRunning(since time.Time) *RestartTracker {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.runningSince = since
+	return r
+}
+
+// Count returns the number of restarts in the current interval.
+func (r *RestartTracker) Count() int {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.count
+}
+
+// Restarts returns the number of restarts since the tracker was created, when
+// the last one was and its reason. last is zero if there was none.
+func (r *RestartTracker) Restarts() (total int, last time.Time, reason string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.total, r.lastRestart, r.lastReason
+}
+
 // GetReason returns a human-readable description for the last store returned by
 // GetState.
 func (r *RestartTracker) GetReason() string {
@@ -88,7 +125,7 @@ func (r *RestartTracker) GetReason() string {
 //
 // If TaskRestarting is returned, the duration is how long to wait until
 // starting the task again.
-func (r *RestartTracker) GetState() (string, time.Duration) {
+func (r *RestartTracker) GetState() (state string, wait time.Duration) {
 	r.lock.Lock()
 	defer r.lock.Unlock()
 
@@ -97,6 +134,18 @@ func (r *RestartTracker) GetState() (string, time.Duration) {
 		r.startErr = nil
 		r.waitRes = nil
 		r.restartTriggered = false
+		r.runningSince = time.Time{}
+	}()
+
+	defer func() {
+		if state == models.TaskRestarting {
+			r.total++
+			r.lastRestart = time.Now()
+			r.lastReason = r.reason
+			if r.lastReason == "" {
+				r.lastReason = models.TaskRestartSignal
+			}
+		}
 	}()
 
 	// Hot path if a restart was triggered
@@ -113,11 +162,18 @@ func (r *RestartTracker) GetState() (string, time.Duration) {
 		return models.TaskTerminated, 0
 	}
 
+	// Check if we have entered a new interval, or the task ran long enough
+	// for its earlier failures not to count.
+	now := time.Now()
+	stable := r.resetWindow > 0 && !r.runningSince.IsZero() && now.Sub(r.runningSince) >= r.resetWindow
+	if stable {
+		r.count = 0
+		r.startTime = now
+	}
+
 	r.count++
 
-	// Check if we have entered a new interval.
 	end := r.startTime.Add(1 * time.Minute)
-	now := time.Now()
 	if now.After(end) {
 		r.count = 0
 		r.startTime = now
@@ -189,3 +245,24 @@ func (r *RestartTracker) jitter() time.Duration {
 	j := float64(r.rand.Int63n(d)) * jitter
 	return time.Duration(d + int64(j))
 }
+
+// jitterDelay moves d randomly by up to frac of it in either direction,
+// keeping the result within restartDelayFloor and restartDelayMax. A zero
+// delay, an immediate restart, is left alone.
+func jitterDelay(d time.Duration, frac float64, rnd *rand.Rand) time.Duration {
+	if d <= 0 || frac <= 0 {
+		return d
+	}
+	if frac >= 1 {
+		frac = 0.99
+	}
+	j := time.Duration((rnd.Float64()*2 - 1) * frac * float64(d))
+	d += j
+	if d < restartDelayFloor {
+		d = restartDelayFloor
+	}
+	if d > restartDelayMax {
+		d = restartDelayMax
+	}
+	return d
+}
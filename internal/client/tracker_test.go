@@ -7,6 +7,7 @@
 package client
 
 import (
+	"errors"
 	"math/rand"
 	"reflect"
 	"sync"
@@ -24,7 +25,7 @@ func Test_newRestartTracker(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			if got := newRestartTracker(); !reflect.DeepEqual(got, tt.want) {
+			if got := newRestartTracker(0); !reflect.DeepEqual(got, tt.want) {
 				t.Errorf("newRestartTracker() = %v, want %v", got, tt.want)
 			}
 		})
@@ -404,3 +405,78 @@ func TestRestartTracker_jitter(t *testing.T) {
 		})
 	}
 }
+
+func Test_jitterDelay(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	base := 15 * time.Second
+	lo, hi := 12*time.Second, 18*time.Second
+	var below, above bool
+	for i := 0; i < 1000; i++ {
+		got := jitterDelay(base, 0.2, rnd)
+		if got < lo || got > hi {
+			t.Fatalf("jitterDelay(%v, 0.2) = %v, want within [%v, %v]", base, got, lo, hi)
+		}
+		below = below || got < 13500*time.Millisecond
+		above = above || got > 16500*time.Millisecond
+	}
+	if !below || !above {
+		t.Errorf("jitterDelay() did not spread over the band: below %v, above %v", below, above)
+	}
+
+	tests := []struct {
+		name string
+		d    time.Duration
+		frac float64
+		want time.Duration
+	}{
+		{"disabled", base, 0, base},
+		{"immediate restart", 0, 0.2, 0},
+		{"floor", 100 * time.Millisecond, 0.2, restartDelayFloor},
+		{"max", restartDelayMax, 0.99, -1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for i := 0; i < 100; i++ {
+				got := jitterDelay(tt.d, tt.frac, rnd)
+				if tt.want >= 0 && got != tt.want {
+					t.Fatalf("jitterDelay(%v, %v) = %v, want %v", tt.d, tt.frac, got, tt.want)
+				}
+				if got > restartDelayMax {
+					t.Fatalf("jitterDelay(%v, %v) = %v, above %v", tt.d, tt.frac, got, restartDelayMax)
+				}
+			}
+		})
+	}
+}
+
+func TestRestartTracker_resetWindow(t *testing.T) {
+	window := 10 * time.Minute
+	fail := models.NewWaitResult(1, errors.New("lost connection"))
+	tests := []struct {
+		name    string
+		running time.Duration
+		want    int
+	}{
+		{"failure within the window counts", time.Minute, 3},
+		{"failure after the window resets", window + time.Minute, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newRestartTracker(window)
+			r.SetWaitResult(fail).GetState()
+			r.SetWaitResult(fail).GetState()
+			r.SetRunning(time.Now().Add(-tt.running)).SetWaitResult(fail).GetState()
+			if got := r.Count(); got != tt.want {
+				t.Errorf("Count() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	// Without a window, running long doesn't reset the count.
+	r := newRestartTracker(0)
+	r.SetWaitResult(fail).GetState()
+	r.SetRunning(time.Now().Add(-time.Hour)).SetWaitResult(fail).GetState()
+	if got := r.Count(); got != 2 {
+		t.Errorf("Count() without a window = %v, want 2", got)
+	}
+}
@@ -75,22 +75,59 @@ func diffAllocs(existing []*models.Allocation, allocs *allocUpdates) *diffResult
 	return result
 }
 
-// persistState is used to help with saving state
-func persistState(path string, data interface{}) error {
+// restoreState is used to read back saved state. A temp file left behind by
+// a save interrupted by a crash is removed. A missing file leaves data
+// untouched. Encrypted state is decrypted with key, plaintext state is read
+// regardless of key.
+func restoreState(path string, data interface{}, key []byte) error {
+	if err := os.Remove(path + ".tmp"); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove stray tmp state: %v", err)
+	}
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to read state: %v", err)
+	}
+	if buf, err = decryptState(buf, key); err != nil {
+		return err
+	}
+	if err := json.Unmarshal(buf, data); err != nil {
+		return fmt.Errorf("failed to decode state: %v", err)
+	}
+	return nil
+}
+
+// persistState is used to help with saving state. The state is written to a
+// temp file and renamed over path, so readers never see a partial file. If
+// syncDir is set the directory is synced after the rename as well. A non-nil
+// key encrypts the state.
+func persistState(path string, data interface{}, syncDir bool, key []byte) error {
 	buf, err := json.Marshal(data)
 	if err != nil {
 		return fmt.Errorf("failed to encode state: %v", err)
 	}
+	if key != nil {
+		if buf, err = encryptState(buf, key); err != nil {
+			return err
+		}
+	}
 	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
 		return fmt.Errorf("failed to make dirs for %s: %v", path, err)
 	}
 	tmpPath := path + ".tmp"
-	if err := ioutil.WriteFile(tmpPath, buf, 0600); err != nil {
+	if err := writeFileSync(tmpPath, buf, 0600); err != nil {
 		return fmt.Errorf("failed to save state to tmp: %v", err)
 	}
 	if err := os.Rename(tmpPath, path); err != nil {
 		return fmt.Errorf("failed to rename tmp to path: %v", err)
 	}
+	if syncDir {
+		if err := syncPath(filepath.Dir(path)); err != nil {
+			return fmt.Errorf("failed to sync dir of %s: %v", path, err)
+		}
+	}
 
 	// Sanity check since users have reported empty state files on disk
 	if stat, err := os.Stat(path); err != nil {
@@ -100,3 +137,31 @@ func persistState(path string, data interface{}) error {
 	}
 	return nil
 }
+
+// writeFileSync is ioutil.WriteFile, except the data is synced to disk
+// before the file is closed.
+func writeFileSync(path string, data []byte, perm os.FileMode) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// syncPath fsyncs the file or directory at path.
+func syncPath(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return f.Sync()
+}
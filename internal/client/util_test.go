@@ -7,6 +7,9 @@
 package client
 
 import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"reflect"
 	"testing"
 	"github.com/actiontech/dtle/internal/models"
@@ -64,8 +67,10 @@ func Test_diffAllocs(t *testing.T) {
 
 func Test_persistState(t *testing.T) {
 	type args struct {
-		path string
-		data interface{}
+		path    string
+		data    interface{}
+		syncDir bool
+		key     []byte
 	}
 	tests := []struct {
 		name    string
@@ -76,9 +81,42 @@ func Test_persistState(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			if err := persistState(tt.args.path, tt.args.data); (err != nil) != tt.wantErr {
+			if err := persistState(tt.args.path, tt.args.data, tt.args.syncDir, tt.args.key); (err != nil) != tt.wantErr {
 				t.Errorf("persistState() error = %v, wantErr %v", err, tt.wantErr)
 			}
 		})
 	}
 }
+
+func Test_restoreState_strayTmp(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dtle-state")
+	if err != nil {
+		t.Fatalf("TempDir() error = %v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "store.json")
+
+	good := workerState{Schema: workerStateSchema, Checkpoint: []byte("uuid:1-10")}
+	if err := persistState(path, &good, true, nil); err != nil {
+		t.Fatalf("persistState() error = %v", err)
+	}
+	if _, err := os.Stat(path + ".tmp"); !os.IsNotExist(err) {
+		t.Errorf("tmp file left after persistState(): %v", err)
+	}
+
+	// A save that crashed halfway through writing the temp file.
+	if err := ioutil.WriteFile(path+".tmp", []byte(`{"Schema":1,"Chec`), 0600); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+
+	var got workerState
+	if err := restoreState(path, &got, nil); err != nil {
+		t.Fatalf("restoreState() error = %v", err)
+	}
+	if string(got.Checkpoint) != "uuid:1-10" {
+		t.Errorf("restoreState() checkpoint = %q, want %q", got.Checkpoint, "uuid:1-10")
+	}
+	if _, err := os.Stat(path + ".tmp"); !os.IsNotExist(err) {
+		t.Errorf("stray tmp file not removed: %v", err)
+	}
+}
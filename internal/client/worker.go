@@ -7,24 +7,27 @@
 package client
 
 import (
+	"context"
 	"crypto/md5"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"hash/fnv"
+	"math"
+	"math/rand"
 	"os"
 	"path/filepath"
-	"strings"
 	"sync"
 	"time"
 
-	"github.com/armon/go-metrics"
-
 	"github.com/actiontech/dtle/internal/client/driver"
 	"github.com/actiontech/dtle/internal/config"
 	log "github.com/actiontech/dtle/internal/logger"
 	"github.com/actiontech/dtle/internal/models"
 )
 
+// The kill backoff defaults, used when the client config leaves them unset.
 const (
 	// killBackoffBaseline is the baseline time for exponential backoff while
 	// killing a task.
@@ -39,21 +42,79 @@ const (
 	killFailureLimit = 5
 )
 
+// defaultPostStopTimeout bounds the PostStopSQL of a task unless configured.
+const defaultPostStopTimeout = 30 * time.Second
+
+// defaultRecentEvents is how many events a worker keeps unless configured.
+const defaultRecentEvents = 10
+
+// maxRuntimeExceeded is the kill reason of a task running past its MaxRuntime.
+const maxRuntimeExceeded = "max runtime exceeded"
+
+// resourceLimitExceeded is the kill reason of a task staying over its
+// ResourceLimits.
+const resourceLimitExceeded = "resource limit exceeded"
+
+// errNilWaitResult fails a task whose driver delivered no wait result.
+var errNilWaitResult = errors.New("driver returned no wait result")
+
+// fatalStartError is a start failure no restart fixes, such as a schema
+// error, which fails the task right away.
+type fatalStartError struct {
+	error
+}
+
+// minStatsInterval is the shortest stats collection interval, so that a bad
+// setting can't make collection a tight loop.
+const minStatsInterval = 100 * time.Millisecond
+
 // Worker is used to wrap a task within an allocation and provide the execution context.
 type Worker struct {
 	config         *config.ClientConfig
 	updater        TaskStateUpdater
-	logger         *log.Logger
+	logger         Logger
 	alloc          *models.Allocation
 	restartTracker *RestartTracker
 
+	// restartRand jitters restart delays. It is seeded per worker so that
+	// workers don't all draw the same jitter.
+	restartRand *rand.Rand
+
 	// running marks whether the task is running
 	running     bool
 	runningLock sync.Mutex
 
+	// paused marks whether the running task is paused. It is guarded by
+	// runningLock.
+	paused bool
+
+	// backpressure marks whether the running task is held back because its
+	// target connection pool is nearly exhausted. It is guarded by
+	// runningLock.
+	backpressure bool
+
+	// cleanupPending marks that the task began its PreStartSQL, so that its
+	// PostStopSQL is due once it stops. It is guarded by runningLock.
+	cleanupPending bool
+
 	taskStats     *models.TaskStatistics
 	taskStatsLock sync.RWMutex
 
+	// lastStatsAt is when taskStats was last collected. It is guarded by
+	// taskStatsLock.
+	lastStatsAt time.Time
+
+	// chunkLatency is the chunk latency of the last stats window in which
+	// the task executed chunks. It is guarded by taskStatsLock.
+	chunkLatency *chunkLatency
+
+	// startedAt is when the task was last started by the run loop, zero for
+	// a restored task.
+	startedAt time.Time
+
+	restartInfo     RestartInfo
+	restartInfoLock sync.Mutex
+
 	task *models.Task
 
 	handle     driver.DriverHandle
@@ -62,6 +123,36 @@ type Worker struct {
 	// payloadRendered tracks whether the payload has been rendered to disk
 	payloadRendered bool
 
+	// checkpoint is the last replication position reported by the handle. It
+	// is kept while there is no handle, e.g. between restarts.
+	checkpoint []byte
+
+	// keyBounds are the last table bounds reported by the handle, kept like
+	// checkpoint.
+	keyBounds map[string]*models.KeyBounds
+
+	// dryRunQueries are the queries of a dry run of the task. They are set by
+	// Run, read them once WaitCh is closed.
+	dryRunQueries []string
+
+	// capture is the file the statements the task runs are appended to if
+	// its CaptureSQL is set. It is opened by startTask, kept open across
+	// restarts and closed once the run loop exits.
+	capture     *os.File
+	captureLock sync.Mutex
+
+	// lastSample is the latest sample emitted by emitStats, at lastSampleAt,
+	// which the heartbeat emits again. heartbeatStopped is set once the task
+	// stopped, after which there are no more heartbeats.
+	lastSample       *MetricsSample
+	lastSampleAt     time.Time
+	heartbeatStopped bool
+	lastSampleLock   sync.Mutex
+
+	// waitErr is the error the last run of the task ended with, and once it
+	// is dead the one it ended with, see WaitResult. It is set by Run.
+	waitErr error
+
 	// startCh is used to trigger the start of the task
 	startCh chan struct{}
 
@@ -73,15 +164,50 @@ type Worker struct {
 	// restartCh is used to restart a task
 	restartCh chan *models.TaskEvent
 
+	// controlWait is how long the latest Restart or Kill waited to be
+	// accepted, and controlDropped the number of restarts dropped as the
+	// restart queue was full. They are guarded by controlLock.
+	controlWait    time.Duration
+	controlDropped int
+	controlLock    sync.Mutex
+
+	// pauseCh and resumeCh are used to pause and resume a running task
+	pauseCh  chan *models.TaskEvent
+	resumeCh chan *models.TaskEvent
+
+	// backpressureCh carries the backpressure events of stats collection to
+	// the run loop
+	backpressureCh chan *models.TaskEvent
+
+	// tracer, if set, traces the lifecycle of the task, see SetTracer.
+	tracer Tracer
+
 	destroy      bool
 	destroyCh    chan struct{}
 	destroyLock  sync.Mutex
 	destroyEvent *models.TaskEvent
+
+	// drainTimeout is how long the handle is given to drain before it is
+	// shut down. Zero shuts it down right away. It is guarded by destroyLock.
+	drainTimeout time.Duration
 	workUpdates  chan *models.TaskUpdate
 
 	// waitCh closing marks the run loop as having exited
 	waitCh chan struct{}
 
+	// ctx is the context passed to Run. Its deadline bounds starting and
+	// killing the task.
+	ctx context.Context
+
+	// recentEvents is a ring of the latest events passed to setState, with
+	// recentEventsNext the slot written next.
+	recentEvents     []RecordedEvent
+	recentEventsNext int
+	recentEventsLock sync.Mutex
+
+	// coalescer holds back repeated updates, if configured.
+	coalescer *eventCoalescer
+
 	// persistLock must be acquired when accessing fields stored by
 	// SaveState. SaveState is called asynchronously to TaskRunner.Run by
 	// AllocRunner, so all store fields must be synchronized using this
@@ -89,52 +215,141 @@ type Worker struct {
 	persistLock sync.Mutex
 }
 
+// workerStateSchema is the current layout of workerState. Version 0 files were
+// written before Checkpoint existed.
+const workerStateSchema = 1
+
 // taskRunnerState is used to snapshot the store of the task runner
 type workerState struct {
 	Version         string
+	Schema          int
 	Task            *models.Task
 	HandleID        string
 	PayloadRendered bool
+
+	// Checkpoint is the handle's replication position at the time of the
+	// snapshot, as returned by DriverHandle.Checkpoint.
+	Checkpoint []byte
+
+	// KeyBounds are the bounds of the tables the task found, as returned by
+	// driver.KeyBoundsCacher.
+	KeyBounds map[string]*models.KeyBounds
+}
+
+// migrate upgrades a snapshot written by an older version to the current
+// schema.
+func (s *workerState) migrate() {
+	if s.Schema < 1 {
+		// Before checkpoints the position only lived in the task's config.
+		if s.Task != nil && len(s.Checkpoint) == 0 {
+			if gtid, ok := s.Task.Config["Gtid"].(string); ok {
+				s.Checkpoint = []byte(gtid)
+			}
+		}
+	}
+	s.Schema = workerStateSchema
+}
+
+// RecordedEvent is a task event with the state it moved the task to. State is
+// empty if the event didn't change the state.
+type RecordedEvent struct {
+	State string
+	Time  time.Time
+	Event *models.TaskEvent
+}
+
+// TaskHealth summarizes the liveness of a task.
+type TaskHealth struct {
+	Running bool
+
+	// LastEvent and LastEventAt are the type and time of the latest event
+	// of the task. They are empty if it had none.
+	LastEvent   string
+	LastEventAt time.Time
+
+	// Restarts is the number of restarts in the current restart interval.
+	Restarts int
+
+	// StatsStale is set when a running task had no stats collected within
+	// twice its collection interval.
+	StatsStale  bool
+	LastStatsAt time.Time
+}
+
+// RestartInfo is the last decision of the restart policy of a task, for
+// reporting such as "restarting in 30s because of a deadlock".
+type RestartInfo struct {
+	// State is TaskRestarting, TaskNotRestarting or TaskTerminated. It is
+	// empty before the task first exits and once it is running again.
+	State  string
+	Reason string
+
+	// Wait is the delay before the restart and Until when it ends, zero
+	// unless the task is restarting.
+	Wait  time.Duration
+	Until time.Time
 }
 
 // TaskStateUpdater is used to signal that tasks store has changed.
 type TaskStateUpdater func(taskName, state string, event *models.TaskEvent)
 
-// NewWorker is used to create a new task context
-func NewWorker(logger *log.Logger, config *config.ClientConfig,
+// NewWorker is used to create a new task context. Everything it logs carries
+// the alloc, job and task as fields.
+func NewWorker(logger Logger, config *config.ClientConfig,
 	updater TaskStateUpdater, alloc *models.Allocation,
 	task *models.Task, workUpdates chan *models.TaskUpdate) *Worker {
 
 	// Build the restart tracker.
 	t := alloc.Job.LookupTask(alloc.Task)
 	if t == nil {
-		logger.Errorf("agent: Alloc '%s' for missing task '%s'", alloc.ID, alloc.Task)
+		logger.Error("agent: Alloc for missing task", "alloc_id", alloc.ID, "task", alloc.Task)
 		return nil
 	}
+	logger = withFields(logger, "alloc_id", alloc.ID, "job", alloc.JobID, "task", task.Type)
 
-	restartTracker := newRestartTracker()
+	restartTracker := newRestartTracker(config.RestartResetWindow)
+
+	seed := fnv.New64a()
+	seed.Write([]byte(alloc.ID + "/" + task.Type))
+	restartRand := rand.New(rand.NewSource(time.Now().UnixNano() ^ int64(seed.Sum64())))
+
+	recentEvents := config.RecentTaskEvents
+	if recentEvents <= 0 {
+		recentEvents = defaultRecentEvents
+	}
+	restartQueueDepth := config.RestartQueueDepth
+	if restartQueueDepth < 0 {
+		restartQueueDepth = 0
+	}
 
 	tc := &Worker{
 		config:         config,
 		updater:        updater,
 		logger:         logger,
 		restartTracker: restartTracker,
+		restartRand:    restartRand,
 		alloc:          alloc,
 		task:           task,
 		destroyCh:      make(chan struct{}),
 		waitCh:         make(chan struct{}),
 		startCh:        make(chan struct{}, 1),
 		unblockCh:      make(chan struct{}),
-		restartCh:      make(chan *models.TaskEvent),
+		restartCh:      make(chan *models.TaskEvent, restartQueueDepth),
+		ctx:            context.Background(),
+		recentEvents:   make([]RecordedEvent, 0, recentEvents),
+		pauseCh:        make(chan *models.TaskEvent),
+		resumeCh:       make(chan *models.TaskEvent),
+		backpressureCh: make(chan *models.TaskEvent),
 		workUpdates:    workUpdates,
 	}
+	tc.coalescer = newEventCoalescer(config, tc.emitUpdate)
 
 	return tc
 }
 
 // MarkReceived marks the task as received.
 func (r *Worker) MarkReceived() {
-	r.logger.Debugf("MarkReceived")
+	r.logger.Debug("MarkReceived")
 	r.updater(r.task.Type, models.TaskStatePending, models.NewTaskEvent(models.TaskReceived))
 }
 
@@ -143,6 +358,18 @@ func (r *Worker) WaitCh() <-chan struct{} {
 	return r.waitCh
 }
 
+// WaitResult returns a channel receiving the error the task ended with once
+// WaitCh is closed: nil if it completed or was destroyed without being
+// failed, else why it failed to start or run, or was killed.
+func (r *Worker) WaitResult() <-chan error {
+	ch := make(chan error, 1)
+	go func() {
+		<-r.waitCh
+		ch <- r.waitErr
+	}()
+	return ch
+}
+
 // stateFilePath returns the path to our store file
 func (r *Worker) stateFilePath() string {
 	// Get the MD5 of the task name
@@ -166,8 +393,7 @@ func (r *Worker) SaveState() error {
 		id := &config.DriverCtx{}
 		handleID := r.handle.ID()
 		if err := json.Unmarshal([]byte(handleID), id); err != nil {
-			r.logger.Errorf("agent: Failed to parse handle '%s': %v",
-				handleID, err)
+			r.logger.Error("agent: Failed to parse handle", "handle", handleID, "error", err)
 		}
 		if id.DriverConfig.Gtid != "" {
 			if r.task.Type == models.TaskTypeDest {
@@ -183,15 +409,81 @@ func (r *Worker) SaveState() error {
 				NatsAddr: id.DriverConfig.NatsAddr,
 			}
 		}
-		r.logger.Debugf("Worker.SaveState: lock: %p, %p", r.task, r.task.ConfigLock)
+		r.logger.Debug("Worker.SaveState: lock", "task_ptr", fmt.Sprintf("%p", r.task), "lock_ptr", fmt.Sprintf("%p", r.task.ConfigLock))
 		r.task.ConfigLock.Lock()
-		r.logger.Debugf("Worker.SaveState: after lock: %p", r.task)
+		r.logger.Debug("Worker.SaveState: after lock", "task_ptr", fmt.Sprintf("%p", r.task))
 		r.task.Config["Gtid"] = id.DriverConfig.Gtid
 		r.task.Config["NatsAddr"] = id.DriverConfig.NatsAddr
 		r.task.ConfigLock.Unlock()
-		r.logger.Debugf("Worker.SaveState: after unlock: %p", r.task)
+		r.logger.Debug("Worker.SaveState: after unlock", "task_ptr", fmt.Sprintf("%p", r.task))
+	}
+
+	snap := workerState{
+		Version:         r.config.Version,
+		Schema:          workerStateSchema,
+		Task:            r.task,
+		PayloadRendered: r.payloadRendered,
+	}
+	if r.handle != nil {
+		snap.HandleID = r.handle.ID()
+		if checkpoint := r.handle.Checkpoint(); len(checkpoint) > 0 {
+			r.checkpoint = checkpoint
+		}
+		if cacher, ok := r.handle.(driver.KeyBoundsCacher); ok {
+			if bounds := cacher.KeyBounds(); len(bounds) > 0 {
+				r.keyBounds = bounds
+			}
+		}
 	}
+	snap.Checkpoint = r.checkpoint
+	snap.KeyBounds = r.keyBounds
 	r.handleLock.Unlock()
+
+	if r.config.StateDir == "" {
+		return nil
+	}
+	key, err := stateKey(r.config)
+	if err != nil {
+		return err
+	}
+	r.task.ConfigLock.RLock()
+	defer r.task.ConfigLock.RUnlock()
+	return persistState(r.stateFilePath(), &snap, r.config.StateSyncDir, key)
+}
+
+// RestoreState is used to restore our store. A saved checkpoint is passed to
+// the driver through the task's "Gtid" config, unless the task already has a
+// position to start from. It never starts the task: that is left to the run
+// loop, see claimStart.
+func (r *Worker) RestoreState() error {
+	r.persistLock.Lock()
+	defer r.persistLock.Unlock()
+
+	key, err := stateKey(r.config)
+	if err != nil {
+		return err
+	}
+	var snap workerState
+	if err := restoreState(r.stateFilePath(), &snap, key); err != nil {
+		return err
+	}
+	snap.migrate()
+
+	r.payloadRendered = snap.PayloadRendered
+	r.checkpoint = snap.Checkpoint
+	r.keyBounds = snap.KeyBounds
+	if len(snap.Checkpoint) == 0 {
+		return nil
+	}
+
+	r.task.ConfigLock.Lock()
+	defer r.task.ConfigLock.Unlock()
+	if r.task.Config == nil {
+		r.task.Config = make(map[string]interface{})
+	}
+	if gtid, _ := r.task.Config["Gtid"].(string); gtid == "" {
+		r.task.Config["Gtid"] = string(snap.Checkpoint)
+	}
 	return nil
 }
 
@@ -206,19 +498,77 @@ func (r *Worker) DestroyState() error {
 // setState is used to update the store of the task runner
 func (r *Worker) setState(state string, event *models.TaskEvent) {
 	// Persist our store to disk.
-	r.logger.Debugf("setState.SaveState")
+	r.logger.Debug("setState.SaveState")
 	if err := r.SaveState(); err != nil {
-		r.logger.Errorf("agent: Failed to save store of Task Runner for task %q: %v", r.task.Type, err)
+		r.logger.Error("agent: Failed to save store of Task Runner", "error", err)
+	}
+
+	if r.coalescer != nil {
+		r.coalescer.Update(state, event)
+		return
+	}
+	r.emitUpdate(state, event)
+}
+
+// emitUpdate records the event and passes the update on to the updater.
+func (r *Worker) emitUpdate(state string, event *models.TaskEvent) {
+	if event != nil {
+		r.recordEvent(state, event)
 	}
 
 	// Indicate the task has been updated.
-	r.logger.Debugf("updater")
+	r.logger.Debug("updater")
 	r.updater(r.task.Type, state, event)
 }
 
+// recordEvent adds an event to the ring of recent events, replacing the oldest
+// once it is full.
+func (r *Worker) recordEvent(state string, event *models.TaskEvent) {
+	r.recentEventsLock.Lock()
+	defer r.recentEventsLock.Unlock()
+	if cap(r.recentEvents) == 0 {
+		return
+	}
+
+	recorded := RecordedEvent{State: state, Time: time.Now(), Event: event.Copy()}
+	if len(r.recentEvents) < cap(r.recentEvents) {
+		r.recentEvents = append(r.recentEvents, recorded)
+	} else {
+		r.recentEvents[r.recentEventsNext] = recorded
+	}
+	r.recentEventsNext = (r.recentEventsNext + 1) % cap(r.recentEvents)
+}
+
+// RecentTransitions returns the latest events of the task with the state they
+// moved it to, oldest first.
+func (r *Worker) RecentTransitions() []RecordedEvent {
+	r.recentEventsLock.Lock()
+	defer r.recentEventsLock.Unlock()
+
+	events := make([]RecordedEvent, 0, len(r.recentEvents))
+	if len(r.recentEvents) == cap(r.recentEvents) {
+		events = append(events, r.recentEvents[r.recentEventsNext:]...)
+		events = append(events, r.recentEvents[:r.recentEventsNext]...)
+	} else {
+		events = append(events, r.recentEvents...)
+	}
+	return events
+}
+
+// RecentEvents returns the latest events of the task, oldest first.
+func (r *Worker) RecentEvents() []*models.TaskEvent {
+	transitions := r.RecentTransitions()
+	events := make([]*models.TaskEvent, len(transitions))
+	for i, t := range transitions {
+		events[i] = t.Event
+	}
+	return events
+}
+
 // createDriver makes a driver for the task
 func (r *Worker) createDriver() (driver.Driver, error) {
-	driverCtx := driver.NewDriverContext(r.task.Type, r.alloc.ID, r.config, r.config.Node, r.logger)
+	driverCtx := driver.NewDriverContext(r.task.Type, r.alloc.ID, r.config, r.config.Node,
+		stdLogger(r.logger, log.New(os.Stderr, log.InfoLevel)))
 	driver, err := driver.NewDriver(r.task.Driver, driverCtx)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create driver '%s' for alloc %s: %v",
@@ -227,38 +577,274 @@ func (r *Worker) createDriver() (driver.Driver, error) {
 	return driver, err
 }
 
-// Run is a long running routine used to manage the task
-func (r *Worker) Run() {
+// Run is a long running routine used to manage the task. Cancelling ctx
+// destroys the task like Destroy does.
+func (r *Worker) Run(ctx context.Context) {
 	defer close(r.waitCh)
-	r.logger.Debugf("agent: Starting task context for '%s' (alloc '%s')",
-		r.task.Type, r.alloc.ID)
+	r.logger.Debug("agent: Starting task context")
+
+	defer forgetMetrics(r.alloc.Job.Name, r.alloc.ID, r.alloc.Task)
+	defer r.emitHeartbeat(false)
+
+	r.ctx = ctx
+	if err := ctx.Err(); err != nil {
+		r.setState(models.TaskStateDead, r.contextKillEvent(err))
+		return
+	}
+	go func() {
+		select {
+		case <-ctx.Done():
+			r.Destroy(r.contextKillEvent(ctx.Err()))
+		case <-r.waitCh:
+		}
+	}()
 
 	// Create a driver so that we can determine the FSIsolation required
-	_, err := r.createDriver()
+	drv, err := r.createDriver()
 	if err != nil {
 		e := fmt.Errorf("failed to create driver of task %q for alloc %q: %v", r.task.Type, r.alloc.ID, err)
-		r.logger.Debugf("setState Run")
+		r.waitErr = e
+		r.logger.Debug("setState Run")
 		r.setState(
 			models.TaskStateDead,
 			models.NewTaskEvent(models.TaskSetupFailure).SetSetupError(e).SetFailsTask())
 		return
 	}
 
+	if r.configFlag("DryRun") {
+		r.dryRun(drv)
+		return
+	}
+
 	// Start the run loop
 	r.run()
 
 	return
 }
 
+// configFlag returns whether the config of the task sets name to true. It is
+// read under the ConfigLock, as SaveState writes the config concurrently.
+func (r *Worker) configFlag(name string) bool {
+	r.task.ConfigLock.RLock()
+	defer r.task.ConfigLock.RUnlock()
+	return r.task.Config[name] == true
+}
+
+// dryRun previews the task instead of starting it. The driver passes the
+// queries it would run to an executor that logs them, and appends them to
+// the capture file if the task's CaptureSQL is set. The task is dead once it
+// is done.
+func (r *Worker) dryRun(drv driver.Driver) {
+	runner, ok := drv.(driver.DryRunner)
+	if !ok {
+		e := fmt.Errorf("driver %q of task %q does not support dry runs", r.task.Driver, r.task.Type)
+		r.waitErr = e
+		r.setState(models.TaskStateDead,
+			models.NewTaskEvent(models.TaskSetupFailure).SetSetupError(e).SetFailsTask())
+		return
+	}
+
+	capture, err := r.openCapture()
+	if err != nil {
+		r.waitErr = err
+		r.setState(models.TaskStateDead,
+			models.NewTaskEvent(models.TaskSetupFailure).SetSetupError(err).SetFailsTask())
+		return
+	}
+
+	ctx := driver.NewExecContext(r.alloc.Job.ID, r.alloc.Job.Type, r.config.MaxPayload)
+	var captureErr error
+	err = runner.DryRun(ctx, r.task, func(query string, args []interface{}) {
+		r.logger.Info("agent: Dry run query", "query", query, "args", args)
+		r.dryRunQueries = append(r.dryRunQueries, query)
+		if capture != nil && captureErr == nil {
+			captureErr = writeCaptured(capture, query, args)
+		}
+	})
+	if capture != nil {
+		if closeErr := capture.Close(); captureErr == nil {
+			captureErr = closeErr
+		}
+		if err == nil && captureErr != nil {
+			err = fmt.Errorf("failed to capture the queries of task %q: %v", r.task.Type, captureErr)
+		}
+	}
+	if err != nil {
+		r.logger.Warn("agent: Dry run failed", "error", err)
+		r.waitErr = err
+		r.setState(models.TaskStateDead,
+			models.NewTaskEvent(models.TaskDriverFailure).SetDriverError(err).SetFailsTask())
+		return
+	}
+	r.setState(models.TaskStateDead, models.NewTaskEvent(models.TaskTerminated).SetExitCode(0).
+		SetDriverMessage(fmt.Sprintf("dry run of %d queries", len(r.dryRunQueries))))
+}
+
+// DryRunQueries returns the queries of a dry run of the task, once WaitCh is
+// closed.
+func (r *Worker) DryRunQueries() []string {
+	return r.dryRunQueries
+}
+
 // prestart handles life-cycle tasks that occur before the task has started.
-func (r *Worker) prestart(resultCh chan bool) {
+// The PreStartSQL of the task is run unless a restored handle is already
+// running it.
+func (r *Worker) prestart(resultCh chan error) {
+	r.handleLock.Lock()
+	handleEmpty := r.handle == nil
+	r.handleLock.Unlock()
+
+	if handleEmpty {
+		if r.task.BlockStart {
+			if err := r.waitUnblock(); err == errDestroyedBlocked {
+				// The run loop handles the destroy.
+				return
+			} else if err != nil {
+				resultCh <- err
+				return
+			}
+		}
+		// From here on whatever the PreStartSQL sets up is cleaned up,
+		// however the task ends, even when a later statement fails.
+		r.armCleanup()
+		if err := r.runSQLHook("PreStartSQL", r.task.PreStartSQL); err != nil {
+			resultCh <- err
+			return
+		}
+	} else {
+		r.armCleanup()
+	}
+
 	// Send the start signal
 	select {
 	case r.startCh <- struct{}{}:
 	default:
 	}
 
-	resultCh <- true
+	resultCh <- nil
+}
+
+// errDestroyedBlocked is returned by waitUnblock if the task is destroyed
+// while it waits.
+var errDestroyedBlocked = errors.New("task destroyed before it was unblocked")
+
+// prestartTimeoutError fails a task that was not unblocked in time.
+type prestartTimeoutError struct {
+	timeout time.Duration
+}
+
+func (e *prestartTimeoutError) Error() string {
+	return fmt.Sprintf("prestart timeout: task not unblocked within %v", e.timeout)
+}
+
+// waitUnblock blocks until UnblockStart is called, the task is destroyed, or
+// its prestart timeout, if any, passes.
+func (r *Worker) waitUnblock() error {
+	timeout := r.task.PrestartTimeout
+	if timeout <= 0 {
+		timeout = r.config.PrestartTimeout
+	}
+	var deadline <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		deadline = timer.C
+	}
+
+	r.logger.Debug("agent: Waiting for task to be unblocked", "timeout", timeout)
+	select {
+	case <-r.unblockCh:
+		return nil
+	case <-r.destroyCh:
+		return errDestroyedBlocked
+	case <-deadline:
+		return &prestartTimeoutError{timeout: timeout}
+	}
+}
+
+// armCleanup makes the PostStopSQL of the task due once it stops.
+func (r *Worker) armCleanup() {
+	r.runningLock.Lock()
+	r.cleanupPending = true
+	r.runningLock.Unlock()
+}
+
+// poststop runs the PostStopSQL of the task once its handle has exited, if
+// its prestart began the PreStartSQL and it hasn't run since. It gives up
+// waiting after the post-stop timeout, so that a hung cleanup can't hold up
+// the task. A failure is only logged, the task is already done.
+func (r *Worker) poststop() {
+	r.runningLock.Lock()
+	pending := r.cleanupPending
+	r.cleanupPending = false
+	r.runningLock.Unlock()
+	if !pending {
+		return
+	}
+
+	timeout := r.postStopTimeout()
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- r.runSQLHook("PostStopSQL", r.task.PostStopSQL)
+	}()
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case err := <-errCh:
+		if err != nil {
+			r.logger.Error("agent: Failed to run post-stop SQL", "error", err)
+		}
+	case <-timer.C:
+		r.logger.Error("agent: Post-stop SQL timed out", "timeout", timeout)
+	}
+}
+
+// postStopTimeout returns the PostStopTimeout of the client config, or the
+// default if unset.
+func (r *Worker) postStopTimeout() time.Duration {
+	if r.config != nil && r.config.PostStopTimeout > 0 {
+		return r.config.PostStopTimeout
+	}
+	return defaultPostStopTimeout
+}
+
+// waitKilled waits for the handle to exit once killTask returns. A handle
+// which couldn't be killed may never exit, so it is waited on for at most the
+// post-stop timeout before the task is cleaned up anyway.
+func (r *Worker) waitKilled(handleWaitCh chan *models.WaitResult, killed bool) {
+	if killed {
+		<-handleWaitCh
+		return
+	}
+	timer := time.NewTimer(r.postStopTimeout())
+	defer timer.Stop()
+	select {
+	case <-handleWaitCh:
+	case <-timer.C:
+		r.logger.Warn("agent: Task didn't exit after failing to kill it. Cleaning up anyway")
+	}
+}
+
+// runSQLHook runs the statements of the hook in order with the task's driver.
+func (r *Worker) runSQLHook(hook string, statements []string) error {
+	if len(statements) == 0 {
+		return nil
+	}
+	drv, err := r.createDriver()
+	if err != nil {
+		return fmt.Errorf("failed to create driver of task %q for alloc %q: %v", r.task.Type, r.alloc.ID, err)
+	}
+	runner, ok := drv.(driver.SQLHookRunner)
+	if !ok {
+		return fmt.Errorf("driver %q of task %q does not support %s", r.task.Driver, r.task.Type, hook)
+	}
+
+	r.logger.Debug("agent: Running SQL hook", "hook", hook, "statements", len(statements))
+	ctx := driver.NewExecContext(r.alloc.Job.ID, r.alloc.Job.Type, r.config.MaxPayload)
+	if err := runner.RunSQL(ctx, r.task, statements); err != nil {
+		return fmt.Errorf("%s of task %q failed: %v", hook, r.task.Type, err)
+	}
+	return nil
 }
 
 // run is the main run loop that handles starting the application, destroying
@@ -268,6 +854,24 @@ func (r *Worker) run() {
 	var stopCollection chan struct{}
 	var handleWaitCh chan *models.WaitResult
 
+	// maxRuntime kills the task once it has run for its MaxRuntime. It is
+	// armed on every start.
+	var maxRuntime *time.Timer
+	defer func() {
+		if maxRuntime != nil {
+			maxRuntime.Stop()
+		}
+		// Clean up after the task whichever way it ended, unless that was
+		// done once its handle exited.
+		r.poststop()
+		r.closeCapture()
+		// The task is dead, and its handle with it.
+		r.handleLock.Lock()
+		r.handle = nil
+		r.handleLock.Unlock()
+		r.releaseStart()
+	}()
+
 	// If we already have a handle, populate the stopCollection and handleWaitCh
 	// to fix the invariant that it exists.
 	r.handleLock.Lock()
@@ -278,20 +882,28 @@ func (r *Worker) run() {
 		stopCollection = make(chan struct{})
 		go r.collectResourceUsageStats(stopCollection)
 		handleWaitCh = r.handle.WaitCh()
+		maxRuntime = r.armMaxRuntime()
 	}
 
 	for {
 		// Do the prestart activities
-		prestartResultCh := make(chan bool, 1)
+		prestartResultCh := make(chan error, 1)
 		go r.prestart(prestartResultCh)
 
 	WAIT:
 		for {
 			select {
-			case success := <-prestartResultCh:
-				if !success {
-					r.logger.Debugf("setState 1")
-					r.setState(models.TaskStateDead, nil)
+			case err := <-prestartResultCh:
+				if err != nil {
+					r.logger.Error("agent: Prestart failed", "error", err)
+					r.waitErr = err
+					eventType := models.TaskSetupFailure
+					if _, ok := err.(*prestartTimeoutError); ok {
+						eventType = models.TaskPrestartTimeout
+					}
+					r.logger.Debug("setState 1")
+					r.setState(models.TaskStateDead,
+						models.NewTaskEvent(eventType).SetSetupError(err).SetFailsTask())
 					return
 				}
 			case <-r.startCh:
@@ -305,18 +917,28 @@ func (r *Worker) run() {
 				if handleEmpty {
 					startErr := r.startTask()
 					r.restartTracker.SetStartError(startErr)
+					r.waitErr = startErr
+					if fatal, ok := startErr.(*fatalStartError); ok {
+						r.waitErr = fatal.error
+						r.logger.Error("agent: Not restarting task on schema error", "error", startErr)
+						r.setState(models.TaskStateDead,
+							models.NewTaskEvent(models.TaskDriverFailure).SetDriverError(startErr).SetFailsTask())
+						return
+					}
 					if startErr != nil {
-						r.logger.Debugf("setState 2")
+						r.logger.Debug("setState 2")
 						r.setState("", models.NewTaskEvent(models.TaskDriverFailure).SetDriverError(startErr))
 						goto RESTART
 					}
 
 					// Mark the task as started
-					r.logger.Debugf("setState 3")
+					r.logger.Debug("setState 3")
 					r.setState(models.TaskStateRunning, models.NewTaskEvent(models.TaskStarted))
 					r.runningLock.Lock()
 					r.running = true
 					r.runningLock.Unlock()
+					r.restartTracker.SetRunning(time.Now())
+					r.setRestartInfo(RestartInfo{})
 
 					if stopCollection == nil {
 						stopCollection = make(chan struct{})
@@ -324,11 +946,22 @@ func (r *Worker) run() {
 					}
 
 					handleWaitCh = r.handle.WaitCh()
+					maxRuntime = r.armMaxRuntime()
 				}
 
 			case waitRes := <-handleWaitCh:
+				// A driver delivering no result is misbehaving. It fails the
+				// task, left to the restart policy, rather than the agent.
+				var event *models.TaskEvent
 				if waitRes == nil {
-					panic("nil wait")
+					r.logger.Error("agent: Driver returned a nil wait result")
+					waitRes = models.NewWaitResult(1, errNilWaitResult)
+					event = models.NewTaskEvent(models.TaskDriverFailure).SetDriverError(errNilWaitResult)
+				} else {
+					event = r.waitErrorToEvent(waitRes)
+					if waitRes.Successful() {
+						r.summarizeCompletion(event)
+					}
 				}
 
 				r.runningLock.Lock()
@@ -340,13 +973,15 @@ func (r *Worker) run() {
 
 				// Log whether the task was successful or not.
 				r.restartTracker.SetWaitResult(waitRes)
-				r.logger.Debugf("setState 4")
-				r.setState("", r.waitErrorToEvent(waitRes))
+				r.waitErr = waitResultError(waitRes)
+				r.logger.Debug("setState 4")
+				r.setState("", event)
 				if !waitRes.Successful() {
-					r.logger.Errorf("agent: Task %q for alloc %q failed: %v", r.task.Type, r.alloc.ID, waitRes)
+					r.logger.Error("agent: Task failed", "result", waitRes)
 				} else {
-					r.logger.Printf("agent: Task %q for alloc %q completed successfully", r.task.Type, r.alloc.ID)
+					r.logger.Info("agent: Task completed successfully")
 				}
+				r.poststop()
 
 				break WAIT
 
@@ -354,34 +989,125 @@ func (r *Worker) run() {
 				r.runningLock.Lock()
 				running := r.running
 				r.runningLock.Unlock()
-				common := fmt.Sprintf("task %v for alloc %q", r.task.Type, r.alloc.ID)
 				if !running {
-					r.logger.Debugf("agent: Skipping restart of %v: task isn't running", common)
+					r.logger.Debug("agent: Skipping restart: task isn't running")
 					continue
 				}
 
-				r.logger.Debugf("agent: Restarting %s: %v", common, event.RestartReason)
-				r.logger.Debugf("setState 5")
+				r.logger.Debug("agent: Restarting", "reason", event.RestartReason)
+				r.logger.Debug("setState 5")
 				r.setState(models.TaskStateRunning, event)
-				r.killTask(nil)
+				killed := r.killTask(nil)
 
-				close(stopCollection)
+				if stopCollection != nil {
+					close(stopCollection)
+				}
 
-				if handleWaitCh != nil {
-					<-handleWaitCh
+				// A paused task has no handleWaitCh; wait on the handle
+				// itself, so that it exited before the next start.
+				r.runningLock.Lock()
+				r.paused = false
+				r.backpressure = false
+				r.runningLock.Unlock()
+				if handleWaitCh == nil {
+					handleWaitCh = r.handle.WaitCh()
 				}
+				r.waitKilled(handleWaitCh, killed)
+				r.poststop()
 
 				// Since the restart isn't from a failure, restart immediately
 				// and don't count against the restart policy
 				r.restartTracker.SetRestartTriggered()
 				break WAIT
 
+			case event := <-r.pauseCh:
+				r.runningLock.Lock()
+				running, paused := r.running, r.paused
+				r.runningLock.Unlock()
+				if !running || paused {
+					r.logger.Debug("agent: Skipping pause: task isn't running")
+					continue
+				}
+
+				r.logger.Debug("agent: Pausing", "reason", event.PauseReason)
+				if err := r.handle.Pause(); err != nil {
+					r.logger.Error("agent: Failed to pause", "error", err)
+					continue
+				}
+
+				// The handle stays alive while paused. Stop collecting its
+				// stats and stop watching it so that nothing is fed to the
+				// restart tracker until it is resumed.
+				close(stopCollection)
+				stopCollection = nil
+				handleWaitCh = nil
+
+				r.runningLock.Lock()
+				r.paused = true
+				r.runningLock.Unlock()
+				r.setState(models.TaskStatePaused, event)
+
+			case event := <-r.resumeCh:
+				r.runningLock.Lock()
+				running, paused := r.running, r.paused
+				r.runningLock.Unlock()
+				if !running || !paused {
+					r.logger.Debug("agent: Skipping resume: task isn't paused")
+					continue
+				}
+
+				r.logger.Debug("agent: Resuming", "reason", event.PauseReason)
+				if err := r.handle.Resume(); err != nil {
+					r.logger.Error("agent: Failed to resume", "error", err)
+					continue
+				}
+
+				r.runningLock.Lock()
+				r.paused = false
+				r.backpressure = false
+				r.runningLock.Unlock()
+
+				stopCollection = make(chan struct{})
+				go r.collectResourceUsageStats(stopCollection)
+				handleWaitCh = r.handle.WaitCh()
+				r.setState(models.TaskStateRunning, event)
+
+			case event := <-r.backpressureCh:
+				apply := event.Type == models.TaskBackpressure
+				r.runningLock.Lock()
+				running, paused, applied := r.running, r.paused, r.backpressure
+				r.runningLock.Unlock()
+				if !running || paused || apply == applied {
+					continue
+				}
+
+				// The handle keeps running and its stats keep being
+				// collected, so that the release is noticed.
+				var err error
+				if apply {
+					r.logger.Warn("agent: Applying backpressure", "reason", event.PauseReason)
+					err = r.handle.Pause()
+				} else {
+					r.logger.Info("agent: Releasing backpressure", "reason", event.PauseReason)
+					err = r.handle.Resume()
+				}
+				if err != nil {
+					r.logger.Error("agent: Failed to change backpressure", "error", err)
+					continue
+				}
+
+				r.runningLock.Lock()
+				r.backpressure = apply
+				r.runningLock.Unlock()
+				r.setState("", event)
+
 			case <-r.destroyCh:
 				r.runningLock.Lock()
 				running := r.running
 				r.runningLock.Unlock()
 				if !running {
-					r.logger.Debugf("setState 6")
+					r.logger.Debug("setState 6")
+					r.waitErr = killError(r.destroyEvent)
 					r.setState(models.TaskStateDead, r.destroyEvent)
 					return
 				}
@@ -394,26 +1120,48 @@ func (r *Worker) run() {
 					if r.destroyEvent.Type == models.TaskKilling {
 						killEvent = r.destroyEvent
 					} else {
-						r.logger.Debugf("setState 7")
+						r.logger.Debug("setState 7")
 						r.setState(models.TaskStateRunning, r.destroyEvent)
 					}
 				}
 
-				r.killTask(killEvent)
-				close(stopCollection)
-				// Wait for handler to exit before calling cleanup
-				<-handleWaitCh
+				killed := r.killTask(killEvent)
+				if stopCollection != nil {
+					close(stopCollection)
+				}
+				// Wait for handler to exit before calling cleanup. A paused
+				// task has no handleWaitCh; wait on the handle itself.
+				r.runningLock.Lock()
+				r.paused = false
+				r.backpressure = false
+				r.runningLock.Unlock()
+				if handleWaitCh == nil {
+					handleWaitCh = r.handle.WaitCh()
+				}
+				r.waitKilled(handleWaitCh, killed)
+				r.poststop()
 
-				r.logger.Debugf("setState 8")
+				r.logger.Debug("setState 8")
+				r.waitErr = killError(r.destroyEvent)
 				r.setState(models.TaskStateDead, nil)
 				return
 			}
 		}
 
 	RESTART:
+		// Clean up a failed start before the next one runs the PreStartSQL
+		// again.
+		r.poststop()
+
+		// The task isn't running anymore; a restart gets a fresh window.
+		if maxRuntime != nil {
+			maxRuntime.Stop()
+			maxRuntime = nil
+		}
+
 		restart := r.shouldRestart()
 		if !restart {
-			r.logger.Debugf("setState 9")
+			r.logger.Debug("setState 9")
 			r.setState(models.TaskStateDead, nil)
 			return
 		}
@@ -424,34 +1172,88 @@ func (r *Worker) run() {
 		handleWaitCh = nil
 		stopCollection = nil
 		r.handleLock.Unlock()
+		r.releaseStart()
+	}
+}
+
+// activeTasks are the workers which are starting, or have a live handle for,
+// each task, by alloc and task type. A worker restored while another still
+// runs the task can't start a second handle writing the same rows.
+var (
+	activeTasks     = make(map[string]*Worker)
+	activeTasksLock sync.Mutex
+)
+
+// claimStart makes the worker the one starting its task, failing if another
+// worker is starting or running it.
+func (r *Worker) claimStart() error {
+	key := r.alloc.ID + "/" + r.task.Type
+	activeTasksLock.Lock()
+	defer activeTasksLock.Unlock()
+	if owner, ok := activeTasks[key]; ok && owner != r {
+		return fmt.Errorf("task %q for alloc %q is already started by another worker", r.task.Type, r.alloc.ID)
 	}
+	activeTasks[key] = r
+	return nil
+}
+
+// releaseStart lets another worker start the task once the handle of the
+// worker is gone.
+func (r *Worker) releaseStart() {
+	key := r.alloc.ID + "/" + r.task.Type
+	activeTasksLock.Lock()
+	defer activeTasksLock.Unlock()
+	if activeTasks[key] == r {
+		delete(activeTasks, key)
+	}
+}
+
+// armMaxRuntime starts the timer killing the task once it exceeds its
+// MaxRuntime. It returns nil if the task has no MaxRuntime.
+func (r *Worker) armMaxRuntime() *time.Timer {
+	if r.task.MaxRuntime <= 0 {
+		return nil
+	}
+	return time.AfterFunc(r.task.MaxRuntime, func() {
+		r.logger.Warn("agent: Killing task", "reason", maxRuntimeExceeded, "max_runtime", r.task.MaxRuntime)
+		r.Kill("agent", maxRuntimeExceeded, true)
+	})
 }
 
 // shouldRestart returns if the task should restart. If the return value is
 // true, the task's restart policy has already been considered and any wait time
 // between restarts has been applied.
 func (r *Worker) shouldRestart() bool {
+	span := r.startSpan(spanRestart)
+	defer span.End()
+
 	state, when := r.restartTracker.GetState()
 	reason := r.restartTracker.GetReason()
+	if state == models.TaskRestarting {
+		when = jitterDelay(when, r.config.RestartJitter, r.restartRand)
+	}
+	span.SetAttributes("state", state, "reason", reason, "delay", when)
+	r.setRestartInfo(RestartInfo{State: state, Reason: reason})
 	switch state {
 	case models.TaskNotRestarting, models.TaskTerminated:
-		r.logger.Printf("agent: Not restarting task: %v for alloc: %v ", r.task.Type, r.alloc.ID)
+		r.logger.Info("agent: Not restarting task")
 		if state == models.TaskNotRestarting {
-			r.logger.Debugf("setState restart 1")
+			r.logger.Debug("setState restart 1")
 			r.setState(models.TaskStateFailed,
 				models.NewTaskEvent(models.TaskNotRestarting).
 					SetRestartReason(reason).SetFailsTask())
 		}
 		return false
 	case models.TaskRestarting:
-		r.logger.Printf("agent: Restarting task %q for alloc %q in %v", r.task.Type, r.alloc.ID, when)
-		r.logger.Debugf("setState restart 2")
+		r.setRestartInfo(RestartInfo{State: state, Reason: reason, Wait: when, Until: time.Now().Add(when)})
+		r.logger.Info("agent: Restarting task", "delay", when)
+		r.logger.Debug("setState restart 2")
 		r.setState(models.TaskStatePending,
 			models.NewTaskEvent(models.TaskRestarting).
 				SetRestartDelay(when).
 				SetRestartReason(reason))
 	default:
-		r.logger.Errorf("agent: Restart tracker returned unknown store: %q", state)
+		r.logger.Error("agent: Restart tracker returned unknown store", "state", state)
 		return false
 	}
 
@@ -466,8 +1268,9 @@ func (r *Worker) shouldRestart() bool {
 	destroyed := r.destroy
 	r.destroyLock.Unlock()
 	if destroyed {
-		r.logger.Debugf("agent: Not restarting task: %v because it has been destroyed", r.task.Type)
-		r.logger.Debugf("setState restart 3")
+		r.logger.Debug("agent: Not restarting task because it has been destroyed")
+		r.logger.Debug("setState restart 3")
+		r.waitErr = killError(r.destroyEvent)
 		r.setState(models.TaskStateDead, r.destroyEvent)
 		return false
 	}
@@ -475,16 +1278,31 @@ func (r *Worker) shouldRestart() bool {
 	return true
 }
 
+func (r *Worker) setRestartInfo(info RestartInfo) {
+	r.restartInfoLock.Lock()
+	defer r.restartInfoLock.Unlock()
+	r.restartInfo = info
+}
+
+// RestartInfo returns the last decision of the restart policy of the task.
+func (r *Worker) RestartInfo() RestartInfo {
+	r.restartInfoLock.Lock()
+	defer r.restartInfoLock.Unlock()
+	return r.restartInfo
+}
+
 // killTask kills the running task. A killing event can optionally be passed and
 // this event is used to mark the task as being killed. It provides a means to
-// store extra information.
-func (r *Worker) killTask(killingEvent *models.TaskEvent) {
+// store extra information. It returns whether the handle was killed, or
+// wasn't running.
+func (r *Worker) killTask(killingEvent *models.TaskEvent) bool {
 	r.runningLock.Lock()
 	running := r.running
 	r.runningLock.Unlock()
 	if !running {
-		return
+		return true
 	}
+	span := r.startSpan(spanKill)
 
 	// Build the event
 	var event *models.TaskEvent
@@ -497,27 +1315,57 @@ func (r *Worker) killTask(killingEvent *models.TaskEvent) {
 	event.SetKillTimeout(models.DefaultKillTimeout)
 
 	// Mark that we received the kill event
-	r.logger.Debugf("setState killTask 1")
+	r.logger.Debug("setState killTask 1")
 	r.setState(models.TaskStateRunning, event)
 
 	// Kill the task using an exponential backoff in-case of failures.
 	destroySuccess, err := r.handleDestroy()
 	if !destroySuccess {
 		// We couldn't successfully destroy the resource created.
-		r.logger.Errorf("agent: Failed to kill task %q. Resources may have been leaked: %v", r.task.Type, err)
+		r.logger.Error("agent: Failed to kill task. Resources may have been leaked", "error", err)
 	}
+	endSpan(span, err)
 
 	r.runningLock.Lock()
 	r.running = false
 	r.runningLock.Unlock()
 
 	// Store that the task has been destroyed and any associated error.
-	r.logger.Debugf("setState killTask 2")
+	r.logger.Debug("setState killTask 2")
+	if err == context.DeadlineExceeded {
+		r.setState("", models.NewTaskEvent(models.TaskKillTimedOut).SetKillError(err))
+		return false
+	}
 	r.setState("", models.NewTaskEvent(models.TaskKilled).SetKillError(err))
+	return destroySuccess
+}
+
+// contextKillEvent returns the event destroying the task once its context is
+// done.
+func (r *Worker) contextKillEvent(err error) *models.TaskEvent {
+	return models.NewTaskEvent(models.TaskKilling).SetKillReason(fmt.Sprintf("context: %v", err))
 }
 
 // startTask creates the driver, task dir, and starts the task.
-func (r *Worker) startTask() error {
+func (r *Worker) startTask() (err error) {
+	span := r.startSpan(spanStart)
+	defer func() { endSpan(span, err) }()
+
+	if r.ctx != nil {
+		if err := r.ctx.Err(); err != nil {
+			return fmt.Errorf("not starting task %q for alloc %q: %v", r.task.Type, r.alloc.ID, err)
+		}
+	}
+	if err := r.claimStart(); err != nil {
+		r.logger.Warn("agent: Not starting task", "error", err)
+		return models.NewRecoverableError(err, true)
+	}
+	defer func() {
+		if err != nil {
+			r.releaseStart()
+		}
+	}()
+
 	// Create a driver
 	drv, err := r.createDriver()
 	if err != nil {
@@ -527,60 +1375,191 @@ func (r *Worker) startTask() error {
 
 	// Run prestart
 	ctx := driver.NewExecContext(r.alloc.Job.ID, r.alloc.Job.Type, r.config.MaxPayload)
+	if r.config.TagQueries {
+		ctx.QueryComment = fmt.Sprintf("dtle job=%s alloc=%s task=%s", r.alloc.Job.Name, r.alloc.ID, r.task.Type)
+	}
+
+	r.handleLock.Lock()
+	ctx.KeyBounds = r.keyBounds
+	r.handleLock.Unlock()
+
+	ctx.Capture, err = r.captureExecutor()
+	if err != nil {
+		return fmt.Errorf("failed to open the capture file of task %q for alloc %q: %v",
+			r.task.Type, r.alloc.ID, err)
+	}
 
 	// Start the job
-	handle, err := drv.Start(ctx, r.task)
+	var handle driver.DriverHandle
+	if r.task.Parallelism > 1 {
+		handle, err = r.startParallel(drv, ctx)
+	} else {
+		handle, err = drv.Start(ctx, r.task)
+	}
 	if err != nil {
 		wrapped := fmt.Sprintf("Failed to start task %q for alloc %q: %v",
 			r.task.Type, r.alloc.ID, err)
-		r.logger.Warnf("agent: %s", wrapped)
-		return models.WrapRecoverable(wrapped, err)
+		r.logger.Warn("agent: Failed to start task", "error", err)
+		// A transient failure, such as the database being unreachable, is
+		// left to the restart policy.
+		startErr := models.NewRecoverableError(errors.New(wrapped), IsRetryable(err))
+		if r.config.FailFastOnSchemaError && IsSchemaError(err) {
+			return &fatalStartError{startErr}
+		}
+		return startErr
 
 	}
 
 	r.handleLock.Lock()
 	r.handle = handle
 	r.handleLock.Unlock()
+	r.startedAt = time.Now()
 	return nil
 }
 
+// startParallel splits the task into Parallelism sub-copies and starts them
+// all, returning a handle driving them as one. If one fails to start, those
+// already started are shut down.
+func (r *Worker) startParallel(drv driver.Driver, ctx *driver.ExecContext) (driver.DriverHandle, error) {
+	splitter, ok := drv.(driver.Splitter)
+	if !ok {
+		return nil, fmt.Errorf("driver of task %q does not support parallel copies", r.task.Type)
+	}
+	tasks, err := splitter.Split(ctx, r.task, r.task.Parallelism)
+	if err != nil {
+		return nil, fmt.Errorf("failed to split task into %d copies: %v", r.task.Parallelism, err)
+	}
+	if len(tasks) == 0 {
+		return nil, fmt.Errorf("failed to split task into %d copies: got none", r.task.Parallelism)
+	}
+
+	handles := make([]driver.DriverHandle, 0, len(tasks))
+	for i, task := range tasks {
+		handle, err := drv.Start(ctx, task)
+		if err != nil {
+			for _, started := range handles {
+				started.Shutdown()
+			}
+			return nil, fmt.Errorf("copy %d of %d: %v", i+1, len(tasks), err)
+		}
+		handles = append(handles, handle)
+	}
+	return newParallelHandle(handles), nil
+}
+
 // collectResourceUsageStats starts collecting resource usage stats of a Task.
 // Collection ends when the passed channel is closed
 func (r *Worker) collectResourceUsageStats(stopCollection <-chan struct{}) {
+	throttle := newThrottleController(r.config)
+	backpressure := newBackpressureController(r.config)
+	limiter := newResourceLimiter(r.task.ResourceLimits)
+	// The task is throttled by the lower of the factors of the delay of its
+	// target and of its resource usage.
+	delayFactor, usageFactor := 1.0, 1.0
+	chunks := newLatencyHistogram(maxChunkSamples)
+
 	// start collecting the stats right away and then start collecting every
 	// collection interval
 	next := time.NewTimer(0)
 	defer next.Stop()
+	// The heartbeat has its own ticker, so that it keeps going when samples
+	// stop arriving.
+	var heartbeat <-chan time.Time
+	if interval := r.config.MetricsHeartbeatInterval; interval > 0 && r.config.PublishAllocationMetrics {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		heartbeat = ticker.C
+	}
 	for {
 		select {
+		case <-heartbeat:
+			r.emitHeartbeat(true)
 		case <-next.C:
-			next.Reset(r.config.StatsCollectionInterval)
-			if r.handle == nil {
+			next.Reset(r.statsInterval())
+			// The handle is cleared once the task is dead, which may be
+			// before the collection stops.
+			r.handleLock.Lock()
+			handle := r.handle
+			r.handleLock.Unlock()
+			if handle == nil {
 				continue
 			}
-			ru, err := r.handle.Stats()
+			if backpressure != nil {
+				pool := handle.PoolStats()
+				if applied, changed := backpressure.Sample(pool); changed {
+					event := models.NewTaskEvent(models.TaskBackpressureReleased)
+					if applied {
+						event = models.NewTaskEvent(models.TaskBackpressure)
+					}
+					event.SetPauseReason(fmt.Sprintf("%.0f%% of the target connection pool in use", pool.Utilization()*100))
+					select {
+					case r.backpressureCh <- event:
+					case <-stopCollection:
+						return
+					}
+				}
+			}
+			ru, err := handle.Stats()
 
 			if err != nil {
 				// Check if the driver doesn't implement stats
 				if err.Error() == driver.DriverStatsNotImplemented.Error() {
-					r.logger.Debugf("agent: Driver for task %q in allocation %q doesn't support stats", r.task.Type, r.alloc.ID)
+					r.logger.Debug("agent: Driver doesn't support stats")
 					return
 				}
 
 				// We do not log when the plugin is shutdown as this is simply a
 				// race between the stopCollection channel being closed and calling
 				// Stats on the handle.
-				if !strings.Contains(err.Error(), "connection is shut down") {
-					r.logger.Warnf("agent: Error fetching stats of task %v: %v", r.task.Type, err)
+				if !IsRetryable(err) {
+					r.logger.Warn("agent: Error fetching stats", "error", err)
 				}
 				continue
 			}
 
+			// Each collection is a window of the chunk latency.
+			var latency *chunkLatency
+			if ru != nil {
+				chunks.Add(ru.ChunkDurations...)
+				latency = chunks.Percentiles()
+				chunks.Reset()
+			}
+
 			r.taskStatsLock.Lock()
 			r.taskStats = ru
+			r.lastStatsAt = time.Now()
+			if latency != nil {
+				r.chunkLatency = latency
+			}
 			r.taskStatsLock.Unlock()
 			if ru != nil {
-				r.emitStats(ru)
+				r.emitStats(ru, latency.gauges()...)
+			}
+			throttled := false
+			if throttle != nil && ru != nil && ru.DelayCount != nil {
+				// DelayCount.Time is in seconds.
+				delay := time.Duration(ru.DelayCount.Time) * time.Second
+				if factor, changed := throttle.Sample(delay); changed {
+					r.logger.Debug("agent: Throttling task", "factor", factor, "delay", delay)
+					delayFactor, throttled = factor, true
+				}
+			}
+			if limiter != nil && ru != nil {
+				factor, changed, exceeded := limiter.Sample(ru)
+				if exceeded != "" {
+					r.logger.Warn("agent: Task over its resource limits", "limit", exceeded, "factor", factor)
+				}
+				if changed {
+					usageFactor, throttled = factor, true
+				}
+				if limiter.Sustained() {
+					r.logger.Warn("agent: Killing task", "reason", resourceLimitExceeded, "limit", exceeded)
+					r.Kill("agent", resourceLimitExceeded, true)
+					limiter = nil
+				}
+			}
+			if throttled {
+				handle.Throttle(math.Min(delayFactor, usageFactor))
 			}
 		case <-stopCollection:
 			return
@@ -588,6 +1567,56 @@ func (r *Worker) collectResourceUsageStats(stopCollection <-chan struct{}) {
 	}
 }
 
+// statsInterval returns the interval to collect the task's stats at. The task's
+// own interval overrides the client's.
+func (r *Worker) statsInterval() time.Duration {
+	interval := r.config.StatsCollectionInterval
+	if r.task.StatsInterval > 0 {
+		interval = r.task.StatsInterval
+	}
+	if interval < minStatsInterval {
+		interval = minStatsInterval
+	}
+	return interval
+}
+
+// Backpressure returns whether the task is held back because the connection
+// pool to its target is nearly exhausted.
+func (r *Worker) Backpressure() bool {
+	r.runningLock.Lock()
+	defer r.runningLock.Unlock()
+	return r.backpressure
+}
+
+// Health returns a summary of the liveness of the task.
+func (r *Worker) Health() TaskHealth {
+	var h TaskHealth
+
+	r.runningLock.Lock()
+	h.Running = r.running
+	r.runningLock.Unlock()
+
+	r.recentEventsLock.Lock()
+	if n := len(r.recentEvents); n > 0 {
+		last := r.recentEvents[(r.recentEventsNext+n-1)%n]
+		h.LastEvent = last.Event.Type
+		h.LastEventAt = last.Time
+	}
+	r.recentEventsLock.Unlock()
+
+	if r.restartTracker != nil {
+		h.Restarts = r.restartTracker.Count()
+	}
+
+	r.taskStatsLock.RLock()
+	h.LastStatsAt = r.lastStatsAt
+	r.taskStatsLock.RUnlock()
+	if h.Running {
+		h.StatsStale = time.Since(h.LastStatsAt) > 2*r.statsInterval()
+	}
+	return h
+}
+
 // LatestResourceUsage returns the last resource utilization datapoint collected
 func (r *Worker) LatestTaskStats() *models.TaskStatistics {
 	r.taskStatsLock.RLock()
@@ -603,23 +1632,134 @@ func (r *Worker) LatestTaskStats() *models.TaskStatistics {
 	return r.taskStats
 }
 
+// statsSnapshot is the document of StatsSnapshot. It is kept apart from
+// models.TaskStatistics so that its shape stays the same for status
+// endpoints. A stats group the driver doesn't report is null.
+type statsSnapshot struct {
+	Job        string              `json:"job"`
+	Alloc      string              `json:"alloc"`
+	Task       string              `json:"task"`
+	Running    bool                `json:"running"`
+	Time       time.Time           `json:"time"`
+	StatsAt    *time.Time          `json:"stats_at"`
+	Table      *tableStatsSnapshot `json:"table"`
+	Delay      *countSnapshot      `json:"delay"`
+	Throughput *countSnapshot      `json:"throughput"`
+	// ChunkLatency is of the last window in which chunks were executed.
+	ChunkLatency *latencySnapshot `json:"chunk_latency"`
+}
+
+// latencySnapshot holds percentiles in seconds.
+type latencySnapshot struct {
+	Chunks int     `json:"chunks"`
+	P50    float64 `json:"p50"`
+	P95    float64 `json:"p95"`
+	P99    float64 `json:"p99"`
+}
+
+type tableStatsSnapshot struct {
+	Insert int64 `json:"insert"`
+	Update int64 `json:"update"`
+	Delete int64 `json:"delete"`
+}
+
+type countSnapshot struct {
+	Num  uint64 `json:"num"`
+	Time uint64 `json:"time"`
+}
+
+// StatsSnapshot returns the latest stats of the task as a JSON document with
+// its identity and whether it is running. A task which isn't running gets a
+// document without stats rather than nil.
+func (r *Worker) StatsSnapshot() ([]byte, error) {
+	snapshot := statsSnapshot{
+		Job:   r.alloc.Job.Name,
+		Alloc: r.alloc.ID,
+		Task:  r.alloc.Task,
+		Time:  time.Now(),
+	}
+	r.runningLock.Lock()
+	snapshot.Running = r.running
+	r.runningLock.Unlock()
+
+	r.taskStatsLock.RLock()
+	ru, statsAt, latency := r.taskStats, r.lastStatsAt, r.chunkLatency
+	r.taskStatsLock.RUnlock()
+	if snapshot.Running && ru != nil {
+		if !statsAt.IsZero() {
+			snapshot.StatsAt = &statsAt
+		}
+		if ru.TableStats != nil {
+			snapshot.Table = &tableStatsSnapshot{
+				Insert: ru.TableStats.InsertCount,
+				Update: ru.TableStats.UpdateCount,
+				Delete: ru.TableStats.DelCount,
+			}
+		}
+		if ru.DelayCount != nil {
+			snapshot.Delay = &countSnapshot{Num: ru.DelayCount.Num, Time: ru.DelayCount.Time}
+		}
+		if ru.ThroughputStat != nil {
+			snapshot.Throughput = &countSnapshot{Num: ru.ThroughputStat.Num, Time: ru.ThroughputStat.Time}
+		}
+		if latency != nil {
+			snapshot.ChunkLatency = &latencySnapshot{
+				Chunks: latency.Chunks,
+				P50:    latency.P50.Seconds(),
+				P95:    latency.P95.Seconds(),
+				P99:    latency.P99.Seconds(),
+			}
+		}
+	}
+	return json.Marshal(snapshot)
+}
+
 // handleDestroy kills the task handle. In the case that killing fails,
 // handleDestroy will retry with an exponential backoff and will give up at a
 // given limit. It returns whether the task was destroyed and the error
-// associated with the last kill attempt.
+// associated with the last kill attempt. Backing off stops at the deadline of
+// the worker's context, returning context.DeadlineExceeded; cancelling the
+// context alone does not cut the kill short.
 func (r *Worker) handleDestroy() (destroyed bool, err error) {
+	span := r.startSpan(spanDestroy)
+	defer func() { endSpan(span, err) }()
+
+	baseline, limit, failureLimit := r.killBackoff()
+
+	var deadline <-chan time.Time
+	if r.ctx != nil {
+		if d, ok := r.ctx.Deadline(); ok {
+			timer := time.NewTimer(time.Until(d))
+			defer timer.Stop()
+			deadline = timer.C
+		}
+	}
+
+	r.destroyLock.Lock()
+	drainTimeout := r.drainTimeout
+	r.destroyLock.Unlock()
+	if drainTimeout > 0 {
+		err := r.drain(drainTimeout, deadline)
+		if err == nil {
+			return true, nil
+		} else if err == context.DeadlineExceeded {
+			return false, err
+		}
+		r.logger.Warn("agent: Failed to drain task. Shutting it down", "error", err)
+	}
+
 	// Cap the number of times we attempt to kill the task.
-	for i := 0; i < killFailureLimit; i++ {
+	for i := 0; i < failureLimit; i++ {
 		if err = r.handle.Shutdown(); err != nil {
 			// Calculate the new backoff
-			backoff := (1 << (2 * uint64(i))) * killBackoffBaseline
-			if backoff > killBackoffLimit {
-				backoff = killBackoffLimit
-			}
+			backoff := killBackoffDuration(i, baseline, limit)
 
-			r.logger.Errorf("agent: Failed to kill task '%s' for alloc %q. Retrying in %v: %v",
-				r.task.Type, r.alloc.ID, backoff, err)
-			time.Sleep(time.Duration(backoff))
+			r.logger.Error("agent: Failed to kill task. Retrying", "backoff", backoff, "error", err)
+			select {
+			case <-time.After(backoff):
+			case <-deadline:
+				return false, context.DeadlineExceeded
+			}
 		} else {
 			// Kill was successful
 			return true, nil
@@ -628,13 +1768,145 @@ func (r *Worker) handleDestroy() (destroyed bool, err error) {
 	return
 }
 
+// drain asks the handle to drain, waiting for it until timeout or deadline.
+func (r *Worker) drain(timeout time.Duration, deadline <-chan time.Time) error {
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- r.handle.Drain()
+	}()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case err := <-errCh:
+		return err
+	case <-timer.C:
+		return fmt.Errorf("drain timed out after %v", timeout)
+	case <-deadline:
+		return context.DeadlineExceeded
+	}
+}
+
+// killBackoff returns the kill backoff parameters of the client config. Unset,
+// zero or negative values fall back to the package defaults.
+func (r *Worker) killBackoff() (baseline, limit time.Duration, failureLimit int) {
+	baseline, limit, failureLimit = killBackoffBaseline, killBackoffLimit, killFailureLimit
+	if r.config == nil {
+		return
+	}
+	if r.config.KillBackoffBaseline > 0 {
+		baseline = r.config.KillBackoffBaseline
+	}
+	if r.config.KillBackoffLimit > 0 {
+		limit = r.config.KillBackoffLimit
+	}
+	if r.config.KillFailureLimit > 0 {
+		failureLimit = r.config.KillFailureLimit
+	}
+	return
+}
+
+// killBackoffDuration returns the backoff after the given failed kill attempt,
+// (1 << (2*attempt)) * baseline capped at limit. It stops growing once the
+// limit is reached, so a large attempt count can't overflow.
+func killBackoffDuration(attempt int, baseline, limit time.Duration) time.Duration {
+	backoff := baseline
+	for i := 0; i < attempt && backoff < limit; i++ {
+		backoff *= 4
+	}
+	if backoff > limit {
+		backoff = limit
+	}
+	return backoff
+}
+
 // Restart will restart the task
 func (r *Worker) Restart(source, reason string) {
 	reasonStr := fmt.Sprintf("%s: %s", source, reason)
 	event := models.NewTaskEvent(models.TaskRestartSignal).SetRestartReason(reasonStr)
 
+	start := time.Now()
+	if cap(r.restartCh) > 0 {
+		select {
+		case r.restartCh <- event:
+		default:
+			r.logger.Warn("agent: Dropping restart: restart queue is full", "reason", reasonStr, "depth", cap(r.restartCh))
+			r.recordControl(time.Since(start), true)
+			return
+		}
+	} else {
+		select {
+		case r.restartCh <- event:
+		case <-r.waitCh:
+		}
+	}
+	r.recordControl(time.Since(start), false)
+}
+
+// recordControl records how long a Restart or Kill waited to be accepted, and
+// whether it was dropped instead, see addControlGauges.
+func (r *Worker) recordControl(wait time.Duration, dropped bool) {
+	r.controlLock.Lock()
+	defer r.controlLock.Unlock()
+	r.controlWait = wait
+	if dropped {
+		r.controlDropped++
+	}
+}
+
+// HandleID returns the ID of the driver handle of the running task, or "" if
+// it has none, such as before it starts or once it is dead. It lets tooling
+// match driver processes left by an agent that crashed to their worker.
+func (r *Worker) HandleID() string {
+	r.handleLock.Lock()
+	defer r.handleLock.Unlock()
+	if r.handle == nil {
+		return ""
+	}
+	return r.handle.ID()
+}
+
+// Signal forwards an OS signal to the running task, if its driver handle is a
+// driver.Signaler.
+func (r *Worker) Signal(s os.Signal) error {
+	r.handleLock.Lock()
+	handle := r.handle
+	r.handleLock.Unlock()
+
+	r.runningLock.Lock()
+	running := r.running
+	r.runningLock.Unlock()
+	if !running || handle == nil {
+		return fmt.Errorf("cannot signal task %q with %v: task is not running", r.task.Type, s)
+	}
+	signaler, ok := handle.(driver.Signaler)
+	if !ok {
+		return fmt.Errorf("cannot signal task %q with %v: driver %q does not support signals", r.task.Type, s, r.task.Driver)
+	}
+
+	r.logger.Debug("agent: Signaling task", "signal", s)
+	r.setState("", models.NewTaskEvent(models.TaskSignaling).SetTaskSignal(s))
+	return signaler.Signal(s)
+}
+
+// Pause will stop the task from consuming events while keeping its handle and
+// replication position. The task stays paused until Resume is called.
+func (r *Worker) Pause(source, reason string) {
+	reasonStr := fmt.Sprintf("%s: %s", source, reason)
+	event := models.NewTaskEvent(models.TaskPaused).SetPauseReason(reasonStr)
+
+	select {
+	case r.pauseCh <- event:
+	case <-r.waitCh:
+	}
+}
+
+// Resume will continue a task stopped by Pause
+func (r *Worker) Resume(source string) {
+	event := models.NewTaskEvent(models.TaskResumed).SetPauseReason(source)
+
 	select {
-	case r.restartCh <- event:
+	case r.resumeCh <- event:
 	case <-r.waitCh:
 	}
 }
@@ -648,8 +1920,10 @@ func (r *Worker) Kill(source, reason string, fail bool) {
 		event.SetFailsTask()
 	}
 
-	r.logger.Debugf("agent: Killing task %v for alloc %q: %v", r.task.Type, r.alloc.ID, reasonStr)
+	r.logger.Debug("agent: Killing task", "reason", reasonStr)
+	start := time.Now()
 	r.Destroy(event)
+	r.recordControl(time.Since(start), false)
 }
 
 // UnblockStart unblocks the starting of the task. It currently assumes only
@@ -661,11 +1935,57 @@ func (r *Worker) UnblockStart(source string) {
 		return
 	}
 
-	r.logger.Debugf("agent: Unblocking task %v for alloc %q: %v", r.task.Type, r.alloc.ID, source)
+	r.logger.Debug("agent: Unblocking task", "source", source)
 	r.unblocked = true
 	close(r.unblockCh)
 }
 
+// summarizeCompletion puts the totals of a task which completed on its
+// Terminated event: the rows of its last stats, and how long it ran since it
+// was started. They are logged and emitted as a final sample too.
+func (r *Worker) summarizeCompletion(event *models.TaskEvent) {
+	r.taskStatsLock.RLock()
+	ru := r.taskStats
+	r.taskStatsLock.RUnlock()
+
+	var elapsed time.Duration
+	if !r.startedAt.IsZero() {
+		elapsed = time.Since(r.startedAt)
+	}
+	var tableStats *models.TableStats
+	if ru != nil {
+		tableStats = ru.TableStats
+	}
+	event.SetTotals(tableStats, elapsed)
+	r.logger.Info("agent: Task done", "inserted", event.InsertCount, "updated", event.UpdateCount,
+		"deleted", event.DelCount, "elapsed", elapsed)
+	if ru != nil {
+		r.emitStats(ru, completionGauge(elapsed))
+	}
+}
+
+// waitResultError returns the error of a run of the task, nil if it was
+// successful.
+func waitResultError(res *models.WaitResult) error {
+	switch {
+	case res.Successful():
+		return nil
+	case res.Err != nil:
+		return res.Err
+	default:
+		return fmt.Errorf("task exited with code %d", res.ExitCode)
+	}
+}
+
+// killError returns the error of a task destroyed by event, nil unless the
+// event fails it.
+func killError(event *models.TaskEvent) error {
+	if event == nil || !event.FailsTask {
+		return nil
+	}
+	return fmt.Errorf("task killed: %s", event.KillReason)
+}
+
 // Helper function for converting a WaitResult into a TaskTerminated event.
 func (r *Worker) waitErrorToEvent(res *models.WaitResult) *models.TaskEvent {
 	return models.NewTaskEvent(models.TaskTerminated).
@@ -676,12 +1996,19 @@ func (r *Worker) waitErrorToEvent(res *models.WaitResult) *models.TaskEvent {
 // Destroy is used to indicate that the task context should be destroyed. The
 // event parameter provides a context for the destroy.
 func (r *Worker) Destroy(event *models.TaskEvent) {
+	r.DestroyGraceful(event, 0)
+}
+
+// DestroyGraceful is like Destroy, except a running task is first given up to
+// timeout to drain, committing the work in flight, before it is shut down.
+func (r *Worker) DestroyGraceful(event *models.TaskEvent, timeout time.Duration) {
 	r.destroyLock.Lock()
 	defer r.destroyLock.Unlock()
 
 	if r.destroy {
 		return
 	}
+	r.drainTimeout = timeout
 	r.destroy = true
 	r.destroyEvent = event
 	close(r.destroyCh)
@@ -689,33 +2016,52 @@ func (r *Worker) Destroy(event *models.TaskEvent) {
 
 // emitStats emits resource usage stats of tasks to remote metrics collector
 // sinks
-func (r *Worker) emitStats(ru *models.TaskStatistics) {
-	labels := []metrics.Label{{"task_name", fmt.Sprintf("%s_%s", r.alloc.Job.Name, r.alloc.Task)}}
-	if r.config.PublishAllocationMetrics {
-		metrics.SetGaugeWithLabels([]string{"network", "in_msgs"}, float32(ru.MsgStat.InMsgs), labels)
-		metrics.SetGaugeWithLabels([]string{"network", "in_msgs"}, float32(ru.MsgStat.InMsgs), labels)
-		metrics.SetGaugeWithLabels([]string{"network", "out_msgs"}, float32(ru.MsgStat.OutMsgs), labels)
-		metrics.SetGaugeWithLabels([]string{"network", "in_bytes"}, float32(ru.MsgStat.InBytes), labels)
-		metrics.SetGaugeWithLabels([]string{"network", "out_bytes"}, float32(ru.MsgStat.OutBytes), labels)
-		metrics.SetGaugeWithLabels([]string{"buffer", "src_queue_size"}, float32(ru.BufferStat.ExtractorTxQueueSize), labels)
-		metrics.SetGaugeWithLabels([]string{"buffer", "dest_group_queue_size"}, float32(ru.BufferStat.ApplierGroupTxQueueSize), labels)
-		metrics.SetGaugeWithLabels([]string{"buffer", "dest_queue_size"}, float32(ru.BufferStat.ApplierTxQueueSize), labels)
-		metrics.SetGaugeWithLabels([]string{"buffer", "send_by_timeout"}, float32(ru.BufferStat.SendByTimeout), labels)
-		metrics.SetGaugeWithLabels([]string{"buffer", "send_by_size_full"}, float32(ru.BufferStat.SendBySizeFull), labels)
-	}
-	if ru.TableStats != nil && r.config.PublishAllocationMetrics {
-		metrics.SetGaugeWithLabels([]string{"table", "insert"}, float32(ru.TableStats.InsertCount), labels)
-		metrics.SetGaugeWithLabels([]string{"table", "update"}, float32(ru.TableStats.UpdateCount), labels)
-		metrics.SetGaugeWithLabels([]string{"table", "delete"}, float32(ru.TableStats.DelCount), labels)
-	}
-
-	if ru.DelayCount != nil && r.config.PublishAllocationMetrics {
-		metrics.SetGaugeWithLabels([]string{"delay", "num"}, float32(ru.DelayCount.Num), labels)
-		metrics.SetGaugeWithLabels([]string{"delay", "time"}, float32(ru.DelayCount.Time), labels)
-	}
-
-	if ru.ThroughputStat != nil && r.config.PublishAllocationMetrics {
-		metrics.SetGaugeWithLabels([]string{"throughput", "num"}, float32(ru.ThroughputStat.Num), labels)
-		metrics.SetGaugeWithLabels([]string{"throughput", "time"}, float32(ru.ThroughputStat.Time), labels)
+func (r *Worker) emitStats(ru *models.TaskStatistics, extra ...MetricsGauge) {
+	if !r.config.PublishAllocationMetrics {
+		return
+	}
+	sample := newMetricsSample(r.alloc.Job.Name, r.alloc.ID, r.alloc.Task, ru)
+	sample.Gauges = append(sample.Gauges, extra...)
+	if r.restartTracker != nil {
+		total, last, reason := r.restartTracker.Restarts()
+		addRestartGauges(sample, total, last, reason)
+	}
+	r.controlLock.Lock()
+	addControlGauges(sample, r.controlWait, r.controlDropped)
+	r.controlLock.Unlock()
+	if r.config.MetricsHeartbeatInterval > 0 {
+		r.lastSampleLock.Lock()
+		r.lastSample = sample
+		r.lastSampleAt = time.Now()
+		r.lastSampleLock.Unlock()
+		sample = withGauges(sample, heartbeatGauges(true, time.Now())...)
+	}
+	for _, sink := range getMetricsSinks() {
+		sink.EmitSample(sample)
+	}
+}
+
+// emitHeartbeat emits the latest sample again with the heartbeat gauges, so
+// that monitoring can tell an idle task from one whose stats collection is
+// stuck. Once a task is not alive, it emits no more heartbeats.
+func (r *Worker) emitHeartbeat(alive bool) {
+	if !r.config.PublishAllocationMetrics || r.config.MetricsHeartbeatInterval <= 0 {
+		return
+	}
+	r.lastSampleLock.Lock()
+	if r.heartbeatStopped {
+		r.lastSampleLock.Unlock()
+		return
+	}
+	r.heartbeatStopped = !alive
+	sample, at := r.lastSample, r.lastSampleAt
+	r.lastSampleLock.Unlock()
+
+	if sample == nil {
+		sample = &MetricsSample{Job: r.alloc.Job.Name, Alloc: r.alloc.ID, Task: r.alloc.Task}
+	}
+	sample = withGauges(sample, heartbeatGauges(alive, at)...)
+	for _, sink := range getMetricsSinks() {
+		sink.EmitSample(sample)
 	}
 }
@@ -7,9 +7,23 @@
 package client
 
 import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"reflect"
+	"strings"
 	"sync"
+	"syscall"
 	"testing"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+
 	"github.com/actiontech/dtle/internal/client/driver"
 	"github.com/actiontech/dtle/internal/config"
 	log "github.com/actiontech/dtle/internal/logger"
@@ -18,7 +32,7 @@ import (
 
 func TestNewWorker(t *testing.T) {
 	type args struct {
-		logger      *log.Logger
+		logger      Logger
 		config      *config.ClientConfig
 		updater     TaskStateUpdater
 		alloc       *models.Allocation
@@ -45,7 +59,7 @@ func TestWorker_MarkReceived(t *testing.T) {
 	type fields struct {
 		config          *config.ClientConfig
 		updater         TaskStateUpdater
-		logger          *log.Logger
+		logger          Logger
 		alloc           *models.Allocation
 		restartTracker  *RestartTracker
 		running         bool
@@ -113,7 +127,7 @@ func TestWorker_WaitCh(t *testing.T) {
 	type fields struct {
 		config          *config.ClientConfig
 		updater         TaskStateUpdater
-		logger          *log.Logger
+		logger          Logger
 		alloc           *models.Allocation
 		restartTracker  *RestartTracker
 		running         bool
@@ -184,7 +198,7 @@ func TestWorker_stateFilePath(t *testing.T) {
 	type fields struct {
 		config          *config.ClientConfig
 		updater         TaskStateUpdater
-		logger          *log.Logger
+		logger          Logger
 		alloc           *models.Allocation
 		restartTracker  *RestartTracker
 		running         bool
@@ -255,7 +269,7 @@ func TestWorker_SaveState(t *testing.T) {
 	type fields struct {
 		config          *config.ClientConfig
 		updater         TaskStateUpdater
-		logger          *log.Logger
+		logger          Logger
 		alloc           *models.Allocation
 		restartTracker  *RestartTracker
 		running         bool
@@ -326,7 +340,7 @@ func TestWorker_DestroyState(t *testing.T) {
 	type fields struct {
 		config          *config.ClientConfig
 		updater         TaskStateUpdater
-		logger          *log.Logger
+		logger          Logger
 		alloc           *models.Allocation
 		restartTracker  *RestartTracker
 		running         bool
@@ -397,7 +411,7 @@ func TestWorker_setState(t *testing.T) {
 	type fields struct {
 		config          *config.ClientConfig
 		updater         TaskStateUpdater
-		logger          *log.Logger
+		logger          Logger
 		alloc           *models.Allocation
 		restartTracker  *RestartTracker
 		running         bool
@@ -470,7 +484,7 @@ func TestWorker_createDriver(t *testing.T) {
 	type fields struct {
 		config          *config.ClientConfig
 		updater         TaskStateUpdater
-		logger          *log.Logger
+		logger          Logger
 		alloc           *models.Allocation
 		restartTracker  *RestartTracker
 		running         bool
@@ -547,7 +561,7 @@ func TestWorker_Run(t *testing.T) {
 	type fields struct {
 		config          *config.ClientConfig
 		updater         TaskStateUpdater
-		logger          *log.Logger
+		logger          Logger
 		alloc           *models.Allocation
 		restartTracker  *RestartTracker
 		running         bool
@@ -606,7 +620,7 @@ func TestWorker_Run(t *testing.T) {
 				waitCh:          tt.fields.waitCh,
 				persistLock:     tt.fields.persistLock,
 			}
-			r.Run()
+			r.Run(context.Background())
 		})
 	}
 }
@@ -615,7 +629,7 @@ func TestWorker_prestart(t *testing.T) {
 	type fields struct {
 		config          *config.ClientConfig
 		updater         TaskStateUpdater
-		logger          *log.Logger
+		logger          Logger
 		alloc           *models.Allocation
 		restartTracker  *RestartTracker
 		running         bool
@@ -640,7 +654,7 @@ func TestWorker_prestart(t *testing.T) {
 		persistLock     sync.Mutex
 	}
 	type args struct {
-		resultCh chan bool
+		resultCh chan error
 	}
 	tests := []struct {
 		name   string
@@ -687,7 +701,7 @@ func TestWorker_run(t *testing.T) {
 	type fields struct {
 		config          *config.ClientConfig
 		updater         TaskStateUpdater
-		logger          *log.Logger
+		logger          Logger
 		alloc           *models.Allocation
 		restartTracker  *RestartTracker
 		running         bool
@@ -755,7 +769,7 @@ func TestWorker_shouldRestart(t *testing.T) {
 	type fields struct {
 		config          *config.ClientConfig
 		updater         TaskStateUpdater
-		logger          *log.Logger
+		logger          Logger
 		alloc           *models.Allocation
 		restartTracker  *RestartTracker
 		running         bool
@@ -826,7 +840,7 @@ func TestWorker_killTask(t *testing.T) {
 	type fields struct {
 		config          *config.ClientConfig
 		updater         TaskStateUpdater
-		logger          *log.Logger
+		logger          Logger
 		alloc           *models.Allocation
 		restartTracker  *RestartTracker
 		running         bool
@@ -898,7 +912,7 @@ func TestWorker_startTask(t *testing.T) {
 	type fields struct {
 		config          *config.ClientConfig
 		updater         TaskStateUpdater
-		logger          *log.Logger
+		logger          Logger
 		alloc           *models.Allocation
 		restartTracker  *RestartTracker
 		running         bool
@@ -969,7 +983,7 @@ func TestWorker_collectResourceUsageStats(t *testing.T) {
 	type fields struct {
 		config          *config.ClientConfig
 		updater         TaskStateUpdater
-		logger          *log.Logger
+		logger          Logger
 		alloc           *models.Allocation
 		restartTracker  *RestartTracker
 		running         bool
@@ -1041,7 +1055,7 @@ func TestWorker_LatestTaskStats(t *testing.T) {
 	type fields struct {
 		config          *config.ClientConfig
 		updater         TaskStateUpdater
-		logger          *log.Logger
+		logger          Logger
 		alloc           *models.Allocation
 		restartTracker  *RestartTracker
 		running         bool
@@ -1112,7 +1126,7 @@ func TestWorker_handleDestroy(t *testing.T) {
 	type fields struct {
 		config          *config.ClientConfig
 		updater         TaskStateUpdater
-		logger          *log.Logger
+		logger          Logger
 		alloc           *models.Allocation
 		restartTracker  *RestartTracker
 		running         bool
@@ -1136,13 +1150,49 @@ func TestWorker_handleDestroy(t *testing.T) {
 		waitCh          chan struct{}
 		persistLock     sync.Mutex
 	}
+	logger := NewLogger(log.New(os.Stderr, log.ErrorLevel))
+	alloc := &models.Allocation{ID: "alloc"}
+	task := &models.Task{Type: models.TaskTypeSrc}
 	tests := []struct {
 		name          string
 		fields        fields
 		wantDestroyed bool
 		wantErr       bool
+		wantShutdowns int
+		wantSleep     time.Duration
 	}{
-		// TODO: Add test cases.
+		{
+			name: "first attempt",
+			fields: fields{
+				config: &config.ClientConfig{KillBackoffBaseline: time.Millisecond},
+				logger: logger, alloc: alloc, task: task,
+				handle: newMockHandle(),
+			},
+			wantDestroyed: true,
+			wantShutdowns: 1,
+		},
+		{
+			name: "retry capped at limit",
+			fields: fields{
+				config: &config.ClientConfig{KillBackoffBaseline: time.Millisecond, KillBackoffLimit: 4 * time.Millisecond},
+				logger: logger, alloc: alloc, task: task,
+				handle: &mockHandle{waitCh: make(chan *models.WaitResult, 1), failShutdowns: 3},
+			},
+			wantDestroyed: true,
+			wantShutdowns: 4,
+			wantSleep:     (1 + 4 + 4) * time.Millisecond,
+		},
+		{
+			name: "give up at failure limit",
+			fields: fields{
+				config: &config.ClientConfig{KillBackoffBaseline: time.Millisecond, KillBackoffLimit: 2 * time.Millisecond, KillFailureLimit: 7},
+				logger: logger, alloc: alloc, task: task,
+				handle: &mockHandle{waitCh: make(chan *models.WaitResult, 1), failShutdowns: 10},
+			},
+			wantErr:       true,
+			wantShutdowns: 7,
+			wantSleep:     (1 + 2*6) * time.Millisecond,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -1173,7 +1223,9 @@ func TestWorker_handleDestroy(t *testing.T) {
 				waitCh:          tt.fields.waitCh,
 				persistLock:     tt.fields.persistLock,
 			}
+			start := time.Now()
 			gotDestroyed, err := r.handleDestroy()
+			slept := time.Since(start)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("Worker.handleDestroy() error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -1181,6 +1233,12 @@ func TestWorker_handleDestroy(t *testing.T) {
 			if gotDestroyed != tt.wantDestroyed {
 				t.Errorf("Worker.handleDestroy() = %v, want %v", gotDestroyed, tt.wantDestroyed)
 			}
+			if got := tt.fields.handle.(*mockHandle).shutdowns; got != tt.wantShutdowns {
+				t.Errorf("Worker.handleDestroy() shutdowns = %v, want %v", got, tt.wantShutdowns)
+			}
+			if slept < tt.wantSleep {
+				t.Errorf("Worker.handleDestroy() slept %v, want at least %v", slept, tt.wantSleep)
+			}
 		})
 	}
 }
@@ -1189,7 +1247,7 @@ func TestWorker_Restart(t *testing.T) {
 	type fields struct {
 		config          *config.ClientConfig
 		updater         TaskStateUpdater
-		logger          *log.Logger
+		logger          Logger
 		alloc           *models.Allocation
 		restartTracker  *RestartTracker
 		running         bool
@@ -1262,7 +1320,7 @@ func TestWorker_Kill(t *testing.T) {
 	type fields struct {
 		config          *config.ClientConfig
 		updater         TaskStateUpdater
-		logger          *log.Logger
+		logger          Logger
 		alloc           *models.Allocation
 		restartTracker  *RestartTracker
 		running         bool
@@ -1336,7 +1394,7 @@ func TestWorker_UnblockStart(t *testing.T) {
 	type fields struct {
 		config          *config.ClientConfig
 		updater         TaskStateUpdater
-		logger          *log.Logger
+		logger          Logger
 		alloc           *models.Allocation
 		restartTracker  *RestartTracker
 		running         bool
@@ -1408,7 +1466,7 @@ func TestWorker_waitErrorToEvent(t *testing.T) {
 	type fields struct {
 		config          *config.ClientConfig
 		updater         TaskStateUpdater
-		logger          *log.Logger
+		logger          Logger
 		alloc           *models.Allocation
 		restartTracker  *RestartTracker
 		running         bool
@@ -1483,7 +1541,7 @@ func TestWorker_Destroy(t *testing.T) {
 	type fields struct {
 		config          *config.ClientConfig
 		updater         TaskStateUpdater
-		logger          *log.Logger
+		logger          Logger
 		alloc           *models.Allocation
 		restartTracker  *RestartTracker
 		running         bool
@@ -1555,7 +1613,7 @@ func TestWorker_emitStats(t *testing.T) {
 	type fields struct {
 		config          *config.ClientConfig
 		updater         TaskStateUpdater
-		logger          *log.Logger
+		logger          Logger
 		alloc           *models.Allocation
 		restartTracker  *RestartTracker
 		running         bool
@@ -1622,3 +1680,2082 @@ func TestWorker_emitStats(t *testing.T) {
 		})
 	}
 }
+
+func TestWorker_StatsSnapshot(t *testing.T) {
+	task := models.NewTask()
+	task.Type = models.TaskTypeSrc
+	alloc := &models.Allocation{ID: "alloc", Task: task.Type, Job: &models.Job{Name: "job", Tasks: []*models.Task{task}}}
+	r := NewWorker(&recordingLogger{}, &config.ClientConfig{}, nil, alloc, task, nil)
+
+	snapshot := func() map[string]interface{} {
+		buf, err := r.StatsSnapshot()
+		if err != nil {
+			t.Fatalf("StatsSnapshot() error = %v", err)
+		}
+		var doc map[string]interface{}
+		if err := json.Unmarshal(buf, &doc); err != nil {
+			t.Fatalf("StatsSnapshot() = %s, not json: %v", buf, err)
+		}
+		keys := []string{"job", "alloc", "task", "running", "time", "stats_at", "table", "delay", "throughput", "chunk_latency"}
+		for _, key := range keys {
+			if _, ok := doc[key]; !ok {
+				t.Errorf("StatsSnapshot() = %s, missing %q", buf, key)
+			}
+		}
+		if len(doc) != len(keys) {
+			t.Errorf("StatsSnapshot() = %s, want only %v", buf, keys)
+		}
+		if doc["job"] != "job" || doc["alloc"] != "alloc" || doc["task"] != models.TaskTypeSrc {
+			t.Errorf("StatsSnapshot() identity = %v %v %v", doc["job"], doc["alloc"], doc["task"])
+		}
+		if _, err := time.Parse(time.RFC3339Nano, doc["time"].(string)); err != nil {
+			t.Errorf("StatsSnapshot() time = %v: %v", doc["time"], err)
+		}
+		return doc
+	}
+
+	doc := snapshot()
+	if doc["running"] != false || doc["stats_at"] != nil || doc["table"] != nil || doc["delay"] != nil || doc["throughput"] != nil {
+		t.Errorf("StatsSnapshot() of a stopped task = %v", doc)
+	}
+
+	r.running = true
+	r.taskStats = testTaskStatistics()
+	r.lastStatsAt = time.Now()
+	doc = snapshot()
+	if doc["running"] != true || doc["stats_at"] == nil {
+		t.Errorf("StatsSnapshot() of a running task = %v", doc)
+	}
+	want := map[string]interface{}{"insert": 10.0, "update": 20.0, "delete": 30.0}
+	if !reflect.DeepEqual(doc["table"], want) {
+		t.Errorf("StatsSnapshot() table = %v, want %v", doc["table"], want)
+	}
+	if want := map[string]interface{}{"num": 600.0, "time": 7.0}; !reflect.DeepEqual(doc["throughput"], want) {
+		t.Errorf("StatsSnapshot() throughput = %v, want %v", doc["throughput"], want)
+	}
+	if doc["chunk_latency"] != nil {
+		t.Errorf("StatsSnapshot() chunk_latency = %v, want null before any chunk", doc["chunk_latency"])
+	}
+
+	r.chunkLatency = &chunkLatency{Chunks: 3, P50: time.Second, P95: 2 * time.Second, P99: 3 * time.Second}
+	doc = snapshot()
+	want = map[string]interface{}{"chunks": 3.0, "p50": 1.0, "p95": 2.0, "p99": 3.0}
+	if !reflect.DeepEqual(doc["chunk_latency"], want) {
+		t.Errorf("StatsSnapshot() chunk_latency = %v, want %v", doc["chunk_latency"], want)
+	}
+}
+
+// sampleRecorder is a MetricsSink keeping the samples emitted to it.
+type sampleRecorder struct {
+	samples []*MetricsSample
+}
+
+func (s *sampleRecorder) EmitSample(sample *MetricsSample) {
+	s.samples = append(s.samples, sample)
+}
+
+func TestWorker_emitStatsRestarts(t *testing.T) {
+	sink := &sampleRecorder{}
+	metricsSinksLock.Lock()
+	saved := metricsSinks
+	metricsSinks = []MetricsSink{sink}
+	metricsSinksLock.Unlock()
+	defer func() {
+		metricsSinksLock.Lock()
+		metricsSinks = saved
+		metricsSinksLock.Unlock()
+	}()
+
+	task := models.NewTask()
+	task.Type = models.TaskTypeSrc
+	alloc := &models.Allocation{ID: "alloc", Task: task.Type, Job: &models.Job{Name: "job", Tasks: []*models.Task{task}}}
+	r := NewWorker(&recordingLogger{}, &config.ClientConfig{PublishAllocationMetrics: true}, nil, alloc, task, nil)
+
+	gauges := func() map[string]MetricsGauge {
+		r.emitStats(&models.TaskStatistics{})
+		got := make(map[string]MetricsGauge)
+		for _, g := range sink.samples[len(sink.samples)-1].Gauges {
+			got[strings.Join(g.Name, ".")] = g
+		}
+		return got
+	}
+	got := gauges()
+	if g := got["restart.total"]; g.Value != 0 || !g.Counter {
+		t.Errorf("restart.total before restarts = %+v, want a 0 counter", g)
+	}
+	if _, ok := got["restart.since_last_seconds"]; ok {
+		t.Errorf("restart.since_last_seconds emitted before any restart")
+	}
+
+	for i := 0; i < 3; i++ {
+		r.restartTracker.SetWaitResult(models.NewWaitResult(1, errors.New("failed")))
+		if state, _ := r.restartTracker.GetState(); state != models.TaskRestarting {
+			t.Fatalf("GetState() = %v, want %v", state, models.TaskRestarting)
+		}
+	}
+	total, _, _ := r.restartTracker.Restarts()
+	got = gauges()
+	if g := got["restart.total"]; g.Value != float32(total) || total != 3 || g.Labels["reason"] != ReasonWithinPolicy {
+		t.Errorf("restart.total = %+v, want %v labeled %q", g, total, ReasonWithinPolicy)
+	}
+	if g, ok := got["restart.since_last_seconds"]; !ok || g.Value < 0 || g.Value > 5 {
+		t.Errorf("restart.since_last_seconds = %+v, %v", g, ok)
+	}
+}
+
+func TestWorker_RestartAcceptWait(t *testing.T) {
+	task := models.NewTask()
+	task.Type = models.TaskTypeSrc
+	alloc := &models.Allocation{ID: "alloc", Task: task.Type, Job: &models.Job{Name: "job", Tasks: []*models.Task{task}}}
+	controlStats := func(r *Worker) (time.Duration, int) {
+		r.controlLock.Lock()
+		defer r.controlLock.Unlock()
+		return r.controlWait, r.controlDropped
+	}
+
+	// The run loop is busy, and takes the restart a while after it is asked
+	// for.
+	r := NewWorker(&recordingLogger{}, &config.ClientConfig{}, nil, alloc, task, nil)
+	busy := 50 * time.Millisecond
+	go func() {
+		time.Sleep(busy)
+		<-r.restartCh
+	}()
+	r.Restart("test", "busy run loop")
+	if wait, dropped := controlStats(r); wait < busy || dropped != 0 {
+		t.Errorf("restart waited %v with %d dropped, want at least %v and none", wait, dropped, busy)
+	}
+
+	// With a queue, restarts are accepted right away until it is full, and
+	// then dropped rather than blocking.
+	logger := &recordingLogger{}
+	r = NewWorker(logger, &config.ClientConfig{RestartQueueDepth: 1}, nil, alloc, task, nil)
+	done := make(chan struct{})
+	go func() {
+		r.Restart("test", "first")
+		r.Restart("test", "second")
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("restart blocked on a full queue")
+	}
+	if _, dropped := controlStats(r); dropped != 1 || len(r.restartCh) != 1 {
+		t.Errorf("dropped %d restarts with %d queued, want 1 and 1", dropped, len(r.restartCh))
+	}
+	if event := <-r.restartCh; event.RestartReason != "test: first" {
+		t.Errorf("queued restart %q, want the first", event.RestartReason)
+	}
+	logged := false
+	for _, e := range logger.entries {
+		logged = logged || e.level == "warn" && e.fields["reason"] == "test: second"
+	}
+	if !logged {
+		t.Errorf("the dropped restart wasn't logged: %+v", logger.entries)
+	}
+
+	sample := &MetricsSample{}
+	addControlGauges(sample, busy, 1)
+	if g := sample.Gauges[0]; g.Value != float32(busy.Seconds()) {
+		t.Errorf("control.accept_wait_seconds = %+v, want %v", g, busy.Seconds())
+	}
+	if g := sample.Gauges[1]; g.Value != 1 || !g.Counter {
+		t.Errorf("control.restarts_dropped = %+v, want a counter of 1", g)
+	}
+}
+
+// lockedSampleRecorder is a sampleRecorder safe to emit to from the worker
+// while the test reads it.
+type lockedSampleRecorder struct {
+	lock sync.Mutex
+	sampleRecorder
+}
+
+func (s *lockedSampleRecorder) EmitSample(sample *MetricsSample) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.sampleRecorder.EmitSample(sample)
+}
+
+// heartbeats returns the liveness of the heartbeats emitted so far, and the
+// sample age of the last one.
+func (s *lockedSampleRecorder) heartbeats() (liveness []float32, age float32) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for _, sample := range s.samples {
+		for _, g := range sample.Gauges {
+			switch strings.Join(g.Name, ".") {
+			case "heartbeat.liveness":
+				liveness = append(liveness, g.Value)
+			case "heartbeat.last_sample_age_seconds":
+				age = g.Value
+			}
+		}
+	}
+	return liveness, age
+}
+
+func TestWorker_MetricsHeartbeat(t *testing.T) {
+	sink := &lockedSampleRecorder{}
+	metricsSinksLock.Lock()
+	saved := metricsSinks
+	metricsSinks = []MetricsSink{sink}
+	metricsSinksLock.Unlock()
+	defer func() {
+		metricsSinksLock.Lock()
+		metricsSinks = saved
+		metricsSinksLock.Unlock()
+	}()
+
+	// Stats are collected once, then not for an hour.
+	handle := newMockHandle()
+	handle.pool = &models.PoolStats{MaxOpen: 10}
+	r := newRunningTestWorker(context.Background(), handle, make(chan string, 100), func(r *Worker) {
+		r.config.PublishAllocationMetrics = true
+		r.config.MetricsHeartbeatInterval = 20 * time.Millisecond
+		r.config.StatsCollectionInterval = time.Hour
+	})
+
+	deadline := time.After(5 * time.Second)
+	for {
+		if liveness, _ := sink.heartbeats(); len(liveness) >= 4 {
+			break
+		}
+		select {
+		case <-deadline:
+			t.Fatal("no heartbeats without new samples")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	liveness, age := sink.heartbeats()
+	for i, l := range liveness {
+		if l != 1 {
+			t.Errorf("heartbeat %d liveness = %v, want 1", i, l)
+		}
+	}
+	if age <= 0 {
+		t.Errorf("last sample age = %v, want it to grow", age)
+	}
+
+	// A stopped task emits a liveness of 0 once.
+	r.Destroy(models.NewTaskEvent(models.TaskKilled))
+	<-r.WaitCh()
+	time.Sleep(50 * time.Millisecond)
+	liveness, _ = sink.heartbeats()
+	if liveness[len(liveness)-1] != 0 || liveness[len(liveness)-2] != 1 {
+		t.Errorf("heartbeats %v, want a final liveness of 0", liveness)
+	}
+}
+
+// mockHandle is a DriverHandle that records the calls made by the Worker.
+type mockHandle struct {
+	lock      sync.Mutex
+	paused    int
+	resumed   int
+	shutdowns int
+	waitCh    chan *models.WaitResult
+
+	// failShutdowns is the number of Shutdown calls that fail before one
+	// succeeds.
+	failShutdowns int
+
+	checkpoint []byte
+
+	// statsCh, if set, is signalled on every Stats call.
+	statsCh chan struct{}
+
+	// drains counts Drain calls. Drain finishes the task after drainDelay,
+	// unless drainBlock is set, in which case it never returns.
+	drains     int
+	drainDelay time.Duration
+	drainBlock chan struct{}
+
+	// pool is returned by PoolStats. Stats is implemented while it is set.
+	pool *models.PoolStats
+}
+
+func newMockHandle() *mockHandle {
+	return &mockHandle{waitCh: make(chan *models.WaitResult, 1)}
+}
+
+func (h *mockHandle) ID() string {
+	return `{"DriverConfig":{}}`
+}
+
+func (h *mockHandle) WaitCh() chan *models.WaitResult {
+	return h.waitCh
+}
+
+func (h *mockHandle) Shutdown() error {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.shutdowns++
+	if h.shutdowns <= h.failShutdowns {
+		return errors.New("target busy")
+	}
+	if h.shutdowns == h.failShutdowns+1 {
+		h.waitCh <- models.NewWaitResult(0, nil)
+	}
+	return nil
+}
+
+func (h *mockHandle) Drain() error {
+	h.lock.Lock()
+	h.drains++
+	h.lock.Unlock()
+	if h.drainBlock != nil {
+		<-h.drainBlock
+		return nil
+	}
+	time.Sleep(h.drainDelay)
+	h.waitCh <- models.NewWaitResult(0, nil)
+	return nil
+}
+
+func (h *mockHandle) Stats() (*models.TaskStatistics, error) {
+	if h.statsCh != nil {
+		select {
+		case h.statsCh <- struct{}{}:
+		default:
+		}
+	}
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if h.pool != nil {
+		return &models.TaskStatistics{}, nil
+	}
+	return nil, driver.DriverStatsNotImplemented
+}
+
+func (h *mockHandle) PoolStats() *models.PoolStats {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	return h.pool
+}
+
+func (h *mockHandle) setPool(pool *models.PoolStats) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.pool = pool
+}
+
+func (h *mockHandle) Throttle(factor float64) {
+}
+
+func (h *mockHandle) Checkpoint() []byte {
+	return h.checkpoint
+}
+
+func (h *mockHandle) Pause() error {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.paused++
+	return nil
+}
+
+func (h *mockHandle) Resume() error {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.resumed++
+	return nil
+}
+
+// newRunningTestWorker returns a Worker running with ctx against handle, as it
+// would be after restoring a running task.
+func newRunningTestWorker(ctx context.Context, handle driver.DriverHandle, states chan string, opts ...func(*Worker)) *Worker {
+	task := models.NewTask()
+	task.Type = models.TaskTypeSrc
+	task.Driver = models.TaskDriverMySQL
+	task.Config = map[string]interface{}{}
+	alloc := &models.Allocation{
+		ID:   "alloc",
+		Task: task.Type,
+		Job:  &models.Job{ID: "job", Name: "job", Tasks: []*models.Task{task}},
+	}
+	updater := func(taskName, state string, event *models.TaskEvent) {
+		if state != "" {
+			states <- state
+		}
+	}
+	r := NewWorker(NewLogger(log.New(os.Stderr, log.ErrorLevel)), &config.ClientConfig{}, updater,
+		alloc, task, make(chan *models.TaskUpdate, 100))
+	r.handle = handle
+	r.running = true
+	for _, opt := range opts {
+		opt(r)
+	}
+	go r.Run(ctx)
+	return r
+}
+
+func expectState(t *testing.T, states chan string, want string) {
+	for {
+		select {
+		case got := <-states:
+			if got == want {
+				return
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for state %q", want)
+		}
+	}
+}
+
+func TestWorker_PauseResume(t *testing.T) {
+	handle := newMockHandle()
+	states := make(chan string, 100)
+	r := newRunningTestWorker(context.Background(), handle, states)
+
+	r.Pause("test", "let target catch up")
+	expectState(t, states, models.TaskStatePaused)
+	// A second pause is a no-op.
+	r.Pause("test", "again")
+
+	r.Resume("test")
+	expectState(t, states, models.TaskStateRunning)
+
+	r.restartTracker.lock.Lock()
+	tripped := r.restartTracker.waitRes != nil || r.restartTracker.restartTriggered
+	r.restartTracker.lock.Unlock()
+	if tripped {
+		t.Errorf("pause and resume must not be seen by the restart tracker")
+	}
+
+	r.Destroy(models.NewTaskEvent(models.TaskKilled))
+	<-r.WaitCh()
+	expectState(t, states, models.TaskStateDead)
+
+	if handle.paused != 1 || handle.resumed != 1 {
+		t.Errorf("paused = %v, resumed = %v, want 1, 1", handle.paused, handle.resumed)
+	}
+}
+
+// slowExitHandle is a handle which exits a while after it is shut down.
+type slowExitHandle struct {
+	*mockHandle
+	once   sync.Once
+	exited chan struct{}
+}
+
+func (h *slowExitHandle) Shutdown() error {
+	h.once.Do(func() {
+		go func() {
+			time.Sleep(50 * time.Millisecond)
+			close(h.exited)
+			h.waitCh <- models.NewWaitResult(0, nil)
+		}()
+	})
+	return nil
+}
+
+// restartDriver starts tasks with mock handles, telling on started whether
+// the handle before had exited.
+type restartDriver struct {
+	before  *slowExitHandle
+	started chan bool
+}
+
+func (d *restartDriver) Start(ctx *driver.ExecContext, task *models.Task) (driver.DriverHandle, error) {
+	select {
+	case <-d.before.exited:
+		d.started <- true
+	default:
+		d.started <- false
+	}
+	return newMockHandle(), nil
+}
+
+func (d *restartDriver) Validate(task *models.Task) (*models.TaskValidateResponse, error) {
+	return &models.TaskValidateResponse{}, nil
+}
+
+func TestWorker_RestartPaused(t *testing.T) {
+	handle := &slowExitHandle{mockHandle: newMockHandle(), exited: make(chan struct{})}
+	drv := &restartDriver{before: handle, started: make(chan bool, 1)}
+	driver.BuiltinDrivers["restart-test"] = func(*driver.DriverContext) driver.Driver { return drv }
+	defer delete(driver.BuiltinDrivers, "restart-test")
+
+	states := make(chan string, 100)
+	r := newRunningTestWorker(context.Background(), handle, states, func(r *Worker) {
+		r.task.Driver = "restart-test"
+	})
+	r.Pause("test", "let target catch up")
+	expectState(t, states, models.TaskStatePaused)
+
+	// The task starts again only once the paused handle exited.
+	r.Restart("test", "config changed")
+	select {
+	case exited := <-drv.started:
+		if !exited {
+			t.Error("restarted the paused task before its handle exited")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the paused task didn't restart")
+	}
+
+	r.Destroy(models.NewTaskEvent(models.TaskKilled))
+	<-r.WaitCh()
+}
+
+func TestWorker_WaitResult(t *testing.T) {
+	awaitResult := func(t *testing.T, r *Worker) error {
+		select {
+		case err := <-r.WaitResult():
+			return err
+		case <-time.After(5 * time.Second):
+			t.Fatal("task didn't finish")
+		}
+		return nil
+	}
+
+	t.Run("failed", func(t *testing.T) {
+		handle := newMockHandle()
+		r := newRunningTestWorker(context.Background(), handle, make(chan string, 100))
+		handle.waitCh <- models.NewWaitResult(2, errors.New("binlog purged"))
+		if err := awaitResult(t, r); err == nil || err.Error() != "binlog purged" {
+			t.Errorf("WaitResult() = %v, want the error of the task", err)
+		}
+	})
+	t.Run("destroyed", func(t *testing.T) {
+		r := newRunningTestWorker(context.Background(), newMockHandle(), make(chan string, 100))
+		r.Destroy(models.NewTaskEvent(models.TaskKilled))
+		if err := awaitResult(t, r); err != nil {
+			t.Errorf("WaitResult() = %v, want nil", err)
+		}
+	})
+	t.Run("killed", func(t *testing.T) {
+		r := newRunningTestWorker(context.Background(), newMockHandle(), make(chan string, 100))
+		r.Kill("agent", "over its memory limit", true)
+		if err := awaitResult(t, r); err == nil || !strings.Contains(err.Error(), "over its memory limit") {
+			t.Errorf("WaitResult() = %v, want the kill reason", err)
+		}
+	})
+}
+
+func TestWorker_nilWaitResult(t *testing.T) {
+	handle := newMockHandle()
+	states := make(chan string, 100)
+	events := make(chan *models.TaskEvent, 100)
+	r := newRunningTestWorker(context.Background(), handle, states, func(r *Worker) {
+		r.updater = func(taskName, state string, event *models.TaskEvent) {
+			if state != "" {
+				states <- state
+			}
+			if event != nil {
+				events <- event
+			}
+		}
+	})
+
+	// The driver closing its wait channel delivers nil results.
+	close(handle.waitCh)
+	expectState(t, states, models.TaskStatePending)
+
+	var failed, restarting bool
+	for !restarting {
+		select {
+		case event := <-events:
+			failed = failed || event.Type == models.TaskDriverFailure && event.DriverError == errNilWaitResult.Error()
+			restarting = event.Type == models.TaskRestarting
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for the restart")
+		}
+	}
+	if !failed {
+		t.Errorf("no driver failure event before the restart")
+	}
+
+	r.Destroy(models.NewTaskEvent(models.TaskKilled))
+	select {
+	case <-r.WaitCh():
+	case <-time.After(5 * time.Second):
+		t.Fatalf("run loop did not exit after destroy")
+	}
+	expectState(t, states, models.TaskStateDead)
+}
+
+// signalHandle is a mockHandle recording the signals forwarded to it.
+type signalHandle struct {
+	*mockHandle
+	signals []os.Signal
+}
+
+func (h *signalHandle) Signal(s os.Signal) error {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.signals = append(h.signals, s)
+	return nil
+}
+
+func TestWorker_Signal(t *testing.T) {
+	handle := &signalHandle{mockHandle: newMockHandle()}
+	states := make(chan string, 100)
+	events := make(chan *models.TaskEvent, 100)
+	r := newRunningTestWorker(context.Background(), handle, states, func(r *Worker) {
+		r.updater = func(taskName, state string, event *models.TaskEvent) {
+			if event != nil {
+				events <- event
+			}
+		}
+	})
+
+	if err := r.Signal(syscall.SIGHUP); err != nil {
+		t.Fatalf("Signal() failed: %v", err)
+	}
+	handle.lock.Lock()
+	if len(handle.signals) != 1 || handle.signals[0] != syscall.SIGHUP {
+		t.Errorf("handle got signals %v, want [SIGHUP]", handle.signals)
+	}
+	handle.lock.Unlock()
+	select {
+	case event := <-events:
+		if event.Type != models.TaskSignaling || event.TaskSignal != syscall.SIGHUP.String() {
+			t.Errorf("got event %+v, want a signaling event", event)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the signaling event")
+	}
+
+	// A handle which doesn't support signals fails them.
+	r.handleLock.Lock()
+	r.handle = handle.mockHandle
+	r.handleLock.Unlock()
+	if err := r.Signal(syscall.SIGHUP); err == nil || !strings.Contains(err.Error(), "does not support signals") {
+		t.Errorf("Signal() of an unsupported handle = %v, want an error", err)
+	}
+
+	r.Destroy(models.NewTaskEvent(models.TaskKilled))
+	select {
+	case <-r.WaitCh():
+	case <-time.After(5 * time.Second):
+		t.Fatalf("run loop did not exit after destroy")
+	}
+
+	// A task which isn't running can't be signaled.
+	task := models.NewTask()
+	task.Type = models.TaskTypeSrc
+	alloc := &models.Allocation{ID: "alloc", Task: task.Type, Job: &models.Job{Name: "job", Tasks: []*models.Task{task}}}
+	stopped := NewWorker(&recordingLogger{}, &config.ClientConfig{}, nil, alloc, task, nil)
+	if err := stopped.Signal(syscall.SIGHUP); err == nil || !strings.Contains(err.Error(), "not running") {
+		t.Errorf("Signal() of a stopped task = %v, want an error", err)
+	}
+}
+
+func TestWorker_HandleID(t *testing.T) {
+	driver.BuiltinDrivers["bounds-test"] = func(*driver.DriverContext) driver.Driver { return &boundsDriver{} }
+	defer delete(driver.BuiltinDrivers, "bounds-test")
+
+	task := models.NewTask()
+	task.Type = models.TaskTypeSrc
+	task.Driver = "bounds-test"
+	task.Config = map[string]interface{}{}
+	alloc := &models.Allocation{ID: "alloc", Task: task.Type, Job: &models.Job{Tasks: []*models.Task{task}}}
+	updater := func(taskName, state string, event *models.TaskEvent) {}
+	r := NewWorker(&recordingLogger{}, &config.ClientConfig{}, updater, alloc, task, make(chan *models.TaskUpdate, 100))
+	if id := r.HandleID(); id != "" {
+		t.Errorf("HandleID() = %q before the start, want none", id)
+	}
+	go r.Run(context.Background())
+
+	deadline := time.After(5 * time.Second)
+	for !r.Health().Running {
+		select {
+		case <-deadline:
+			t.Fatal("task didn't start")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	if id, want := r.HandleID(), newMockHandle().ID(); id != want {
+		t.Errorf("HandleID() = %q, want %q", id, want)
+	}
+
+	r.Destroy(models.NewTaskEvent(models.TaskKilled))
+	select {
+	case <-r.WaitCh():
+	case <-time.After(5 * time.Second):
+		t.Fatal("task didn't finish")
+	}
+	if id := r.HandleID(); id != "" {
+		t.Errorf("HandleID() = %q once destroyed, want none", id)
+	}
+}
+
+// failDriver fails to start its tasks with err.
+type failDriver struct {
+	err error
+}
+
+func (d *failDriver) Start(ctx *driver.ExecContext, task *models.Task) (driver.DriverHandle, error) {
+	return nil, d.err
+}
+
+func (d *failDriver) Validate(task *models.Task) (*models.TaskValidateResponse, error) {
+	return &models.TaskValidateResponse{}, nil
+}
+
+func TestWorker_RestartInfo(t *testing.T) {
+	driver.BuiltinDrivers["fail-test"] = func(*driver.DriverContext) driver.Driver { return &failDriver{err: io.EOF} }
+	defer delete(driver.BuiltinDrivers, "fail-test")
+
+	task := models.NewTask()
+	task.Type = models.TaskTypeSrc
+	task.Driver = "fail-test"
+	task.Config = map[string]interface{}{}
+	alloc := &models.Allocation{ID: "alloc", Task: task.Type, Job: &models.Job{Tasks: []*models.Task{task}}}
+	updater := func(taskName, state string, event *models.TaskEvent) {}
+	r := NewWorker(&recordingLogger{}, &config.ClientConfig{}, updater, alloc, task, make(chan *models.TaskUpdate, 100))
+	if got := r.RestartInfo(); got != (RestartInfo{}) {
+		t.Errorf("RestartInfo() before the task ran = %+v, want none", got)
+	}
+	go r.Run(context.Background())
+
+	// The connection lost while starting is retried after a delay.
+	deadline := time.After(5 * time.Second)
+	var info RestartInfo
+	for info.State != models.TaskRestarting {
+		select {
+		case <-deadline:
+			t.Fatalf("task didn't restart, RestartInfo() = %+v", info)
+		case <-time.After(10 * time.Millisecond):
+		}
+		info = r.RestartInfo()
+	}
+	if info.Reason != ReasonWithinPolicy {
+		t.Errorf("RestartInfo() reason = %q, want %q", info.Reason, ReasonWithinPolicy)
+	}
+	if info.Wait <= 0 || !info.Until.After(time.Now()) {
+		t.Errorf("RestartInfo() = %+v, want a wait until later", info)
+	}
+
+	r.Destroy(models.NewTaskEvent(models.TaskKilled))
+	select {
+	case <-r.WaitCh():
+	case <-time.After(5 * time.Second):
+		t.Fatal("task didn't finish")
+	}
+}
+
+func TestWorker_FailFastOnSchemaError(t *testing.T) {
+	startErrs := map[string]error{
+		"fail-schema-test": &mysql.MySQLError{Number: 1054, Message: "Unknown column 'email' in 'field list'"},
+		"fail-conn-test":   io.EOF,
+	}
+	for name, err := range startErrs {
+		err := err
+		driver.BuiltinDrivers[name] = func(*driver.DriverContext) driver.Driver { return &failDriver{err: err} }
+		defer delete(driver.BuiltinDrivers, name)
+	}
+	newWorker := func(driverName string) (*Worker, chan *models.TaskEvent) {
+		task := models.NewTask()
+		task.Type = models.TaskTypeSrc
+		task.Driver = driverName
+		task.Config = map[string]interface{}{}
+		alloc := &models.Allocation{ID: "alloc", Task: task.Type, Job: &models.Job{Tasks: []*models.Task{task}}}
+		events := make(chan *models.TaskEvent, 100)
+		updater := func(taskName, state string, event *models.TaskEvent) {
+			if event != nil {
+				events <- event
+			}
+		}
+		cfg := &config.ClientConfig{FailFastOnSchemaError: true}
+		return NewWorker(&recordingLogger{}, cfg, updater, alloc, task, make(chan *models.TaskUpdate, 100)), events
+	}
+
+	// An unknown column fails the task without a restart.
+	r, events := newWorker("fail-schema-test")
+	go r.Run(context.Background())
+	select {
+	case <-r.WaitCh():
+	case <-time.After(5 * time.Second):
+		t.Fatal("task didn't fail")
+	}
+	var failed bool
+	for len(events) > 0 {
+		event := <-events
+		if event.Type == models.TaskRestarting {
+			t.Errorf("task restarted on a schema error")
+		}
+		if event.Type == models.TaskDriverFailure && event.FailsTask {
+			failed = true
+		}
+	}
+	if !failed {
+		t.Errorf("no driver failure failing the task")
+	}
+	if info := r.RestartInfo(); info.State == models.TaskRestarting {
+		t.Errorf("RestartInfo() = %+v, want no restart", info)
+	}
+
+	// A lost connection is still left to the restart policy.
+	r, _ = newWorker("fail-conn-test")
+	go r.Run(context.Background())
+	deadline := time.After(5 * time.Second)
+	for r.RestartInfo().State != models.TaskRestarting {
+		select {
+		case <-deadline:
+			t.Fatalf("task didn't restart, RestartInfo() = %+v", r.RestartInfo())
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	r.Destroy(models.NewTaskEvent(models.TaskKilled))
+	select {
+	case <-r.WaitCh():
+	case <-time.After(5 * time.Second):
+		t.Fatal("task didn't finish")
+	}
+}
+
+func TestWorker_DestroyWhilePaused(t *testing.T) {
+	handle := newMockHandle()
+	states := make(chan string, 100)
+	r := newRunningTestWorker(context.Background(), handle, states)
+
+	r.Pause("test", "let target catch up")
+	expectState(t, states, models.TaskStatePaused)
+
+	r.Destroy(models.NewTaskEvent(models.TaskKilled))
+	select {
+	case <-r.WaitCh():
+	case <-time.After(5 * time.Second):
+		t.Fatalf("run loop did not exit after destroy")
+	}
+	expectState(t, states, models.TaskStateDead)
+
+	if handle.shutdowns != 1 {
+		t.Errorf("shutdowns = %v, want 1", handle.shutdowns)
+	}
+	if r.paused {
+		t.Errorf("worker still marked as paused after destroy")
+	}
+}
+
+func TestWorker_killBackoff(t *testing.T) {
+	tests := []struct {
+		name             string
+		config           *config.ClientConfig
+		wantBaseline     time.Duration
+		wantLimit        time.Duration
+		wantFailureLimit int
+	}{
+		{"nil config", nil, killBackoffBaseline, killBackoffLimit, killFailureLimit},
+		{"unset", &config.ClientConfig{}, killBackoffBaseline, killBackoffLimit, killFailureLimit},
+		{"negative", &config.ClientConfig{KillBackoffBaseline: -1, KillBackoffLimit: -1, KillFailureLimit: -1},
+			killBackoffBaseline, killBackoffLimit, killFailureLimit},
+		{"configured", &config.ClientConfig{KillBackoffBaseline: time.Second, KillBackoffLimit: time.Hour, KillFailureLimit: 50},
+			time.Second, time.Hour, 50},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &Worker{config: tt.config}
+			baseline, limit, failureLimit := r.killBackoff()
+			if baseline != tt.wantBaseline || limit != tt.wantLimit || failureLimit != tt.wantFailureLimit {
+				t.Errorf("Worker.killBackoff() = %v, %v, %v, want %v, %v, %v", baseline, limit, failureLimit,
+					tt.wantBaseline, tt.wantLimit, tt.wantFailureLimit)
+			}
+		})
+	}
+}
+
+func Test_killBackoffDuration(t *testing.T) {
+	tests := []struct {
+		attempt int
+		want    time.Duration
+	}{
+		{0, 5 * time.Second},
+		{1, 20 * time.Second},
+		{2, 80 * time.Second},
+		{3, 2 * time.Minute},
+		{100, 2 * time.Minute},
+	}
+	for _, tt := range tests {
+		if got := killBackoffDuration(tt.attempt, killBackoffBaseline, killBackoffLimit); got != tt.want {
+			t.Errorf("killBackoffDuration(%v) = %v, want %v", tt.attempt, got, tt.want)
+		}
+	}
+}
+
+func newCheckpointTestWorker(stateDir string) *Worker {
+	task := models.NewTask()
+	task.Type = models.TaskTypeSrc
+	task.Config = map[string]interface{}{}
+	return &Worker{
+		config:      &config.ClientConfig{StateDir: stateDir},
+		logger:      NewLogger(log.New(os.Stderr, log.ErrorLevel)),
+		alloc:       &models.Allocation{ID: "alloc", JobID: "job"},
+		task:        task,
+		workUpdates: make(chan *models.TaskUpdate, 10),
+	}
+}
+
+func TestWorker_RestoreState(t *testing.T) {
+	dir, err := ioutil.TempDir("", "worker")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	gtid := "3f2b1a4c-0000-0000-0000-000000000001:1-10"
+	r := newCheckpointTestWorker(dir)
+	r.handle = &mockHandle{checkpoint: []byte(gtid)}
+	if err := r.SaveState(); err != nil {
+		t.Fatalf("SaveState() = %v", err)
+	}
+	// Losing the handle keeps the last checkpoint.
+	r.handle = nil
+	if err := r.SaveState(); err != nil {
+		t.Fatalf("SaveState() = %v", err)
+	}
+
+	restored := newCheckpointTestWorker(dir)
+	if err := restored.RestoreState(); err != nil {
+		t.Fatalf("RestoreState() = %v", err)
+	}
+	if got := restored.task.Config["Gtid"]; got != gtid {
+		t.Errorf("restored Gtid = %v, want %v", got, gtid)
+	}
+
+	// A position handed down with the task wins over the local one.
+	restored = newCheckpointTestWorker(dir)
+	restored.task.Config["Gtid"] = "3f2b1a4c-0000-0000-0000-000000000001:1-20"
+	if err := restored.RestoreState(); err != nil {
+		t.Fatalf("RestoreState() = %v", err)
+	}
+	if got := restored.task.Config["Gtid"]; got != "3f2b1a4c-0000-0000-0000-000000000001:1-20" {
+		t.Errorf("restored Gtid = %v, want the task's own", got)
+	}
+
+	// Nothing saved yet.
+	empty := newCheckpointTestWorker(filepath.Join(dir, "none"))
+	if err := empty.RestoreState(); err != nil || len(empty.task.Config) != 0 {
+		t.Errorf("RestoreState() without a file = %v, config %v", err, empty.task.Config)
+	}
+}
+
+// boundsHandle reports the bounds of the tables of a task.
+type boundsHandle struct {
+	*mockHandle
+	bounds map[string]*models.KeyBounds
+}
+
+func (h *boundsHandle) KeyBounds() map[string]*models.KeyBounds {
+	return h.bounds
+}
+
+// boundsDriver starts boundsHandles, recording the bounds it is passed.
+type boundsDriver struct {
+	bounds map[string]*models.KeyBounds
+
+	lock   sync.Mutex
+	passed []map[string]*models.KeyBounds
+}
+
+func (d *boundsDriver) Start(ctx *driver.ExecContext, task *models.Task) (driver.DriverHandle, error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.passed = append(d.passed, ctx.KeyBounds)
+	return &boundsHandle{mockHandle: newMockHandle(), bounds: d.bounds}, nil
+}
+
+func (d *boundsDriver) Validate(task *models.Task) (*models.TaskValidateResponse, error) {
+	return &models.TaskValidateResponse{}, nil
+}
+
+func TestWorker_KeyBounds(t *testing.T) {
+	dir, err := ioutil.TempDir("", "worker")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	cached := map[string]*models.KeyBounds{
+		"`db`.`tbl`": {Min: []string{"1"}, Max: []string{"1000000"}, AppendOnly: true},
+	}
+	r := newCheckpointTestWorker(dir)
+	r.handle = &boundsHandle{mockHandle: newMockHandle(), bounds: cached}
+	if err := r.SaveState(); err != nil {
+		t.Fatalf("SaveState() = %v", err)
+	}
+
+	// The restarted task is passed the cached bounds.
+	drv := &boundsDriver{bounds: map[string]*models.KeyBounds{
+		"`db`.`tbl`": {Min: []string{"1"}, Max: []string{"900"}},
+	}}
+	driver.BuiltinDrivers["bounds-test"] = func(*driver.DriverContext) driver.Driver { return drv }
+	defer delete(driver.BuiltinDrivers, "bounds-test")
+	restored := newCheckpointTestWorker(dir)
+	restored.task.Driver = "bounds-test"
+	restored.alloc.Job = &models.Job{ID: "job", Tasks: []*models.Task{restored.task}}
+	if err := restored.RestoreState(); err != nil {
+		t.Fatalf("RestoreState() = %v", err)
+	}
+	if err := restored.startTask(); err != nil {
+		t.Fatalf("startTask() = %v", err)
+	}
+	defer restored.releaseStart()
+	drv.lock.Lock()
+	passed := drv.passed
+	drv.lock.Unlock()
+	if len(passed) != 1 || !reflect.DeepEqual(passed[0], cached) {
+		t.Fatalf("started with bounds %v, want the cached %v", passed, cached)
+	}
+
+	// A task which found them again, the cached ones being stale, replaces
+	// them.
+	if err := restored.SaveState(); err != nil {
+		t.Fatalf("SaveState() = %v", err)
+	}
+	again := newCheckpointTestWorker(dir)
+	if err := again.RestoreState(); err != nil {
+		t.Fatalf("RestoreState() = %v", err)
+	}
+	if !reflect.DeepEqual(again.keyBounds, drv.bounds) {
+		t.Errorf("restored bounds %v, want those found again %v", again.keyBounds, drv.bounds)
+	}
+}
+
+func TestWorker_RestoreState_legacy(t *testing.T) {
+	dir, err := ioutil.TempDir("", "worker")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	r := newCheckpointTestWorker(dir)
+	legacy := `{"Version":"2.0","Task":{"Type":"Src","Config":{"Gtid":"3f2b1a4c-0000-0000-0000-000000000001:1-5"}},"HandleID":"","PayloadRendered":true}`
+	if err := os.MkdirAll(filepath.Dir(r.stateFilePath()), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(r.stateFilePath(), []byte(legacy), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := r.RestoreState(); err != nil {
+		t.Fatalf("RestoreState() = %v", err)
+	}
+	if got := r.task.Config["Gtid"]; got != "3f2b1a4c-0000-0000-0000-000000000001:1-5" {
+		t.Errorf("restored Gtid = %v", got)
+	}
+	if !r.payloadRendered {
+		t.Errorf("payloadRendered not restored")
+	}
+}
+
+func TestWorker_Run_cancelBeforeStart(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	var events []*models.TaskEvent
+	task := &models.Task{Type: models.TaskTypeSrc, Driver: "unknown"}
+	r := &Worker{
+		config: &config.ClientConfig{},
+		logger: NewLogger(log.New(os.Stderr, log.ErrorLevel)),
+		alloc:  &models.Allocation{ID: "alloc", Job: &models.Job{Name: "job"}},
+		task:   task,
+		updater: func(taskName, state string, event *models.TaskEvent) {
+			if state != models.TaskStateDead {
+				t.Errorf("unexpected state %q", state)
+			}
+			events = append(events, event)
+		},
+		waitCh: make(chan struct{}),
+	}
+	r.Run(ctx)
+
+	// The unknown driver would have failed with a setup error.
+	if len(events) != 1 || events[0].Type != models.TaskKilling || events[0].KillReason != "context: context canceled" {
+		t.Errorf("Run() events = %#v", events)
+	}
+}
+
+func TestWorker_Run_cancelWhileRunning(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	handle := newMockHandle()
+	handle.statsCh = make(chan struct{}, 1)
+	states := make(chan string, 100)
+	r := newRunningTestWorker(ctx, handle, states)
+
+	// Stats are collected once the run loop is up.
+	<-handle.statsCh
+	cancel()
+	select {
+	case <-r.WaitCh():
+	case <-time.After(5 * time.Second):
+		t.Fatalf("run loop did not exit after cancel")
+	}
+	expectState(t, states, models.TaskStateDead)
+	if handle.shutdowns != 1 {
+		t.Errorf("shutdowns = %v, want 1", handle.shutdowns)
+	}
+	if r.destroyEvent == nil || r.destroyEvent.KillReason != "context: context canceled" {
+		t.Errorf("destroy event = %#v", r.destroyEvent)
+	}
+}
+
+func TestWorker_Run_cancelDuringKill(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	handle := &mockHandle{waitCh: make(chan *models.WaitResult, 1), failShutdowns: 100}
+
+	var lock sync.Mutex
+	var killEvent *models.TaskEvent
+	updater := func(taskName, state string, event *models.TaskEvent) {
+		lock.Lock()
+		defer lock.Unlock()
+		if event != nil && (event.Type == models.TaskKilled || event.Type == models.TaskKillTimedOut) {
+			killEvent = event
+		}
+	}
+	task := &models.Task{Type: models.TaskTypeSrc, Driver: models.TaskDriverMySQL, Config: map[string]interface{}{}, ConfigLock: &sync.RWMutex{}}
+	alloc := &models.Allocation{ID: "alloc", Task: task.Type, Job: &models.Job{Name: "job", Tasks: []*models.Task{task}}}
+	// A long backoff: only the deadline can end the kill in time.
+	cfg := &config.ClientConfig{KillBackoffBaseline: time.Hour}
+	r := NewWorker(NewLogger(log.New(os.Stderr, log.ErrorLevel)), cfg, updater, alloc, task, make(chan *models.TaskUpdate, 100))
+	r.handle = handle
+	r.running = true
+	// The handle never exits; the run loop stays blocked waiting for it.
+	go r.Run(ctx)
+
+	deadline := time.After(5 * time.Second)
+	for {
+		lock.Lock()
+		got := killEvent
+		lock.Unlock()
+		if got != nil {
+			if got.Type != models.TaskKillTimedOut || got.KillError != context.DeadlineExceeded.Error() {
+				t.Errorf("kill event = %#v", got)
+			}
+			break
+		}
+		select {
+		case <-deadline:
+			t.Fatalf("kill did not time out")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	if handle.shutdowns != 1 {
+		t.Errorf("shutdowns = %v, want 1", handle.shutdowns)
+	}
+	handle.waitCh <- models.NewWaitResult(0, nil)
+	<-r.WaitCh()
+}
+
+func TestWorker_statsInterval(t *testing.T) {
+	tests := []struct {
+		name   string
+		config time.Duration
+		task   time.Duration
+		want   time.Duration
+	}{
+		{"client default", time.Second, 0, time.Second},
+		{"task override", time.Second, 5 * time.Second, 5 * time.Second},
+		{"faster task", 10 * time.Second, time.Second, time.Second},
+		{"too small task", time.Second, time.Millisecond, minStatsInterval},
+		{"too small client", 0, 0, minStatsInterval},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &Worker{
+				config: &config.ClientConfig{StatsCollectionInterval: tt.config},
+				task:   &models.Task{StatsInterval: tt.task},
+			}
+			if got := r.statsInterval(); got != tt.want {
+				t.Errorf("Worker.statsInterval() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWorker_RecentEvents(t *testing.T) {
+	task := &models.Task{Type: models.TaskTypeSrc}
+	alloc := &models.Allocation{ID: "alloc", Task: task.Type, Job: &models.Job{Tasks: []*models.Task{task}}}
+	updater := func(taskName, state string, event *models.TaskEvent) {}
+	r := NewWorker(&recordingLogger{}, &config.ClientConfig{RecentTaskEvents: 3}, updater, alloc, task, nil)
+
+	if got := r.RecentEvents(); len(got) != 0 {
+		t.Fatalf("RecentEvents() = %v, want none", got)
+	}
+
+	r.setState(models.TaskStatePending, models.NewTaskEvent(models.TaskReceived))
+	r.setState(models.TaskStateRunning, models.NewTaskEvent(models.TaskStarted))
+	r.setState(models.TaskStateDead, nil) // not an event
+	if got := r.RecentEvents(); len(got) != 2 || got[0].Type != models.TaskReceived || got[1].Type != models.TaskStarted {
+		t.Errorf("RecentEvents() = %v", got)
+	}
+
+	r.setState(models.TaskStatePaused, models.NewTaskEvent(models.TaskPaused))
+	r.setState(models.TaskStateRunning, models.NewTaskEvent(models.TaskResumed))
+	r.setState("", models.NewTaskEvent(models.TaskKilling))
+
+	var types []string
+	for _, e := range r.RecentEvents() {
+		types = append(types, e.Type)
+	}
+	if want := []string{models.TaskPaused, models.TaskResumed, models.TaskKilling}; !reflect.DeepEqual(types, want) {
+		t.Errorf("RecentEvents() = %v, want %v", types, want)
+	}
+
+	var states []string
+	for _, e := range r.RecentTransitions() {
+		states = append(states, e.State)
+		if e.Time.IsZero() {
+			t.Errorf("transition %v has no time", e.Event.Type)
+		}
+	}
+	if want := []string{models.TaskStatePaused, models.TaskStateRunning, ""}; !reflect.DeepEqual(states, want) {
+		t.Errorf("RecentTransitions() states = %v, want %v", states, want)
+	}
+
+	if got := NewWorker(&recordingLogger{}, &config.ClientConfig{}, updater, alloc, task, nil); cap(got.recentEvents) != defaultRecentEvents {
+		t.Errorf("default capacity = %v, want %v", cap(got.recentEvents), defaultRecentEvents)
+	}
+}
+
+func TestWorker_MaxRuntime(t *testing.T) {
+	handle := newMockHandle()
+	states := make(chan string, 100)
+	// The mock only finishes when it is shut down.
+	r := newRunningTestWorker(context.Background(), handle, states, func(r *Worker) {
+		r.task.MaxRuntime = 20 * time.Millisecond
+	})
+
+	select {
+	case <-r.WaitCh():
+	case <-time.After(5 * time.Second):
+		t.Fatalf("task was not killed after its max runtime")
+	}
+	expectState(t, states, models.TaskStateDead)
+
+	r.destroyLock.Lock()
+	event := r.destroyEvent
+	r.destroyLock.Unlock()
+	if event == nil || event.KillReason != "agent: "+maxRuntimeExceeded || !event.FailsTask {
+		t.Errorf("destroy event = %#v", event)
+	}
+	if handle.shutdowns != 1 {
+		t.Errorf("shutdowns = %v, want 1", handle.shutdowns)
+	}
+}
+
+// usageHandle reports a task using memory bytes, and records the factors it
+// is throttled to.
+type usageHandle struct {
+	*mockHandle
+	memory uint64
+
+	statsCalls int
+	throttles  []float64
+}
+
+func (h *usageHandle) Stats() (*models.TaskStatistics, error) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.statsCalls++
+	return &models.TaskStatistics{MemoryBytes: h.memory}, nil
+}
+
+func (h *usageHandle) Throttle(factor float64) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.throttles = append(h.throttles, factor)
+}
+
+func TestWorker_ResourceLimits(t *testing.T) {
+	handle := &usageHandle{mockHandle: newMockHandle(), memory: 200}
+	states := make(chan string, 100)
+	r := newRunningTestWorker(context.Background(), handle, states, func(r *Worker) {
+		r.task.ResourceLimits = &models.ResourceLimits{MemoryBytes: 100, BreachSamples: 3}
+	})
+
+	select {
+	case <-r.WaitCh():
+	case <-time.After(5 * time.Second):
+		t.Fatalf("task was not killed over its resource limits")
+	}
+	expectState(t, states, models.TaskStateDead)
+
+	r.destroyLock.Lock()
+	event := r.destroyEvent
+	r.destroyLock.Unlock()
+	if event == nil || event.KillReason != "agent: "+resourceLimitExceeded || !event.FailsTask {
+		t.Errorf("destroy event = %#v", event)
+	}
+	handle.lock.Lock()
+	defer handle.lock.Unlock()
+	// It is throttled on every sample over the limit until the third.
+	if handle.statsCalls < 3 {
+		t.Errorf("killed after %d samples, want 3", handle.statsCalls)
+	}
+	if want := []float64{0.5, 0.25, 0.125}; !reflect.DeepEqual(handle.throttles, want) {
+		t.Errorf("throttled to %v, want %v", handle.throttles, want)
+	}
+}
+
+func TestWorker_armMaxRuntime(t *testing.T) {
+	r := &Worker{task: &models.Task{}}
+	if timer := r.armMaxRuntime(); timer != nil {
+		t.Errorf("armMaxRuntime() without MaxRuntime = %v, want nil", timer)
+	}
+}
+
+func TestWorker_Health(t *testing.T) {
+	task := &models.Task{Type: models.TaskTypeSrc}
+	alloc := &models.Allocation{ID: "alloc", Task: task.Type, Job: &models.Job{Tasks: []*models.Task{task}}}
+	updater := func(taskName, state string, event *models.TaskEvent) {}
+	r := NewWorker(&recordingLogger{}, &config.ClientConfig{StatsCollectionInterval: time.Second}, updater, alloc, task, nil)
+
+	// Not running.
+	if got := r.Health(); got.Running || got.StatsStale || got.LastEvent != "" || got.Restarts != 0 {
+		t.Errorf("Health() = %+v, want an idle task", got)
+	}
+
+	// Running, but stats stopped coming in.
+	r.setState(models.TaskStateRunning, models.NewTaskEvent(models.TaskStarted))
+	r.runningLock.Lock()
+	r.running = true
+	r.runningLock.Unlock()
+	r.restartTracker.SetWaitResult(models.NewWaitResult(1, errors.New("exited"))).GetState()
+	r.taskStatsLock.Lock()
+	r.lastStatsAt = time.Now().Add(-3 * time.Second)
+	r.taskStatsLock.Unlock()
+
+	got := r.Health()
+	if !got.Running || !got.StatsStale || got.LastEvent != models.TaskStarted || got.LastEventAt.IsZero() || got.Restarts != 1 {
+		t.Errorf("Health() = %+v, want a running task with stale stats", got)
+	}
+
+	r.taskStatsLock.Lock()
+	r.lastStatsAt = time.Now()
+	r.taskStatsLock.Unlock()
+	if got := r.Health(); got.StatsStale {
+		t.Errorf("Health() = %+v, want fresh stats", got)
+	}
+}
+
+func TestWorker_DestroyGraceful(t *testing.T) {
+	block := make(chan struct{})
+	defer close(block)
+	tests := []struct {
+		name          string
+		handle        *mockHandle
+		wantShutdowns int
+	}{
+		{"drained", &mockHandle{waitCh: make(chan *models.WaitResult, 1), drainDelay: 10 * time.Millisecond}, 0},
+		{"drain timed out", &mockHandle{waitCh: make(chan *models.WaitResult, 1), drainBlock: block}, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			states := make(chan string, 100)
+			r := newRunningTestWorker(context.Background(), tt.handle, states)
+
+			r.DestroyGraceful(models.NewTaskEvent(models.TaskKilled), 100*time.Millisecond)
+			select {
+			case <-r.WaitCh():
+			case <-time.After(5 * time.Second):
+				t.Fatalf("run loop did not exit after destroy")
+			}
+			expectState(t, states, models.TaskStateDead)
+
+			tt.handle.lock.Lock()
+			defer tt.handle.lock.Unlock()
+			if tt.handle.drains != 1 {
+				t.Errorf("drains = %v, want 1", tt.handle.drains)
+			}
+			if tt.handle.shutdowns != tt.wantShutdowns {
+				t.Errorf("shutdowns = %v, want %v", tt.handle.shutdowns, tt.wantShutdowns)
+			}
+		})
+	}
+}
+
+func TestWorker_DestroyGraceful_deadline(t *testing.T) {
+	block := make(chan struct{})
+	defer close(block)
+	handle := &mockHandle{waitCh: make(chan *models.WaitResult, 1), drainBlock: block}
+	r := &Worker{
+		logger: &recordingLogger{},
+		handle: handle,
+		config: &config.ClientConfig{},
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	r.ctx = ctx
+	r.drainTimeout = time.Hour
+
+	destroyed, err := r.handleDestroy()
+	if destroyed || err != context.DeadlineExceeded {
+		t.Errorf("handleDestroy() = %v, %v, want false, %v", destroyed, err, context.DeadlineExceeded)
+	}
+	if handle.shutdowns != 0 {
+		t.Errorf("shutdowns = %v, want 0", handle.shutdowns)
+	}
+}
+
+// dryRunDriver is a driver whose dry run passes queries to the executor. Its
+// tasks run them once started.
+type dryRunDriver struct {
+	queries []string
+	// args are those of the queries, if any
+	args   [][]interface{}
+	starts int
+}
+
+func (d *dryRunDriver) Start(ctx *driver.ExecContext, task *models.Task) (driver.DriverHandle, error) {
+	d.starts++
+	if ctx.Capture != nil {
+		d.DryRun(ctx, task, ctx.Capture)
+	}
+	return &mockHandle{waitCh: make(chan *models.WaitResult, 1)}, nil
+}
+
+func (d *dryRunDriver) Validate(task *models.Task) (*models.TaskValidateResponse, error) {
+	return &models.TaskValidateResponse{}, nil
+}
+
+func (d *dryRunDriver) DryRun(ctx *driver.ExecContext, task *models.Task, exec driver.Executor) error {
+	for i, query := range d.queries {
+		var args []interface{}
+		if i < len(d.args) {
+			args = d.args[i]
+		}
+		exec(query, args)
+	}
+	return nil
+}
+
+func TestWorker_DryRun(t *testing.T) {
+	drv := &dryRunDriver{queries: []string{"insert into `db`.`tbl` (`id`) values (?)", "delete from `db`.`tbl` where ((`id` = ?))"}}
+	driver.BuiltinDrivers["dry-run-test"] = func(*driver.DriverContext) driver.Driver { return drv }
+	defer delete(driver.BuiltinDrivers, "dry-run-test")
+
+	task := &models.Task{Type: models.TaskTypeDest, Driver: "dry-run-test", Config: map[string]interface{}{"DryRun": true}, ConfigLock: &sync.RWMutex{}}
+	alloc := &models.Allocation{ID: "alloc", Task: task.Type, Job: &models.Job{Tasks: []*models.Task{task}}}
+	var last *models.TaskEvent
+	var lastState string
+	updater := func(taskName, state string, event *models.TaskEvent) {
+		lastState, last = state, event
+	}
+	logger := &recordingLogger{}
+	r := NewWorker(logger, &config.ClientConfig{}, updater, alloc, task, nil)
+	go r.Run(context.Background())
+
+	select {
+	case <-r.WaitCh():
+	case <-time.After(5 * time.Second):
+		t.Fatal("dry run didn't finish")
+	}
+	if drv.starts != 0 {
+		t.Errorf("Start() called %v times in a dry run", drv.starts)
+	}
+	if !reflect.DeepEqual(r.DryRunQueries(), drv.queries) {
+		t.Errorf("DryRunQueries() = %v, want %v", r.DryRunQueries(), drv.queries)
+	}
+	state := &models.TaskState{State: lastState, Events: []*models.TaskEvent{last}}
+	if !state.Successful() {
+		t.Errorf("task ended %v with %+v, want it dead and successful", lastState, last)
+	}
+	logged := 0
+	for _, e := range logger.entries {
+		if e.msg == "agent: Dry run query" {
+			logged++
+		}
+	}
+	if logged != len(drv.queries) {
+		t.Errorf("logged %v queries, want %v", logged, len(drv.queries))
+	}
+}
+
+func TestWorker_DryRunCapture(t *testing.T) {
+	drv := &dryRunDriver{
+		queries: []string{"insert into `db`.`tbl` (`id`, `name`) values (?, ?)", "delete from `db`.`tbl` where ((`id` = ?))"},
+		args:    [][]interface{}{{1, "it's"}, {[]byte{0x01}}},
+	}
+	driver.BuiltinDrivers["dry-run-test"] = func(*driver.DriverContext) driver.Driver { return drv }
+	defer delete(driver.BuiltinDrivers, "dry-run-test")
+
+	dir, err := ioutil.TempDir("", "dtle-capture")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	want := "insert into `db`.`tbl` (`id`, `name`) values (1, 'it\\'s');\n" +
+		"delete from `db`.`tbl` where ((`id` = x'01'));\n"
+	// A second dry run appends to the file of the first.
+	for run := 1; run <= 2; run++ {
+		task := models.NewTask()
+		task.Type = models.TaskTypeDest
+		task.Driver = "dry-run-test"
+		task.Config = map[string]interface{}{"DryRun": true, "CaptureSQL": true}
+		alloc := &models.Allocation{ID: "alloc", Task: task.Type, Job: &models.Job{Tasks: []*models.Task{task}}}
+		var last *models.TaskEvent
+		var lastState string
+		updater := func(taskName, state string, event *models.TaskEvent) {
+			lastState, last = state, event
+		}
+		r := NewWorker(&recordingLogger{}, &config.ClientConfig{StateDir: dir}, updater, alloc, task, nil)
+		go r.Run(context.Background())
+		select {
+		case <-r.WaitCh():
+		case <-time.After(5 * time.Second):
+			t.Fatal("dry run didn't finish")
+		}
+		state := &models.TaskState{State: lastState, Events: []*models.TaskEvent{last}}
+		if !state.Successful() {
+			t.Fatalf("task ended %v with %+v, want it dead and successful", lastState, last)
+		}
+		captured, err := ioutil.ReadFile(r.captureFilePath())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := string(captured); got != strings.Repeat(want, run) {
+			t.Errorf("run %d captured %q, want %q", run, got, strings.Repeat(want, run))
+		}
+	}
+
+	// Capturing needs a state dir.
+	task := &models.Task{Type: models.TaskTypeDest, Driver: "dry-run-test",
+		Config: map[string]interface{}{"DryRun": true, "CaptureSQL": true}, ConfigLock: &sync.RWMutex{}}
+	alloc := &models.Allocation{ID: "alloc", Task: task.Type, Job: &models.Job{Tasks: []*models.Task{task}}}
+	var last *models.TaskEvent
+	updater := func(taskName, state string, event *models.TaskEvent) {
+		last = event
+	}
+	r := NewWorker(&recordingLogger{}, &config.ClientConfig{}, updater, alloc, task, nil)
+	go r.Run(context.Background())
+	select {
+	case <-r.WaitCh():
+	case <-time.After(5 * time.Second):
+		t.Fatal("dry run didn't finish")
+	}
+	if last == nil || last.Type != models.TaskSetupFailure || !last.FailsTask {
+		t.Errorf("dry run without a state dir ended with %+v, want a setup failure", last)
+	}
+}
+
+func TestWorker_Capture(t *testing.T) {
+	drv := &dryRunDriver{
+		queries: []string{"insert into `db`.`tbl` (`id`, `name`) values (?, ?)", "delete from `db`.`tbl` where ((`id` = ?))"},
+		args:    [][]interface{}{{1, "it's"}, {[]byte{0x01}}},
+	}
+	driver.BuiltinDrivers["dry-run-test"] = func(*driver.DriverContext) driver.Driver { return drv }
+	defer delete(driver.BuiltinDrivers, "dry-run-test")
+
+	dir, err := ioutil.TempDir("", "dtle-capture")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	task := models.NewTask()
+	task.Type = models.TaskTypeDest
+	task.Driver = "dry-run-test"
+	task.Config = map[string]interface{}{"CaptureSQL": true}
+	alloc := &models.Allocation{ID: "alloc", Task: task.Type, Job: &models.Job{Tasks: []*models.Task{task}}}
+	updater := func(taskName, state string, event *models.TaskEvent) {}
+	r := NewWorker(&recordingLogger{}, &config.ClientConfig{StateDir: dir}, updater, alloc, task, make(chan *models.TaskUpdate, 100))
+	go r.Run(context.Background())
+
+	deadline := time.After(5 * time.Second)
+	for !r.Health().Running {
+		select {
+		case <-deadline:
+			t.Fatal("task didn't start")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	r.Destroy(models.NewTaskEvent(models.TaskKilled))
+	select {
+	case <-r.WaitCh():
+	case <-time.After(5 * time.Second):
+		t.Fatal("task didn't finish")
+	}
+
+	// The queries the task ran are captured with their args.
+	captured, err := ioutil.ReadFile(r.captureFilePath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "insert into `db`.`tbl` (`id`, `name`) values (1, 'it\\'s');\n" +
+		"delete from `db`.`tbl` where ((`id` = x'01'));\n"
+	if got := string(captured); got != want {
+		t.Errorf("captured %q, want %q", got, want)
+	}
+	if r.capture != nil {
+		t.Error("the capture file is still open once the task finished")
+	}
+}
+
+func TestWorker_openCaptureEncrypted(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dtle-capture")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// The statements would be in plaintext next to the encrypted state.
+	task := models.NewTask()
+	task.Type = models.TaskTypeDest
+	task.Config = map[string]interface{}{"CaptureSQL": true}
+	cfg := &config.ClientConfig{StateDir: dir, StateEncryptionKey: base64.StdEncoding.EncodeToString(make([]byte, 16))}
+	r := &Worker{config: cfg, alloc: &models.Allocation{ID: "alloc"}, task: task}
+	if capture, err := r.openCapture(); err == nil {
+		capture.Close()
+		t.Fatal("openCapture() opened a plaintext capture file of a client encrypting its state")
+	}
+	if _, err := os.Stat(r.captureFilePath()); !os.IsNotExist(err) {
+		t.Errorf("capture file exists, err = %v", err)
+	}
+}
+
+func TestWorker_Backpressure(t *testing.T) {
+	handle := newMockHandle()
+	handle.pool = &models.PoolStats{MaxOpen: 10, InUse: 10}
+	states := make(chan string, 100)
+	r := newRunningTestWorker(context.Background(), handle, states, func(r *Worker) {
+		r.config.BackpressureThreshold = 0.9
+	})
+	defer func() {
+		r.Destroy(models.NewTaskEvent(models.TaskKilled))
+		<-r.WaitCh()
+	}()
+
+	waitBackpressure := func(want bool) {
+		deadline := time.Now().Add(5 * time.Second)
+		for r.Backpressure() != want {
+			if time.Now().After(deadline) {
+				t.Fatalf("Backpressure() did not become %v", want)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	waitBackpressure(true)
+	handle.lock.Lock()
+	paused := handle.paused
+	handle.lock.Unlock()
+	if paused != 1 {
+		t.Errorf("paused = %v, want 1", paused)
+	}
+
+	handle.setPool(&models.PoolStats{MaxOpen: 10, InUse: 2})
+	waitBackpressure(false)
+	handle.lock.Lock()
+	resumed := handle.resumed
+	handle.lock.Unlock()
+	if resumed != 1 {
+		t.Errorf("resumed = %v, want 1", resumed)
+	}
+
+	var types []string
+	for _, e := range r.RecentEvents() {
+		if e.Type == models.TaskBackpressure || e.Type == models.TaskBackpressureReleased {
+			types = append(types, e.Type)
+		}
+	}
+	if !reflect.DeepEqual(types, []string{models.TaskBackpressure, models.TaskBackpressureReleased}) {
+		t.Errorf("backpressure events = %v", types)
+	}
+}
+
+// hookDriver is a driver that records the hook statements it runs and the
+// starts of the task in order.
+type hookDriver struct {
+	lock   sync.Mutex
+	ran    []string
+	fail   string
+	handle *mockHandle
+}
+
+func (d *hookDriver) Start(ctx *driver.ExecContext, task *models.Task) (driver.DriverHandle, error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.ran = append(d.ran, "start")
+	return d.handle, nil
+}
+
+func (d *hookDriver) Validate(task *models.Task) (*models.TaskValidateResponse, error) {
+	return &models.TaskValidateResponse{}, nil
+}
+
+func (d *hookDriver) RunSQL(ctx *driver.ExecContext, task *models.Task, statements []string) error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	for _, statement := range statements {
+		if statement == d.fail {
+			return errors.New("failed")
+		}
+		d.ran = append(d.ran, statement)
+	}
+	return nil
+}
+
+func (d *hookDriver) statements() []string {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	return append([]string(nil), d.ran...)
+}
+
+func TestWorker_SQLHooks(t *testing.T) {
+	tests := []struct {
+		name    string
+		fail    string
+		want    []string
+		wantErr bool
+	}{
+		{"in order", "", []string{"set foreign_key_checks=0", "create table staging (id int)", "start", "drop table staging"}, false},
+		// what the statements before the failing one set up is cleaned up
+		{"failing statement aborts start", "create table staging (id int)", []string{"set foreign_key_checks=0", "drop table staging"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			drv := &hookDriver{fail: tt.fail, handle: &mockHandle{waitCh: make(chan *models.WaitResult, 1)}}
+			driver.BuiltinDrivers["hook-test"] = func(*driver.DriverContext) driver.Driver { return drv }
+			defer delete(driver.BuiltinDrivers, "hook-test")
+
+			task := models.NewTask()
+			task.Type = models.TaskTypeDest
+			task.Driver = "hook-test"
+			task.Config = map[string]interface{}{}
+			task.PreStartSQL = []string{"set foreign_key_checks=0", "create table staging (id int)"}
+			task.PostStopSQL = []string{"drop table staging"}
+			alloc := &models.Allocation{ID: "alloc", Task: task.Type, Job: &models.Job{Tasks: []*models.Task{task}}}
+			var events []*models.TaskEvent
+			var eventsLock sync.Mutex
+			updater := func(taskName, state string, event *models.TaskEvent) {
+				eventsLock.Lock()
+				defer eventsLock.Unlock()
+				if event != nil {
+					events = append(events, event)
+				}
+			}
+			r := NewWorker(&recordingLogger{}, &config.ClientConfig{}, updater, alloc, task, make(chan *models.TaskUpdate, 100))
+			go r.Run(context.Background())
+
+			// Stop the task once it has started, so that its handle exits.
+			deadline := time.After(5 * time.Second)
+		WAIT:
+			for {
+				select {
+				case <-r.WaitCh():
+					break WAIT
+				case <-deadline:
+					t.Fatal("task didn't finish")
+				case <-time.After(10 * time.Millisecond):
+					if r.Health().Running {
+						r.Destroy(models.NewTaskEvent(models.TaskKilled))
+					}
+				}
+			}
+			if got := drv.statements(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ran %v, want %v", got, tt.want)
+			}
+			eventsLock.Lock()
+			defer eventsLock.Unlock()
+			last := events[len(events)-1]
+			if failed := last.Type == models.TaskSetupFailure && last.FailsTask; failed != tt.wantErr {
+				t.Errorf("last event %+v, want a failing setup event %v", last, tt.wantErr)
+			}
+			if tt.wantErr && !strings.Contains(last.SetupError, "PreStartSQL") {
+				t.Errorf("setup error %q doesn't name the hook", last.SetupError)
+			}
+		})
+	}
+}
+
+func TestWorker_postStopAfterHardKill(t *testing.T) {
+	tests := []struct {
+		name          string
+		failShutdowns int
+		fail          string
+		wantRan       []string
+		wantLog       string
+	}{
+		{"kill fails", 100, "", []string{"create table staging (id int)", "start", "drop table staging"}, "agent: Task didn't exit after failing to kill it. Cleaning up anyway"},
+		{"cleanup fails", 0, "drop table staging", []string{"create table staging (id int)", "start"}, "agent: Failed to run post-stop SQL"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handle := newMockHandle()
+			handle.failShutdowns = tt.failShutdowns
+			drv := &hookDriver{fail: tt.fail, handle: handle}
+			driver.BuiltinDrivers["kill-hook-test"] = func(*driver.DriverContext) driver.Driver { return drv }
+			defer delete(driver.BuiltinDrivers, "kill-hook-test")
+
+			task := models.NewTask()
+			task.Type = models.TaskTypeDest
+			task.Driver = "kill-hook-test"
+			task.Config = map[string]interface{}{}
+			task.PreStartSQL = []string{"create table staging (id int)"}
+			task.PostStopSQL = []string{"drop table staging"}
+			alloc := &models.Allocation{ID: "alloc", Task: task.Type, Job: &models.Job{Tasks: []*models.Task{task}}}
+			updater := func(taskName, state string, event *models.TaskEvent) {}
+			cfg := &config.ClientConfig{
+				KillBackoffBaseline: time.Millisecond,
+				KillBackoffLimit:    time.Millisecond,
+				KillFailureLimit:    2,
+				PostStopTimeout:     50 * time.Millisecond,
+			}
+			logger := &recordingLogger{}
+			r := NewWorker(logger, cfg, updater, alloc, task, make(chan *models.TaskUpdate, 100))
+			go r.Run(context.Background())
+
+			// The handle never exits if it can't be shut down; the task is
+			// cleaned up and let go all the same.
+			deadline := time.After(5 * time.Second)
+		WAIT:
+			for {
+				select {
+				case <-r.WaitCh():
+					break WAIT
+				case <-deadline:
+					t.Fatal("task didn't finish")
+				case <-time.After(10 * time.Millisecond):
+					if r.Health().Running {
+						r.Destroy(models.NewTaskEvent(models.TaskKilled))
+					}
+				}
+			}
+			if got := drv.statements(); !reflect.DeepEqual(got, tt.wantRan) {
+				t.Errorf("ran %v, want %v", got, tt.wantRan)
+			}
+			logger.lock.Lock()
+			defer logger.lock.Unlock()
+			logged := false
+			for _, e := range logger.entries {
+				logged = logged || e.msg == tt.wantLog
+			}
+			if !logged {
+				t.Errorf("%q wasn't logged: %+v", tt.wantLog, logger.entries)
+			}
+		})
+	}
+}
+
+func TestWorker_prestartTimeout(t *testing.T) {
+	tests := []struct {
+		name      string
+		unblock   bool
+		wantEvent string
+		wantRan   []string
+	}{
+		{"never unblocked", false, models.TaskPrestartTimeout, nil},
+		{"unblocked", true, models.TaskKilled, []string{"start"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			drv := &hookDriver{handle: newMockHandle()}
+			driver.BuiltinDrivers["block-test"] = func(*driver.DriverContext) driver.Driver { return drv }
+			defer delete(driver.BuiltinDrivers, "block-test")
+
+			task := models.NewTask()
+			task.Type = models.TaskTypeDest
+			task.Driver = "block-test"
+			task.Config = map[string]interface{}{}
+			task.BlockStart = true
+			task.PrestartTimeout = 100 * time.Millisecond
+			alloc := &models.Allocation{ID: "alloc", Task: task.Type, Job: &models.Job{Tasks: []*models.Task{task}}}
+			var events []*models.TaskEvent
+			var eventsLock sync.Mutex
+			updater := func(taskName, state string, event *models.TaskEvent) {
+				eventsLock.Lock()
+				defer eventsLock.Unlock()
+				if event != nil {
+					events = append(events, event)
+				}
+			}
+			r := NewWorker(&recordingLogger{}, &config.ClientConfig{}, updater, alloc, task, make(chan *models.TaskUpdate, 100))
+			if tt.unblock {
+				r.UnblockStart("test")
+			}
+			go r.Run(context.Background())
+
+			deadline := time.After(5 * time.Second)
+		WAIT:
+			for {
+				select {
+				case <-r.WaitCh():
+					break WAIT
+				case <-deadline:
+					t.Fatal("task didn't finish")
+				case <-time.After(10 * time.Millisecond):
+					if r.Health().Running {
+						r.Destroy(models.NewTaskEvent(models.TaskKilled))
+					}
+				}
+			}
+			if got := drv.statements(); !reflect.DeepEqual(got, tt.wantRan) {
+				t.Errorf("ran %v, want %v", got, tt.wantRan)
+			}
+			eventsLock.Lock()
+			defer eventsLock.Unlock()
+			last := events[len(events)-1]
+			if last.Type != tt.wantEvent {
+				t.Errorf("last event %+v, want %q", last, tt.wantEvent)
+			}
+			if tt.wantEvent == models.TaskPrestartTimeout && (!last.FailsTask || !strings.Contains(last.SetupError, "prestart timeout")) {
+				t.Errorf("timeout event %+v doesn't fail the task with a prestart timeout", last)
+			}
+		})
+	}
+}
+
+func TestWorker_completionSummary(t *testing.T) {
+	handle := &rangeHandle{mockHandle: newMockHandle(), from: 0, to: 100}
+	states := make(chan string, 100)
+	events := make(chan *models.TaskEvent, 100)
+	r := newRunningTestWorker(context.Background(), handle, states, func(r *Worker) {
+		r.startedAt = time.Now().Add(-time.Second)
+		r.updater = func(taskName, state string, event *models.TaskEvent) {
+			if state != "" {
+				states <- state
+			}
+			if event != nil {
+				events <- event
+			}
+		}
+	})
+
+	deadline := time.After(5 * time.Second)
+	for {
+		r.taskStatsLock.RLock()
+		collected := r.taskStats != nil
+		r.taskStatsLock.RUnlock()
+		if collected {
+			break
+		}
+		select {
+		case <-deadline:
+			t.Fatal("stats weren't collected")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+
+	handle.waitCh <- models.NewWaitResult(0, nil)
+	var done *models.TaskEvent
+	for done == nil {
+		select {
+		case event := <-events:
+			if event.Type == models.TaskTerminated {
+				done = event
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for the terminated event")
+		}
+	}
+	if done.InsertCount != 100 || done.UpdateCount != 0 || done.DelCount != 0 {
+		t.Errorf("got %d inserts, %d updates and %d deletes, want 100 inserts",
+			done.InsertCount, done.UpdateCount, done.DelCount)
+	}
+	if done.Elapsed < time.Second {
+		t.Errorf("got elapsed %v, want at least 1s", done.Elapsed)
+	}
+
+	r.Destroy(models.NewTaskEvent(models.TaskKilled))
+	select {
+	case <-r.WaitCh():
+	case <-time.After(5 * time.Second):
+		t.Fatalf("run loop did not exit after destroy")
+	}
+}
+
+// activeDriver counts the handles it started which weren't shut down yet.
+type activeDriver struct {
+	lock      sync.Mutex
+	active    int
+	maxActive int
+	started   int
+}
+
+func (d *activeDriver) Start(ctx *driver.ExecContext, task *models.Task) (driver.DriverHandle, error) {
+	d.lock.Lock()
+	d.active++
+	d.started++
+	if d.active > d.maxActive {
+		d.maxActive = d.active
+	}
+	d.lock.Unlock()
+	// widen the window for a second start
+	time.Sleep(time.Millisecond)
+	return &activeHandle{mockHandle: newMockHandle(), driver: d}, nil
+}
+
+func (d *activeDriver) Validate(task *models.Task) (*models.TaskValidateResponse, error) {
+	return &models.TaskValidateResponse{}, nil
+}
+
+type activeHandle struct {
+	*mockHandle
+	driver *activeDriver
+}
+
+func (h *activeHandle) Shutdown() error {
+	h.driver.lock.Lock()
+	h.driver.active--
+	h.driver.lock.Unlock()
+	return h.mockHandle.Shutdown()
+}
+
+func TestWorker_singleStart(t *testing.T) {
+	dir, err := ioutil.TempDir("", "worker")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	drv := &activeDriver{}
+	driver.BuiltinDrivers["active-test"] = func(*driver.DriverContext) driver.Driver { return drv }
+	defer delete(driver.BuiltinDrivers, "active-test")
+
+	// Workers restored for the same task all try to start it at once.
+	workers := make([]*Worker, 8)
+	errs := make([]error, len(workers))
+	var wg sync.WaitGroup
+	for i := range workers {
+		r := newCheckpointTestWorker(dir)
+		r.task.Driver = "active-test"
+		r.alloc.Job = &models.Job{ID: "job", Tasks: []*models.Task{r.task}}
+		workers[i] = r
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if err := workers[i].RestoreState(); err != nil {
+				errs[i] = err
+				return
+			}
+			errs[i] = workers[i].startTask()
+		}(i)
+	}
+	wg.Wait()
+
+	var started *Worker
+	for i, err := range errs {
+		if err == nil {
+			if started != nil {
+				t.Errorf("worker %d started the task, which another one had started", i)
+			}
+			started = workers[i]
+		} else if !models.IsRecoverable(err) {
+			t.Errorf("worker %d failed to start with %v, want a recoverable error", i, err)
+		}
+	}
+	if started == nil {
+		t.Fatal("no worker started the task")
+	}
+	drv.lock.Lock()
+	if drv.maxActive != 1 || drv.started != 1 {
+		t.Errorf("started %d handles with up to %d active, want 1", drv.started, drv.maxActive)
+	}
+	drv.lock.Unlock()
+
+	// Once the handle is gone, another worker can start the task.
+	started.handle.Shutdown()
+	started.releaseStart()
+	other := workers[0]
+	if other == started {
+		other = workers[1]
+	}
+	if err := other.startTask(); err != nil {
+		t.Fatalf("startTask() after the first handle is gone = %v", err)
+	}
+	other.handle.Shutdown()
+	other.releaseStart()
+}
@@ -30,11 +30,12 @@ const (
 	DefaultBindPort  int = 8191
 	DefaultClusterID     = "udup-cluster"
 
-	channelBufferSize = 600
-	defaultNumRetries = 5
-	defaultChunkSize  = 2000
-	defaultNumWorkers = 1
-	defaultMsgBytes   = 20 * 1024
+	channelBufferSize         = 600
+	defaultNumRetries         = 5
+	defaultChunkSize          = 2000
+	defaultNumWorkers         = 1
+	defaultMsgBytes           = 20 * 1024
+	defaultReplicaWaitTimeout = 60 // second
 )
 
 // RPCHandler can be provided to the Client if there is a local server
@@ -49,6 +50,16 @@ type ClientConfig struct {
 	// StateDir is where we store our state
 	StateDir string
 
+	// StateEncryptionKey is the base64 encoded AES key, of 16, 24 or 32
+	// bytes, state files are encrypted with. If empty the UDUP_STATE_KEY
+	// environment variable is used, and without either state is saved in
+	// plaintext.
+	StateEncryptionKey string
+
+	// StateSyncDir makes state saves also fsync StateDir, so a renamed
+	// state file survives a power loss.
+	StateSyncDir bool
+
 	// AllocDir is where we store data for allocations
 	AllocDir string
 
@@ -96,6 +107,87 @@ type ClientConfig struct {
 	// allocation metrics to remote Metric sinks
 	PublishAllocationMetrics bool
 
+	// MetricsHeartbeatInterval, if set, is the interval at which a running
+	// task whose driver has stats emits its latest sample again, with a
+	// liveness of 1 and the age of the sample, whether or not new stats were
+	// collected. A task which stops emits a liveness of 0 once.
+	MetricsHeartbeatInterval time.Duration
+
+	// KillBackoffBaseline is the baseline time for exponential backoff while
+	// killing a task. Zero uses the default of 5s.
+	KillBackoffBaseline time.Duration
+
+	// KillBackoffLimit is the limit of the exponential backoff for killing a
+	// task. Zero uses the default of 2m.
+	KillBackoffLimit time.Duration
+
+	// KillFailureLimit is how many times a task kill is attempted before
+	// giving up. Zero uses the default of 5.
+	KillFailureLimit int
+
+	// ThrottleMaxDelay is the replication delay above which a task is slowed
+	// down. Zero disables throttling. A MySQL src task slows down by the delay
+	// its destination acks the binlog with. Dest tasks set the pace of
+	// neither side, so throttling them has no effect.
+	ThrottleMaxDelay time.Duration
+
+	// ThrottleFloor is the lowest fraction of full speed a throttled task
+	// runs at, in (0, 1]. Zero uses the default of 0.1.
+	ThrottleFloor float64
+
+	// BackpressureThreshold is the fraction, in (0, 1], of its target
+	// connection pool in use at which a task stops consuming events. Zero
+	// disables backpressure.
+	BackpressureThreshold float64
+
+	// BackpressureRelease is the fraction of the pool in use below which a
+	// task held back by backpressure consumes events again. Zero uses three
+	// quarters of BackpressureThreshold.
+	BackpressureRelease float64
+
+	// TagQueries puts a comment with the job, alloc and task into the
+	// queries tasks run, and the chunk into those of a dump, for auditing on
+	// the databases.
+	TagQueries bool
+
+	// PrestartTimeout is how long a task with BlockStart waits to be
+	// unblocked before it fails. Zero waits forever.
+	PrestartTimeout time.Duration
+
+	// PostStopTimeout is how long the PostStopSQL of a stopped task may run
+	// before the task is let go without it. Zero uses 30 seconds.
+	PostStopTimeout time.Duration
+
+	// RestartJitter is the fraction, in [0, 1), by which a task's restart
+	// delay is randomly moved up or down so tasks failing together don't
+	// restart together. Zero disables it.
+	RestartJitter float64
+
+	// RestartResetWindow is how long a task must run before failing for its
+	// earlier failures to be forgotten, so that one failing rarely doesn't use
+	// up the restarts of its policy. Zero disables it.
+	RestartResetWindow time.Duration
+
+	// EventCoalesceWindow is how long identical task events are held back
+	// after one is passed on, so that a task restarting in a tight loop
+	// doesn't flood the updater. The repeats are passed on as one event at
+	// the end of the window. Zero disables it.
+	EventCoalesceWindow time.Duration
+
+	// RestartQueueDepth is how many restart requests a task holds while its
+	// run loop is busy. A request beyond them is logged and dropped rather
+	// than blocking its caller. Zero makes a request wait for the run loop.
+	RestartQueueDepth int
+
+	// FailFastOnSchemaError fails a task whose start fails with a schema
+	// error, such as an unknown column on the target, rather than leaving it
+	// to the restart policy to retry what fails the same way every time.
+	FailFastOnSchemaError bool
+
+	// RecentTaskEvents is how many of its latest events a task keeps in
+	// memory. Zero uses the default of 10.
+	RecentTaskEvents int
+
 	// LogLevel is the level of the logs to putout
 	LogLevel string
 
@@ -146,6 +238,25 @@ type MySQLDriverConfig struct {
 	GroupCount                          int
 	GroupMaxSize                        int
 	GroupTimeout                        int // millisecond
+	// ChunkTargetTime, if set, tunes the chunk size of tables with a unique
+	// key so that a chunk is read in about this long, within ChunkSizeMin and
+	// ChunkSizeMax. ChunkSize is the size of the first chunk.
+	ChunkTargetTime int // millisecond
+	ChunkSizeMin    int64
+	ChunkSizeMax    int64
+	// SkipResumeVerify turns off checking the chunks of a full copy against
+	// the target. The source sends the checksum of every chunk of a table with
+	// a unique key, and the destination skips a chunk already on the target
+	// or copies it again if it differs, such as when a copy is started again
+	// after a restart. Checksumming the chunks costs a second read of them.
+	SkipResumeVerify bool
+	// QueryComment, if set, is put as a comment into the queries of the
+	// task, see sql.QueryComment. It is set by the driver.
+	QueryComment string
+	// Capture, if set, is passed the statements the applier runs on the
+	// target, with their args, once their transaction commits. It is set by
+	// the driver.
+	Capture func(query string, args []interface{})
 
 	Gtid                     string
 	GtidStart                string
@@ -175,6 +286,21 @@ type MySQLDriverConfig struct {
 	Stage                string
 	ApproveHeterogeneous bool
 	SkipCreateDbTable    bool
+	// InsertMode is how copied rows are written: "replace" (default), "ignore" or "update"
+	InsertMode string
+	// ReplicaConnectionConfig, if set, is a replica of the source the rows
+	// of the full copy are read from instead, to offload the source. It must
+	// replicate by GTID. The copy waits up to ReplicaWaitTimeout for it to
+	// catch up with the source.
+	ReplicaConnectionConfig *umconf.ConnectionConfig
+	ReplicaWaitTimeout      int // second
+	// LowerCaseTableNames is the lower_case_table_names of the target server.
+	// Unless 0, the names of the target databases and tables are lowercased.
+	// The applier reads it from the server.
+	LowerCaseTableNames int
+	// CopyRange, if set, is the part of a full copy split by the driver that
+	// the task makes. It is set by the driver.
+	CopyRange *CopyRange
 
 	throttleMutex               *sync.Mutex
 	CountingRowsFlag            int64
@@ -217,6 +343,16 @@ func (a *MySQLDriverConfig) SetDefault() *MySQLDriverConfig {
 	if "" == result.ConnectionConfig.Charset {
 		result.ConnectionConfig.Charset = "utf8mb4"
 	}
+	if result.ReplicaConnectionConfig != nil {
+		if result.ReplicaWaitTimeout <= 0 {
+			result.ReplicaWaitTimeout = defaultReplicaWaitTimeout
+		}
+		if "" == result.ReplicaConnectionConfig.Charset {
+			replica := *result.ReplicaConnectionConfig
+			replica.Charset = result.ConnectionConfig.Charset
+			result.ReplicaConnectionConfig = &replica
+		}
+	}
 	return &result
 }
 
@@ -295,15 +431,32 @@ func (m *MySQLDriverConfig) GetCriticalLoad() umconf.LoadMap {
 	return m.criticalLoad.Duplicate()
 }
 
+// CopyRange is the part of the full copy of a src task split into Count
+// copies by ranges of the unique keys of its tables, of which the copy of
+// Index makes one. The first copy also creates the tables, copies those not
+// split and streams the binlog once the others are done.
+type CopyRange struct {
+	ID    string // shared by the copies of a split
+	Index int
+	Count int
+	// Where is the condition on the unique key of each table split, by
+	// qualified name, that restricts the rows the copy makes.
+	Where map[string]string
+	// Bounds are the bounds of the tables split, by qualified name.
+	Bounds map[string]*models.KeyBounds
+}
+
 // TableName is the table configuration
 // slave restrict replication to a given table
 type DataSource struct {
-	TableSchema string
-	Tables      []*Table
+	TableSchema       string
+	TableSchemaRename string // target database name, if different
+	Tables            []*Table
 }
 
 type Table struct {
 	TableName   string
+	TableRename string // target table name, if different
 	TableSchema string
 	Counter     int64
 
@@ -316,6 +469,12 @@ type Table struct {
 	RowsEstimate int64
 
 	Where string // TODO load from job description
+
+	IndexHint     *umconf.IndexHint // index hint for chunk queries, if any
+	VersionColumn string            // e.g. "updated_at", see umconf.ColumnList.VersionColumn
+	OptimizerHint string            // e.g. "MAX_EXECUTION_TIME(1000)", put into a /*+ */ comment
+	AppendOnly    bool              // rows are only ever added past the highest key, see models.KeyBounds
+	ColumnRenames map[string]string // source -> target names of the columns named differently on the target
 }
 
 type TableContext struct {
@@ -56,10 +56,33 @@ type TimezoneConvertion struct {
 	ToTimezone string
 }
 
+// TimezoneConversionPolicy converts the values of all columns of the given types
+// from one timezone to another. A column's own TimezoneConversion overrides it.
+type TimezoneConversionPolicy struct {
+	AppliesTo    []ColumnType
+	FromTimezone string
+	ToTimezone   string
+}
+
+// AppliesToColumn tells whether the policy converts the column, which is the
+// case if it has one of the types and no TimezoneConversion of its own.
+func (p *TimezoneConversionPolicy) AppliesToColumn(column *Column) bool {
+	if p == nil || column.TimezoneConversion != nil {
+		return false
+	}
+	for _, columnType := range p.AppliesTo {
+		if column.Type == columnType {
+			return true
+		}
+	}
+	return false
+}
+
 type Column struct {
 	Name               string
 	IsUnsigned         bool
 	Charset            string
+	Collation          string // if set, chunk ordering and comparison use this collation
 	Type               ColumnType
 	ColumnType         string
 	Key                string
@@ -67,12 +90,97 @@ type Column struct {
 	Nullable           bool
 	Precision          int // for decimal, time or datetime
 	Scale              int // for decimal
+	// Generated is set for STORED and VIRTUAL generated columns, which can't
+	// be written to
+	Generated bool
+	// SortDirection is the order of the column within the unique key it is
+	// read by, descending for the b of an (a asc, b desc) index
+	SortDirection SortDirection
+	// Transformer, if set, changes the values written to the column, see
+	// TransformArg. It isn't sent along with the column.
+	Transformer ValueTransformer `json:"-"`
 	// somehow ugly. A better solution might be MetaInfo with subtypes
 }
 
+// SortDirection is the order of a column within an index.
+type SortDirection int
+
+const (
+	SortAscending SortDirection = iota
+	SortDescending
+)
+
+// ParseSortDirection parses the COLLATION of a column of
+// INFORMATION_SCHEMA.STATISTICS, 'D' for descending. Anything else,
+// including the NULL of an unsorted index, is ascending.
+func ParseSortDirection(collation string) SortDirection {
+	if strings.ToUpper(collation) == "D" {
+		return SortDescending
+	}
+	return SortAscending
+}
+
+// Keyword returns the direction as written in an order by clause.
+func (d SortDirection) Keyword() string {
+	if d == SortDescending {
+		return "desc"
+	}
+	return "asc"
+}
+
+// ValueTransformer returns the value to store in place of a value read from
+// the source, such as to re-encrypt it or map a legacy code. It is given the
+// value as converted by ConvertArg, never a NULL, and must return the same
+// value for the same input, as it is also applied to the values rows are
+// matched by.
+type ValueTransformer func(value interface{}) interface{}
+
 func (c *Column) IsPk() bool {
 	return c.Key == "PRI"
 }
+
+// EnumValues returns the values declared by an enum ColumnType, e.g. enum('a','b').
+func (c *Column) EnumValues() []string {
+	if !strings.HasPrefix(c.ColumnType, "enum(") || !strings.HasSuffix(c.ColumnType, ")") {
+		return nil
+	}
+	declared := c.ColumnType[len("enum(") : len(c.ColumnType)-1]
+	var values []string
+	var value []byte
+	inQuote := false
+	for i := 0; i < len(declared); i++ {
+		ch := declared[i]
+		switch {
+		case !inQuote && ch == '\'':
+			inQuote = true
+			value = value[:0]
+		case inQuote && ch == '\'' && i+1 < len(declared) && declared[i+1] == '\'':
+			value = append(value, '\'')
+			i++
+		case inQuote && ch == '\'':
+			inQuote = false
+			values = append(values, string(value))
+		case inQuote:
+			value = append(value, ch)
+		}
+	}
+	return values
+}
+
+// EnumOrdinal returns the index MySQL stores for an enum value: 1 for the first
+// declared value, 0 for the empty error value.
+func (c *Column) EnumOrdinal(value string) (int, bool) {
+	for i, v := range c.EnumValues() {
+		if v == value {
+			return i + 1, true
+		}
+	}
+	if value == "" {
+		return 0, true
+	}
+	return 0, false
+}
+
 func (c *Column) ConvertArg(arg interface{}) interface{} {
 	if fmt.Sprintf("%s", arg) == "" {
 		return ""
@@ -120,6 +228,17 @@ func (c *Column) ConvertArg(arg interface{}) interface{} {
 	return arg
 }
 
+// TransformArg converts an arg with ConvertArg, then with the Transformer of
+// the column if it has one. The builders of the statements writing the
+// target use it; the queries reading the source use ConvertArg alone.
+func (c *Column) TransformArg(arg interface{}) interface{} {
+	arg = c.ConvertArg(arg)
+	if c.Transformer != nil {
+		arg = c.Transformer(arg)
+	}
+	return arg
+}
+
 func NewColumns(names []string) []Column {
 	result := make([]Column, len(names))
 	for i := range names {
@@ -153,6 +272,15 @@ func NewColumnsMap(orderedColumns []Column) ColumnsMap {
 type ColumnList struct {
 	Columns  []Column
 	Ordinals ColumnsMap
+	// TimezonePolicy, if set, converts columns without a TimezoneConversion
+	TimezonePolicy *TimezoneConversionPolicy
+	// VersionColumn, if set, names a column that only grows as a row changes,
+	// such as updated_at. Updates then skip target rows that are newer.
+	VersionColumn string
+	// SourceOrdinals, if set, maps column names onto their ordinals in the
+	// rows given to the builders, such as rows of a source table whose
+	// columns are in another order. Unset, rows are in the order of Columns.
+	SourceOrdinals ColumnsMap
 }
 
 // NewColumnList creates an object given ordered list of column names
@@ -194,6 +322,17 @@ func (c *ColumnList) Names() []string {
 	return names
 }
 
+// ArgOrdinal returns the ordinal of a column in the rows given to the
+// builders, see SourceOrdinals.
+func (c *ColumnList) ArgOrdinal(columnName string) (ordinal int, ok bool) {
+	if c.SourceOrdinals != nil {
+		ordinal, ok = c.SourceOrdinals[columnName]
+	} else {
+		ordinal, ok = c.Ordinals[columnName]
+	}
+	return ordinal, ok
+}
+
 // TODO caller doesn't handle nil.
 func (c *ColumnList) GetColumn(columnName string) *Column {
 	if ordinal, ok := c.Ordinals[columnName]; ok {
@@ -316,6 +455,33 @@ func (c *UniqueKey) String() string {
 	return fmt.Sprintf("%s: %s; has nullable: %+v", description, c.Columns.Names(), c.HasNullable)
 }
 
+type IndexHintKind string
+
+const (
+	ForceIndexHint  IndexHintKind = "force"
+	UseIndexHint    IndexHintKind = "use"
+	IgnoreIndexHint IndexHintKind = "ignore"
+)
+
+// IndexHint is an index hint put after the table name of a chunk query
+type IndexHint struct {
+	Kind  IndexHintKind
+	Index string
+}
+
+// String renders the hint, e.g. "force index (`PRIMARY`)". An empty hint renders
+// as an empty string.
+func (h *IndexHint) String() string {
+	if h == nil || h.Index == "" {
+		return ""
+	}
+	kind := h.Kind
+	if kind == "" {
+		kind = ForceIndexHint
+	}
+	return fmt.Sprintf("%s index (`%s`)", kind, h.Index)
+}
+
 type ColumnValues struct {
 	AbstractValues []*interface{}
 	ValuesPointers []*interface{}
@@ -38,3 +38,27 @@ func TestGetColumn(t *testing.T) {
 		test.S(t).ExpectTrue(column == nil)
 	}
 }
+
+func TestEnumOrdinal(t *testing.T) {
+	column := Column{Name: "e", Type: EnumColumnType, ColumnType: "enum('b','a','it''s')"}
+	test.S(t).ExpectTrue(reflect.DeepEqual(column.EnumValues(), []string{"b", "a", "it's"}))
+	{
+		ordinal, ok := column.EnumOrdinal("a")
+		test.S(t).ExpectTrue(ok)
+		test.S(t).ExpectEquals(ordinal, 2)
+	}
+	{
+		ordinal, ok := column.EnumOrdinal("it's")
+		test.S(t).ExpectTrue(ok)
+		test.S(t).ExpectEquals(ordinal, 3)
+	}
+	{
+		ordinal, ok := column.EnumOrdinal("")
+		test.S(t).ExpectTrue(ok)
+		test.S(t).ExpectEquals(ordinal, 0)
+	}
+	{
+		_, ok := column.EnumOrdinal("c")
+		test.S(t).ExpectFalse(ok)
+	}
+}
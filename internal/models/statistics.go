@@ -7,6 +7,8 @@
 package models
 
 import (
+	"time"
+
 	gonats "github.com/nats-io/go-nats"
 )
 
@@ -82,6 +84,30 @@ type TaskStatistics struct {
 	BufferStat         BufferStat
 	Stage              string
 	Timestamp          int64
+	// ChunkDurations are how long each chunk executed since the last call to
+	// Stats took.
+	ChunkDurations []time.Duration
+	// MemoryBytes and CPUPercent are the resources the task uses, as
+	// measured by its driver. Both are zero if it doesn't measure them.
+	MemoryBytes uint64
+	CPUPercent  float64
+}
+
+// PoolStats describes the connection pool a task writes to its target with.
+type PoolStats struct {
+	// MaxOpen is the size of the pool, 0 if it is unlimited.
+	MaxOpen int
+	InUse   int
+	Idle    int
+}
+
+// Utilization returns the fraction of the pool in use. An unlimited pool is
+// never exhausted, so its utilization is 0.
+func (s *PoolStats) Utilization() float64 {
+	if s == nil || s.MaxOpen <= 0 {
+		return 0
+	}
+	return float64(s.InUse) / float64(s.MaxOpen)
 }
 
 type AllocStatistics struct {
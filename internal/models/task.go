@@ -47,6 +47,35 @@ type Task struct {
 	// task exits, other tasks will be gracefully terminated.
 	Leader bool
 
+	// StatsInterval overrides the client's stats collection interval for
+	// this task. Zero uses the client's.
+	StatsInterval time.Duration
+
+	// MaxRuntime is how long the task may run before it is killed and marked
+	// as failed. Every start or restart gets a fresh window. Zero disables
+	// the limit.
+	MaxRuntime time.Duration
+
+	// PreStartSQL is run on the task's database, in order, before the task
+	// is started, such as to set up a staging table. PostStopSQL is run
+	// after it exits. Both are run on a connection of their own.
+	PreStartSQL []string
+	PostStopSQL []string
+
+	// BlockStart makes the task wait for the worker to be unblocked, such
+	// as by a template it needs being rendered, before it is first started.
+	// PrestartTimeout bounds the wait, overriding the client's, and fails
+	// the task once it is exceeded. Zero uses the client's.
+	BlockStart      bool
+	PrestartTimeout time.Duration
+
+	// Parallelism, if above one, splits the key space of the task into as
+	// many ranges, copied side by side. Its driver must be a Splitter.
+	Parallelism int
+
+	// ResourceLimits, if set, bound the resources the task may use.
+	ResourceLimits *ResourceLimits
+
 	// Constraints can be specified at a task group level and apply to
 	// all the tasks contained.
 	Constraints []*Constraint
@@ -57,6 +86,28 @@ func NewTask() *Task {
 		ConfigLock: &sync.RWMutex{},
 	}
 }
+
+// ResourceLimits bound the memory and CPU a task may use, as reported in its
+// stats. A limit of zero doesn't apply. A task over a limit is throttled, and
+// killed once it has been over for BreachSamples stats collections in a row,
+// so that a spike doesn't kill it. Zero BreachSamples uses the default.
+// Only the tasks of drivers reporting their usage are limited, which the
+// MySQL and Kafka drivers don't yet.
+type ResourceLimits struct {
+	MemoryBytes   uint64
+	CPUPercent    float64
+	BreachSamples int
+}
+
+// KeyBounds are the lowest and highest unique key of a table, as found by a
+// scan of its index before it is chunked, each value as the text read from
+// the table. An AppendOnly table only ever gets rows past Max.
+type KeyBounds struct {
+	Min        []string
+	Max        []string
+	AppendOnly bool
+}
+
 func (t *Task) Copy() *Task {
 	if t == nil {
 		return nil
@@ -119,6 +170,7 @@ const (
 	TaskStateFailed   = "failed"
 	TaskStateStarting = "starting"
 	TaskStateLost     = "lost"
+	TaskStatePaused   = "paused" // The task is alive but not consuming events.
 )
 
 // TaskState tracks the current store of a task and events that caused store
@@ -185,6 +237,10 @@ const (
 	// failure in the driver.
 	TaskDriverFailure = "Driver Failure"
 
+	// TaskPrestartTimeout indicates that the task was not unblocked within
+	// its prestart timeout, and was failed without being started.
+	TaskPrestartTimeout = "Prestart Timeout"
+
 	// TaskReceived signals that the task has been pulled by the client at the
 	// given timestamp.
 	TaskReceived = "Received"
@@ -202,6 +258,10 @@ const (
 	// TaskKilled indicates a user has killed the task.
 	TaskKilled = "Killed"
 
+	// TaskKillTimedOut indicates that killing the task did not finish before
+	// the deadline of the task's context.
+	TaskKillTimedOut = "Kill Timed Out"
+
 	// TaskRestarting indicates that task terminated and is being restarted.
 	TaskRestarting = "Restarting"
 
@@ -213,6 +273,26 @@ const (
 	// restarted
 	TaskRestartSignal = "Restart Signaled"
 
+	// TaskSignaling indicates that an OS signal is being forwarded to the
+	// task.
+	TaskSignaling = "Signaling"
+
+	// TaskPaused indicates that the task has been signalled to stop
+	// consuming events while keeping its handle and position.
+	TaskPaused = "Paused"
+
+	// TaskResumed indicates that a paused task has been signalled to
+	// continue consuming events.
+	TaskResumed = "Resumed"
+
+	// TaskBackpressure indicates that the task stopped consuming events
+	// because the connection pool to its target is nearly exhausted.
+	TaskBackpressure = "Backpressure"
+
+	// TaskBackpressureReleased indicates that the connection pool of a task
+	// held back by backpressure drained and the task consumes events again.
+	TaskBackpressureReleased = "Backpressure Released"
+
 	// TaskSiblingFailed indicates that a sibling task in the task has
 	// failed.
 	TaskSiblingFailed = "Sibling Task Failed"
@@ -233,6 +313,9 @@ type TaskEvent struct {
 	// Restart fields.
 	RestartReason string
 
+	// Pause fields.
+	PauseReason string
+
 	// Setup Failure fields.
 	SetupError string
 
@@ -270,6 +353,17 @@ type TaskEvent struct {
 
 	// DriverMessage indicates a driver action being taken.
 	DriverMessage string
+
+	// Repeats is how many identical events were coalesced into this one,
+	// such as during a restart loop.
+	Repeats int
+
+	// Completion fields: the rows a task which completed wrote, and how long
+	// it ran.
+	InsertCount int64
+	UpdateCount int64
+	DelCount    int64
+	Elapsed     time.Duration
 }
 
 func (te *TaskEvent) GoString() string {
@@ -331,6 +425,16 @@ func (e *TaskEvent) SetExitMessage(err error) *TaskEvent {
 	return e
 }
 
+func (e *TaskEvent) SetTotals(stats *TableStats, elapsed time.Duration) *TaskEvent {
+	if stats != nil {
+		e.InsertCount = stats.InsertCount
+		e.UpdateCount = stats.UpdateCount
+		e.DelCount = stats.DelCount
+	}
+	e.Elapsed = elapsed
+	return e
+}
+
 func (e *TaskEvent) SetKillError(err error) *TaskEvent {
 	if err != nil {
 		e.KillError = err.Error()
@@ -353,6 +457,11 @@ func (e *TaskEvent) SetRestartReason(reason string) *TaskEvent {
 	return e
 }
 
+func (e *TaskEvent) SetPauseReason(reason string) *TaskEvent {
+	e.PauseReason = reason
+	return e
+}
+
 func (e *TaskEvent) SetTaskSignalReason(r string) *TaskEvent {
 	e.TaskSignalReason = r
 	return e